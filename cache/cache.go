@@ -94,6 +94,9 @@ type BackendConfig struct {
 	Backend   string                `yaml:"backend"`
 	Memcached MemcachedClientConfig `yaml:"memcached"`
 	Redis     RedisClientConfig     `yaml:"redis"`
+
+	// Encryption of the cache entries, applied to any backend.
+	Encryption EncryptionConfig `yaml:"encryption"`
 }
 
 // Validate the config.
@@ -102,6 +105,10 @@ func (cfg *BackendConfig) Validate() error {
 		return fmt.Errorf("unsupported cache backend: %s", cfg.Backend)
 	}
 
+	if err := cfg.Encryption.Validate(); err != nil {
+		return err
+	}
+
 	switch cfg.Backend {
 	case BackendMemcached:
 		return cfg.Memcached.Validate()
@@ -112,15 +119,31 @@ func (cfg *BackendConfig) Validate() error {
 }
 
 func CreateClient(cacheName string, cfg BackendConfig, logger log.Logger, reg prometheus.Registerer) (Cache, error) {
+	var (
+		client Cache
+		err    error
+	)
+
 	switch cfg.Backend {
 	case "":
 		// No caching.
 		return nil, nil
 	case BackendMemcached:
-		return NewMemcachedClientWithConfig(logger, cacheName, cfg.Memcached, reg)
+		client, err = NewMemcachedClientWithConfig(logger, cacheName, cfg.Memcached, reg)
 	case BackendRedis:
-		return NewRedisClient(logger, cacheName, cfg.Redis, reg)
+		client, err = NewRedisClient(logger, cacheName, cfg.Redis, reg)
 	default:
 		return nil, errors.Errorf("unsupported cache type for cache %s: %s", cacheName, cfg.Backend)
 	}
+	if err != nil {
+		return nil, err
+	}
+
+	encrypted, err := NewEncryption(cfg.Encryption, client, logger, reg)
+	if err != nil {
+		client.Stop()
+		return nil, err
+	}
+
+	return encrypted, nil
 }
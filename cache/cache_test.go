@@ -63,4 +63,12 @@ func TestBackendConfig_Validate(t *testing.T) {
 
 		require.Error(t, cfg.Validate())
 	})
+
+	t.Run("encryption invalid", func(t *testing.T) {
+		cfg := BackendConfig{
+			Encryption: EncryptionConfig{KeyFiles: []string{"/keys/key-1", "/other/key-1"}},
+		}
+
+		require.ErrorIs(t, cfg.Validate(), errEncryptionDuplicatedKeyID)
+	})
 }
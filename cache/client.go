@@ -269,26 +269,30 @@ func (c *baseClient) delete(ctx context.Context, key string, f func(ctx context.
 }
 
 func (c *baseClient) trackError(op string, err error) {
+	c.metrics.failures.WithLabelValues(op, errorReason(err)).Inc()
+}
+
+// errorReason returns the reason label value used to track the input failed operation error.
+func errorReason(err error) string {
 	var connErr *memcache.ConnectTimeoutError
 	var netErr net.Error
 	switch {
 	case errors.As(err, &connErr):
-		c.metrics.failures.WithLabelValues(op, reasonConnectTimeout).Inc()
+		return reasonConnectTimeout
 	case errors.As(err, &netErr):
 		if netErr.Timeout() {
-			c.metrics.failures.WithLabelValues(op, reasonTimeout).Inc()
-		} else {
-			c.metrics.failures.WithLabelValues(op, reasonNetworkError).Inc()
+			return reasonTimeout
 		}
+		return reasonNetworkError
 	case errors.Is(err, ErrNotStored):
-		c.metrics.failures.WithLabelValues(op, reasonNotStored).Inc()
+		return reasonNotStored
 	case errors.Is(err, ErrInvalidTTL):
-		c.metrics.failures.WithLabelValues(op, reasonInvalidTTL).Inc()
+		return reasonInvalidTTL
 	case errors.Is(err, memcache.ErrMalformedKey):
-		c.metrics.failures.WithLabelValues(op, reasonMalformedKey).Inc()
+		return reasonMalformedKey
 	case errors.Is(err, memcache.ErrServerError):
-		c.metrics.failures.WithLabelValues(op, reasonServerError).Inc()
+		return reasonServerError
 	default:
-		c.metrics.failures.WithLabelValues(op, reasonOther).Inc()
+		return reasonOther
 	}
 }
@@ -5,15 +5,21 @@ package cache
 
 import (
 	"context"
+	"encoding/binary"
 	"errors"
 	"flag"
 	"fmt"
+	"os"
 	"strings"
 	"time"
 
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
 	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 
 	"github.com/grafana/dskit/internal/slices"
 )
@@ -21,22 +27,44 @@ import (
 const (
 	// CompressionSnappy is the value of the snappy compression.
 	CompressionSnappy = "snappy"
+
+	// CompressionZstd is the value of the zstd compression.
+	CompressionZstd = "zstd"
+
+	// CompressionLZ4 is the value of the lz4 compression.
+	CompressionLZ4 = "lz4"
+
+	defaultZstdLevel = 3
+)
+
+// Header bytes prefixing zstd and lz4 compressed entries. Snappy compressed entries have no header,
+// so that they can be decoded by SnappyCache, and are decoded as a fallback.
+const (
+	compressionHeaderZstd byte = 0xfd
+	compressionHeaderLZ4  byte = 0xfe
 )
 
 var (
-	supportedCompressions     = []string{CompressionSnappy}
+	supportedCompressions     = []string{CompressionSnappy, CompressionZstd, CompressionLZ4}
 	errUnsupportedCompression = errors.New("unsupported compression")
+	errInvalidZstdLevel       = errors.New("invalid zstd compression level, must be between 1 and 22")
+	errZstdDictionaryRequired = errors.New("zstd dictionary is only supported with zstd compression")
 
 	_ Cache = (*SnappyCache)(nil)
+	_ Cache = (*CompressedCache)(nil)
 )
 
 type CompressionConfig struct {
-	Compression string `yaml:"compression"`
+	Compression        string `yaml:"compression"`
+	ZstdLevel          int    `yaml:"zstd_level" category:"advanced"`
+	ZstdDictionaryFile string `yaml:"zstd_dictionary_file" category:"experimental"`
 }
 
 // RegisterFlagsWithPrefix registers flags with provided prefix.
 func (cfg *CompressionConfig) RegisterFlagsWithPrefix(f *flag.FlagSet, prefix string) {
 	f.StringVar(&cfg.Compression, prefix+"compression", "", fmt.Sprintf("Enable cache compression, if not empty. Supported values are: %s.", strings.Join(supportedCompressions, ", ")))
+	f.IntVar(&cfg.ZstdLevel, prefix+"compression-zstd-level", defaultZstdLevel, "The zstd compression level, between 1 (fastest) and 22 (best compression). Only used with zstd compression.")
+	f.StringVar(&cfg.ZstdDictionaryFile, prefix+"compression-zstd-dictionary-file", "", "Path to a zstd dictionary file, shared by all the readers and writers of the cache, used to improve the compression of small entries. Only used with zstd compression.")
 }
 
 func (cfg *CompressionConfig) Validate() error {
@@ -44,17 +72,286 @@ func (cfg *CompressionConfig) Validate() error {
 		return errUnsupportedCompression
 	}
 
+	if cfg.Compression == CompressionZstd && (cfg.ZstdLevel < 1 || cfg.ZstdLevel > 22) {
+		return errInvalidZstdLevel
+	}
+
+	if cfg.Compression != CompressionZstd && cfg.ZstdDictionaryFile != "" {
+		return errZstdDictionaryRequired
+	}
+
 	return nil
 }
 
+// NewCompression wraps next with the configured compression, like NewCompressionWithMetrics without
+// registering the metrics. If the compression can't be set up, the error is logged and next is
+// returned without compression.
 func NewCompression(cfg CompressionConfig, next Cache, logger log.Logger) Cache {
+	c, err := NewCompressionWithMetrics(cfg, next, logger, nil)
+	if err != nil {
+		level.Error(logger).Log("msg", "failed to set up cache compression, compression is disabled", "compression", cfg.Compression, "err", err)
+		return next
+	}
+	return c
+}
+
+// NewCompressionWithMetrics wraps next with the configured compression. Entries are decoded regardless
+// of the compression they've been written with, so that the compression can be changed with a rolling update.
+func NewCompressionWithMetrics(cfg CompressionConfig, next Cache, logger log.Logger, reg prometheus.Registerer) (Cache, error) {
+	var (
+		codec      compressionCodec
+		dictionary []byte
+		err        error
+	)
+
+	if cfg.ZstdDictionaryFile != "" {
+		if dictionary, err = os.ReadFile(cfg.ZstdDictionaryFile); err != nil {
+			return nil, fmt.Errorf("read zstd dictionary: %w", err)
+		}
+	}
+
 	switch cfg.Compression {
 	case CompressionSnappy:
-		return NewSnappy(next, logger)
+		codec = snappyCodec{}
+	case CompressionZstd:
+		codec, err = newZstdCodec(cfg.ZstdLevel, dictionary)
+	case CompressionLZ4:
+		codec = lz4Codec{}
 	default:
 		// No compression.
-		return next
+		return next, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return newCompressedCache(next, cfg.Compression, codec, dictionary, logger, reg)
+}
+
+// compressionCodec compresses and decompresses cache entries.
+type compressionCodec interface {
+	// encode returns the compressed value, prefixed by the header identifying the codec, if any.
+	encode(value []byte) []byte
+}
+
+// snappyCodec compresses entries without header, in the same format as SnappyCache.
+type snappyCodec struct{}
+
+func (snappyCodec) encode(value []byte) []byte {
+	return snappy.Encode(nil, value)
+}
+
+type zstdCodec struct {
+	encoder *zstd.Encoder
+}
+
+func newZstdCodec(level int, dictionary []byte) (*zstdCodec, error) {
+	opts := []zstd.EOption{zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level))}
+	if len(dictionary) > 0 {
+		opts = append(opts, zstd.WithEncoderDict(dictionary))
+	}
+
+	encoder, err := zstd.NewWriter(nil, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("create zstd encoder: %w", err)
+	}
+
+	return &zstdCodec{encoder: encoder}, nil
+}
+
+func (c *zstdCodec) encode(value []byte) []byte {
+	out := make([]byte, 1, 1+c.encoder.MaxEncodedSize(len(value)))
+	out[0] = compressionHeaderZstd
+	return c.encoder.EncodeAll(value, out)
+}
+
+// lz4Codec compresses entries as a LZ4 block, prefixed by the uncompressed length.
+type lz4Codec struct{}
+
+func (lz4Codec) encode(value []byte) []byte {
+	out := make([]byte, 1+binary.MaxVarintLen64+lz4.CompressBlockBound(len(value)))
+	out[0] = compressionHeaderLZ4
+	offset := 1 + binary.PutUvarint(out[1:], uint64(len(value)))
+
+	n, err := lz4.CompressBlock(value, out[offset:], nil)
+	if err != nil {
+		// Can't happen, because the destination buffer is large enough.
+		panic(err)
+	}
+
+	return out[:offset+n]
+}
+
+// compressionDecoder decodes entries compressed by any of the supported compressions.
+type compressionDecoder struct {
+	zstd *zstd.Decoder
+}
+
+func newCompressionDecoder(dictionary []byte) (*compressionDecoder, error) {
+	opts := []zstd.DOption{zstd.WithDecoderConcurrency(0)}
+	if len(dictionary) > 0 {
+		opts = append(opts, zstd.WithDecoderDicts(dictionary))
+	}
+
+	decoder, err := zstd.NewReader(nil, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("create zstd decoder: %w", err)
+	}
+
+	return &compressionDecoder{zstd: decoder}, nil
+}
+
+func (d *compressionDecoder) decode(value []byte) ([]byte, error) {
+	if len(value) > 0 {
+		switch value[0] {
+		case compressionHeaderZstd:
+			if decoded, err := d.zstd.DecodeAll(value[1:], []byte{}); err == nil {
+				return decoded, nil
+			}
+		case compressionHeaderLZ4:
+			if decoded, err := decodeLZ4(value[1:]); err == nil {
+				return decoded, nil
+			}
+		}
+	}
+
+	// Fallback to snappy entries, which have no header: a snappy entry could
+	// start with the same byte used as header by the other compressions.
+	return snappy.Decode(nil, value)
+}
+
+func (d *compressionDecoder) close() {
+	d.zstd.Close()
+}
+
+func decodeLZ4(value []byte) ([]byte, error) {
+	size, n := binary.Uvarint(value)
+	if n <= 0 {
+		return nil, errors.New("invalid lz4 entry length")
+	}
+
+	// LZ4 can't compress data more than 255 times, so a larger size means the entry is corrupted.
+	if size > uint64(len(value)-n)*255 {
+		return nil, errors.New("lz4 entry length exceeds the max compression ratio")
+	}
+
+	decoded := make([]byte, size)
+	decodedSize, err := lz4.UncompressBlock(value[n:], decoded)
+	if err != nil {
+		return nil, err
+	}
+	if uint64(decodedSize) != size {
+		return nil, errors.New("lz4 entry length mismatch")
+	}
+
+	return decoded, nil
+}
+
+// CompressedCache is a Cache wrapper compressing entries with a configurable compression.
+// It can decode entries compressed with any of the supported compressions.
+type CompressedCache struct {
+	next    Cache
+	logger  log.Logger
+	codec   compressionCodec
+	decoder *compressionDecoder
+
+	uncompressedBytes prometheus.Counter
+	compressedBytes   prometheus.Counter
+}
+
+func newCompressedCache(next Cache, compression string, codec compressionCodec, dictionary []byte, logger log.Logger, reg prometheus.Registerer) (*CompressedCache, error) {
+	decoder, err := newCompressionDecoder(dictionary)
+	if err != nil {
+		return nil, err
 	}
+
+	labels := map[string]string{"name": next.Name(), "compression": compression}
+
+	return &CompressedCache{
+		next:    next,
+		logger:  logger,
+		codec:   codec,
+		decoder: decoder,
+		uncompressedBytes: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name:        "cache_compression_uncompressed_bytes_total",
+			Help:        "Total number of bytes of cache entries before compression.",
+			ConstLabels: labels,
+		}),
+		compressedBytes: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name:        "cache_compression_compressed_bytes_total",
+			Help:        "Total number of bytes of cache entries after compression.",
+			ConstLabels: labels,
+		}),
+	}, nil
+}
+
+func (c *CompressedCache) encode(value []byte) []byte {
+	encoded := c.codec.encode(value)
+	c.uncompressedBytes.Add(float64(len(value)))
+	c.compressedBytes.Add(float64(len(encoded)))
+	return encoded
+}
+
+// SetAsync implements Cache.
+func (c *CompressedCache) SetAsync(key string, value []byte, ttl time.Duration) {
+	c.next.SetAsync(key, c.encode(value), ttl)
+}
+
+// SetMultiAsync implements Cache.
+func (c *CompressedCache) SetMultiAsync(data map[string][]byte, ttl time.Duration) {
+	encoded := make(map[string][]byte, len(data))
+	for key, value := range data {
+		encoded[key] = c.encode(value)
+	}
+
+	c.next.SetMultiAsync(encoded, ttl)
+}
+
+// Set implements Cache.
+func (c *CompressedCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return c.next.Set(ctx, key, c.encode(value), ttl)
+}
+
+// Add implements Cache.
+func (c *CompressedCache) Add(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return c.next.Add(ctx, key, c.encode(value), ttl)
+}
+
+// GetMulti implements Cache.
+func (c *CompressedCache) GetMulti(ctx context.Context, keys []string, opts ...Option) map[string][]byte {
+	found := c.next.GetMulti(ctx, keys, opts...)
+	decoded := make(map[string][]byte, len(found))
+
+	for key, encodedValue := range found {
+		decodedValue, err := c.decoder.decode(encodedValue)
+		if err != nil {
+			level.Error(c.logger).Log("msg", "failed to decode cache entry", "err", err)
+			continue
+		}
+
+		decoded[key] = decodedValue
+	}
+
+	return decoded
+}
+
+// Stop implements Cache.
+func (c *CompressedCache) Stop() {
+	c.next.Stop()
+	c.decoder.close()
+	if codec, ok := c.codec.(*zstdCodec); ok {
+		_ = codec.encoder.Close()
+	}
+}
+
+// Name implements Cache.
+func (c *CompressedCache) Name() string {
+	return c.next.Name()
+}
+
+// Delete implements Cache.
+func (c *CompressedCache) Delete(ctx context.Context, key string) error {
+	return c.next.Delete(ctx, key)
 }
 
 type SnappyCache struct {
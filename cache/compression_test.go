@@ -1,12 +1,20 @@
 package cache
 
 import (
+	"bytes"
 	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/go-kit/log"
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestCompressionConfig_Validate(t *testing.T) {
@@ -28,6 +36,32 @@ func TestCompressionConfig_Validate(t *testing.T) {
 			},
 			expected: errUnsupportedCompression,
 		},
+		"should pass with zstd compression": {
+			cfg: CompressionConfig{
+				Compression:        "zstd",
+				ZstdLevel:          defaultZstdLevel,
+				ZstdDictionaryFile: "/path/to/dictionary",
+			},
+		},
+		"should fail with invalid zstd level": {
+			cfg: CompressionConfig{
+				Compression: "zstd",
+				ZstdLevel:   23,
+			},
+			expected: errInvalidZstdLevel,
+		},
+		"should fail with zstd dictionary and a different compression": {
+			cfg: CompressionConfig{
+				Compression:        "lz4",
+				ZstdDictionaryFile: "/path/to/dictionary",
+			},
+			expected: errZstdDictionaryRequired,
+		},
+		"should pass with lz4 compression": {
+			cfg: CompressionConfig{
+				Compression: "lz4",
+			},
+		},
 	}
 
 	for testName, testData := range tests {
@@ -66,3 +100,175 @@ func TestSnappyCache(t *testing.T) {
 		assert.Equal(t, expected, c.GetMulti(ctx, []string{"a", "b", "c"}))
 	})
 }
+
+func TestCompressedCache(t *testing.T) {
+	ctx := context.Background()
+	value := bytes.Repeat([]byte("value-"), 100)
+
+	for _, compression := range []string{CompressionSnappy, CompressionZstd, CompressionLZ4} {
+		t.Run(compression, func(t *testing.T) {
+			backend := NewMockCache()
+			reg := prometheus.NewPedanticRegistry()
+			c, err := NewCompressionWithMetrics(CompressionConfig{Compression: compression, ZstdLevel: defaultZstdLevel}, backend, log.NewNopLogger(), reg)
+			require.NoError(t, err)
+			t.Cleanup(c.Stop)
+
+			assert.Empty(t, c.GetMulti(ctx, []string{"a", "b", "c"}))
+
+			c.SetMultiAsync(map[string][]byte{"a": value}, time.Hour)
+			c.SetAsync("b", []byte("b"), time.Hour)
+			require.NoError(t, c.Set(ctx, "c", []byte("value-c"), time.Hour))
+			require.NoError(t, c.Add(ctx, "d", value, time.Hour))
+			backend.SetAsync("e", []byte("not compressed"), time.Hour)
+
+			expected := map[string][]byte{"a": value, "b": []byte("b"), "c": []byte("value-c"), "d": value}
+			assert.Equal(t, expected, c.GetMulti(ctx, []string{"a", "b", "c", "d", "e"}))
+
+			// The backend should store compressed entries, prefixed by the header of the compression
+			// except for snappy, which is stored in the same format as SnappyCache.
+			stored := backend.GetMulti(ctx, []string{"a"})["a"]
+			assert.Less(t, len(stored), len(value))
+			if compression == CompressionSnappy {
+				assert.Equal(t, snappy.Encode(nil, value), stored)
+			} else {
+				assert.Equal(t, map[string]byte{CompressionZstd: compressionHeaderZstd, CompressionLZ4: compressionHeaderLZ4}[compression], stored[0])
+			}
+
+			uncompressed := 2*len(value) + len("b") + len("value-c")
+			assert.Equal(t, float64(uncompressed), testutil.ToFloat64(c.(*CompressedCache).uncompressedBytes))
+			assert.Less(t, testutil.ToFloat64(c.(*CompressedCache).compressedBytes), float64(uncompressed))
+			assert.Equal(t, 2, testutil.CollectAndCount(reg))
+		})
+	}
+}
+
+func TestCompressedCache_ShouldDecodeEntriesWrittenWithAnyCompression(t *testing.T) {
+	ctx := context.Background()
+	backend := NewMockCache()
+
+	// Simulate a rolling update, where the cache is written by clients configured with different compressions.
+	writers := map[string]Cache{"legacy": NewSnappy(backend, log.NewNopLogger())}
+	for _, compression := range supportedCompressions {
+		c, err := NewCompressionWithMetrics(CompressionConfig{Compression: compression, ZstdLevel: defaultZstdLevel}, backend, log.NewNopLogger(), nil)
+		require.NoError(t, err)
+		t.Cleanup(c.Stop)
+		writers[compression] = c
+	}
+
+	var keys []string
+	expected := map[string][]byte{}
+	for writerName, writer := range writers {
+		for i := 1; i <= 10; i++ {
+			key := fmt.Sprintf("%s-%d", writerName, i)
+			value := []byte(strings.Repeat(key, i))
+
+			require.NoError(t, writer.Set(ctx, key, value, time.Hour))
+			keys = append(keys, key)
+			expected[key] = value
+		}
+	}
+
+	for readerName, reader := range writers {
+		if readerName == "legacy" {
+			continue
+		}
+
+		t.Run(readerName, func(t *testing.T) {
+			assert.Equal(t, expected, reader.GetMulti(ctx, keys))
+		})
+	}
+
+	// Entries written with snappy compression can still be decoded by the legacy snappy cache.
+	t.Run("legacy", func(t *testing.T) {
+		var snappyKeys []string
+		snappyExpected := map[string][]byte{}
+		for _, prefix := range []string{"legacy", CompressionSnappy} {
+			for i := 1; i <= 10; i++ {
+				key := fmt.Sprintf("%s-%d", prefix, i)
+				snappyKeys = append(snappyKeys, key)
+				snappyExpected[key] = expected[key]
+			}
+		}
+		assert.Equal(t, snappyExpected, writers["legacy"].GetMulti(ctx, snappyKeys))
+	})
+}
+
+func TestCompressedCache_ShouldDecodeLegacySnappyEntriesStartingWithAHeaderByte(t *testing.T) {
+	ctx := context.Background()
+	backend := NewMockCache()
+
+	c, err := NewCompressionWithMetrics(CompressionConfig{Compression: CompressionSnappy}, backend, log.NewNopLogger(), nil)
+	require.NoError(t, err)
+	t.Cleanup(c.Stop)
+
+	// Legacy snappy entries start with the uvarint encoded length of the value, so the length
+	// of these values is picked to have the first byte equal to one of the compression headers.
+	legacy := NewSnappy(backend, log.NewNopLogger())
+	expected := map[string][]byte{}
+	for _, header := range []byte{compressionHeaderZstd, compressionHeaderLZ4} {
+		key := fmt.Sprintf("legacy-%x", header)
+		value := bytes.Repeat([]byte{'x'}, int(header))
+		require.NoError(t, legacy.Set(ctx, key, value, time.Hour))
+		require.Equal(t, header, backend.GetMulti(ctx, []string{key})[key][0])
+		expected[key] = value
+	}
+
+	assert.Equal(t, expected, c.GetMulti(ctx, []string{"legacy-fd", "legacy-fe"}))
+}
+
+func TestCompressedCache_ZstdDictionary(t *testing.T) {
+	ctx := context.Background()
+	backend := NewMockCache()
+
+	// The dictionary has been trained with "zstd --train" on JSON entries similar to the one used by this test.
+	dictionaryFile := filepath.Join("testdata", "zstd.dict")
+
+	withDictionary, err := NewCompressionWithMetrics(CompressionConfig{Compression: CompressionZstd, ZstdLevel: defaultZstdLevel, ZstdDictionaryFile: dictionaryFile}, backend, log.NewNopLogger(), nil)
+	require.NoError(t, err)
+	t.Cleanup(withDictionary.Stop)
+
+	withoutDictionary, err := NewCompressionWithMetrics(CompressionConfig{Compression: CompressionZstd, ZstdLevel: defaultZstdLevel}, backend, log.NewNopLogger(), nil)
+	require.NoError(t, err)
+	t.Cleanup(withoutDictionary.Stop)
+
+	value := []byte(`{"tenant":"user-1000","series":"http_requests_total","labels":{"job":"api","instance":"1000"}}`)
+	require.NoError(t, withDictionary.Set(ctx, "with-dictionary", value, time.Hour))
+	require.NoError(t, withoutDictionary.Set(ctx, "without-dictionary", value, time.Hour))
+
+	stored := backend.GetMulti(ctx, []string{"with-dictionary", "without-dictionary"})
+	assert.Less(t, len(stored["with-dictionary"]), len(stored["without-dictionary"]))
+
+	assert.Equal(t, map[string][]byte{"with-dictionary": value, "without-dictionary": value}, withDictionary.GetMulti(ctx, []string{"with-dictionary", "without-dictionary"}))
+
+	// Entries compressed with the dictionary can't be decoded without it.
+	assert.Equal(t, map[string][]byte{"without-dictionary": value}, withoutDictionary.GetMulti(ctx, []string{"with-dictionary", "without-dictionary"}))
+}
+
+func TestNewCompression_ShouldFailOnMissingZstdDictionary(t *testing.T) {
+	cfg := CompressionConfig{Compression: CompressionZstd, ZstdLevel: defaultZstdLevel, ZstdDictionaryFile: filepath.Join(t.TempDir(), "missing")}
+	backend := NewMockCache()
+
+	_, err := NewCompressionWithMetrics(cfg, backend, log.NewNopLogger(), nil)
+	require.Error(t, err)
+
+	// NewCompression can't return the error, so it disables the compression.
+	assert.Equal(t, backend, NewCompression(cfg, backend, log.NewNopLogger()))
+}
+
+func TestDecodeLZ4_ShouldFailOnCorruptedEntries(t *testing.T) {
+	encoded := lz4Codec{}.encode(bytes.Repeat([]byte("a"), 1000))
+
+	_, err := decodeLZ4(encoded[1:])
+	require.NoError(t, err)
+
+	for name, corrupted := range map[string][]byte{
+		"empty":          {},
+		"truncated":      encoded[1 : len(encoded)-1],
+		"too large size": append([]byte{0xff, 0xff, 0xff, 0xff, 0x0f}, encoded[3:]...),
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := decodeLZ4(corrupted)
+			require.Error(t, err)
+		})
+	}
+}
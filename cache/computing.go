@@ -0,0 +1,409 @@
+package cache
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/grafana/dskit/concurrency"
+)
+
+const (
+	computedEntryVersion    byte = 1
+	computedEntryHeaderSize      = 1 + 8 + 8
+
+	computeLeaseSuffix = "#lease"
+
+	defaultLeasePollInterval = 100 * time.Millisecond
+	defaultLeaseConcurrency  = 10
+)
+
+// Codec encodes values of type T to cache entries, and decodes them back.
+type Codec[T any] interface {
+	Encode(value T) ([]byte, error)
+	Decode(data []byte) (T, error)
+}
+
+// ComputeFunc computes the values of the input keys. Keys missing from the returned
+// map are considered to have no value, and are neither cached nor returned.
+type ComputeFunc[T any] func(ctx context.Context, keys []string) (map[string]T, error)
+
+type ComputingCacheConfig struct {
+	// TTL of the computed entries.
+	TTL time.Duration
+
+	// LeaseTTL is the TTL of the lease a caller acquires on a missing key, via Cache.Add(), before computing
+	// its value. While the lease is held, other callers (even on other replicas) wait for the value to be
+	// computed instead of computing it too. Leases are disabled if 0.
+	LeaseTTL time.Duration
+
+	// LeaseWaitTimeout is how long a caller waits for the value of a key whose lease is held by another
+	// caller, before computing the value by itself.
+	LeaseWaitTimeout time.Duration
+
+	// LeasePollInterval is how frequently a caller waiting for a key whose lease is held by another
+	// caller looks up the key in the cache. Defaults to 100ms if 0.
+	LeasePollInterval time.Duration
+
+	// LeaseConcurrency is the maximum number of leases acquired or released concurrently, as each
+	// lease is a separate cache operation. Defaults to 10 if 0.
+	LeaseConcurrency int
+
+	// EarlyExpirationBeta controls the probabilistic early expiration of the entries: entries are
+	// recomputed before their expiration with a probability increasing as the expiration approaches
+	// and with the time it took to compute them, so that popular entries are unlikely to expire all
+	// at once. Values greater than 1 favor earlier recomputations. Early expiration is disabled if 0.
+	EarlyExpirationBeta float64
+}
+
+// ComputingCache looks up values of type T in a Cache, computing and caching the missing ones.
+//
+// Each cached value is stored along with its expiration time and the time it took to compute it,
+// which are used for the probabilistic early expiration. While an early expired value is being
+// recomputed by another caller, the early expired value is returned.
+type ComputingCache[T any] struct {
+	cache  Cache
+	codec  Codec[T]
+	cfg    ComputingCacheConfig
+	logger log.Logger
+
+	// Mocked in tests.
+	now  func() time.Time
+	rand func() float64
+
+	requests         prometheus.Counter
+	hits             prometheus.Counter
+	earlyExpirations prometheus.Counter
+	computed         prometheus.Counter
+	leaseContended   prometheus.Counter
+	leaseTimeouts    prometheus.Counter
+}
+
+// NewComputingCache makes a new ComputingCache.
+func NewComputingCache[T any](cache Cache, codec Codec[T], cfg ComputingCacheConfig, logger log.Logger, reg prometheus.Registerer) *ComputingCache[T] {
+	if cfg.LeasePollInterval <= 0 {
+		cfg.LeasePollInterval = defaultLeasePollInterval
+	}
+	if cfg.LeaseConcurrency <= 0 {
+		cfg.LeaseConcurrency = defaultLeaseConcurrency
+	}
+
+	labels := map[string]string{"name": cache.Name()}
+
+	return &ComputingCache[T]{
+		cache:  cache,
+		codec:  codec,
+		cfg:    cfg,
+		logger: logger,
+		now:    time.Now,
+		rand:   rand.Float64,
+
+		requests: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name:        "cache_compute_requests_total",
+			Help:        "Total number of items requests to the computing cache.",
+			ConstLabels: labels,
+		}),
+		hits: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name:        "cache_compute_hits_total",
+			Help:        "Total number of items requests to the computing cache that were a hit.",
+			ConstLabels: labels,
+		}),
+		earlyExpirations: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name:        "cache_compute_early_expirations_total",
+			Help:        "Total number of items found in the computing cache that were early expired.",
+			ConstLabels: labels,
+		}),
+		computed: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name:        "cache_compute_computed_total",
+			Help:        "Total number of items computed by the computing cache.",
+			ConstLabels: labels,
+		}),
+		leaseContended: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name:        "cache_compute_lease_contended_total",
+			Help:        "Total number of items whose lease was held by another caller.",
+			ConstLabels: labels,
+		}),
+		leaseTimeouts: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name:        "cache_compute_lease_wait_timeouts_total",
+			Help:        "Total number of items computed after timing out waiting for another caller holding the lease.",
+			ConstLabels: labels,
+		}),
+	}
+}
+
+// GetOrCompute returns the values of the input keys, looking them up in the cache and computing the missing
+// ones with compute. Computed values are stored in the cache. An error is returned if compute fails, or
+// if ctx is canceled while waiting for the values computed by other callers.
+func (c *ComputingCache[T]) GetOrCompute(ctx context.Context, keys []string, compute ComputeFunc[T]) (map[string]T, error) {
+	c.requests.Add(float64(len(keys)))
+
+	results := make(map[string]T, len(keys))
+	expired := map[string]T{}
+	missing := c.lookup(ctx, keys, results, expired)
+	c.hits.Add(float64(len(results)))
+	c.earlyExpirations.Add(float64(len(expired)))
+
+	if len(missing) == 0 {
+		return results, nil
+	}
+
+	owned, contended := c.acquireLeases(ctx, missing)
+	c.leaseContended.Add(float64(len(contended)))
+
+	// Keys whose lease is held by another caller are resolved with the early expired value, if any,
+	// otherwise we wait for the other caller to compute them.
+	waiting := make([]string, 0, len(contended))
+	for _, key := range contended {
+		if value, ok := expired[key]; ok {
+			results[key] = value
+			continue
+		}
+		waiting = append(waiting, key)
+	}
+
+	if len(owned) > 0 {
+		err := c.computeAndStore(ctx, owned, compute, results)
+		c.releaseLeases(ctx, owned, results)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(waiting) > 0 {
+		timedOut, err := c.waitForLeases(ctx, waiting, results)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(timedOut) > 0 {
+			c.leaseTimeouts.Add(float64(len(timedOut)))
+			if err := c.computeAndStore(ctx, timedOut, compute, results); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return results, nil
+}
+
+// lookup looks up keys in the cache, adding fresh values to results and early expired values to expired.
+// It returns the keys which need to be computed, including the early expired ones.
+func (c *ComputingCache[T]) lookup(ctx context.Context, keys []string, results, expired map[string]T) []string {
+	found := c.cache.GetMulti(ctx, keys)
+	now := c.now()
+
+	var missing []string
+	seen := make(map[string]struct{}, len(keys))
+	for _, key := range keys {
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+
+		data, ok := found[key]
+		if !ok {
+			missing = append(missing, key)
+			continue
+		}
+
+		expiresAt, delta, payload, err := decodeComputedEntry(data)
+		if err == nil {
+			var value T
+			if value, err = c.codec.Decode(payload); err == nil {
+				if c.isEarlyExpired(now, expiresAt, delta) {
+					expired[key] = value
+					missing = append(missing, key)
+				} else {
+					results[key] = value
+				}
+				continue
+			}
+		}
+
+		level.Warn(c.logger).Log("msg", "failed to decode computed cache entry", "key", key, "err", err)
+		missing = append(missing, key)
+	}
+
+	return missing
+}
+
+// isEarlyExpired implements the probabilistic early expiration described in the paper
+// "Optimal Probabilistic Cache Stampede Prevention" by Vattani, Chierichetti and Lowenstein.
+func (c *ComputingCache[T]) isEarlyExpired(now, expiresAt time.Time, delta time.Duration) bool {
+	if c.cfg.EarlyExpirationBeta <= 0 {
+		return false
+	}
+
+	// The random value must be in (0, 1], because log(0) is -Inf.
+	gap := -float64(delta) * c.cfg.EarlyExpirationBeta * math.Log(1-c.rand())
+	return !now.Add(time.Duration(gap)).Before(expiresAt)
+}
+
+// acquireLeases tries to acquire the lease of the input keys, and returns the keys whose lease has been
+// acquired and the ones whose lease is held by another caller. If leases are disabled, or the lease can't
+// be acquired because of a cache error, the key is considered owned.
+func (c *ComputingCache[T]) acquireLeases(ctx context.Context, keys []string) (owned, contended []string) {
+	if c.cfg.LeaseTTL <= 0 {
+		return keys, nil
+	}
+
+	errs := make([]error, len(keys))
+	_ = concurrency.ForEachJob(ctx, len(keys), c.cfg.LeaseConcurrency, func(ctx context.Context, idx int) error {
+		errs[idx] = c.cache.Add(ctx, keys[idx]+computeLeaseSuffix, []byte{}, c.cfg.LeaseTTL)
+		return nil
+	})
+
+	for idx, key := range keys {
+		err := errs[idx]
+		switch {
+		case errors.Is(err, ErrNotStored):
+			contended = append(contended, key)
+		case err != nil:
+			level.Warn(c.logger).Log("msg", "failed to acquire computed cache entry lease", "key", key, "err", err)
+			owned = append(owned, key)
+		default:
+			owned = append(owned, key)
+		}
+	}
+
+	return owned, contended
+}
+
+// releaseLeases releases the leases of the keys which have not been computed. The leases of
+// computed keys expire on their own, so that other callers find the value once it's stored.
+func (c *ComputingCache[T]) releaseLeases(ctx context.Context, keys []string, results map[string]T) {
+	if c.cfg.LeaseTTL <= 0 {
+		return
+	}
+
+	var release []string
+	for _, key := range keys {
+		if _, ok := results[key]; !ok {
+			release = append(release, key)
+		}
+	}
+
+	_ = concurrency.ForEachJob(ctx, len(release), c.cfg.LeaseConcurrency, func(ctx context.Context, idx int) error {
+		key := release[idx]
+		if err := c.cache.Delete(ctx, key+computeLeaseSuffix); err != nil && !errors.Is(err, ErrNotStored) {
+			level.Warn(c.logger).Log("msg", "failed to release computed cache entry lease", "key", key, "err", err)
+		}
+		return nil
+	})
+}
+
+// waitForLeases polls the cache for the values of the input keys, computed by the callers holding their
+// lease, until LeaseWaitTimeout. It returns the keys whose value has not been found before the timeout.
+func (c *ComputingCache[T]) waitForLeases(ctx context.Context, keys []string, results map[string]T) ([]string, error) {
+	timeout := time.NewTimer(c.cfg.LeaseWaitTimeout)
+	defer timeout.Stop()
+
+	ticker := time.NewTicker(c.cfg.LeasePollInterval)
+	defer ticker.Stop()
+
+	for len(keys) > 0 {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-timeout.C:
+			return keys, nil
+		case <-ticker.C:
+		}
+
+		// Early expiration is not checked here, because the values have just been computed.
+		found := c.cache.GetMulti(ctx, keys)
+		remaining := keys[:0]
+		for _, key := range keys {
+			value, ok := c.decodeFound(key, found)
+			if !ok {
+				remaining = append(remaining, key)
+				continue
+			}
+			results[key] = value
+		}
+		keys = remaining
+	}
+
+	return keys, nil
+}
+
+func (c *ComputingCache[T]) decodeFound(key string, found map[string][]byte) (T, bool) {
+	var value T
+
+	data, ok := found[key]
+	if !ok {
+		return value, false
+	}
+
+	_, _, payload, err := decodeComputedEntry(data)
+	if err == nil {
+		value, err = c.codec.Decode(payload)
+	}
+	if err != nil {
+		level.Warn(c.logger).Log("msg", "failed to decode computed cache entry", "key", key, "err", err)
+		return value, false
+	}
+
+	return value, true
+}
+
+// computeAndStore computes the values of the input keys, adds them to results and stores them in the cache.
+func (c *ComputingCache[T]) computeAndStore(ctx context.Context, keys []string, compute ComputeFunc[T], results map[string]T) error {
+	start := c.now()
+	computed, err := compute(ctx, keys)
+	if err != nil {
+		return err
+	}
+
+	delta := c.now().Sub(start)
+	expiresAt := c.now().Add(c.cfg.TTL)
+	c.computed.Add(float64(len(computed)))
+
+	entries := make(map[string][]byte, len(computed))
+	for key, value := range computed {
+		results[key] = value
+
+		payload, err := c.codec.Encode(value)
+		if err != nil {
+			level.Warn(c.logger).Log("msg", "failed to encode computed cache entry", "key", key, "err", err)
+			continue
+		}
+		entries[key] = encodeComputedEntry(expiresAt, delta, payload)
+	}
+
+	if len(entries) > 0 {
+		c.cache.SetMultiAsync(entries, c.cfg.TTL)
+	}
+
+	return nil
+}
+
+// encodeComputedEntry returns the cache entry in the format: <version><expiration unix nanoseconds><compute duration nanoseconds><payload>.
+func encodeComputedEntry(expiresAt time.Time, delta time.Duration, payload []byte) []byte {
+	out := make([]byte, computedEntryHeaderSize, computedEntryHeaderSize+len(payload))
+	out[0] = computedEntryVersion
+	binary.BigEndian.PutUint64(out[1:], uint64(expiresAt.UnixNano()))
+	binary.BigEndian.PutUint64(out[9:], uint64(delta))
+	return append(out, payload...)
+}
+
+func decodeComputedEntry(data []byte) (expiresAt time.Time, delta time.Duration, payload []byte, err error) {
+	if len(data) < computedEntryHeaderSize {
+		return time.Time{}, 0, nil, errors.New("computed cache entry is too short")
+	}
+	if data[0] != computedEntryVersion {
+		return time.Time{}, 0, nil, errors.New("unsupported computed cache entry version")
+	}
+
+	expiresAt = time.Unix(0, int64(binary.BigEndian.Uint64(data[1:])))
+	delta = time.Duration(binary.BigEndian.Uint64(data[9:]))
+	return expiresAt, delta, data[computedEntryHeaderSize:], nil
+}
@@ -0,0 +1,282 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/atomic"
+)
+
+func TestComputingCache_GetOrCompute(t *testing.T) {
+	ctx := context.Background()
+	backend := NewMockCache()
+	reg := prometheus.NewPedanticRegistry()
+	c := NewComputingCache[string](backend, stringCodec{}, ComputingCacheConfig{TTL: time.Hour, LeaseTTL: time.Minute}, log.NewNopLogger(), reg)
+
+	compute := &recordingComputeFunc{values: map[string]string{"a": "value-a", "b": "value-b"}}
+
+	// Nothing is cached, so all keys should be computed. Keys without a value should not be returned.
+	actual, err := c.GetOrCompute(ctx, []string{"a", "b", "c", "a"}, compute.compute)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"a": "value-a", "b": "value-b"}, actual)
+	assert.Equal(t, [][]string{{"a", "b", "c"}}, compute.calls)
+
+	// The computed values should be cached, so only the missing key should be computed.
+	actual, err = c.GetOrCompute(ctx, []string{"a", "b", "c"}, compute.compute)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"a": "value-a", "b": "value-b"}, actual)
+	assert.Equal(t, [][]string{{"a", "b", "c"}, {"c"}}, compute.calls)
+
+	// The cached entries should have the configured TTL.
+	items := backend.GetItems()
+	assert.Equal(t, backend.now.Add(time.Hour), items["a"].ExpiresAt)
+
+	// The leases of the computed keys should expire on their own, while the others should be released.
+	assert.Contains(t, items, "a"+computeLeaseSuffix)
+	assert.NotContains(t, items, "c"+computeLeaseSuffix)
+
+	require.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(`
+		# HELP cache_compute_computed_total Total number of items computed by the computing cache.
+		# TYPE cache_compute_computed_total counter
+		cache_compute_computed_total{name="mock"} 2
+		# HELP cache_compute_hits_total Total number of items requests to the computing cache that were a hit.
+		# TYPE cache_compute_hits_total counter
+		cache_compute_hits_total{name="mock"} 2
+		# HELP cache_compute_requests_total Total number of items requests to the computing cache.
+		# TYPE cache_compute_requests_total counter
+		cache_compute_requests_total{name="mock"} 7
+	`), "cache_compute_computed_total", "cache_compute_hits_total", "cache_compute_requests_total"))
+}
+
+func TestComputingCache_GetOrCompute_ShouldReturnComputeErrors(t *testing.T) {
+	ctx := context.Background()
+	backend := NewMockCache()
+	c := NewComputingCache[string](backend, stringCodec{}, ComputingCacheConfig{TTL: time.Hour, LeaseTTL: time.Minute}, log.NewNopLogger(), nil)
+
+	expectedErr := errors.New("compute failed")
+	_, err := c.GetOrCompute(ctx, []string{"a"}, func(context.Context, []string) (map[string]string, error) {
+		return nil, expectedErr
+	})
+	require.Equal(t, expectedErr, err)
+
+	// The lease should have been released, so that another caller can compute the key.
+	assert.Empty(t, backend.GetItems())
+}
+
+func TestComputingCache_GetOrCompute_ShouldSkipUndecodableEntries(t *testing.T) {
+	ctx := context.Background()
+	backend := NewMockCache()
+	c := NewComputingCache[string](backend, stringCodec{}, ComputingCacheConfig{TTL: time.Hour}, log.NewNopLogger(), nil)
+
+	backend.SetAsync("a", []byte("not a computed entry"), time.Hour)
+
+	compute := &recordingComputeFunc{values: map[string]string{"a": "value-a"}}
+	actual, err := c.GetOrCompute(ctx, []string{"a"}, compute.compute)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"a": "value-a"}, actual)
+	assert.Equal(t, [][]string{{"a"}}, compute.calls)
+}
+
+func TestComputingCache_GetOrCompute_ShouldWaitForTheLeaseOwner(t *testing.T) {
+	ctx := context.Background()
+	backend := NewMockCache()
+	cfg := ComputingCacheConfig{TTL: time.Hour, LeaseTTL: time.Minute, LeaseWaitTimeout: 10 * time.Second, LeasePollInterval: 10 * time.Millisecond}
+	c := NewComputingCache[string](backend, stringCodec{}, cfg, log.NewNopLogger(), nil)
+
+	// Simulate another caller holding the lease of "a", and storing its value later.
+	require.NoError(t, backend.Add(ctx, "a"+computeLeaseSuffix, []byte{}, time.Minute))
+
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		time.Sleep(50 * time.Millisecond)
+		backend.SetAsync("a", encodeComputedEntry(time.Now().Add(time.Hour), time.Second, []byte("value-a")), time.Hour)
+	}()
+
+	compute := &recordingComputeFunc{values: map[string]string{"a": "computed-a", "b": "computed-b"}}
+	actual, err := c.GetOrCompute(ctx, []string{"a", "b"}, compute.compute)
+	require.NoError(t, err)
+	wg.Wait()
+
+	assert.Equal(t, map[string]string{"a": "value-a", "b": "computed-b"}, actual)
+	assert.Equal(t, [][]string{{"b"}}, compute.calls)
+	assert.Equal(t, float64(1), testutil.ToFloat64(c.leaseContended))
+	assert.Equal(t, float64(0), testutil.ToFloat64(c.leaseTimeouts))
+}
+
+func TestComputingCache_GetOrCompute_ShouldAcquireLeasesConcurrently(t *testing.T) {
+	ctx := context.Background()
+	backend := &concurrencyTrackingCache{MockCache: NewMockCache()}
+	cfg := ComputingCacheConfig{TTL: time.Hour, LeaseTTL: time.Minute, LeaseConcurrency: 4}
+	c := NewComputingCache[string](backend, stringCodec{}, cfg, log.NewNopLogger(), nil)
+
+	keys := make([]string, 0, 20)
+	for i := 0; i < 20; i++ {
+		keys = append(keys, fmt.Sprintf("key-%d", i))
+	}
+
+	compute := &recordingComputeFunc{values: map[string]string{}}
+	_, err := c.GetOrCompute(ctx, keys, compute.compute)
+	require.NoError(t, err)
+
+	// The leases of all keys should have been acquired, and then released because no value has been computed.
+	assert.Equal(t, [][]string{keys}, compute.calls)
+	assert.Empty(t, backend.GetItems())
+	assert.Equal(t, int64(cfg.LeaseConcurrency), backend.maxInflight.Load())
+}
+
+func TestComputingCache_GetOrCompute_ShouldComputeAfterLeaseWaitTimeout(t *testing.T) {
+	ctx := context.Background()
+	backend := NewMockCache()
+	cfg := ComputingCacheConfig{TTL: time.Hour, LeaseTTL: time.Minute, LeaseWaitTimeout: 50 * time.Millisecond, LeasePollInterval: 10 * time.Millisecond}
+	c := NewComputingCache[string](backend, stringCodec{}, cfg, log.NewNopLogger(), nil)
+
+	// Simulate another caller holding the lease of "a", and never storing its value.
+	require.NoError(t, backend.Add(ctx, "a"+computeLeaseSuffix, []byte{}, time.Minute))
+
+	compute := &recordingComputeFunc{values: map[string]string{"a": "computed-a"}}
+	actual, err := c.GetOrCompute(ctx, []string{"a"}, compute.compute)
+	require.NoError(t, err)
+
+	assert.Equal(t, map[string]string{"a": "computed-a"}, actual)
+	assert.Equal(t, [][]string{{"a"}}, compute.calls)
+	assert.Equal(t, float64(1), testutil.ToFloat64(c.leaseTimeouts))
+
+	t.Run("should return error if the context is canceled while waiting", func(t *testing.T) {
+		require.NoError(t, backend.Add(ctx, "b"+computeLeaseSuffix, []byte{}, time.Minute))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := c.GetOrCompute(ctx, []string{"b"}, compute.compute)
+		require.ErrorIs(t, err, context.Canceled)
+	})
+}
+
+func TestComputingCache_GetOrCompute_EarlyExpiration(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	backend := NewMockCache()
+	c := NewComputingCache[string](backend, stringCodec{}, ComputingCacheConfig{TTL: time.Hour, LeaseTTL: time.Minute, EarlyExpirationBeta: 1}, log.NewNopLogger(), nil)
+	c.now = func() time.Time { return now }
+
+	// The entry expires in 1 minute, and took 10 seconds to compute.
+	backend.SetAsync("a", encodeComputedEntry(now.Add(time.Minute), 10*time.Second, []byte("old")), time.Hour)
+	compute := &recordingComputeFunc{values: map[string]string{"a": "new"}}
+
+	// With the random value close to 0, the entry should not be early expired.
+	c.rand = func() float64 { return 0.1 }
+	actual, err := c.GetOrCompute(ctx, []string{"a"}, compute.compute)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"a": "old"}, actual)
+	assert.Empty(t, compute.calls)
+
+	// With the random value close to 1, the entry should be early expired: since the lease
+	// is held by another caller, the early expired value should be returned.
+	c.rand = func() float64 { return 0.999999 }
+	require.NoError(t, backend.Add(ctx, "a"+computeLeaseSuffix, []byte{}, time.Minute))
+	actual, err = c.GetOrCompute(ctx, []string{"a"}, compute.compute)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"a": "old"}, actual)
+	assert.Empty(t, compute.calls)
+
+	// Once the lease is released, the early expired entry should be recomputed.
+	require.NoError(t, backend.Delete(ctx, "a"+computeLeaseSuffix))
+	actual, err = c.GetOrCompute(ctx, []string{"a"}, compute.compute)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"a": "new"}, actual)
+	assert.Equal(t, [][]string{{"a"}}, compute.calls)
+	assert.Equal(t, float64(2), testutil.ToFloat64(c.earlyExpirations))
+}
+
+func TestComputedEntry(t *testing.T) {
+	expiresAt := time.Unix(0, time.Now().UnixNano())
+
+	actualExpiresAt, actualDelta, actualPayload, err := decodeComputedEntry(encodeComputedEntry(expiresAt, time.Second, []byte("payload")))
+	require.NoError(t, err)
+	assert.Equal(t, expiresAt, actualExpiresAt)
+	assert.Equal(t, time.Second, actualDelta)
+	assert.Equal(t, []byte("payload"), actualPayload)
+
+	_, _, _, err = decodeComputedEntry([]byte("short"))
+	require.Error(t, err)
+
+	_, _, _, err = decodeComputedEntry(append([]byte{computedEntryVersion + 1}, make([]byte, computedEntryHeaderSize)...))
+	require.Error(t, err)
+}
+
+type stringCodec struct{}
+
+func (stringCodec) Encode(value string) ([]byte, error) {
+	return []byte(value), nil
+}
+
+func (stringCodec) Decode(data []byte) (string, error) {
+	return string(data), nil
+}
+
+// recordingComputeFunc computes values from a static map, and records the keys of each call.
+type recordingComputeFunc struct {
+	values map[string]string
+	calls  [][]string
+}
+
+func (f *recordingComputeFunc) compute(_ context.Context, keys []string) (map[string]string, error) {
+	f.calls = append(f.calls, keys)
+
+	computed := map[string]string{}
+	for _, key := range keys {
+		if value, ok := f.values[key]; ok {
+			computed[key] = value
+		}
+	}
+	return computed, nil
+}
+
+// concurrencyTrackingCache is a MockCache tracking the max number of concurrent Set(), Add() and Delete() calls.
+type concurrencyTrackingCache struct {
+	*MockCache
+
+	inflight    atomic.Int64
+	maxInflight atomic.Int64
+}
+
+func (c *concurrencyTrackingCache) track() func() {
+	n := c.inflight.Inc()
+	for {
+		prev := c.maxInflight.Load()
+		if n <= prev || c.maxInflight.CAS(prev, n) {
+			break
+		}
+	}
+
+	// Give the other calls the time to start.
+	time.Sleep(10 * time.Millisecond)
+	return func() { c.inflight.Dec() }
+}
+
+func (c *concurrencyTrackingCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	defer c.track()()
+	return c.MockCache.Set(ctx, key, value, ttl)
+}
+
+func (c *concurrencyTrackingCache) Add(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	defer c.track()()
+	return c.MockCache.Add(ctx, key, value, ttl)
+}
+
+func (c *concurrencyTrackingCache) Delete(ctx context.Context, key string) error {
+	defer c.track()()
+	return c.MockCache.Delete(ctx, key)
+}
@@ -0,0 +1,310 @@
+package cache
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/grafana/dskit/flagext"
+)
+
+const (
+	// maxEncryptionKeyIDLength is the max length of a key ID, which is stored in a single byte.
+	maxEncryptionKeyIDLength = 255
+
+	reasonUnknownKey     = "unknown-key"
+	reasonMalformedEntry = "malformed-entry"
+	reasonAuthentication = "authentication"
+)
+
+var (
+	errEncryptionDuplicatedKeyID = errors.New("duplicated encryption key ID")
+	errEncryptionKeyIDTooLong    = fmt.Errorf("encryption key ID must be at most %d characters", maxEncryptionKeyIDLength)
+
+	_ Cache = (*EncryptedCache)(nil)
+)
+
+type EncryptionConfig struct {
+	// KeyFiles is the list of files containing the encryption keys. The key ID of each key is
+	// the name of its file. The first key is used to encrypt entries, while all keys are used
+	// to decrypt them, so that entries encrypted with a previous key are still readable.
+	KeyFiles flagext.StringSliceCSV `yaml:"key_files" category:"experimental"`
+}
+
+// RegisterFlagsWithPrefix registers flags with provided prefix.
+func (cfg *EncryptionConfig) RegisterFlagsWithPrefix(f *flag.FlagSet, prefix string) {
+	f.Var(&cfg.KeyFiles, prefix+"encryption-key-files", "Comma-separated list of files containing the hex-encoded AES keys (128, 192 or 256 bits) used to encrypt cache entries, if not empty. The key ID is the file name. The first key is used to encrypt entries, while all keys are used to decrypt them, which allows to rotate keys.")
+}
+
+// Enabled returns whether cache entries encryption is enabled.
+func (cfg *EncryptionConfig) Enabled() bool {
+	return len(cfg.KeyFiles) > 0
+}
+
+func (cfg *EncryptionConfig) Validate() error {
+	seen := map[string]struct{}{}
+	for _, file := range cfg.KeyFiles {
+		id := encryptionKeyID(file)
+		if len(id) > maxEncryptionKeyIDLength {
+			return errEncryptionKeyIDTooLong
+		}
+		if _, ok := seen[id]; ok {
+			return errors.Wrap(errEncryptionDuplicatedKeyID, id)
+		}
+		seen[id] = struct{}{}
+	}
+
+	return nil
+}
+
+// NewEncryption wraps next with an EncryptedCache if encryption is enabled, otherwise it returns next.
+func NewEncryption(cfg EncryptionConfig, next Cache, logger log.Logger, reg prometheus.Registerer) (Cache, error) {
+	if !cfg.Enabled() {
+		return next, nil
+	}
+
+	keys := make([]EncryptionKey, 0, len(cfg.KeyFiles))
+	for _, file := range cfg.KeyFiles {
+		key, err := LoadEncryptionKey(file)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	return NewEncrypted(next, keys, logger, reg)
+}
+
+// EncryptionKey is an AES key identified by an ID.
+type EncryptionKey struct {
+	ID  string
+	Key []byte
+}
+
+// LoadEncryptionKey loads the hex-encoded AES key stored in file. The key ID is the file name.
+func LoadEncryptionKey(file string) (EncryptionKey, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return EncryptionKey{}, errors.Wrap(err, "read encryption key file")
+	}
+
+	key, err := hex.DecodeString(strings.TrimSpace(string(content)))
+	if err != nil {
+		return EncryptionKey{}, errors.Wrapf(err, "decode encryption key file %s", file)
+	}
+
+	return EncryptionKey{ID: encryptionKeyID(file), Key: key}, nil
+}
+
+func encryptionKeyID(file string) string {
+	return filepath.Base(file)
+}
+
+// EncryptedCache is a Cache wrapper encrypting values with AES-GCM. Each encrypted value is prefixed
+// by the ID of the key used to encrypt it, so that values encrypted with any of the configured keys can
+// be decrypted. The cache key is used as additional authenticated data, so that a value can't be
+// successfully decrypted if it's stored under a different cache key.
+type EncryptedCache struct {
+	next   Cache
+	logger log.Logger
+
+	// encryptKeyID and encryptAEAD are the key used to encrypt values.
+	encryptKeyID string
+	encryptAEAD  cipher.AEAD
+
+	// decryptAEADs holds the keys used to decrypt values, by key ID.
+	decryptAEADs map[string]cipher.AEAD
+
+	decryptFailures *prometheus.CounterVec
+}
+
+// NewEncrypted makes a new AES-GCM encryption cache wrapper. The first key is used to
+// encrypt values, while all keys are used to decrypt them.
+func NewEncrypted(next Cache, keys []EncryptionKey, logger log.Logger, reg prometheus.Registerer) (*EncryptedCache, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("no encryption key provided")
+	}
+
+	c := &EncryptedCache{
+		next:         next,
+		logger:       logger,
+		decryptAEADs: make(map[string]cipher.AEAD, len(keys)),
+		decryptFailures: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name:        "cache_encryption_decrypt_failures_total",
+			Help:        "Total number of cache entries which failed to be decrypted.",
+			ConstLabels: map[string]string{"name": next.Name()},
+		}, []string{"reason"}),
+	}
+	c.decryptFailures.WithLabelValues(reasonUnknownKey)
+	c.decryptFailures.WithLabelValues(reasonMalformedEntry)
+	c.decryptFailures.WithLabelValues(reasonAuthentication)
+
+	for i, key := range keys {
+		if len(key.ID) == 0 || len(key.ID) > maxEncryptionKeyIDLength {
+			return nil, fmt.Errorf("invalid encryption key ID %q: must be between 1 and %d characters", key.ID, maxEncryptionKeyIDLength)
+		}
+		if _, ok := c.decryptAEADs[key.ID]; ok {
+			return nil, errors.Wrap(errEncryptionDuplicatedKeyID, key.ID)
+		}
+
+		block, err := aes.NewCipher(key.Key)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid encryption key %s", key.ID)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid encryption key %s", key.ID)
+		}
+
+		c.decryptAEADs[key.ID] = aead
+		if i == 0 {
+			c.encryptKeyID = key.ID
+			c.encryptAEAD = aead
+		}
+	}
+
+	return c, nil
+}
+
+// SetAsync implements Cache.
+func (c *EncryptedCache) SetAsync(key string, value []byte, ttl time.Duration) {
+	encrypted, err := c.encrypt(key, value)
+	if err != nil {
+		level.Error(c.logger).Log("msg", "failed to encrypt cache entry", "err", err)
+		return
+	}
+
+	c.next.SetAsync(key, encrypted, ttl)
+}
+
+// SetMultiAsync implements Cache.
+func (c *EncryptedCache) SetMultiAsync(data map[string][]byte, ttl time.Duration) {
+	encrypted := make(map[string][]byte, len(data))
+	for key, value := range data {
+		encryptedValue, err := c.encrypt(key, value)
+		if err != nil {
+			level.Error(c.logger).Log("msg", "failed to encrypt cache entry", "err", err)
+			continue
+		}
+
+		encrypted[key] = encryptedValue
+	}
+
+	c.next.SetMultiAsync(encrypted, ttl)
+}
+
+// Set implements Cache.
+func (c *EncryptedCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	encrypted, err := c.encrypt(key, value)
+	if err != nil {
+		return err
+	}
+
+	return c.next.Set(ctx, key, encrypted, ttl)
+}
+
+// Add implements Cache.
+func (c *EncryptedCache) Add(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	encrypted, err := c.encrypt(key, value)
+	if err != nil {
+		return err
+	}
+
+	return c.next.Add(ctx, key, encrypted, ttl)
+}
+
+// GetMulti implements Cache. Entries which fail to be decrypted are skipped.
+func (c *EncryptedCache) GetMulti(ctx context.Context, keys []string, opts ...Option) map[string][]byte {
+	found := c.next.GetMulti(ctx, keys, opts...)
+	decrypted := make(map[string][]byte, len(found))
+
+	for key, encryptedValue := range found {
+		value, reason, err := c.decrypt(key, encryptedValue)
+		if err != nil {
+			c.decryptFailures.WithLabelValues(reason).Inc()
+			level.Error(c.logger).Log("msg", "failed to decrypt cache entry", "reason", reason, "err", err)
+			continue
+		}
+
+		decrypted[key] = value
+	}
+
+	return decrypted
+}
+
+// Stop implements Cache.
+func (c *EncryptedCache) Stop() {
+	c.next.Stop()
+}
+
+// Name implements Cache.
+func (c *EncryptedCache) Name() string {
+	return c.next.Name()
+}
+
+// Delete implements Cache.
+func (c *EncryptedCache) Delete(ctx context.Context, key string) error {
+	return c.next.Delete(ctx, key)
+}
+
+// encrypt returns the encrypted value, in the format: <key ID length (1 byte)><key ID><nonce><ciphertext>.
+func (c *EncryptedCache) encrypt(key string, value []byte) ([]byte, error) {
+	headerSize := 1 + len(c.encryptKeyID)
+	nonceSize := c.encryptAEAD.NonceSize()
+
+	out := make([]byte, headerSize+nonceSize, headerSize+nonceSize+len(value)+c.encryptAEAD.Overhead())
+	out[0] = byte(len(c.encryptKeyID))
+	copy(out[1:], c.encryptKeyID)
+
+	nonce := out[headerSize : headerSize+nonceSize]
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, errors.Wrap(err, "generate nonce")
+	}
+
+	return c.encryptAEAD.Seal(out, nonce, value, []byte(key)), nil
+}
+
+// decrypt returns the decrypted value or, in case of error, the reason of the failure.
+func (c *EncryptedCache) decrypt(key string, encrypted []byte) ([]byte, string, error) {
+	if len(encrypted) == 0 {
+		return nil, reasonMalformedEntry, errors.New("empty entry")
+	}
+
+	headerSize := 1 + int(encrypted[0])
+	if len(encrypted) < headerSize {
+		return nil, reasonMalformedEntry, errors.New("entry shorter than the key ID")
+	}
+
+	keyID := string(encrypted[1:headerSize])
+	aead, ok := c.decryptAEADs[keyID]
+	if !ok {
+		return nil, reasonUnknownKey, fmt.Errorf("unknown encryption key ID %q", keyID)
+	}
+
+	nonceSize := aead.NonceSize()
+	if len(encrypted) < headerSize+nonceSize+aead.Overhead() {
+		return nil, reasonMalformedEntry, errors.New("entry shorter than the nonce and authentication tag")
+	}
+
+	nonce := encrypted[headerSize : headerSize+nonceSize]
+	value, err := aead.Open(nil, nonce, encrypted[headerSize+nonceSize:], []byte(key))
+	if err != nil {
+		return nil, reasonAuthentication, err
+	}
+
+	return value, "", nil
+}
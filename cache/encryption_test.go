@@ -0,0 +1,181 @@
+package cache
+
+import (
+	"context"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncryptionConfig_Validate(t *testing.T) {
+	tests := map[string]struct {
+		cfg      EncryptionConfig
+		expected error
+	}{
+		"should pass with default config": {
+			cfg: EncryptionConfig{},
+		},
+		"should pass with multiple keys": {
+			cfg: EncryptionConfig{KeyFiles: []string{"/keys/key-1", "/keys/key-2"}},
+		},
+		"should fail with duplicated key IDs": {
+			cfg:      EncryptionConfig{KeyFiles: []string{"/keys/key-1", "/other/key-1"}},
+			expected: errEncryptionDuplicatedKeyID,
+		},
+		"should fail with a too long key ID": {
+			cfg:      EncryptionConfig{KeyFiles: []string{"/keys/" + strings.Repeat("a", maxEncryptionKeyIDLength+1)}},
+			expected: errEncryptionKeyIDTooLong,
+		},
+	}
+
+	for testName, testData := range tests {
+		t.Run(testName, func(t *testing.T) {
+			assert.ErrorIs(t, testData.cfg.Validate(), testData.expected)
+		})
+	}
+}
+
+func TestNewEncryption(t *testing.T) {
+	dir := t.TempDir()
+	backend := NewMockCache()
+
+	t.Run("should return the wrapped cache if encryption is disabled", func(t *testing.T) {
+		c, err := NewEncryption(EncryptionConfig{}, backend, log.NewNopLogger(), nil)
+		require.NoError(t, err)
+		assert.Same(t, backend, c)
+	})
+
+	t.Run("should load keys from files", func(t *testing.T) {
+		file := writeEncryptionKeyFile(t, dir, "key-1", 32)
+
+		c, err := NewEncryption(EncryptionConfig{KeyFiles: []string{file}}, backend, log.NewNopLogger(), nil)
+		require.NoError(t, err)
+		require.IsType(t, &EncryptedCache{}, c)
+		assert.Equal(t, "key-1", c.(*EncryptedCache).encryptKeyID)
+	})
+
+	t.Run("should fail on missing key file", func(t *testing.T) {
+		_, err := NewEncryption(EncryptionConfig{KeyFiles: []string{filepath.Join(dir, "missing")}}, backend, log.NewNopLogger(), nil)
+		require.Error(t, err)
+	})
+
+	t.Run("should fail on invalid key size", func(t *testing.T) {
+		file := writeEncryptionKeyFile(t, dir, "invalid", 10)
+
+		_, err := NewEncryption(EncryptionConfig{KeyFiles: []string{file}}, backend, log.NewNopLogger(), nil)
+		require.Error(t, err)
+	})
+}
+
+func TestEncryptedCache(t *testing.T) {
+	ctx := context.Background()
+	backend := NewMockCache()
+	reg := prometheus.NewPedanticRegistry()
+	c, err := NewEncrypted(backend, []EncryptionKey{{ID: "key-1", Key: make([]byte, 32)}}, log.NewNopLogger(), reg)
+	require.NoError(t, err)
+
+	t.Run("GetMulti() should return empty results if no key has been found", func(t *testing.T) {
+		assert.Empty(t, c.GetMulti(ctx, []string{"a", "b", "c"}))
+	})
+
+	t.Run("GetMulti() should return previously set keys", func(t *testing.T) {
+		expected := map[string][]byte{
+			"a": []byte("value-a"),
+			"b": []byte("value-b"),
+		}
+
+		c.SetMultiAsync(expected, time.Hour)
+		require.NoError(t, c.Set(ctx, "c", []byte("value-c"), time.Hour))
+		expected["c"] = []byte("value-c")
+
+		assert.Equal(t, expected, c.GetMulti(ctx, []string{"a", "b", "c"}))
+	})
+
+	t.Run("values should be stored encrypted", func(t *testing.T) {
+		c.SetAsync("a", []byte("value-a"), time.Hour)
+
+		stored := backend.GetMulti(ctx, []string{"a"})["a"]
+		assert.NotContains(t, string(stored), "value-a")
+		assert.Equal(t, []byte("\x05key-1"), stored[:6])
+	})
+
+	t.Run("Add() should not overwrite existing keys", func(t *testing.T) {
+		require.NoError(t, c.Add(ctx, "new", []byte("value-new"), time.Hour))
+		require.ErrorIs(t, c.Add(ctx, "new", []byte("other"), time.Hour), ErrNotStored)
+		assert.Equal(t, map[string][]byte{"new": []byte("value-new")}, c.GetMulti(ctx, []string{"new"}))
+	})
+
+	t.Run("GetMulti() should skip entries failing to decrypt", func(t *testing.T) {
+		c.SetMultiAsync(map[string][]byte{"a": []byte("value-a"), "b": []byte("value-b")}, time.Hour)
+
+		// Entries moved to a different key shouldn't be decrypted.
+		backend.SetAsync("c", backend.GetMulti(ctx, []string{"a"})["a"], time.Hour)
+		// Entries encrypted with an unknown key shouldn't be decrypted.
+		backend.SetAsync("d", []byte("\x05key-9000000000000000000000000000"), time.Hour)
+		// Malformed entries shouldn't be decrypted.
+		backend.SetAsync("e", []byte("\x05key-1"), time.Hour)
+		backend.SetAsync("f", []byte{}, time.Hour)
+
+		assert.Equal(t, map[string][]byte{"a": []byte("value-a"), "b": []byte("value-b")}, c.GetMulti(ctx, []string{"a", "b", "c", "d", "e", "f"}))
+
+		require.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(`
+			# HELP cache_encryption_decrypt_failures_total Total number of cache entries which failed to be decrypted.
+			# TYPE cache_encryption_decrypt_failures_total counter
+			cache_encryption_decrypt_failures_total{name="mock",reason="authentication"} 1
+			cache_encryption_decrypt_failures_total{name="mock",reason="malformed-entry"} 2
+			cache_encryption_decrypt_failures_total{name="mock",reason="unknown-key"} 1
+		`)))
+	})
+}
+
+func TestEncryptedCache_KeyRotation(t *testing.T) {
+	ctx := context.Background()
+	backend := NewMockCache()
+
+	oldKey := EncryptionKey{ID: "old", Key: []byte(strings.Repeat("o", 16))}
+	newKey := EncryptionKey{ID: "new", Key: []byte(strings.Repeat("n", 32))}
+
+	before, err := NewEncrypted(backend, []EncryptionKey{oldKey}, log.NewNopLogger(), nil)
+	require.NoError(t, err)
+	require.NoError(t, before.Set(ctx, "a", []byte("value-a"), time.Hour))
+
+	// After the rotation, new entries are encrypted with the new key while
+	// entries encrypted with the old key should still be readable.
+	after, err := NewEncrypted(backend, []EncryptionKey{newKey, oldKey}, log.NewNopLogger(), nil)
+	require.NoError(t, err)
+	require.NoError(t, after.Set(ctx, "b", []byte("value-b"), time.Hour))
+
+	assert.Equal(t, map[string][]byte{"a": []byte("value-a"), "b": []byte("value-b")}, after.GetMulti(ctx, []string{"a", "b"}))
+	assert.Equal(t, map[string][]byte{"a": []byte("value-a")}, before.GetMulti(ctx, []string{"a", "b"}))
+}
+
+func TestNewEncrypted_ShouldFailOnInvalidKeys(t *testing.T) {
+	tests := map[string][]EncryptionKey{
+		"no keys":           nil,
+		"empty key ID":      {{ID: "", Key: make([]byte, 16)}},
+		"duplicated key ID": {{ID: "a", Key: make([]byte, 16)}, {ID: "a", Key: make([]byte, 32)}},
+		"invalid key size":  {{ID: "a", Key: make([]byte, 20)}},
+	}
+
+	for testName, keys := range tests {
+		t.Run(testName, func(t *testing.T) {
+			_, err := NewEncrypted(NewMockCache(), keys, log.NewNopLogger(), nil)
+			require.Error(t, err)
+		})
+	}
+}
+
+func writeEncryptionKeyFile(t *testing.T, dir, name string, size int) string {
+	file := filepath.Join(dir, name)
+	require.NoError(t, os.WriteFile(file, []byte(hex.EncodeToString(make([]byte, size))+"\n"), 0o600))
+	return file
+}
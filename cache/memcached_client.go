@@ -29,6 +29,15 @@ import (
 const (
 	dnsProviderUpdateInterval = 30 * time.Second
 	maxTTL                    = 30 * 24 * time.Hour
+
+	// MemcachedServerSelectorJumpHash shards keys to memcached servers with MemcachedJumpHashSelector.
+	MemcachedServerSelectorJumpHash = "jump-hash"
+
+	// MemcachedServerSelectorKetama shards keys to memcached servers with MemcachedKetamaSelector.
+	MemcachedServerSelectorKetama = "ketama"
+
+	// memcachedZoneSeparator separates the zone from the address in zone-prefixed memcached addresses.
+	memcachedZoneSeparator = "="
 )
 
 var (
@@ -36,6 +45,8 @@ var (
 	ErrMemcachedMaxAsyncConcurrencyNotPositive = errors.New("max async concurrency must be positive")
 	ErrInvalidWriteBufferSizeBytes             = errors.New("invalid write buffer size specified (must be greater than 0)")
 	ErrInvalidReadBufferSizeBytes              = errors.New("invalid read buffer size specified (must be greater than 0)")
+	ErrInvalidMemcachedServerSelector          = fmt.Errorf("invalid memcached server selector (supported values: %s, %s)", MemcachedServerSelectorJumpHash, MemcachedServerSelectorKetama)
+	ErrMemcachedZoneAwarenessRequiresKetama    = fmt.Errorf("memcached zone awareness requires the %s server selector", MemcachedServerSelectorKetama)
 
 	_ Cache = (*MemcachedClient)(nil)
 )
@@ -69,10 +80,19 @@ type updatableServerSelector interface {
 	SetServers(servers ...string) error
 }
 
+// zoneAwareServerSelector is an updatableServerSelector which also knows the zone of each server.
+type zoneAwareServerSelector interface {
+	updatableServerSelector
+
+	// SetZoneServers is like SetServers, but takes the servers grouped by zone.
+	SetZoneServers(servers map[string][]string) error
+}
+
 // MemcachedClientConfig is the config accepted by RemoteCacheClient.
 type MemcachedClientConfig struct {
 	// Addresses specifies the list of memcached addresses. The addresses get
-	// resolved with the DNS provider.
+	// resolved with the DNS provider. When using the ketama server selector, each
+	// address can be prefixed by its zone, in the form "<zone>=<address>".
 	Addresses flagext.StringSliceCSV `yaml:"addresses"`
 
 	// Timeout specifies the socket read/write timeout.
@@ -119,6 +139,15 @@ type MemcachedClientConfig struct {
 	// Items bigger than MaxItemSize are skipped. If set to 0, no maximum size is enforced.
 	MaxItemSize int `yaml:"max_item_size" category:"advanced"`
 
+	// ServerSelector specifies how keys are sharded to memcached servers. Supported values
+	// are MemcachedServerSelectorJumpHash and MemcachedServerSelectorKetama.
+	ServerSelector string `yaml:"server_selector" category:"experimental"`
+
+	// Zone is the zone of this client. When set, keys are sharded to the memcached servers
+	// whose address is prefixed by the same zone, falling back to the servers of all zones
+	// if there are no servers in this zone. Requires the ketama server selector.
+	Zone string `yaml:"zone" category:"experimental"`
+
 	// TLSEnabled enables connecting to Memcached with TLS.
 	TLSEnabled bool `yaml:"tls_enabled" category:"advanced"`
 
@@ -127,7 +156,7 @@ type MemcachedClientConfig struct {
 }
 
 func (c *MemcachedClientConfig) RegisterFlagsWithPrefix(prefix string, f *flag.FlagSet) {
-	f.Var(&c.Addresses, prefix+"addresses", "Comma-separated list of memcached addresses. Each address can be an IP address, hostname, or an entry specified in the DNS Service Discovery format.")
+	f.Var(&c.Addresses, prefix+"addresses", "Comma-separated list of memcached addresses. Each address can be an IP address, hostname, or an entry specified in the DNS Service Discovery format. When using the ketama server selector, each address can be prefixed by its zone, in the form <zone>=<address>.")
 	f.DurationVar(&c.Timeout, prefix+"timeout", 200*time.Millisecond, "The socket read/write timeout.")
 	f.DurationVar(&c.ConnectTimeout, prefix+"connect-timeout", 200*time.Millisecond, "The connection timeout.")
 	f.IntVar(&c.WriteBufferSizeBytes, prefix+"write-buffer-size-bytes", 4096, "The size of the write buffer (in bytes). The buffer is allocated for each connection to memcached.")
@@ -139,6 +168,8 @@ func (c *MemcachedClientConfig) RegisterFlagsWithPrefix(prefix string, f *flag.F
 	f.IntVar(&c.MaxGetMultiConcurrency, prefix+"max-get-multi-concurrency", 100, "The maximum number of concurrent connections running get operations. If set to 0, concurrency is unlimited.")
 	f.IntVar(&c.MaxGetMultiBatchSize, prefix+"max-get-multi-batch-size", 100, "The maximum number of keys a single underlying get operation should run. If more keys are specified, internally keys are split into multiple batches and fetched concurrently, honoring the max concurrency. If set to 0, the max batch size is unlimited.")
 	f.IntVar(&c.MaxItemSize, prefix+"max-item-size", 1024*1024, "The maximum size of an item stored in memcached, in bytes. Bigger items are not stored. If set to 0, no maximum size is enforced.")
+	f.StringVar(&c.ServerSelector, prefix+"server-selector", MemcachedServerSelectorJumpHash, fmt.Sprintf("The algorithm used to shard keys to memcached servers. Supported values: %s, %s. The %s selector uses a consistent hashing ring with virtual nodes, compatible with other ketama clients.", MemcachedServerSelectorJumpHash, MemcachedServerSelectorKetama, MemcachedServerSelectorKetama))
+	f.StringVar(&c.Zone, prefix+"zone", "", "The zone of this client. When set, keys are sharded to the memcached servers prefixed by the same zone in the addresses, falling back to the servers of all zones if there are no servers in this zone. Requires the ketama server selector.")
 	f.BoolVar(&c.TLSEnabled, prefix+"tls-enabled", false, "Enable connecting to Memcached with TLS.")
 	c.TLS.RegisterFlagsWithPrefix(prefix, f)
 }
@@ -159,9 +190,46 @@ func (c *MemcachedClientConfig) Validate() error {
 		return ErrMemcachedMaxAsyncConcurrencyNotPositive
 	}
 
+	switch c.ServerSelector {
+	case "", MemcachedServerSelectorJumpHash:
+		if c.isZoneAware() {
+			return ErrMemcachedZoneAwarenessRequiresKetama
+		}
+	case MemcachedServerSelectorKetama:
+	default:
+		return ErrInvalidMemcachedServerSelector
+	}
+
 	return nil
 }
 
+// isZoneAware returns whether the client zone or any address zone is configured.
+func (c *MemcachedClientConfig) isZoneAware() bool {
+	if c.Zone != "" {
+		return true
+	}
+	for _, addr := range c.Addresses {
+		if strings.Contains(addr, memcachedZoneSeparator) {
+			return true
+		}
+	}
+	return false
+}
+
+// zoneAddresses returns the configured addresses grouped by zone. Addresses without
+// a zone prefix are grouped in the empty zone.
+func (c *MemcachedClientConfig) zoneAddresses() map[string][]string {
+	out := map[string][]string{}
+	for _, addr := range c.Addresses {
+		zone, zoneAddr, ok := strings.Cut(addr, memcachedZoneSeparator)
+		if !ok {
+			zone, zoneAddr = "", addr
+		}
+		out[zone] = append(out[zone], zoneAddr)
+	}
+	return out
+}
+
 type MemcachedClient struct {
 	*baseClient
 
@@ -176,6 +244,10 @@ type MemcachedClient struct {
 	// Address provider used to keep the memcached servers list updated.
 	addressProvider AddressProvider
 
+	// Address providers used to keep the memcached servers list of each zone updated,
+	// when the selector is zone-aware. The key is the zone.
+	zoneAddressProviders map[string]AddressProvider
+
 	// Channel used to notify internal goroutines when they should quit.
 	stop chan struct{}
 
@@ -207,8 +279,13 @@ func NewMemcachedClientWithConfig(logger log.Logger, name string, config Memcach
 	}
 
 	// We use a custom servers selector in order to use a jump hash
-	// for servers selection.
-	selector := &MemcachedJumpHashSelector{}
+	// (or a ketama ring) for servers selection.
+	var selector updatableServerSelector
+	if config.ServerSelector == MemcachedServerSelectorKetama {
+		selector = NewMemcachedKetamaSelector(config.Zone)
+	} else {
+		selector = &MemcachedJumpHashSelector{}
+	}
 
 	client := memcache.NewFromSelector(selector)
 	client.Timeout = config.Timeout
@@ -272,6 +349,15 @@ func newMemcachedClient(
 		),
 	}
 
+	// Zone-aware selectors need to know the zone of each server, so addresses
+	// of each zone are resolved separately.
+	if _, ok := selector.(zoneAwareServerSelector); ok && config.isZoneAware() {
+		c.zoneAddressProviders = map[string]AddressProvider{}
+		for zone := range config.zoneAddresses() {
+			c.zoneAddressProviders[zone] = addressProvider.Clone()
+		}
+	}
+
 	c.clientInfo = promauto.With(reg).NewGaugeFunc(prometheus.GaugeOpts{
 		Name: clientInfoMetricName,
 		Help: "A metric with a constant '1' value labeled by configuration options from which memcached client was configured.",
@@ -286,6 +372,8 @@ func newMemcachedClient(
 			"max_item_size":                            strconv.FormatUint(uint64(config.MaxItemSize), 10),
 			"max_get_multi_concurrency":                strconv.Itoa(config.MaxGetMultiConcurrency),
 			"max_get_multi_batch_size":                 strconv.Itoa(config.MaxGetMultiBatchSize),
+			"server_selector":                          config.ServerSelector,
+			"zone":                                     config.Zone,
 		},
 	},
 		func() float64 { return 1 },
@@ -736,6 +824,10 @@ func (c *MemcachedClient) resolveAddrs() error {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
+	if len(c.zoneAddressProviders) > 0 {
+		return c.resolveZoneAddrs(ctx)
+	}
+
 	// If some dns resolution fails, log the error.
 	if err := c.addressProvider.Resolve(ctx, c.config.Addresses); err != nil {
 		level.Error(c.logger).Log("msg", "failed to resolve addresses for memcached", "addresses", strings.Join(c.config.Addresses, ","), "err", err)
@@ -748,3 +840,27 @@ func (c *MemcachedClient) resolveAddrs() error {
 
 	return c.selector.SetServers(servers...)
 }
+
+func (c *MemcachedClient) resolveZoneAddrs(ctx context.Context) error {
+	servers := map[string][]string{}
+	numServers := 0
+
+	for zone, addrs := range c.config.zoneAddresses() {
+		provider := c.zoneAddressProviders[zone]
+
+		// If some dns resolution fails, log the error.
+		if err := provider.Resolve(ctx, addrs); err != nil {
+			level.Error(c.logger).Log("msg", "failed to resolve addresses for memcached", "zone", zone, "addresses", strings.Join(addrs, ","), "err", err)
+		}
+
+		servers[zone] = provider.Addresses()
+		numServers += len(servers[zone])
+	}
+
+	// Fail in case no server address is resolved.
+	if numServers == 0 {
+		return fmt.Errorf("no server address resolved for %s", c.name)
+	}
+
+	return c.selector.(zoneAwareServerSelector).SetZoneServers(servers)
+}
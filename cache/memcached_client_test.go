@@ -47,6 +47,34 @@ func TestMemcachedClientConfig_Validate(t *testing.T) {
 			},
 			expectedErr: ErrInvalidReadBufferSizeBytes,
 		},
+		"should pass on ketama server selector with zones": {
+			setup: func(config *MemcachedClientConfig) {
+				config.ServerSelector = MemcachedServerSelectorKetama
+				config.Zone = "zone-a"
+				config.Addresses = flagext.StringSliceCSV{"zone-a=localhost:11211", "zone-b=localhost:11212"}
+			},
+			expectedErr: nil,
+		},
+		"should return error on unknown server selector": {
+			setup: func(config *MemcachedClientConfig) {
+				config.ServerSelector = "unknown"
+			},
+			expectedErr: ErrInvalidMemcachedServerSelector,
+		},
+		"should return error on zone configured with the jump hash server selector": {
+			setup: func(config *MemcachedClientConfig) {
+				config.ServerSelector = MemcachedServerSelectorJumpHash
+				config.Zone = "zone-a"
+			},
+			expectedErr: ErrMemcachedZoneAwarenessRequiresKetama,
+		},
+		"should return error on zone-prefixed addresses with the jump hash server selector": {
+			setup: func(config *MemcachedClientConfig) {
+				config.ServerSelector = MemcachedServerSelectorJumpHash
+				config.Addresses = flagext.StringSliceCSV{"zone-a=localhost:11211"}
+			},
+			expectedErr: ErrMemcachedZoneAwarenessRequiresKetama,
+		},
 	}
 
 	for testName, testData := range tests {
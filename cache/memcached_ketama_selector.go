@@ -0,0 +1,220 @@
+package cache
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/grafana/gomemcache/memcache"
+)
+
+const (
+	// ketamaPointsPerHash is the number of ring points computed from a single MD5 digest.
+	ketamaPointsPerHash = 4
+
+	// ketamaHashesPerServer is the number of MD5 digests computed for each server. Each server
+	// gets ketamaHashesPerServer * ketamaPointsPerHash virtual nodes in the ring, like libketama.
+	ketamaHashesPerServer = 40
+)
+
+// MemcachedKetamaSelector implements the memcache.ServerSelector interface, utilizing a
+// consistent hashing ring with virtual nodes to distribute keys to servers. The ring is
+// built like libketama does, so keys are sharded to the same servers by any other
+// ketama-compatible client configured with the same list of servers.
+//
+// Unlike MemcachedJumpHashSelector, the sharding doesn't depend on the order of the servers,
+// and adding or removing any server only moves the keys owned by that server.
+//
+// MemcachedKetamaSelector can optionally be zone-aware: servers are grouped by zone, and keys
+// are sharded to the servers in the preferred zone. If there are no servers in the preferred
+// zone, keys are sharded across the servers of all zones.
+type MemcachedKetamaSelector struct {
+	// zone is the preferred zone. An empty zone means no preference.
+	zone string
+
+	mtx sync.RWMutex
+	// zoneRings holds the ring of each zone, while allRing includes the servers of all zones.
+	zoneRings map[string]ketamaRing
+	allRing   ketamaRing
+	addrs     []net.Addr
+}
+
+// NewMemcachedKetamaSelector returns a MemcachedKetamaSelector preferring servers in the input
+// zone. If zone is empty, keys are sharded across all servers, regardless of their zone.
+func NewMemcachedKetamaSelector(zone string) *MemcachedKetamaSelector {
+	return &MemcachedKetamaSelector{zone: zone}
+}
+
+// SetServers changes a MemcachedKetamaSelector's set of servers at runtime and is safe
+// for concurrent use by multiple goroutines. All servers are assigned to the empty zone.
+//
+// SetServers returns an error if any of the server names fail to resolve. No attempt is
+// made to connect to the server. If any error occurs, no changes are made to the internal
+// server list.
+func (s *MemcachedKetamaSelector) SetServers(servers ...string) error {
+	return s.SetZoneServers(map[string][]string{"": servers})
+}
+
+// SetZoneServers is like SetServers, but takes the servers grouped by zone.
+func (s *MemcachedKetamaSelector) SetZoneServers(servers map[string][]string) error {
+	var (
+		zoneRings = make(map[string]ketamaRing, len(servers))
+		allRing   ketamaRing
+		addrs     []net.Addr
+		seen      = map[string]struct{}{}
+	)
+
+	for zone, zoneServers := range servers {
+		var ring ketamaRing
+
+		for _, server := range zoneServers {
+			addr, err := resolveMemcachedServer(server)
+			if err != nil {
+				return err
+			}
+
+			points := ketamaServerPoints(server, addr)
+			ring = append(ring, points...)
+			allRing = append(allRing, points...)
+
+			if _, ok := seen[addr.String()]; !ok {
+				seen[addr.String()] = struct{}{}
+				addrs = append(addrs, addr)
+			}
+		}
+
+		if len(ring) > 0 {
+			ring.sort()
+			zoneRings[zone] = ring
+		}
+	}
+
+	allRing.sort()
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.zoneRings = zoneRings
+	s.allRing = allRing
+	s.addrs = addrs
+	return nil
+}
+
+// PickServer returns the server address that a given item should be shared onto.
+func (s *MemcachedKetamaSelector) PickServer(key string) (net.Addr, error) {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	ring, ok := s.zoneRings[s.zone]
+	if s.zone == "" || !ok {
+		// No zone preference, or no servers in the preferred zone: fall back to all zones.
+		ring = s.allRing
+	}
+
+	if len(ring) == 0 {
+		return nil, memcache.ErrNoServers
+	}
+	return ring.get(ketamaKeyHash(key)), nil
+}
+
+// Each iterates over each server and calls the given function.
+// If f returns a non-nil error, iteration will stop and that
+// error will be returned.
+func (s *MemcachedKetamaSelector) Each(f func(net.Addr) error) error {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	for _, addr := range s.addrs {
+		if err := f(addr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type ketamaPoint struct {
+	hash uint32
+	addr net.Addr
+}
+
+// ketamaRing is a list of points sorted by hash.
+type ketamaRing []ketamaPoint
+
+func (r ketamaRing) sort() {
+	sort.Slice(r, func(i, j int) bool {
+		if r[i].hash != r[j].hash {
+			return r[i].hash < r[j].hash
+		}
+		// Break ties on the address, so that the ring doesn't depend on the servers order.
+		return r[i].addr.String() < r[j].addr.String()
+	})
+}
+
+// get returns the address of the first point whose hash is greater or equal than the input one,
+// wrapping around the ring. The ring must not be empty.
+func (r ketamaRing) get(hash uint32) net.Addr {
+	i := sort.Search(len(r), func(i int) bool { return r[i].hash >= hash })
+	if i == len(r) {
+		i = 0
+	}
+	return r[i].addr
+}
+
+// ketamaServerPoints returns the points of a server in the ring. Points are computed from the MD5
+// digest of "<server>-<index>", where server is the server name as configured, like libketama does.
+func ketamaServerPoints(server string, addr net.Addr) []ketamaPoint {
+	points := make([]ketamaPoint, 0, ketamaHashesPerServer*ketamaPointsPerHash)
+
+	for i := 0; i < ketamaHashesPerServer; i++ {
+		digest := md5.Sum([]byte(server + "-" + strconv.Itoa(i)))
+
+		for h := 0; h < ketamaPointsPerHash; h++ {
+			points = append(points, ketamaPoint{
+				hash: binary.LittleEndian.Uint32(digest[h*4:]),
+				addr: addr,
+			})
+		}
+	}
+
+	return points
+}
+
+// ketamaKeyHash returns the hash of a key in the ring, which is the little-endian
+// uint32 made of the first 4 bytes of the key MD5 digest.
+func ketamaKeyHash(key string) uint32 {
+	digest := md5.Sum([]byte(key))
+	return binary.LittleEndian.Uint32(digest[:4])
+}
+
+// resolveMemcachedServer resolves the address of a memcached server the same way memcache.ServerList does.
+func resolveMemcachedServer(server string) (net.Addr, error) {
+	if strings.Contains(server, "/") {
+		addr, err := net.ResolveUnixAddr("unix", server)
+		if err != nil {
+			return nil, err
+		}
+		return newMemcachedStaticAddr(addr), nil
+	}
+
+	addr, err := net.ResolveTCPAddr("tcp", server)
+	if err != nil {
+		return nil, err
+	}
+	return newMemcachedStaticAddr(addr), nil
+}
+
+// memcachedStaticAddr caches the Network() and String() values from any net.Addr.
+type memcachedStaticAddr struct {
+	network, str string
+}
+
+func newMemcachedStaticAddr(addr net.Addr) net.Addr {
+	return &memcachedStaticAddr{network: addr.Network(), str: addr.String()}
+}
+
+func (a *memcachedStaticAddr) Network() string { return a.network }
+func (a *memcachedStaticAddr) String() string  { return a.str }
@@ -0,0 +1,166 @@
+package cache
+
+import (
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/grafana/gomemcache/memcache"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemcachedKetamaSelector_PickServer(t *testing.T) {
+	s := NewMemcachedKetamaSelector("")
+	require.NoError(t, s.SetServers("10.0.0.1:11211", "10.0.0.2:11211", "10.0.0.3:11211"))
+
+	// The expected servers have been computed independently, following the libketama algorithm.
+	for key, expected := range map[string]string{
+		"foo":   "10.0.0.3:11211",
+		"bar":   "10.0.0.1:11211",
+		"baz":   "10.0.0.3:11211",
+		"qux":   "10.0.0.2:11211",
+		"hello": "10.0.0.1:11211",
+		"world": "10.0.0.3:11211",
+	} {
+		addr, err := s.PickServer(key)
+		require.NoError(t, err)
+		assert.Equal(t, expected, addr.String(), "key: %s", key)
+	}
+}
+
+func TestMemcachedKetamaSelector_PickServerShouldNotDependOnServersOrder(t *testing.T) {
+	s1 := NewMemcachedKetamaSelector("")
+	require.NoError(t, s1.SetServers("127.0.0.1:11211", "127.0.0.2:11211", "127.0.0.3:11211"))
+
+	s2 := NewMemcachedKetamaSelector("")
+	require.NoError(t, s2.SetServers("127.0.0.3:11211", "127.0.0.1:11211", "127.0.0.2:11211"))
+
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		addr1, err := s1.PickServer(key)
+		require.NoError(t, err)
+		addr2, err := s2.PickServer(key)
+		require.NoError(t, err)
+		require.Equal(t, addr1.String(), addr2.String())
+	}
+}
+
+func TestMemcachedKetamaSelector_PickServerShouldOnlyMoveKeysOfRemovedServer(t *testing.T) {
+	const numKeys = 10000
+
+	s := NewMemcachedKetamaSelector("")
+	require.NoError(t, s.SetServers("127.0.0.1:11211", "127.0.0.2:11211", "127.0.0.3:11211", "127.0.0.4:11211"))
+
+	before := make([]string, numKeys)
+	distribution := map[string]int{}
+	for i := 0; i < numKeys; i++ {
+		addr, err := s.PickServer(fmt.Sprintf("key-%d", i))
+		require.NoError(t, err)
+		before[i] = addr.String()
+		distribution[addr.String()]++
+	}
+
+	// Keys should be evenly distributed across servers, within some tolerance.
+	require.Len(t, distribution, 4)
+	for addr, count := range distribution {
+		assert.InDelta(t, numKeys/4, count, numKeys/10, "server: %s", addr)
+	}
+
+	require.NoError(t, s.SetServers("127.0.0.1:11211", "127.0.0.2:11211", "127.0.0.4:11211"))
+
+	for i := 0; i < numKeys; i++ {
+		addr, err := s.PickServer(fmt.Sprintf("key-%d", i))
+		require.NoError(t, err)
+
+		if before[i] != "127.0.0.3:11211" {
+			require.Equal(t, before[i], addr.String())
+		} else {
+			require.NotEqual(t, before[i], addr.String())
+		}
+	}
+}
+
+func TestMemcachedKetamaSelector_PickServerWithZones(t *testing.T) {
+	servers := map[string][]string{
+		"zone-a": {"127.0.0.1:11211", "127.0.0.2:11211"},
+		"zone-b": {"127.0.0.3:11211"},
+	}
+
+	tests := map[string]struct {
+		zone            string
+		expectedServers []string
+	}{
+		"should pick servers in the preferred zone": {
+			zone:            "zone-b",
+			expectedServers: []string{"127.0.0.3:11211"},
+		},
+		"should fall back to all zones if there are no servers in the preferred zone": {
+			zone:            "zone-c",
+			expectedServers: []string{"127.0.0.1:11211", "127.0.0.2:11211", "127.0.0.3:11211"},
+		},
+		"should pick servers in all zones if there's no preferred zone": {
+			zone:            "",
+			expectedServers: []string{"127.0.0.1:11211", "127.0.0.2:11211", "127.0.0.3:11211"},
+		},
+	}
+
+	for testName, testData := range tests {
+		t.Run(testName, func(t *testing.T) {
+			s := NewMemcachedKetamaSelector(testData.zone)
+			require.NoError(t, s.SetZoneServers(servers))
+
+			picked := map[string]struct{}{}
+			for i := 0; i < 1000; i++ {
+				addr, err := s.PickServer(fmt.Sprintf("key-%d", i))
+				require.NoError(t, err)
+				picked[addr.String()] = struct{}{}
+			}
+
+			actualServers := make([]string, 0, len(picked))
+			for addr := range picked {
+				actualServers = append(actualServers, addr)
+			}
+			assert.ElementsMatch(t, testData.expectedServers, actualServers)
+		})
+	}
+}
+
+func TestMemcachedKetamaSelector_NoServers(t *testing.T) {
+	s := NewMemcachedKetamaSelector("zone-a")
+
+	_, err := s.PickServer("foo")
+	require.ErrorIs(t, err, memcache.ErrNoServers)
+
+	require.NoError(t, s.SetServers())
+	_, err = s.PickServer("foo")
+	require.ErrorIs(t, err, memcache.ErrNoServers)
+}
+
+func TestMemcachedKetamaSelector_SetServersShouldNotChangeServersOnError(t *testing.T) {
+	s := NewMemcachedKetamaSelector("")
+	require.NoError(t, s.SetServers("127.0.0.1:11211"))
+	require.Error(t, s.SetServers("127.0.0.2:11211", "invalid:port"))
+
+	var addrs []string
+	require.NoError(t, s.Each(func(addr net.Addr) error {
+		addrs = append(addrs, addr.String())
+		return nil
+	}))
+	assert.Equal(t, []string{"127.0.0.1:11211"}, addrs)
+}
+
+func TestMemcachedKetamaSelector_Each(t *testing.T) {
+	s := NewMemcachedKetamaSelector("zone-a")
+	require.NoError(t, s.SetZoneServers(map[string][]string{
+		"zone-a": {"127.0.0.1:11211", "127.0.0.1:11211"},
+		"zone-b": {"127.0.0.2:11211"},
+	}))
+
+	var addrs []string
+	require.NoError(t, s.Each(func(addr net.Addr) error {
+		addrs = append(addrs, addr.String())
+		return nil
+	}))
+	assert.ElementsMatch(t, []string{"127.0.0.1:11211", "127.0.0.2:11211"}, addrs)
+}
@@ -25,6 +25,7 @@ import (
 var (
 	ErrRedisConfigNoEndpoint               = errors.New("no redis endpoint provided")
 	ErrRedisMaxAsyncConcurrencyNotPositive = errors.New("max async concurrency must be positive")
+	ErrRedisClusterModeWithMasterName      = errors.New("redis cluster mode can't be enabled when a redis sentinel master name is configured")
 
 	_ Cache = (*RedisClient)(nil)
 )
@@ -51,6 +52,14 @@ type RedisClientConfig struct {
 	// MasterName is Redis Sentinel master name. An empty string for Redis Server or Redis Cluster.
 	MasterName string `yaml:"master_name" category:"advanced"`
 
+	// ClusterMode forces the client to connect to a Redis Cluster, even if a single endpoint is configured.
+	// When disabled, Redis Cluster is used if multiple endpoints are configured and MasterName is empty.
+	ClusterMode bool `yaml:"cluster_mode" category:"advanced"`
+
+	// ReadFromReplicas enables sending read operations to replicas, when connecting
+	// to Redis Cluster or Redis Sentinel. Replicas may return stale data.
+	ReadFromReplicas bool `yaml:"read_from_replicas" category:"advanced"`
+
 	// DialTimeout specifies the client dial timeout.
 	DialTimeout time.Duration `yaml:"dial_timeout" category:"advanced"`
 
@@ -113,6 +122,8 @@ func (c *RedisClientConfig) RegisterFlagsWithPrefix(prefix string, f *flag.FlagS
 	f.Var(&c.Password, prefix+"password", "Password to use when connecting to Redis.")
 	f.IntVar(&c.DB, prefix+"db", 0, "Database index.")
 	f.StringVar(&c.MasterName, prefix+"master-name", "", "Redis Sentinel master name. An empty string for Redis Server or Redis Cluster.")
+	f.BoolVar(&c.ClusterMode, prefix+"cluster-mode", false, "Connect to a Redis Cluster even if a single endpoint is configured. Redis Cluster is always used when multiple endpoints are configured and the Redis Sentinel master name is empty.")
+	f.BoolVar(&c.ReadFromReplicas, prefix+"read-from-replicas", false, "Send read operations to replicas when connecting to Redis Cluster or Redis Sentinel. Replicas may return stale data.")
 	f.DurationVar(&c.DialTimeout, prefix+"dial-timeout", time.Second*5, "Client dial timeout.")
 	f.DurationVar(&c.ReadTimeout, prefix+"read-timeout", time.Second*3, "Client read timeout.")
 	f.DurationVar(&c.WriteTimeout, prefix+"write-timeout", time.Second*3, "Client write timeout.")
@@ -138,6 +149,9 @@ func (c *RedisClientConfig) Validate() error {
 	if c.MaxAsyncConcurrency <= 0 {
 		return ErrRedisMaxAsyncConcurrencyNotPositive
 	}
+	if c.ClusterMode && c.MasterName != "" {
+		return ErrRedisClusterModeWithMasterName
+	}
 	return nil
 }
 
@@ -147,6 +161,13 @@ type RedisClient struct {
 	client redis.UniversalClient
 	config RedisClientConfig
 
+	// cluster is the Redis Cluster client, or nil if not connecting to a Redis Cluster. When set,
+	// it's the same client as the universal one.
+	cluster *redis.ClusterClient
+
+	// node is the node label value used to track per-node metrics when not connecting to a Redis Cluster.
+	node string
+
 	// Name provides an identifier for the instantiated Client
 	name string
 
@@ -156,7 +177,39 @@ type RedisClient struct {
 	logger log.Logger
 
 	// Tracked metrics.
-	clientInfo prometheus.GaugeFunc
+	clientInfo  prometheus.GaugeFunc
+	nodeMetrics *redisNodeMetrics
+}
+
+// redisNodeMetrics tracks operations against each single Redis node. For Redis Cluster, a node is
+// identified by its address, while for Redis Server and Redis Sentinel the configured endpoint or
+// master name is used.
+type redisNodeMetrics struct {
+	operations *prometheus.CounterVec
+	failures   *prometheus.CounterVec
+	duration   *prometheus.HistogramVec
+}
+
+func newRedisNodeMetrics(reg prometheus.Registerer) *redisNodeMetrics {
+	return &redisNodeMetrics{
+		operations: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "node_operations_total",
+			Help: "Total number of operations against a single cache node.",
+		}, []string{"node", "operation"}),
+		failures: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "node_operation_failures_total",
+			Help: "Total number of operations against a single cache node that failed.",
+		}, []string{"node", "operation", "reason"}),
+		duration: promauto.With(reg).NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "node_operation_duration_seconds",
+			Help:    "Duration of operations against a single cache node.",
+			Buckets: []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.2, 0.5, 1, 3, 6, 10},
+			// Use defaults recommended by Prometheus for native histograms.
+			NativeHistogramBucketFactor:     1.1,
+			NativeHistogramMaxBucketNumber:  100,
+			NativeHistogramMinResetDuration: time.Hour,
+		}, []string{"node", "operation"}),
+	}
 }
 
 // NewRedisClient makes a new RedisClient.
@@ -192,12 +245,33 @@ func NewRedisClient(logger log.Logger, name string, config RedisClientConfig, re
 	metrics := newClientMetrics(reg)
 
 	c := &RedisClient{
-		baseClient: newBaseClient(logger, uint64(config.MaxItemSize), config.MaxAsyncBufferSize, config.MaxAsyncConcurrency, metrics),
-		client:     redis.NewUniversalClient(opts),
-		name:       name,
-		config:     config,
-		logger:     log.With(logger, "name", name),
+		baseClient:  newBaseClient(logger, uint64(config.MaxItemSize), config.MaxAsyncBufferSize, config.MaxAsyncConcurrency, metrics),
+		name:        name,
+		config:      config,
+		logger:      log.With(logger, "name", name),
+		nodeMetrics: newRedisNodeMetrics(reg),
 	}
+
+	switch {
+	case config.MasterName != "" && config.ReadFromReplicas:
+		// Reading from replicas is only supported by the failover cluster client, which
+		// routes read-only commands to a random replica (or the master).
+		failoverOpts := opts.Failover()
+		failoverOpts.RouteRandomly = true
+		c.client = redis.NewFailoverClusterClient(failoverOpts)
+		c.node = config.MasterName
+	case config.MasterName != "":
+		c.client = redis.NewFailoverClient(opts.Failover())
+		c.node = config.MasterName
+	case config.ClusterMode || len(opts.Addrs) > 1:
+		opts.ReadOnly = config.ReadFromReplicas
+		c.cluster = redis.NewClusterClient(opts.Cluster())
+		c.client = c.cluster
+	default:
+		c.client = redis.NewClient(opts.Simple())
+		c.node = opts.Addrs[0]
+	}
+
 	if config.MaxGetMultiConcurrency > 0 {
 		c.getMultiGate = gate.New(
 			prometheus.WrapRegistererWithPrefix(getMultiMetricNamePrefix, reg),
@@ -219,6 +293,8 @@ func NewRedisClient(logger log.Logger, name string, config RedisClientConfig, re
 			"max_item_size":             strconv.FormatUint(uint64(config.MaxItemSize), 10),
 			"max_get_multi_concurrency": strconv.Itoa(config.MaxGetMultiConcurrency),
 			"max_get_multi_batch_size":  strconv.Itoa(config.MaxGetMultiBatchSize),
+			"cluster_mode":              strconv.FormatBool(c.cluster != nil),
+			"read_from_replicas":        strconv.FormatBool(config.ReadFromReplicas),
 		},
 	},
 		func() float64 { return 1 },
@@ -270,36 +346,109 @@ func (c *RedisClient) GetMulti(ctx context.Context, keys []string, _ ...Option)
 	if len(keys) == 0 {
 		return nil
 	}
+	c.metrics.requests.Add(float64(len(keys)))
+
+	var results map[string][]byte
+	if c.cluster != nil {
+		results = c.clusterGetMulti(ctx, keys)
+	} else {
+		results = c.getMulti(ctx, keys)
+	}
+	if results == nil {
+		return nil
+	}
+
+	c.metrics.hits.Add(float64(len(results)))
+	return results
+}
+
+func (c *RedisClient) getMulti(ctx context.Context, keys []string) map[string][]byte {
 	var mu sync.Mutex
 	results := make(map[string][]byte, len(keys))
-	c.metrics.requests.Add(float64(len(keys)))
 
 	err := doWithBatch(ctx, len(keys), c.config.MaxGetMultiBatchSize, c.getMultiGate, func(startIndex, endIndex int) error {
 		start := time.Now()
 		c.metrics.operations.WithLabelValues(opGetMulti).Inc()
-
-		var cacheHitBytes int
+		c.nodeMetrics.operations.WithLabelValues(c.node, opGetMulti).Inc()
 
 		currentKeys := keys[startIndex:endIndex]
 		resp, err := c.client.MGet(ctx, currentKeys...).Result()
+		c.nodeMetrics.duration.WithLabelValues(c.node, opGetMulti).Observe(time.Since(start).Seconds())
 		if err != nil {
-			level.Warn(c.logger).Log("msg", "failed to mget items from redis", "err", err, "items", len(resp))
+			level.Warn(c.logger).Log("msg", "failed to mget items from redis", "node", c.node, "err", err, "items", len(currentKeys))
+			c.trackError(opGetMulti, err)
+			c.trackNodeError(c.node, opGetMulti, err)
 			return nil
 		}
+
 		mu.Lock()
 		defer mu.Unlock()
-		for i := 0; i < len(resp); i++ {
-			key := currentKeys[i]
-			switch val := resp[i].(type) {
-			case string:
-				cacheHitBytes += len(val)
-				results[key] = stringToBytes(val)
-			case nil: // miss
-			default:
-				level.Warn(c.logger).Log("msg",
-					fmt.Sprintf("unexpected redis mget result type:%T %v", resp[i], resp[i]))
+		cacheHitBytes := c.addMGetResults(results, currentKeys, resp)
+
+		c.metrics.dataSize.WithLabelValues(opGetMulti).Observe(float64(cacheHitBytes))
+		c.metrics.duration.WithLabelValues(opGetMulti).Observe(time.Since(start).Seconds())
+		return nil
+	})
+	if err != nil {
+		level.Warn(c.logger).Log("msg", "failed to mget items from redis", "err", err, "items", len(keys))
+		return nil
+	}
+
+	return results
+}
+
+// clusterGetMulti fetches keys from a Redis Cluster. Keys are grouped by the node serving them,
+// and each node is queried with one MGET per hash slot, pipelined in a single round trip.
+func (c *RedisClient) clusterGetMulti(ctx context.Context, keys []string) map[string][]byte {
+	batches, err := groupRedisClusterKeys(keys, c.config.MaxGetMultiBatchSize, func(key string) (*redis.Client, error) {
+		if c.config.ReadFromReplicas {
+			return c.cluster.SlaveForKey(ctx, key)
+		}
+		return c.cluster.MasterForKey(ctx, key)
+	})
+	if err != nil {
+		level.Warn(c.logger).Log("msg", "failed to lookup redis cluster nodes for mget", "err", err, "items", len(keys))
+		c.trackError(opGetMulti, err)
+		return nil
+	}
+
+	var mu sync.Mutex
+	results := make(map[string][]byte, len(keys))
+
+	err = doWithBatch(ctx, len(batches), 1, c.getMultiGate, func(batchIndex, _ int) error {
+		batch := batches[batchIndex]
+
+		start := time.Now()
+		c.metrics.operations.WithLabelValues(opGetMulti).Inc()
+		c.nodeMetrics.operations.WithLabelValues(batch.node, opGetMulti).Inc()
+
+		cmds, err := mgetBySlot(ctx, batch.client, batch.slots)
+		if isRedisClusterRedirectionError(err) {
+			// Slots have been migrated since we looked up the nodes. Reload the cluster
+			// state and let the cluster client follow the redirections.
+			level.Debug(c.logger).Log("msg", "redis cluster node redirected mget, reloading cluster state", "node", batch.node, "err", err)
+			c.cluster.ReloadState(ctx)
+			cmds, err = mgetBySlot(ctx, c.cluster, batch.slots)
+		}
+		c.nodeMetrics.duration.WithLabelValues(batch.node, opGetMulti).Observe(time.Since(start).Seconds())
+
+		if err != nil {
+			level.Warn(c.logger).Log("msg", "failed to mget items from redis", "node", batch.node, "err", err, "items", batch.size)
+			c.trackError(opGetMulti, err)
+			c.trackNodeError(batch.node, opGetMulti, err)
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		// Some of the pipelined commands may have succeeded even if others failed.
+		var cacheHitBytes int
+		for i, cmd := range cmds {
+			if resp, err := cmd.Result(); err == nil {
+				cacheHitBytes += c.addMGetResults(results, batch.slots[i], resp)
 			}
 		}
+
 		c.metrics.dataSize.WithLabelValues(opGetMulti).Observe(float64(cacheHitBytes))
 		c.metrics.duration.WithLabelValues(opGetMulti).Observe(time.Since(start).Seconds())
 		return nil
@@ -309,10 +458,55 @@ func (c *RedisClient) GetMulti(ctx context.Context, keys []string, _ ...Option)
 		return nil
 	}
 
-	c.metrics.hits.Add(float64(len(results)))
 	return results
 }
 
+// mgetBySlot runs one MGET for each group of keys in the same hash slot, pipelining them
+// if there's more than one group. It returns the first error encountered, if any.
+func mgetBySlot(ctx context.Context, client redis.Cmdable, slots [][]string) ([]*redis.SliceCmd, error) {
+	if len(slots) == 1 {
+		cmd := client.MGet(ctx, slots[0]...)
+		return []*redis.SliceCmd{cmd}, cmd.Err()
+	}
+
+	pipe := client.Pipeline()
+	cmds := make([]*redis.SliceCmd, 0, len(slots))
+	for _, keys := range slots {
+		cmds = append(cmds, pipe.MGet(ctx, keys...))
+	}
+	_, err := pipe.Exec(ctx)
+	return cmds, err
+}
+
+// addMGetResults adds the hits of an MGET response to results, and returns the number of bytes fetched.
+func (c *RedisClient) addMGetResults(results map[string][]byte, keys []string, resp []interface{}) int {
+	var cacheHitBytes int
+	for i := 0; i < len(resp); i++ {
+		key := keys[i]
+		switch val := resp[i].(type) {
+		case string:
+			cacheHitBytes += len(val)
+			results[key] = stringToBytes(val)
+		case nil: // miss
+		default:
+			level.Warn(c.logger).Log("msg",
+				fmt.Sprintf("unexpected redis mget result type:%T %v", resp[i], resp[i]))
+		}
+	}
+	return cacheHitBytes
+}
+
+// trackNodeError tracks a failed operation against a single node. Errors returned by the Redis
+// server are tracked as server errors.
+func (c *RedisClient) trackNodeError(node, op string, err error) {
+	reason := errorReason(err)
+	var redisErr redis.Error
+	if reason == reasonOther && errors.As(err, &redisErr) {
+		reason = reasonServerError
+	}
+	c.nodeMetrics.failures.WithLabelValues(node, op, reason).Inc()
+}
+
 // Delete implement RemoteCacheClient.
 func (c *RedisClient) Delete(ctx context.Context, key string) error {
 	return c.delete(ctx, key, func(ctx context.Context, key string) error {
@@ -9,7 +9,11 @@ import (
 
 	"github.com/alicebob/miniredis"
 	"github.com/go-kit/log"
+	"github.com/go-redis/redis/v8"
+	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
 	dstls "github.com/grafana/dskit/crypto/tls"
@@ -249,6 +253,28 @@ func TestValidateRedisConfig(t *testing.T) {
 			},
 			expectErr: true,
 		},
+		{
+			name: "clusterModeConfig",
+			config: func() RedisClientConfig {
+				cfg := defaultRedisClientConfig
+				cfg.Endpoint = flagext.StringSliceCSV{"127.0.0.1:6789"}
+				cfg.ClusterMode = true
+				cfg.ReadFromReplicas = true
+				return cfg
+			},
+			expectErr: false,
+		},
+		{
+			name: "sentinelReadFromReplicasConfig",
+			config: func() RedisClientConfig {
+				cfg := defaultRedisClientConfig
+				cfg.Endpoint = flagext.StringSliceCSV{"127.0.0.1:6789", "127.0.0.1:6790"}
+				cfg.MasterName = "master"
+				cfg.ReadFromReplicas = true
+				return cfg
+			},
+			expectErr: false,
+		},
 	}
 
 	for _, tt := range tests {
@@ -271,3 +297,146 @@ func TestValidateRedisConfig(t *testing.T) {
 		})
 	}
 }
+
+func TestRedisClientConfig_Validate(t *testing.T) {
+	cfg := defaultRedisClientConfig
+	cfg.Endpoint = flagext.StringSliceCSV{"127.0.0.1:6789"}
+	require.NoError(t, cfg.Validate())
+
+	cfg.ClusterMode = true
+	require.NoError(t, cfg.Validate())
+
+	cfg.MasterName = "master"
+	require.ErrorIs(t, cfg.Validate(), ErrRedisClusterModeWithMasterName)
+}
+
+func TestRedisClient_GetMultiShouldTrackNodeMetrics(t *testing.T) {
+	s, err := miniredis.Run()
+	require.NoError(t, err)
+	defer s.Close()
+
+	cfg := defaultRedisClientConfig
+	cfg.Endpoint = flagext.StringSliceCSV{s.Addr()}
+
+	reg := prometheus.NewPedanticRegistry()
+	c, err := NewRedisClient(log.NewNopLogger(), "test", cfg, reg)
+	require.NoError(t, err)
+	defer c.Stop()
+
+	node := s.Addr()
+	require.NoError(t, c.Set(context.Background(), "key1", []byte{1}, time.Hour))
+	require.Equal(t, map[string][]byte{"key1": {1}}, c.GetMulti(context.Background(), []string{"key1", "key2"}))
+	require.Equal(t, float64(1), testutil.ToFloat64(c.nodeMetrics.operations.WithLabelValues(node, opGetMulti)))
+
+	// Once the server is down, the failure should be tracked for the node.
+	s.Close()
+	require.Empty(t, c.GetMulti(context.Background(), []string{"key1"}))
+	require.Equal(t, float64(2), testutil.ToFloat64(c.nodeMetrics.operations.WithLabelValues(node, opGetMulti)))
+
+	var nodeFailures float64
+	for _, reason := range []string{reasonConnectTimeout, reasonTimeout, reasonNetworkError, reasonServerError, reasonOther} {
+		nodeFailures += testutil.ToFloat64(c.nodeMetrics.failures.WithLabelValues(node, opGetMulti, reason))
+	}
+	require.Equal(t, float64(1), nodeFailures)
+}
+
+func TestRedisClusterSlot(t *testing.T) {
+	tests := map[string]int{
+		// Expected slots are the ones returned by Redis CLUSTER KEYSLOT.
+		"":                     0,
+		"foo":                  12182,
+		"somekey":              11058,
+		"123456789":            12739,
+		"foo{hash_tag}":        2515,
+		"{user1000}.following": redisClusterSlot("user1000"),
+		"{user1000}.followers": redisClusterSlot("user1000"),
+		// Only the first hash tag is considered.
+		"foo{bar}{zap}": redisClusterSlot("bar"),
+		"foo{{bar}}zap": redisClusterSlot("{bar"),
+		// Empty hash tags are ignored and the whole key is hashed.
+		"foo{}{bar}": int(crc16("foo{}{bar}") % redisClusterSlots),
+		// Keys with an unterminated hash tag are hashed as a whole.
+		"foo{bar": int(crc16("foo{bar") % redisClusterSlots),
+	}
+
+	for key, expected := range tests {
+		t.Run(key, func(t *testing.T) {
+			require.Equal(t, expected, redisClusterSlot(key))
+		})
+	}
+
+	require.NotEqual(t, redisClusterSlot("foo{}{bar}"), redisClusterSlot("bar"))
+	require.NotEqual(t, redisClusterSlot("foo{bar"), redisClusterSlot("bar"))
+}
+
+func TestGroupRedisClusterKeys(t *testing.T) {
+	// Fake a cluster made of two nodes, each one serving half of the slots.
+	nodes := []*redis.Client{
+		redis.NewClient(&redis.Options{Addr: "node-1:6379"}),
+		redis.NewClient(&redis.Options{Addr: "node-2:6379"}),
+	}
+	defer func() {
+		for _, node := range nodes {
+			require.NoError(t, node.Close())
+		}
+	}()
+
+	nodeForKey := func(key string) (*redis.Client, error) {
+		return nodes[redisClusterSlot(key)*len(nodes)/redisClusterSlots], nil
+	}
+
+	// "{a}" keys are served by node-2 (slot 15495), while "{b}" and "{c}" keys are served by node-1 (slots 3300 and 7365).
+	keys := []string{"{a}1", "{b}1", "{a}2", "{c}1", "{b}2", "{a}3"}
+
+	t.Run("no batch size limit", func(t *testing.T) {
+		batches, err := groupRedisClusterKeys(keys, 0, nodeForKey)
+		require.NoError(t, err)
+		require.Len(t, batches, 2)
+
+		assert.Equal(t, "node-2:6379", batches[0].node)
+		assert.Equal(t, [][]string{{"{a}1", "{a}2", "{a}3"}}, batches[0].slots)
+		assert.Equal(t, 3, batches[0].size)
+
+		assert.Equal(t, "node-1:6379", batches[1].node)
+		assert.Equal(t, [][]string{{"{b}1", "{b}2"}, {"{c}1"}}, batches[1].slots)
+		assert.Equal(t, 3, batches[1].size)
+	})
+
+	t.Run("batch size limit", func(t *testing.T) {
+		batches, err := groupRedisClusterKeys(keys, 2, nodeForKey)
+		require.NoError(t, err)
+		require.Len(t, batches, 4)
+
+		assert.Equal(t, "node-2:6379", batches[0].node)
+		assert.Equal(t, [][]string{{"{a}1", "{a}2"}}, batches[0].slots)
+		assert.Equal(t, "node-2:6379", batches[1].node)
+		assert.Equal(t, [][]string{{"{a}3"}}, batches[1].slots)
+		assert.Equal(t, "node-1:6379", batches[2].node)
+		assert.Equal(t, [][]string{{"{b}1", "{b}2"}}, batches[2].slots)
+		assert.Equal(t, "node-1:6379", batches[3].node)
+		assert.Equal(t, [][]string{{"{c}1"}}, batches[3].slots)
+	})
+
+	t.Run("node lookup failure", func(t *testing.T) {
+		_, err := groupRedisClusterKeys(keys, 0, func(string) (*redis.Client, error) {
+			return nil, errors.New("cluster state not available")
+		})
+		require.Error(t, err)
+	})
+}
+
+func TestIsRedisClusterRedirectionError(t *testing.T) {
+	assert.False(t, isRedisClusterRedirectionError(nil))
+	assert.False(t, isRedisClusterRedirectionError(errors.New("MOVED 3999 127.0.0.1:6381")))
+	assert.False(t, isRedisClusterRedirectionError(redis.ErrClosed))
+	assert.True(t, isRedisClusterRedirectionError(redisProtoError("MOVED 3999 127.0.0.1:6381")))
+	assert.True(t, isRedisClusterRedirectionError(redisProtoError("ASK 3999 127.0.0.1:6381")))
+	assert.False(t, isRedisClusterRedirectionError(redisProtoError("ERR unknown command")))
+}
+
+// redisProtoError is an error returned by the Redis server.
+type redisProtoError string
+
+func (e redisProtoError) Error() string { return string(e) }
+
+func (redisProtoError) RedisError() {}
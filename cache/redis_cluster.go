@@ -0,0 +1,118 @@
+package cache
+
+import (
+	"strings"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/pkg/errors"
+)
+
+// redisClusterSlots is the number of hash slots in a Redis Cluster.
+const redisClusterSlots = 16384
+
+// redisClusterSlot returns the Redis Cluster hash slot of the input key. If the key contains
+// a non-empty hash tag (the substring between the first "{" and the following "}"), only the
+// hash tag is hashed, so that keys sharing the same hash tag are guaranteed to be in the same slot.
+func redisClusterSlot(key string) int {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+	return int(crc16(key) % redisClusterSlots)
+}
+
+// crc16 computes the CRC16-CCITT (XMODEM) checksum used by Redis Cluster for key hashing.
+func crc16(s string) uint16 {
+	var crc uint16
+	for i := 0; i < len(s); i++ {
+		crc ^= uint16(s[i]) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// redisClusterGetMultiBatch is a batch of keys served by a single Redis Cluster node.
+type redisClusterGetMultiBatch struct {
+	// node is the address of the node serving the batch.
+	node   string
+	client *redis.Client
+
+	// slots holds the batch keys grouped by hash slot, because a single MGET can't span multiple slots.
+	slots [][]string
+	size  int
+}
+
+// groupRedisClusterKeys splits the input keys into batches, where each batch only contains keys served by
+// the same node and is no bigger than batchSize keys (0 means no limit). The node serving a key is resolved
+// by nodeForKey, which is called once per hash slot.
+func groupRedisClusterKeys(keys []string, batchSize int, nodeForKey func(key string) (*redis.Client, error)) ([]redisClusterGetMultiBatch, error) {
+	// Group keys by slot, preserving the order in which slots are first seen.
+	var (
+		slots    []int
+		slotKeys = map[int][]string{}
+	)
+	for _, key := range keys {
+		slot := redisClusterSlot(key)
+		if _, ok := slotKeys[slot]; !ok {
+			slots = append(slots, slot)
+		}
+		slotKeys[slot] = append(slotKeys[slot], key)
+	}
+
+	// Group slots by node. We keep track of the last batch of each node, so that a new batch
+	// is created only once the previous one is full.
+	var (
+		batches     []redisClusterGetMultiBatch
+		nodeBatches = map[string]int{}
+	)
+	for _, slot := range slots {
+		remaining := slotKeys[slot]
+
+		client, err := nodeForKey(remaining[0])
+		if err != nil {
+			return nil, err
+		}
+		node := client.Options().Addr
+
+		for len(remaining) > 0 {
+			idx, ok := nodeBatches[node]
+			if !ok || (batchSize > 0 && batches[idx].size >= batchSize) {
+				batches = append(batches, redisClusterGetMultiBatch{node: node, client: client})
+				idx = len(batches) - 1
+				nodeBatches[node] = idx
+			}
+
+			n := len(remaining)
+			if batchSize > 0 {
+				n = min(n, batchSize-batches[idx].size)
+			}
+
+			batches[idx].slots = append(batches[idx].slots, remaining[:n])
+			batches[idx].size += n
+			remaining = remaining[n:]
+		}
+	}
+
+	return batches, nil
+}
+
+// isRedisClusterRedirectionError returns whether the input error is a MOVED or ASK redirection
+// returned by a Redis Cluster node which doesn't serve the requested slot anymore.
+func isRedisClusterRedirectionError(err error) bool {
+	if err == nil {
+		return false
+	}
+	var redisErr redis.Error
+	if !errors.As(err, &redisErr) {
+		return false
+	}
+	msg := redisErr.Error()
+	return strings.HasPrefix(msg, "MOVED ") || strings.HasPrefix(msg, "ASK ")
+}
@@ -0,0 +1,183 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var _ Cache = (*SingleFlightCache)(nil)
+
+// SingleFlightCache wraps a Cache and coalesces concurrent lookups of the same keys: when a key is
+// requested while a lookup for the same key is already in-flight (even as part of a different set of
+// keys), the caller waits for the in-flight lookup and shares its result instead of looking up the key
+// again. Only the keys which are not in-flight are looked up in the wrapped Cache. If the in-flight
+// lookup is cut short because the context of its caller is canceled, the waiting callers look up
+// the key again with their own context.
+//
+// Values returned by GetMulti may be shared between callers, so they must not be modified.
+// Lookups with a custom Allocator are never coalesced, because the allocated values are owned
+// by a single caller.
+type SingleFlightCache struct {
+	next Cache
+
+	mtx      sync.Mutex
+	inflight map[string]*singleFlightCall
+
+	requests     prometheus.Counter
+	deduplicated prometheus.Counter
+}
+
+// singleFlightCall is an in-flight lookup of a single key.
+type singleFlightCall struct {
+	// done is closed once the lookup has completed.
+	done  chan struct{}
+	value []byte
+	found bool
+
+	// canceled is whether the lookup was cut short by the cancellation of the context of the
+	// caller owning it, in which case a miss doesn't mean the key is not in the cache.
+	canceled bool
+}
+
+// NewSingleFlightCache makes a new SingleFlightCache.
+func NewSingleFlightCache(next Cache, name string, reg prometheus.Registerer) *SingleFlightCache {
+	return &SingleFlightCache{
+		next:     next,
+		inflight: map[string]*singleFlightCall{},
+
+		requests: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name:        "cache_singleflight_requests_total",
+			Help:        "Total number of items requests to the single-flight cache.",
+			ConstLabels: map[string]string{"name": name},
+		}),
+		deduplicated: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name:        "cache_singleflight_deduplicated_total",
+			Help:        "Total number of items requests to the single-flight cache served by an in-flight lookup of another caller.",
+			ConstLabels: map[string]string{"name": name},
+		}),
+	}
+}
+
+// GetMulti implements Cache. If ctx is canceled while waiting for in-flight lookups of other
+// callers, the keys which are still in-flight are returned as misses.
+func (c *SingleFlightCache) GetMulti(ctx context.Context, keys []string, opts ...Option) map[string][]byte {
+	c.requests.Add(float64(len(keys)))
+
+	options := &Options{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	if options.Alloc != nil {
+		return c.next.GetMulti(ctx, keys, opts...)
+	}
+
+	var (
+		owned   = make([]string, 0, len(keys))
+		calls   = make(map[string]*singleFlightCall, len(keys))
+		waiting = map[string]*singleFlightCall{}
+	)
+
+	c.mtx.Lock()
+	for _, key := range keys {
+		if _, ok := calls[key]; ok {
+			// Duplicated key in the same request.
+			continue
+		}
+		if _, ok := waiting[key]; ok {
+			continue
+		}
+
+		if call, ok := c.inflight[key]; ok {
+			waiting[key] = call
+			continue
+		}
+
+		call := &singleFlightCall{done: make(chan struct{})}
+		c.inflight[key] = call
+		calls[key] = call
+		owned = append(owned, key)
+	}
+	c.mtx.Unlock()
+
+	c.deduplicated.Add(float64(len(waiting)))
+
+	var found map[string][]byte
+	if len(owned) > 0 {
+		func() {
+			// Always complete the calls, so that waiting callers never hang.
+			defer func() { c.complete(calls, found, ctx.Err() != nil) }()
+			found = c.next.GetMulti(ctx, owned, opts...)
+		}()
+	}
+	if found == nil {
+		found = make(map[string][]byte, len(waiting))
+	}
+
+	var refetch []string
+	for key, call := range waiting {
+		select {
+		case <-call.done:
+			if call.found {
+				found[key] = call.value
+			} else if call.canceled {
+				refetch = append(refetch, key)
+			}
+		case <-ctx.Done():
+			return found
+		}
+	}
+
+	// Look up again the keys whose shared lookup was cut short by another caller.
+	if len(refetch) > 0 {
+		for key, value := range c.next.GetMulti(ctx, refetch, opts...) {
+			found[key] = value
+		}
+	}
+
+	return found
+}
+
+// complete publishes the results of the input calls to the waiting callers, and removes them from the in-flight ones.
+func (c *SingleFlightCache) complete(calls map[string]*singleFlightCall, results map[string][]byte, canceled bool) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	for key, call := range calls {
+		call.value, call.found = results[key]
+		call.canceled = canceled
+		delete(c.inflight, key)
+		close(call.done)
+	}
+}
+
+func (c *SingleFlightCache) SetAsync(key string, value []byte, ttl time.Duration) {
+	c.next.SetAsync(key, value, ttl)
+}
+
+func (c *SingleFlightCache) SetMultiAsync(data map[string][]byte, ttl time.Duration) {
+	c.next.SetMultiAsync(data, ttl)
+}
+
+func (c *SingleFlightCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return c.next.Set(ctx, key, value, ttl)
+}
+
+func (c *SingleFlightCache) Add(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return c.next.Add(ctx, key, value, ttl)
+}
+
+func (c *SingleFlightCache) Delete(ctx context.Context, key string) error {
+	return c.next.Delete(ctx, key)
+}
+
+func (c *SingleFlightCache) Stop() {
+	c.next.Stop()
+}
+
+func (c *SingleFlightCache) Name() string {
+	return c.next.Name()
+}
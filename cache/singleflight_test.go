@@ -0,0 +1,155 @@
+package cache
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/dskit/test"
+)
+
+func TestSingleFlightCache_GetMultiShouldCoalesceInFlightLookups(t *testing.T) {
+	ctx := context.Background()
+	backend := newBlockingMockCache()
+	backend.SetMultiAsync(map[string][]byte{"a": []byte("1"), "b": []byte("2"), "c": []byte("3")}, time.Hour)
+
+	reg := prometheus.NewPedanticRegistry()
+	c := NewSingleFlightCache(backend, "test", reg)
+
+	var (
+		wg                    sync.WaitGroup
+		first, second         map[string][]byte
+		firstKeys, secondKeys []string
+	)
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		first = c.GetMulti(ctx, []string{"a", "b", "missing"})
+	}()
+	firstKeys = <-backend.started
+
+	// The second lookup partially overlaps with the in-flight one: only the keys
+	// which are not in-flight should be looked up.
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		second = c.GetMulti(ctx, []string{"b", "c", "missing", "c"})
+	}()
+	secondKeys = <-backend.started
+
+	close(backend.release)
+	wg.Wait()
+
+	assert.Equal(t, []string{"a", "b", "missing"}, firstKeys)
+	assert.Equal(t, []string{"c"}, secondKeys)
+	assert.Equal(t, map[string][]byte{"a": []byte("1"), "b": []byte("2")}, first)
+	assert.Equal(t, map[string][]byte{"b": []byte("2"), "c": []byte("3")}, second)
+	assert.Empty(t, c.inflight)
+
+	require.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(`
+		# HELP cache_singleflight_deduplicated_total Total number of items requests to the single-flight cache served by an in-flight lookup of another caller.
+		# TYPE cache_singleflight_deduplicated_total counter
+		cache_singleflight_deduplicated_total{name="test"} 2
+		# HELP cache_singleflight_requests_total Total number of items requests to the single-flight cache.
+		# TYPE cache_singleflight_requests_total counter
+		cache_singleflight_requests_total{name="test"} 7
+	`)))
+}
+
+func TestSingleFlightCache_GetMultiShouldReturnMissesOnContextCanceledWhileWaiting(t *testing.T) {
+	backend := newBlockingMockCache()
+	backend.SetAsync("a", []byte("1"), time.Hour)
+	c := NewSingleFlightCache(backend, "test", nil)
+
+	done := make(chan map[string][]byte)
+	go func() {
+		done <- c.GetMulti(context.Background(), []string{"a"})
+	}()
+	<-backend.started
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Empty(t, c.GetMulti(ctx, []string{"a"}))
+
+	close(backend.release)
+	assert.Equal(t, map[string][]byte{"a": []byte("1")}, <-done)
+}
+
+func TestSingleFlightCache_GetMultiShouldLookUpAgainKeysWhoseInFlightLookupWasCanceled(t *testing.T) {
+	backend := newBlockingMockCache()
+	backend.SetAsync("a", []byte("1"), time.Hour)
+	c := NewSingleFlightCache(backend, "test", nil)
+
+	// The first caller owns the lookup, and its context is canceled while the lookup is in-flight.
+	ownerCtx, cancel := context.WithCancel(context.Background())
+	ownerDone := make(chan map[string][]byte)
+	go func() {
+		ownerDone <- c.GetMulti(ownerCtx, []string{"a"})
+	}()
+	<-backend.started
+
+	waiterDone := make(chan map[string][]byte)
+	go func() {
+		waiterDone <- c.GetMulti(context.Background(), []string{"a"})
+	}()
+	test.Poll(t, time.Second, 1.0, func() interface{} {
+		return testutil.ToFloat64(c.deduplicated)
+	})
+
+	cancel()
+	close(backend.release)
+	assert.Empty(t, <-ownerDone)
+
+	// The waiting caller should look up the key again, with its own context.
+	assert.Equal(t, []string{"a"}, <-backend.started)
+	assert.Equal(t, map[string][]byte{"a": []byte("1")}, <-waiterDone)
+	assert.Empty(t, c.inflight)
+}
+
+func TestSingleFlightCache_GetMultiShouldNotCoalesceLookupsWithAllocator(t *testing.T) {
+	backend := newBlockingMockCache()
+	close(backend.release)
+	backend.SetAsync("a", []byte("1"), time.Hour)
+	c := NewSingleFlightCache(backend, "test", nil)
+
+	// Simulate an in-flight lookup.
+	c.inflight["a"] = &singleFlightCall{done: make(chan struct{})}
+
+	go func() { <-backend.started }()
+	assert.Equal(t, map[string][]byte{"a": []byte("1")}, c.GetMulti(context.Background(), []string{"a"}, WithAllocator(&nopAllocator{})))
+}
+
+// blockingMockCache is a MockCache whose GetMulti() notifies the requested keys
+// on the started channel and then blocks until release is closed. Like real clients,
+// it returns no values if the context has been canceled in the meanwhile.
+type blockingMockCache struct {
+	*MockCache
+
+	started chan []string
+	release chan struct{}
+}
+
+func newBlockingMockCache() *blockingMockCache {
+	return &blockingMockCache{
+		MockCache: NewMockCache(),
+		started:   make(chan []string),
+		release:   make(chan struct{}),
+	}
+}
+
+func (m *blockingMockCache) GetMulti(ctx context.Context, keys []string, opts ...Option) map[string][]byte {
+	m.started <- keys
+	<-m.release
+	if ctx.Err() != nil {
+		return map[string][]byte{}
+	}
+	return m.MockCache.GetMulti(ctx, keys, opts...)
+}
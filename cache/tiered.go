@@ -0,0 +1,467 @@
+package cache
+
+import (
+	"context"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/grafana/dskit/concurrency"
+)
+
+const (
+	// TieredCacheWriteThrough writes to the second tier synchronously, and then to the first tier.
+	TieredCacheWriteThrough = "write-through"
+
+	// TieredCacheWriteBack writes to the first tier synchronously, and asynchronously to the second tier.
+	TieredCacheWriteBack = "write-back"
+
+	tierL1 = "l1"
+	tierL2 = "l2"
+
+	// tieredEntryHeaderSize is the size of the header prepended to the values stored in the first tier:
+	// 1 byte for the entry kind and 8 bytes for the time until which the entry is fresh.
+	tieredEntryHeaderSize = 9
+
+	tieredEntryValue    byte = 1
+	tieredEntryNegative byte = 2
+
+	// tieredCacheRevalidationTimeout is the timeout of the background refresh of stale entries.
+	tieredCacheRevalidationTimeout = 10 * time.Second
+
+	// tieredCacheAsyncWriteBufferSize and tieredCacheAsyncWriteConcurrency configure the queue of the
+	// asynchronous writes, when the write mode is TieredCacheWriteThrough.
+	tieredCacheAsyncWriteBufferSize  = 10000
+	tieredCacheAsyncWriteConcurrency = 10
+
+	// tieredCacheL2WriteConcurrency is the maximum number of values of an asynchronous write stored
+	// concurrently in the second tier, when the write mode is TieredCacheWriteThrough.
+	tieredCacheL2WriteConcurrency = 10
+)
+
+var (
+	ErrInvalidTieredCacheWriteMode = fmt.Errorf("invalid tiered cache write mode (supported values: %s, %s)", TieredCacheWriteThrough, TieredCacheWriteBack)
+	ErrInvalidTieredCacheL1TTL     = errors.New("tiered cache L1 TTL must be positive")
+	ErrInvalidTieredCacheTTL       = errors.New("tiered cache negative and stale TTLs must not be negative")
+
+	_ Cache = (*TieredCache)(nil)
+)
+
+// TieredCacheConfig is the config accepted by TieredCache.
+type TieredCacheConfig struct {
+	// WriteMode is either TieredCacheWriteThrough or TieredCacheWriteBack.
+	WriteMode string `yaml:"write_mode" category:"experimental"`
+
+	// L1TTL is the maximum TTL of entries stored in the first tier, after which they're considered stale.
+	// It's also the TTL of the entries fetched from the second tier, whose actual TTL is unknown.
+	L1TTL time.Duration `yaml:"l1_ttl" category:"experimental"`
+
+	// NegativeTTL is the TTL of the misses cached in the first tier, to avoid looking up keys
+	// missing from the second tier over and over. 0 disables negative caching.
+	NegativeTTL time.Duration `yaml:"negative_ttl" category:"experimental"`
+
+	// StaleTTL is how long stale entries in the first tier can still be returned, while they're
+	// refreshed from the second tier in the background. 0 disables stale-while-revalidate.
+	StaleTTL time.Duration `yaml:"stale_ttl" category:"experimental"`
+}
+
+// RegisterFlagsWithPrefix adds the flags required to config this to the given FlagSet.
+func (cfg *TieredCacheConfig) RegisterFlagsWithPrefix(prefix string, f *flag.FlagSet) {
+	f.StringVar(&cfg.WriteMode, prefix+"write-mode", TieredCacheWriteThrough, fmt.Sprintf("How writes are propagated to the tiers. Supported values: %s (the second tier is written synchronously), %s (the second tier is written asynchronously).", TieredCacheWriteThrough, TieredCacheWriteBack))
+	f.DurationVar(&cfg.L1TTL, prefix+"l1-ttl", time.Minute, "The maximum TTL of entries stored in the first tier, after which they're considered stale. It's also the TTL of the entries fetched from the second tier.")
+	f.DurationVar(&cfg.NegativeTTL, prefix+"negative-ttl", 0, "The TTL of the misses cached in the first tier. 0 disables negative caching.")
+	f.DurationVar(&cfg.StaleTTL, prefix+"stale-ttl", 0, "How long stale entries in the first tier can still be returned while they're refreshed from the second tier in the background. 0 disables stale-while-revalidate.")
+}
+
+// Validate the config.
+func (cfg *TieredCacheConfig) Validate() error {
+	if cfg.WriteMode != TieredCacheWriteThrough && cfg.WriteMode != TieredCacheWriteBack {
+		return ErrInvalidTieredCacheWriteMode
+	}
+	if cfg.L1TTL <= 0 {
+		return ErrInvalidTieredCacheL1TTL
+	}
+	if cfg.NegativeTTL < 0 || cfg.StaleTTL < 0 {
+		return ErrInvalidTieredCacheTTL
+	}
+	return nil
+}
+
+// TieredCache composes two caches: a first tier (typically small and fast, e.g. in-process) in front of
+// a second tier (typically large and shared, e.g. memcached). Lookups are served from the first tier when
+// possible, and entries fetched from the second tier are stored in the first tier.
+//
+// Values stored in the first tier are prefixed by a small header, so the first tier must only be accessed
+// through the TieredCache. Values stored in the second tier are stored as is.
+type TieredCache struct {
+	l1     Cache
+	l2     Cache
+	cfg    TieredCacheConfig
+	name   string
+	logger log.Logger
+
+	// now is the clock used to check entries freshness. It's a field to allow overriding it in tests.
+	now func() time.Time
+
+	// revalidating tracks the keys whose stale entry is currently being refreshed.
+	revalidatingMx sync.Mutex
+	revalidating   map[string]struct{}
+	revalidations  sync.WaitGroup
+
+	// asyncWrites runs the asynchronous writes with TieredCacheWriteThrough, which store the values in the
+	// first tier only once they've been stored in the second tier.
+	asyncWrites *asyncQueue
+
+	requests          *prometheus.CounterVec
+	hits              *prometheus.CounterVec
+	negativeHits      prometheus.Counter
+	staleHits         prometheus.Counter
+	revalidated       prometheus.Counter
+	revalidatedMissed prometheus.Counter
+}
+
+// NewTieredCache makes a new TieredCache, with l1 as first tier and l2 as second tier.
+func NewTieredCache(name string, l1, l2 Cache, cfg TieredCacheConfig, logger log.Logger, reg prometheus.Registerer) *TieredCache {
+	c := &TieredCache{
+		l1:           l1,
+		l2:           l2,
+		cfg:          cfg,
+		name:         name,
+		logger:       log.With(logger, "name", name),
+		now:          time.Now,
+		revalidating: map[string]struct{}{},
+		asyncWrites:  newAsyncQueue(tieredCacheAsyncWriteBufferSize, tieredCacheAsyncWriteConcurrency),
+
+		requests: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name:        "cache_tier_requests_total",
+			Help:        "Total number of items requests to each tier of the tiered cache.",
+			ConstLabels: map[string]string{"name": name},
+		}, []string{"tier"}),
+		hits: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name:        "cache_tier_hits_total",
+			Help:        "Total number of items requests to each tier of the tiered cache that were a hit.",
+			ConstLabels: map[string]string{"name": name},
+		}, []string{"tier"}),
+		negativeHits: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name:        "cache_tier_negative_hits_total",
+			Help:        "Total number of items requests to the tiered cache that were a cached miss in the first tier.",
+			ConstLabels: map[string]string{"name": name},
+		}),
+		staleHits: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name:        "cache_tier_stale_hits_total",
+			Help:        "Total number of items requests to the tiered cache that returned a stale entry from the first tier.",
+			ConstLabels: map[string]string{"name": name},
+		}),
+		revalidated: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name:        "cache_tier_revalidations_total",
+			Help:        "Total number of stale entries refreshed from the second tier in the background.",
+			ConstLabels: map[string]string{"name": name},
+		}),
+		revalidatedMissed: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name:        "cache_tier_revalidations_missed_total",
+			Help:        "Total number of stale entries which couldn't be refreshed because they were missing from the second tier.",
+			ConstLabels: map[string]string{"name": name},
+		}),
+	}
+
+	for _, tier := range []string{tierL1, tierL2} {
+		c.requests.WithLabelValues(tier)
+		c.hits.WithLabelValues(tier)
+	}
+
+	return c
+}
+
+// GetMulti implements Cache.
+func (c *TieredCache) GetMulti(ctx context.Context, keys []string, opts ...Option) map[string][]byte {
+	var (
+		now    = c.now()
+		found  = make(map[string][]byte, len(keys))
+		misses = make([]string, 0, len(keys))
+		stale  []string
+	)
+
+	c.requests.WithLabelValues(tierL1).Add(float64(len(keys)))
+	l1Hits := c.l1.GetMulti(ctx, keys, opts...)
+
+	for _, key := range keys {
+		raw, ok := l1Hits[key]
+		if !ok {
+			misses = append(misses, key)
+			continue
+		}
+
+		kind, freshUntil, value, ok := decodeTieredEntry(raw)
+		if !ok {
+			level.Warn(c.logger).Log("msg", "failed to decode tiered cache entry from the first tier", "key", key)
+			misses = append(misses, key)
+			continue
+		}
+
+		switch {
+		case now.Before(freshUntil) && kind == tieredEntryNegative:
+			c.hits.WithLabelValues(tierL1).Inc()
+			c.negativeHits.Inc()
+		case now.Before(freshUntil):
+			c.hits.WithLabelValues(tierL1).Inc()
+			found[key] = value
+		case kind == tieredEntryValue && c.cfg.StaleTTL > 0 && now.Before(freshUntil.Add(c.cfg.StaleTTL)):
+			// Return the stale entry, and refresh it in the background.
+			c.hits.WithLabelValues(tierL1).Inc()
+			c.staleHits.Inc()
+			found[key] = value
+			stale = append(stale, key)
+		default:
+			misses = append(misses, key)
+		}
+	}
+
+	if len(stale) > 0 {
+		c.revalidate(stale)
+	}
+
+	if len(misses) > 0 {
+		for key, value := range c.fetchFromL2(ctx, misses, now, opts...) {
+			found[key] = value
+		}
+	}
+
+	return found
+}
+
+// fetchFromL2 fetches the input keys from the second tier, and stores the result in the first tier.
+func (c *TieredCache) fetchFromL2(ctx context.Context, keys []string, now time.Time, opts ...Option) map[string][]byte {
+	c.requests.WithLabelValues(tierL2).Add(float64(len(keys)))
+	l2Hits := c.l2.GetMulti(ctx, keys, opts...)
+	c.hits.WithLabelValues(tierL2).Add(float64(len(l2Hits)))
+
+	entries := make(map[string][]byte, len(l2Hits))
+	for key, value := range l2Hits {
+		entries[key] = encodeTieredEntry(tieredEntryValue, now.Add(c.cfg.L1TTL), value)
+	}
+	if len(entries) > 0 {
+		c.l1.SetMultiAsync(entries, c.cfg.L1TTL+c.cfg.StaleTTL)
+	}
+
+	if c.cfg.NegativeTTL > 0 && len(l2Hits) < len(keys) {
+		negative := make(map[string][]byte, len(keys)-len(l2Hits))
+		for _, key := range keys {
+			if _, ok := l2Hits[key]; !ok {
+				negative[key] = encodeTieredEntry(tieredEntryNegative, now.Add(c.cfg.NegativeTTL), nil)
+			}
+		}
+		c.l1.SetMultiAsync(negative, c.cfg.NegativeTTL)
+	}
+
+	return l2Hits
+}
+
+// revalidate refreshes the input stale keys from the second tier in the background. Keys which are
+// already being refreshed are skipped.
+func (c *TieredCache) revalidate(keys []string) {
+	c.revalidatingMx.Lock()
+	toRefresh := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if _, ok := c.revalidating[key]; ok {
+			continue
+		}
+		c.revalidating[key] = struct{}{}
+		toRefresh = append(toRefresh, key)
+	}
+	c.revalidatingMx.Unlock()
+
+	if len(toRefresh) == 0 {
+		return
+	}
+
+	c.revalidations.Add(1)
+	go func() {
+		defer c.revalidations.Done()
+		defer func() {
+			c.revalidatingMx.Lock()
+			for _, key := range toRefresh {
+				delete(c.revalidating, key)
+			}
+			c.revalidatingMx.Unlock()
+		}()
+
+		ctx, cancel := context.WithTimeout(context.Background(), tieredCacheRevalidationTimeout)
+		defer cancel()
+
+		refreshed := c.fetchFromL2(ctx, toRefresh, c.now())
+		c.revalidated.Add(float64(len(refreshed)))
+
+		// Remove the stale entries which don't exist anymore in the second tier,
+		// unless they've been replaced by a negative entry.
+		for _, key := range toRefresh {
+			if _, ok := refreshed[key]; ok {
+				continue
+			}
+			c.revalidatedMissed.Inc()
+			if c.cfg.NegativeTTL == 0 {
+				if err := c.l1.Delete(ctx, key); err != nil {
+					level.Warn(c.logger).Log("msg", "failed to delete stale entry from the first tier", "key", key, "err", err)
+				}
+			}
+		}
+	}()
+}
+
+// SetAsync implements Cache. Like Set, it honours the write mode: with TieredCacheWriteThrough, the value is
+// stored in the first tier only if it has been successfully stored in the second tier.
+func (c *TieredCache) SetAsync(key string, value []byte, ttl time.Duration) {
+	c.SetMultiAsync(map[string][]byte{key: value}, ttl)
+}
+
+// SetMultiAsync implements Cache. Like Set, it honours the write mode: with TieredCacheWriteThrough, the values
+// are stored in the first tier only if they have been successfully stored in the second tier.
+func (c *TieredCache) SetMultiAsync(data map[string][]byte, ttl time.Duration) {
+	if c.cfg.WriteMode == TieredCacheWriteBack {
+		c.l1.SetMultiAsync(c.encodeValues(data, ttl), c.l1TTL(ttl))
+		c.l2.SetMultiAsync(data, ttl)
+		return
+	}
+
+	err := c.asyncWrites.submit(func() {
+		// Because this operation is executed in a separate goroutine, it's run without the caller context.
+		ctx := context.Background()
+
+		keys := make([]string, 0, len(data))
+		for key := range data {
+			keys = append(keys, key)
+		}
+
+		// Store the values in the second tier concurrently, to not hold the async worker for a round trip per key.
+		var storedMx sync.Mutex
+		stored := make(map[string][]byte, len(data))
+		_ = concurrency.ForEachJob(ctx, len(keys), tieredCacheL2WriteConcurrency, func(ctx context.Context, idx int) error {
+			key := keys[idx]
+			if err := c.l2.Set(ctx, key, data[key], ttl); err != nil {
+				level.Warn(c.logger).Log("msg", "failed to store item to the second tier", "key", key, "err", err)
+				return nil
+			}
+
+			storedMx.Lock()
+			stored[key] = data[key]
+			storedMx.Unlock()
+			return nil
+		})
+
+		if len(stored) > 0 {
+			c.l1.SetMultiAsync(c.encodeValues(stored, ttl), c.l1TTL(ttl))
+		}
+	})
+	if err != nil {
+		level.Debug(c.logger).Log("msg", "failed to store items to the tiered cache because the async buffer is full", "err", err, "size", tieredCacheAsyncWriteBufferSize)
+	}
+}
+
+// Set implements Cache. With TieredCacheWriteThrough, the value is stored in the first tier only if it
+// has been successfully stored in the second tier. With TieredCacheWriteBack, the value is stored in the
+// first tier synchronously, and asynchronously in the second tier.
+func (c *TieredCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	if c.cfg.WriteMode == TieredCacheWriteBack {
+		if err := c.l1.Set(ctx, key, c.encodeValue(value, ttl), c.l1TTL(ttl)); err != nil {
+			return err
+		}
+		c.l2.SetAsync(key, value, ttl)
+		return nil
+	}
+
+	if err := c.l2.Set(ctx, key, value, ttl); err != nil {
+		return err
+	}
+	return c.l1.Set(ctx, key, c.encodeValue(value, ttl), c.l1TTL(ttl))
+}
+
+// Add implements Cache. The value is always added to the second tier synchronously, regardless of the
+// write mode, because the second tier is the one deciding whether the key already exists.
+func (c *TieredCache) Add(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	if err := c.l2.Add(ctx, key, value, ttl); err != nil {
+		return err
+	}
+
+	// The first tier may hold a stale or negative entry for the key, so we overwrite it.
+	return c.l1.Set(ctx, key, c.encodeValue(value, ttl), c.l1TTL(ttl))
+}
+
+// Delete implements Cache. The key is deleted from the second tier first, so that a concurrent read can't
+// store the deleted value again in the first tier after it has been deleted from it.
+func (c *TieredCache) Delete(ctx context.Context, key string) error {
+	if err := c.l2.Delete(ctx, key); err != nil {
+		return err
+	}
+	return c.l1.Delete(ctx, key)
+}
+
+// Stop implements Cache. It waits for the running background refreshes and asynchronous writes before
+// stopping both tiers.
+func (c *TieredCache) Stop() {
+	c.revalidations.Wait()
+	c.asyncWrites.stop()
+	c.l1.Stop()
+	c.l2.Stop()
+}
+
+// Name implements Cache.
+func (c *TieredCache) Name() string {
+	return c.name
+}
+
+// encodeValue encodes a value to store in the first tier, fresh for the input TTL capped to the first tier TTL.
+func (c *TieredCache) encodeValue(value []byte, ttl time.Duration) []byte {
+	return encodeTieredEntry(tieredEntryValue, c.now().Add(c.freshFor(ttl)), value)
+}
+
+// encodeValues encodes the values to store in the first tier, like encodeValue.
+func (c *TieredCache) encodeValues(data map[string][]byte, ttl time.Duration) map[string][]byte {
+	entries := make(map[string][]byte, len(data))
+	for key, value := range data {
+		entries[key] = c.encodeValue(value, ttl)
+	}
+	return entries
+}
+
+// freshFor returns how long an entry stored with the input TTL is fresh in the first tier.
+func (c *TieredCache) freshFor(ttl time.Duration) time.Duration {
+	// A non-positive TTL means the entry never expires.
+	if ttl <= 0 || ttl > c.cfg.L1TTL {
+		return c.cfg.L1TTL
+	}
+	return ttl
+}
+
+// l1TTL returns the TTL of an entry stored in the first tier, including the stale period.
+func (c *TieredCache) l1TTL(ttl time.Duration) time.Duration {
+	return c.freshFor(ttl) + c.cfg.StaleTTL
+}
+
+func encodeTieredEntry(kind byte, freshUntil time.Time, value []byte) []byte {
+	out := make([]byte, tieredEntryHeaderSize+len(value))
+	out[0] = kind
+	binary.BigEndian.PutUint64(out[1:tieredEntryHeaderSize], uint64(freshUntil.UnixNano()))
+	copy(out[tieredEntryHeaderSize:], value)
+	return out
+}
+
+func decodeTieredEntry(raw []byte) (kind byte, freshUntil time.Time, value []byte, ok bool) {
+	if len(raw) < tieredEntryHeaderSize {
+		return 0, time.Time{}, nil, false
+	}
+
+	kind = raw[0]
+	if kind != tieredEntryValue && kind != tieredEntryNegative {
+		return 0, time.Time{}, nil, false
+	}
+
+	freshUntil = time.Unix(0, int64(binary.BigEndian.Uint64(raw[1:tieredEntryHeaderSize])))
+	return kind, freshUntil, raw[tieredEntryHeaderSize:], true
+}
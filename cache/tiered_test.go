@@ -0,0 +1,289 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/dskit/test"
+)
+
+func TestTieredCacheConfig_Validate(t *testing.T) {
+	tests := map[string]struct {
+		setup       func(cfg *TieredCacheConfig)
+		expectedErr error
+	}{
+		"should pass on default config": {
+			setup:       func(*TieredCacheConfig) {},
+			expectedErr: nil,
+		},
+		"should return error on invalid write mode": {
+			setup: func(cfg *TieredCacheConfig) {
+				cfg.WriteMode = "unknown"
+			},
+			expectedErr: ErrInvalidTieredCacheWriteMode,
+		},
+		"should return error on zero L1 TTL": {
+			setup: func(cfg *TieredCacheConfig) {
+				cfg.L1TTL = 0
+			},
+			expectedErr: ErrInvalidTieredCacheL1TTL,
+		},
+		"should return error on negative stale TTL": {
+			setup: func(cfg *TieredCacheConfig) {
+				cfg.StaleTTL = -time.Second
+			},
+			expectedErr: ErrInvalidTieredCacheTTL,
+		},
+	}
+
+	for testName, testData := range tests {
+		t.Run(testName, func(t *testing.T) {
+			cfg := TieredCacheConfig{WriteMode: TieredCacheWriteThrough, L1TTL: time.Minute}
+			testData.setup(&cfg)
+			require.Equal(t, testData.expectedErr, cfg.Validate())
+		})
+	}
+}
+
+func TestTieredCache_GetMulti(t *testing.T) {
+	ctx := context.Background()
+	l1, l2 := NewInstrumentedMockCache(), NewInstrumentedMockCache()
+	reg := prometheus.NewPedanticRegistry()
+	c := NewTieredCache("test", l1, l2, TieredCacheConfig{WriteMode: TieredCacheWriteThrough, L1TTL: time.Minute}, log.NewNopLogger(), reg)
+
+	// This entry is only known by the second tier.
+	l2.SetAsync("foo", []byte("bar"), time.Hour)
+
+	require.Equal(t, map[string][]byte{"foo": []byte("bar")}, c.GetMulti(ctx, []string{"foo", "missing"}))
+	assert.Equal(t, 1, l2.CountFetchCalls())
+
+	// The entry should have been stored in the first tier, so the second tier isn't queried anymore.
+	require.Equal(t, map[string][]byte{"foo": []byte("bar")}, c.GetMulti(ctx, []string{"foo"}))
+	assert.Equal(t, 1, l2.CountFetchCalls())
+
+	require.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(`
+		# HELP cache_tier_hits_total Total number of items requests to each tier of the tiered cache that were a hit.
+		# TYPE cache_tier_hits_total counter
+		cache_tier_hits_total{name="test",tier="l1"} 1
+		cache_tier_hits_total{name="test",tier="l2"} 1
+		# HELP cache_tier_requests_total Total number of items requests to each tier of the tiered cache.
+		# TYPE cache_tier_requests_total counter
+		cache_tier_requests_total{name="test",tier="l1"} 3
+		cache_tier_requests_total{name="test",tier="l2"} 2
+	`), "cache_tier_hits_total", "cache_tier_requests_total"))
+
+	// Once the first tier entry is stale, it should be fetched again from the second tier.
+	c.now = func() time.Time { return time.Now().Add(2 * time.Minute) }
+	l2.SetAsync("foo", []byte("updated"), time.Hour)
+	require.Equal(t, map[string][]byte{"foo": []byte("updated")}, c.GetMulti(ctx, []string{"foo"}))
+	assert.Equal(t, 2, l2.CountFetchCalls())
+}
+
+func TestTieredCache_Set(t *testing.T) {
+	for _, writeMode := range []string{TieredCacheWriteThrough, TieredCacheWriteBack} {
+		t.Run(writeMode, func(t *testing.T) {
+			ctx := context.Background()
+			l1, l2 := NewMockCache(), NewMockCache()
+			c := NewTieredCache("test", l1, l2, TieredCacheConfig{WriteMode: writeMode, L1TTL: time.Minute}, log.NewNopLogger(), nil)
+
+			require.NoError(t, c.Set(ctx, "foo", []byte("bar"), time.Hour))
+			c.SetAsync("async", []byte("async"), time.Hour)
+			c.SetMultiAsync(map[string][]byte{"multi": []byte("multi")}, time.Hour)
+
+			// Wait until the asynchronous writes have been stored in both tiers.
+			test.Poll(t, time.Second, 3, func() interface{} {
+				return len(l1.GetItems())
+			})
+
+			// The second tier should store values as is.
+			assert.Equal(t, map[string][]byte{"foo": []byte("bar"), "async": []byte("async"), "multi": []byte("multi")}, l2.GetMulti(ctx, []string{"foo", "async", "multi"}))
+
+			// The first tier should store values with the header, and a TTL capped to the first tier TTL.
+			items := l1.GetItems()
+			require.Len(t, items, 3)
+			for key, expected := range map[string]string{"foo": "bar", "async": "async", "multi": "multi"} {
+				kind, _, value, ok := decodeTieredEntry(items[key].Data)
+				require.True(t, ok)
+				assert.Equal(t, tieredEntryValue, kind)
+				assert.Equal(t, []byte(expected), value)
+				assert.WithinDuration(t, l1.now.Add(time.Minute), items[key].ExpiresAt, time.Second)
+			}
+
+			assert.Equal(t, map[string][]byte{"foo": []byte("bar")}, c.GetMulti(ctx, []string{"foo"}))
+		})
+	}
+}
+
+func TestTieredCache_SetAsync_WriteThroughShouldNotStoreInFirstTierOnSecondTierFailure(t *testing.T) {
+	ctx := context.Background()
+	l1, l2 := NewMockCache(), &failingMockCache{MockCache: NewMockCache(), failingKeys: map[string]struct{}{"failing": {}}}
+	c := NewTieredCache("test", l1, l2, TieredCacheConfig{WriteMode: TieredCacheWriteThrough, L1TTL: time.Minute}, log.NewNopLogger(), nil)
+	t.Cleanup(c.Stop)
+
+	c.SetAsync("failing", []byte("failing"), time.Hour)
+	c.SetMultiAsync(map[string][]byte{"foo": []byte("bar"), "failing": []byte("failing")}, time.Hour)
+
+	test.Poll(t, time.Second, []string{"foo"}, func() interface{} {
+		keys := []string{}
+		for key := range l1.GetItems() {
+			keys = append(keys, key)
+		}
+		return keys
+	})
+
+	// The value which couldn't be stored in the second tier should never be stored in the first tier.
+	assert.Equal(t, map[string][]byte{"foo": []byte("bar")}, c.GetMulti(ctx, []string{"foo", "failing"}))
+	assert.Equal(t, map[string][]byte{"foo": []byte("bar")}, l2.GetMulti(ctx, []string{"foo", "failing"}))
+}
+
+func TestTieredCache_SetMultiAsync_WriteThroughShouldStoreInSecondTierConcurrently(t *testing.T) {
+	ctx := context.Background()
+	l1, l2 := NewMockCache(), &concurrencyTrackingCache{MockCache: NewMockCache()}
+	c := NewTieredCache("test", l1, l2, TieredCacheConfig{WriteMode: TieredCacheWriteThrough, L1TTL: time.Minute}, log.NewNopLogger(), nil)
+	t.Cleanup(c.Stop)
+
+	data := map[string][]byte{}
+	keys := []string{}
+	for i := 0; i < 3*tieredCacheL2WriteConcurrency; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		data[key] = []byte(key)
+		keys = append(keys, key)
+	}
+	c.SetMultiAsync(data, time.Hour)
+
+	test.Poll(t, 5*time.Second, len(data), func() interface{} {
+		return len(l1.GetItems())
+	})
+	assert.Equal(t, data, c.GetMulti(ctx, keys))
+	assert.Equal(t, int64(tieredCacheL2WriteConcurrency), l2.maxInflight.Load())
+}
+
+func TestTieredCache_Add(t *testing.T) {
+	ctx := context.Background()
+	l1, l2 := NewMockCache(), NewMockCache()
+	c := NewTieredCache("test", l1, l2, TieredCacheConfig{WriteMode: TieredCacheWriteBack, L1TTL: time.Minute, NegativeTTL: time.Minute}, log.NewNopLogger(), nil)
+
+	// Cache a negative entry for the key.
+	require.Empty(t, c.GetMulti(ctx, []string{"foo"}))
+
+	require.NoError(t, c.Add(ctx, "foo", []byte("bar"), time.Hour))
+	require.Equal(t, map[string][]byte{"foo": []byte("bar")}, c.GetMulti(ctx, []string{"foo"}))
+
+	// The key already exists in the second tier, even if the first tier doesn't have it.
+	require.NoError(t, l1.Delete(ctx, "foo"))
+	require.ErrorIs(t, c.Add(ctx, "foo", []byte("other"), time.Hour), ErrNotStored)
+	require.Equal(t, map[string][]byte{"foo": []byte("bar")}, c.GetMulti(ctx, []string{"foo"}))
+}
+
+func TestTieredCache_Delete(t *testing.T) {
+	ctx := context.Background()
+	l1, l2 := NewMockCache(), NewMockCache()
+	c := NewTieredCache("test", l1, l2, TieredCacheConfig{WriteMode: TieredCacheWriteThrough, L1TTL: time.Minute}, log.NewNopLogger(), nil)
+
+	require.NoError(t, c.Set(ctx, "foo", []byte("bar"), time.Hour))
+	require.NoError(t, c.Delete(ctx, "foo"))
+
+	assert.Empty(t, l1.GetItems())
+	assert.Empty(t, l2.GetItems())
+	assert.Empty(t, c.GetMulti(ctx, []string{"foo"}))
+}
+
+func TestTieredCache_Delete_ShouldDeleteFromSecondTierFirst(t *testing.T) {
+	ctx := context.Background()
+	l1, l2 := NewMockCache(), &failingMockCache{MockCache: NewMockCache(), failingKeys: map[string]struct{}{"foo": {}}}
+	c := NewTieredCache("test", l1, l2, TieredCacheConfig{WriteMode: TieredCacheWriteBack, L1TTL: time.Minute}, log.NewNopLogger(), nil)
+
+	require.NoError(t, c.Set(ctx, "foo", []byte("bar"), time.Hour))
+
+	// The key can't be deleted from the second tier, so it should be left in the first tier too.
+	require.Error(t, c.Delete(ctx, "foo"))
+	assert.Contains(t, l1.GetItems(), "foo")
+}
+
+func TestTieredCache_NegativeCaching(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	l1, l2 := NewInstrumentedMockCache(), NewInstrumentedMockCache()
+	reg := prometheus.NewPedanticRegistry()
+	c := NewTieredCache("test", l1, l2, TieredCacheConfig{WriteMode: TieredCacheWriteThrough, L1TTL: time.Hour, NegativeTTL: time.Minute}, log.NewNopLogger(), reg)
+	c.now = func() time.Time { return now }
+
+	require.Empty(t, c.GetMulti(ctx, []string{"foo"}))
+	assert.Equal(t, 1, l2.CountFetchCalls())
+
+	// The miss should be cached in the first tier.
+	require.Empty(t, c.GetMulti(ctx, []string{"foo"}))
+	assert.Equal(t, 1, l2.CountFetchCalls())
+	assert.Equal(t, float64(1), testutil.ToFloat64(c.negativeHits))
+
+	// Once the negative entry has expired, the key should be fetched again from the second tier.
+	l2.SetAsync("foo", []byte("bar"), time.Hour)
+	c.now = func() time.Time { return now.Add(2 * time.Minute) }
+	require.Equal(t, map[string][]byte{"foo": []byte("bar")}, c.GetMulti(ctx, []string{"foo"}))
+	assert.Equal(t, 2, l2.CountFetchCalls())
+
+	// Writing a key should override the negative entry.
+	require.Empty(t, c.GetMulti(ctx, []string{"other"}))
+	require.NoError(t, c.Set(ctx, "other", []byte("value"), time.Hour))
+	require.Equal(t, map[string][]byte{"other": []byte("value")}, c.GetMulti(ctx, []string{"other"}))
+}
+
+func TestTieredCache_StaleWhileRevalidate(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	l1, l2 := NewInstrumentedMockCache(), NewInstrumentedMockCache()
+	reg := prometheus.NewPedanticRegistry()
+	c := NewTieredCache("test", l1, l2, TieredCacheConfig{WriteMode: TieredCacheWriteThrough, L1TTL: time.Minute, StaleTTL: time.Minute}, log.NewNopLogger(), reg)
+	c.now = func() time.Time { return now }
+
+	require.NoError(t, c.Set(ctx, "foo", []byte("old"), time.Hour))
+	require.NoError(t, c.Set(ctx, "deleted", []byte("old"), time.Hour))
+	require.NoError(t, l2.Set(ctx, "foo", []byte("new"), time.Hour))
+	require.NoError(t, l2.Delete(ctx, "deleted"))
+
+	// The stale entries should be returned, while refreshed in the background.
+	c.now = func() time.Time { return now.Add(90 * time.Second) }
+	require.Equal(t, map[string][]byte{"foo": []byte("old"), "deleted": []byte("old")}, c.GetMulti(ctx, []string{"foo", "deleted"}))
+
+	c.revalidations.Wait()
+	require.Equal(t, map[string][]byte{"foo": []byte("new")}, c.GetMulti(ctx, []string{"foo", "deleted"}))
+
+	assert.Equal(t, float64(2), testutil.ToFloat64(c.staleHits))
+	assert.Equal(t, float64(1), testutil.ToFloat64(c.revalidated))
+	assert.Equal(t, float64(1), testutil.ToFloat64(c.revalidatedMissed))
+
+	// Entries older than the stale period should be fetched synchronously from the second tier.
+	require.NoError(t, l2.Set(ctx, "foo", []byte("newer"), time.Hour))
+	c.now = func() time.Time { return now.Add(5 * time.Minute) }
+	require.Equal(t, map[string][]byte{"foo": []byte("newer")}, c.GetMulti(ctx, []string{"foo"}))
+}
+
+// failingMockCache is a MockCache failing to set and delete the configured keys.
+type failingMockCache struct {
+	*MockCache
+	failingKeys map[string]struct{}
+}
+
+func (m *failingMockCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	if _, ok := m.failingKeys[key]; ok {
+		return errors.New("mocked error")
+	}
+	return m.MockCache.Set(ctx, key, value, ttl)
+}
+
+func (m *failingMockCache) Delete(ctx context.Context, key string) error {
+	if _, ok := m.failingKeys[key]; ok {
+		return errors.New("mocked error")
+	}
+	return m.MockCache.Delete(ctx, key)
+}
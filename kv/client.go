@@ -12,7 +12,10 @@ import (
 	"github.com/grafana/dskit/kv/codec"
 	"github.com/grafana/dskit/kv/consul"
 	"github.com/grafana/dskit/kv/etcd"
+	"github.com/grafana/dskit/kv/file"
+	"github.com/grafana/dskit/kv/kubernetes"
 	"github.com/grafana/dskit/kv/memberlist"
+	"github.com/grafana/dskit/kv/raft"
 )
 
 const (
@@ -39,16 +42,22 @@ var (
 )
 
 // StoreConfig is a configuration used for building single store client, either
-// Consul, Etcd, Memberlist or MultiClient. It was extracted from Config to keep
+// Consul, Etcd, File, Kubernetes, Memberlist, Raft or MultiClient. It was extracted from Config to keep
 // single-client config separate from final client-config (with all the wrappers)
 type StoreConfig struct {
-	Consul consul.Config `yaml:"consul"`
-	Etcd   etcd.Config   `yaml:"etcd"`
-	Multi  MultiConfig   `yaml:"multi"`
+	Consul     consul.Config     `yaml:"consul"`
+	Etcd       etcd.Config       `yaml:"etcd"`
+	File       file.Config       `yaml:"file"`
+	Kubernetes kubernetes.Config `yaml:"kubernetes"`
+	Multi      MultiConfig       `yaml:"multi"`
 
 	// Function that returns memberlist.KV store to use. By using a function, we can delay
 	// initialization of memberlist.KV until it is actually required.
 	MemberlistKV func() (*memberlist.KV, error) `yaml:"-"`
+
+	// Function that returns raft.KV store to use. By using a function, we can delay
+	// initialization of raft.KV until it is actually required.
+	RaftKV func() (*raft.KV, error) `yaml:"-"`
 }
 
 // Config is config for a KVStore currently used by ring and HA tracker,
@@ -73,6 +82,8 @@ func (cfg *Config) RegisterFlagsWithPrefix(flagsPrefix, defaultPrefix string, f
 	// be easier to have everything under ring, so ring.consul.<flag-name>
 	cfg.Consul.RegisterFlags(f, flagsPrefix)
 	cfg.Etcd.RegisterFlagsWithPrefix(f, flagsPrefix)
+	cfg.File.RegisterFlagsWithPrefix(f, flagsPrefix)
+	cfg.Kubernetes.RegisterFlagsWithPrefix(f, flagsPrefix)
 	cfg.Multi.RegisterFlagsWithPrefix(f, flagsPrefix)
 
 	if flagsPrefix == "" {
@@ -85,7 +96,7 @@ func (cfg *Config) RegisterFlagsWithPrefix(flagsPrefix, defaultPrefix string, f
 	}
 
 	f.StringVar(&cfg.Prefix, flagsPrefix+"prefix", defaultPrefix, "The prefix for the keys in the store. Should end with a /.")
-	f.StringVar(&cfg.Store, flagsPrefix+"store", cfg.Store, "Backend storage to use for the ring. Supported values are: consul, etcd, inmemory, memberlist, multi.")
+	f.StringVar(&cfg.Store, flagsPrefix+"store", cfg.Store, "Backend storage to use for the ring. Supported values are: consul, etcd, file, inmemory, kubernetes, memberlist, multi, raft.")
 }
 
 // Client is a high-level client for key-value stores (such as Etcd and
@@ -145,6 +156,12 @@ func createClient(backend string, prefix string, cfg StoreConfig, codec codec.Co
 	case "etcd":
 		client, err = etcd.New(cfg.Etcd, codec, logger)
 
+	case "file":
+		client, err = file.New(cfg.File, codec, logger)
+
+	case "kubernetes":
+		client, err = kubernetes.New(cfg.Kubernetes, codec, logger)
+
 	case "inmemory":
 		// If we use the in-memory store, make sure everyone gets the same instance
 		// within the same process.
@@ -164,8 +181,18 @@ func createClient(backend string, prefix string, cfg StoreConfig, codec codec.Co
 			return nil, err
 		}
 
+	case "raft":
+		kv, err := cfg.RaftKV()
+		if err != nil {
+			return nil, err
+		}
+		client, err = raft.NewClient(kv, codec)
+		if err != nil {
+			return nil, err
+		}
+
 	case "multi":
-		client, err = buildMultiClient(cfg, codec, reg, logger)
+		client, err = buildMultiClient(cfg, prefix, codec, reg, logger)
 
 	// This case is for testing. The mock KV client does not do anything internally.
 	case "mock":
@@ -191,7 +218,7 @@ func createClient(backend string, prefix string, cfg StoreConfig, codec codec.Co
 	return newMetricsClient(backend, client, prometheus.WrapRegistererWith(role.Labels(), reg)), nil
 }
 
-func buildMultiClient(cfg StoreConfig, codec codec.Codec, reg prometheus.Registerer, logger log.Logger) (Client, error) {
+func buildMultiClient(cfg StoreConfig, prefix string, codec codec.Codec, reg prometheus.Registerer, logger log.Logger) (Client, error) {
 	if cfg.Multi.Primary == "" || cfg.Multi.Secondary == "" {
 		return nil, fmt.Errorf("primary or secondary store not set")
 	}
@@ -217,5 +244,5 @@ func buildMultiClient(cfg StoreConfig, codec codec.Codec, reg prometheus.Registe
 		{client: secondary, name: cfg.Multi.Secondary},
 	}
 
-	return NewMultiClient(cfg.Multi, clients, logger, reg), nil
+	return newMultiClient(cfg.Multi, clients, prefix, codec, logger, reg), nil
 }
@@ -59,6 +59,18 @@ type kv interface {
 	List(path string, q *consul.QueryOptions) (consul.KVPairs, *consul.QueryMeta, error)
 	Delete(key string, q *consul.WriteOptions) (*consul.WriteMeta, error)
 	Put(p *consul.KVPair, q *consul.WriteOptions) (*consul.WriteMeta, error)
+	Txn(ops consul.TxnOps, q *consul.QueryOptions) (bool, *consul.TxnResponse, *consul.QueryMeta, error)
+}
+
+// consulKV is the kv implementation of the Consul client. The transactions are not part of the
+// Consul KV API anymore, but of the Txn API.
+type consulKV struct {
+	*consul.KV
+	txn *consul.Txn
+}
+
+func (c consulKV) Txn(ops consul.TxnOps, q *consul.QueryOptions) (bool, *consul.TxnResponse, *consul.QueryMeta, error) {
+	return c.txn.Txn(ops, q)
 }
 
 // Client is a kv.Client for Consul.
@@ -100,7 +112,7 @@ func NewClient(cfg Config, codec codec.Codec, logger log.Logger, registerer prom
 	consulMetrics := newConsulMetrics(registerer)
 
 	c := &Client{
-		kv:            consulInstrumentation{client.KV(), consulMetrics},
+		kv:            consulInstrumentation{consulKV{client.KV(), client.Txn()}, consulMetrics},
 		codec:         codec,
 		cfg:           cfg,
 		logger:        logger,
@@ -206,6 +218,113 @@ func (c *Client) cas(ctx context.Context, key string, f func(in interface{}) (ou
 	return fmt.Errorf("failed to CAS %s", key)
 }
 
+// CASMulti implements kv.TxnClient, with a Consul transaction checking the modify index of all the keys.
+// Consul limits the number of operations in a transaction, so the number of keys is limited too.
+func (c *Client) CASMulti(ctx context.Context, keys []string, f func(in []interface{}) (out []interface{}, retry bool, err error)) error {
+	return instrument.CollectedRequest(ctx, "CASMulti loop", c.consulMetrics.consulRequestDuration, instrument.ErrorCode, func(ctx context.Context) error {
+		return c.casMulti(ctx, keys, f)
+	})
+}
+
+func (c *Client) casMulti(ctx context.Context, keys []string, f func(in []interface{}) (out []interface{}, retry bool, err error)) error {
+	retries := c.cfg.MaxCasRetries
+	if retries == 0 {
+		retries = 10
+	}
+
+	sleepBeforeRetry := time.Duration(0)
+	if c.cfg.CasRetryDelay > 0 {
+		sleepBeforeRetry = time.Duration(rand.Int63n(c.cfg.CasRetryDelay.Nanoseconds()))
+	}
+
+attempts:
+	for i := 0; i < retries; i++ {
+		if i > 0 && sleepBeforeRetry > 0 {
+			time.Sleep(sleepBeforeRetry)
+		}
+
+		// The keys are read one by one, but the transaction fails if any of them has been modified meanwhile.
+		intermediate := make([]interface{}, len(keys))
+		indexes := make([]uint64, len(keys))
+		for j, key := range keys {
+			// Get with default options - don't want stale data to compare with
+			options := &consul.QueryOptions{}
+			kvp, _, err := c.kv.Get(key, options.WithContext(ctx))
+			if err != nil {
+				level.Error(c.logger).Log("msg", "error getting key", "key", key, "err", err)
+				continue attempts
+			}
+			if kvp == nil {
+				// If key doesn't exist, index will be 0.
+				continue
+			}
+
+			intermediate[j], err = c.codec.Decode(kvp.Value)
+			if err != nil {
+				level.Error(c.logger).Log("msg", "error decoding key", "key", key, "err", err)
+				continue attempts
+			}
+			indexes[j] = kvp.ModifyIndex
+		}
+
+		intermediate, retry, err := f(intermediate)
+		if err != nil {
+			if !retry {
+				return err
+			}
+			continue
+		}
+
+		// Treat the callback returning nil for intermediate as a decision to
+		// not actually write to Consul, but this is not an error.
+		if intermediate == nil {
+			return nil
+		}
+		if len(intermediate) != len(keys) {
+			return fmt.Errorf("the multi-key CAS callback returned %d values for %d keys", len(intermediate), len(keys))
+		}
+
+		ops := make(consul.TxnOps, 0, len(keys))
+		updated := false
+		for j, key := range keys {
+			if intermediate[j] == nil {
+				// Only check the key hasn't been modified.
+				if indexes[j] == 0 {
+					ops = append(ops, &consul.TxnOp{KV: &consul.KVTxnOp{Verb: consul.KVCheckNotExists, Key: key}})
+				} else {
+					ops = append(ops, &consul.TxnOp{KV: &consul.KVTxnOp{Verb: consul.KVCheckIndex, Key: key, Index: indexes[j]}})
+				}
+				continue
+			}
+
+			bytes, err := c.codec.Encode(intermediate[j])
+			if err != nil {
+				level.Error(c.logger).Log("msg", "error serialising value", "key", key, "err", err)
+				continue attempts
+			}
+			// A CAS with index 0 only succeeds if the key doesn't exist.
+			ops = append(ops, &consul.TxnOp{KV: &consul.KVTxnOp{Verb: consul.KVCAS, Key: key, Value: bytes, Index: indexes[j]}})
+			updated = true
+		}
+		if !updated {
+			return nil
+		}
+
+		options := &consul.QueryOptions{}
+		ok, _, _, err := c.kv.Txn(ops, options.WithContext(ctx))
+		if err != nil {
+			level.Error(c.logger).Log("msg", "error CASing", "keys", fmt.Sprint(keys), "err", err)
+			continue
+		}
+		if !ok {
+			level.Debug(c.logger).Log("msg", "error CASing, trying again", "keys", fmt.Sprint(keys))
+			continue
+		}
+		return nil
+	}
+	return fmt.Errorf("failed to CAS %v", keys)
+}
+
 // WatchKey will watch a given key in consul for changes. When the value
 // under said key changes, the f callback is called with the deserialised
 // value. To construct the deserialised value, a factory function should be
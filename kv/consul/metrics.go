@@ -86,3 +86,16 @@ func (c consulInstrumentation) Put(p *consul.KVPair, options *consul.WriteOption
 	})
 	return result, err
 }
+
+func (c consulInstrumentation) Txn(ops consul.TxnOps, options *consul.QueryOptions) (bool, *consul.TxnResponse, *consul.QueryMeta, error) {
+	var ok bool
+	var resp *consul.TxnResponse
+	var meta *consul.QueryMeta
+	err := instrument.CollectedRequest(options.Context(), "Txn", c.consulMetrics.consulRequestDuration, instrument.ErrorCode, func(ctx context.Context) error {
+		options = options.WithContext(ctx)
+		var err error
+		ok, resp, meta, err = c.kv.Txn(ops, options)
+		return err
+	})
+	return ok, resp, meta, err
+}
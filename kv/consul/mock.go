@@ -236,6 +236,66 @@ func (m *mockKV) Delete(key string, _ *consul.WriteOptions) (*consul.WriteMeta,
 	return nil, nil
 }
 
+// Txn supports the KV operations used by the Client: cas, check-index and check-not-exists.
+func (m *mockKV) Txn(ops consul.TxnOps, _ *consul.QueryOptions) (bool, *consul.TxnResponse, *consul.QueryMeta, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	// Check all the operations before applying any of them.
+	var errs consul.TxnErrors
+	for i, op := range ops {
+		if op.KV == nil {
+			return false, nil, nil, fmt.Errorf("unsupported transaction operation at index %d", i)
+		}
+
+		existing, ok := m.kvps[op.KV.Key]
+		switch op.KV.Verb {
+		case consul.KVCAS, consul.KVCheckIndex:
+			if (op.KV.Index == 0 && ok) || (op.KV.Index != 0 && (!ok || existing.ModifyIndex != op.KV.Index)) {
+				errs = append(errs, &consul.TxnError{OpIndex: i, What: "index mismatch"})
+			}
+		case consul.KVCheckNotExists:
+			if ok {
+				errs = append(errs, &consul.TxnError{OpIndex: i, What: "key exists"})
+			}
+		default:
+			return false, nil, nil, fmt.Errorf("unsupported transaction verb %q", op.KV.Verb)
+		}
+	}
+	if len(errs) > 0 {
+		return false, &consul.TxnResponse{Errors: errs}, nil, nil
+	}
+
+	// All the keys are modified at the same index, like in Consul.
+	m.current++
+	resp := &consul.TxnResponse{}
+	for _, op := range ops {
+		if op.KV.Verb != consul.KVCAS {
+			continue
+		}
+
+		existing, ok := m.kvps[op.KV.Key]
+		if ok {
+			existing.Value = op.KV.Value
+			existing.ModifyIndex = m.current
+		} else {
+			existing = &consul.KVPair{
+				Key:         op.KV.Key,
+				Value:       op.KV.Value,
+				CreateIndex: m.current,
+				ModifyIndex: m.current,
+			}
+			m.kvps[op.KV.Key] = existing
+		}
+		resp.Results = append(resp.Results, &consul.TxnResult{KV: copyKVPair(existing)})
+	}
+
+	m.cond.Broadcast()
+
+	level.Debug(m.logger).Log("msg", "Txn", "ops", len(ops), "modify_index", m.current)
+	return true, resp, nil, nil
+}
+
 func (m *mockKV) ResetIndex() {
 	m.mtx.Lock()
 	defer m.mtx.Unlock()
@@ -189,6 +189,107 @@ func (c *Client) CAS(ctx context.Context, key string, f func(in interface{}) (ou
 	return fmt.Errorf("failed to CAS %s", key)
 }
 
+// CASMulti implements kv.TxnClient, with an etcd transaction comparing the versions of all the keys.
+func (c *Client) CASMulti(ctx context.Context, keys []string, f func(in []interface{}) (out []interface{}, retry bool, err error)) error {
+	var lastErr error
+
+	for i := 0; i < c.cfg.MaxRetries; i++ {
+		// Read all the keys at the same revision.
+		gets := make([]clientv3.Op, 0, len(keys))
+		for _, key := range keys {
+			gets = append(gets, clientv3.OpGet(key))
+		}
+		resp, err := c.cli.Txn(ctx).Then(gets...).Commit()
+		if err != nil {
+			level.Error(c.logger).Log("msg", "error getting keys", "keys", fmt.Sprint(keys), "err", err)
+			lastErr = err
+			continue
+		}
+
+		intermediate := make([]interface{}, len(keys))
+		versions := make([]int64, len(keys))
+		var decodeErr error
+		for j, r := range resp.Responses {
+			kvs := r.GetResponseRange().GetKvs()
+			if len(kvs) == 0 {
+				continue
+			}
+			intermediate[j], decodeErr = c.codec.Decode(kvs[0].Value)
+			if decodeErr != nil {
+				level.Error(c.logger).Log("msg", "error decoding key", "key", keys[j], "err", decodeErr)
+				break
+			}
+			versions[j] = kvs[0].Version
+		}
+		if decodeErr != nil {
+			lastErr = decodeErr
+			continue
+		}
+
+		var retry bool
+		intermediate, retry, err = f(intermediate)
+		if err != nil {
+			if !retry {
+				return err
+			}
+			lastErr = err
+			continue
+		}
+
+		// Callback returning nil means it doesn't want to CAS anymore.
+		if intermediate == nil {
+			return nil
+		}
+		if len(intermediate) != len(keys) {
+			return fmt.Errorf("the multi-key CAS callback returned %d values for %d keys", len(intermediate), len(keys))
+		}
+
+		compares := make([]clientv3.Cmp, 0, len(keys))
+		puts := make([]clientv3.Op, 0, len(keys))
+		var encodeErr error
+		for j, key := range keys {
+			compares = append(compares, clientv3.Compare(clientv3.Version(key), "=", versions[j]))
+			if intermediate[j] == nil {
+				continue
+			}
+
+			var buf []byte
+			buf, encodeErr = c.codec.Encode(intermediate[j])
+			if encodeErr != nil {
+				level.Error(c.logger).Log("msg", "error serialising value", "key", key, "err", encodeErr)
+				break
+			}
+			puts = append(puts, clientv3.OpPut(key, string(buf)))
+		}
+		if encodeErr != nil {
+			lastErr = encodeErr
+			continue
+		}
+		if len(puts) == 0 {
+			return nil
+		}
+
+		result, err := c.cli.Txn(ctx).If(compares...).Then(puts...).Commit()
+		if err != nil {
+			level.Error(c.logger).Log("msg", "error CASing", "keys", fmt.Sprint(keys), "err", err)
+			lastErr = err
+			continue
+		}
+		// result is not Succeeded if any of the comparisons was false, meaning if any of the keys has been modified.
+		if !result.Succeeded {
+			level.Debug(c.logger).Log("msg", "failed to CAS, versions did not match in etcd", "keys", fmt.Sprint(keys))
+			continue
+		}
+
+		return nil
+	}
+
+	if lastErr != nil {
+		return lastErr
+	}
+	return fmt.Errorf("failed to CAS %v", keys)
+}
+
 // WatchKey implements kv.Client.
 func (c *Client) WatchKey(ctx context.Context, key string, f func(interface{}) bool) {
 	backoff := backoff.New(ctx, backoff.Config{
@@ -329,12 +329,12 @@ func (m *mockKV) doTxn(op clientv3.Op) (clientv3.OpResponse, error) {
 
 	responses := make([]*etcdserverpb.ResponseOp, 0, len(toRun))
 	for _, o := range toRun {
-		_, err := m.doInternal(o)
+		res, err := m.doInternal(o)
 		if err != nil {
 			panic(fmt.Sprintf("unexpected error running transaction: %s", err))
 		}
 
-		responses = append(responses, &etcdserverpb.ResponseOp{Response: nil})
+		responses = append(responses, txnResponseOp(res))
 	}
 
 	res := clientv3.TxnResponse{
@@ -344,6 +344,22 @@ func (m *mockKV) doTxn(op clientv3.Op) (clientv3.OpResponse, error) {
 	return res.OpResponse(), nil
 }
 
+// txnResponseOp converts the response of an operation run within a transaction.
+func txnResponseOp(res clientv3.OpResponse) *etcdserverpb.ResponseOp {
+	switch {
+	case res.Get() != nil:
+		return &etcdserverpb.ResponseOp{Response: &etcdserverpb.ResponseOp_ResponseRange{ResponseRange: (*etcdserverpb.RangeResponse)(res.Get())}}
+	case res.Put() != nil:
+		return &etcdserverpb.ResponseOp{Response: &etcdserverpb.ResponseOp_ResponsePut{ResponsePut: (*etcdserverpb.PutResponse)(res.Put())}}
+	case res.Del() != nil:
+		return &etcdserverpb.ResponseOp{Response: &etcdserverpb.ResponseOp_ResponseDeleteRange{ResponseDeleteRange: (*etcdserverpb.DeleteRangeResponse)(res.Del())}}
+	case res.Txn() != nil:
+		return &etcdserverpb.ResponseOp{Response: &etcdserverpb.ResponseOp_ResponseTxn{ResponseTxn: (*etcdserverpb.TxnResponse)(res.Txn())}}
+	default:
+		return &etcdserverpb.ResponseOp{}
+	}
+}
+
 // matchingKeys returns the keys of elements that match the given Op
 func (m *mockKV) matchingKeys(op clientv3.Op, kvps map[string]mvccpb.KeyValue) []string {
 	// NOTE that even when Op is a prefix match, the key bytes will be the same
@@ -0,0 +1,673 @@
+package file
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+
+	"github.com/grafana/dskit/internal/slices"
+	"github.com/grafana/dskit/kv/codec"
+)
+
+const (
+	// lockFileName is the file locked during CAS and Delete operations. It's shared by all the keys.
+	lockFileName = ".lock"
+
+	// tempFilePattern is the pattern of the temporary files written before being renamed to the value files.
+	tempFilePattern = ".tmp-*"
+
+	// txnFileName is the journal of the multi-key CAS operation being applied, if any.
+	txnFileName = ".txn"
+)
+
+var errEmptyKey = errors.New("the key must not be empty")
+
+// Config for a new file.Client.
+type Config struct {
+	Dir           string        `yaml:"dir"`
+	PollInterval  time.Duration `yaml:"poll_interval" category:"advanced"`
+	MaxCASRetries int           `yaml:"max_cas_retries" category:"advanced"`
+}
+
+func (cfg *Config) RegisterFlags(f *flag.FlagSet) {
+	cfg.RegisterFlagsWithPrefix(f, "")
+}
+
+// RegisterFlagsWithPrefix adds the flags required to config this to the given FlagSet.
+func (cfg *Config) RegisterFlagsWithPrefix(f *flag.FlagSet, prefix string) {
+	f.StringVar(&cfg.Dir, prefix+"file.dir", "./data-kv/", "The directory where the values are stored, one file per key. Processes running on the same host can share the directory.")
+	f.DurationVar(&cfg.PollInterval, prefix+"file.poll-interval", time.Second, "How frequently watches check the directory for changes. Changes are usually notified earlier by inotify, when supported by the filesystem.")
+	f.IntVar(&cfg.MaxCASRetries, prefix+"file.max-cas-retries", 10, "The maximum number of retries of a CAS operation, when the CAS callback asks to retry.")
+}
+
+// Client implements kv.Client storing each key in a file of a local directory. Values are written
+// to a temporary file, and then atomically renamed, so readers never observe partial writes. CAS and
+// Delete operations hold an exclusive lock on a file of the directory, so multiple processes can share it.
+//
+// Multi-key CAS operations first write all the values to a journal file, which is atomically renamed
+// and then applied to the value files. Readers look up the journal before the value files, so they
+// observe either all the new values or none of them. A journal left by a crashed process is applied
+// by the next operation acquiring the lock.
+//
+// Watches are notified by inotify (or the equivalent mechanism of the platform), and additionally poll the
+// directory, because some filesystems don't support inotify.
+type Client struct {
+	cfg    Config
+	codec  codec.Codec
+	logger log.Logger
+
+	// Serializes the CAS and Delete operations in this process, on top of the file lock.
+	mtx sync.Mutex
+}
+
+// New makes a new Client, creating the directory if it doesn't exist.
+func New(cfg Config, codec codec.Codec, logger log.Logger) (*Client, error) {
+	if err := os.MkdirAll(cfg.Dir, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create the KV store directory: %w", err)
+	}
+
+	return &Client{
+		cfg:    cfg,
+		codec:  codec,
+		logger: logger,
+	}, nil
+}
+
+// List implements kv.Client.
+func (c *Client) List(_ context.Context, prefix string) ([]string, error) {
+	entries, err := os.ReadDir(c.cfg.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	txn, err := c.readTxn()
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		key, ok := keyFromFileName(entry.Name())
+		if ok && !entry.IsDir() && strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+
+	// Keys created by the pending journal may not have a value file yet.
+	for key := range txn {
+		if strings.HasPrefix(key, prefix) && !slices.Contains(keys, key) {
+			keys = append(keys, key)
+		}
+	}
+
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// Get implements kv.Client.
+func (c *Client) Get(_ context.Context, key string) (interface{}, error) {
+	if key == "" {
+		return nil, errEmptyKey
+	}
+	return c.read(key)
+}
+
+// Delete implements kv.Client.
+func (c *Client) Delete(_ context.Context, key string) error {
+	if key == "" {
+		return errEmptyKey
+	}
+
+	unlock, err := c.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	err = os.Remove(c.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// CAS implements kv.Client.
+func (c *Client) CAS(_ context.Context, key string, f func(in interface{}) (out interface{}, retry bool, err error)) error {
+	if key == "" {
+		return errEmptyKey
+	}
+
+	var lastErr error
+	for i := 0; i < c.cfg.MaxCASRetries; i++ {
+		// The lock is released between retries, to give other processes a chance to update the value.
+		retry, err := c.cas(key, f)
+		if err == nil {
+			return nil
+		}
+		if !retry {
+			return err
+		}
+		lastErr = err
+	}
+
+	if lastErr != nil {
+		return lastErr
+	}
+	return fmt.Errorf("failed to CAS %s", key)
+}
+
+// cas runs a single CAS attempt holding the lock. It returns whether the attempt can be retried on error.
+func (c *Client) cas(key string, f func(in interface{}) (out interface{}, retry bool, err error)) (bool, error) {
+	unlock, err := c.lock()
+	if err != nil {
+		level.Error(c.logger).Log("msg", "error locking the KV store directory", "key", key, "err", err)
+		return true, err
+	}
+	defer unlock()
+
+	intermediate, err := c.read(key)
+	if err != nil {
+		level.Error(c.logger).Log("msg", "error getting key", "key", key, "err", err)
+		return true, err
+	}
+
+	intermediate, retry, err := f(intermediate)
+	if err != nil {
+		return retry, err
+	}
+
+	// Callback returning nil means it doesn't want to CAS anymore.
+	if intermediate == nil {
+		return false, nil
+	}
+
+	buf, err := c.codec.Encode(intermediate)
+	if err != nil {
+		level.Error(c.logger).Log("msg", "error serialising value", "key", key, "err", err)
+		return true, err
+	}
+
+	if err := c.write(key, buf); err != nil {
+		level.Error(c.logger).Log("msg", "error CASing", "key", key, "err", err)
+		return true, err
+	}
+	return false, nil
+}
+
+// CASMulti implements kv.TxnClient. The lock is held while updating all the keys, and the new values
+// are committed at once by renaming the journal file, so readers observe either all of them or none.
+func (c *Client) CASMulti(_ context.Context, keys []string, f func(in []interface{}) (out []interface{}, retry bool, err error)) error {
+	for _, key := range keys {
+		if key == "" {
+			return errEmptyKey
+		}
+	}
+
+	var lastErr error
+	for i := 0; i < c.cfg.MaxCASRetries; i++ {
+		// The lock is released between retries, to give other processes a chance to update the values.
+		retry, err := c.casMulti(keys, f)
+		if err == nil {
+			return nil
+		}
+		if !retry {
+			return err
+		}
+		lastErr = err
+	}
+
+	if lastErr != nil {
+		return lastErr
+	}
+	return fmt.Errorf("failed to CAS %v", keys)
+}
+
+// casMulti runs a single multi-key CAS attempt holding the lock. It returns whether the attempt can be
+// retried on error.
+func (c *Client) casMulti(keys []string, f func(in []interface{}) (out []interface{}, retry bool, err error)) (bool, error) {
+	unlock, err := c.lock()
+	if err != nil {
+		level.Error(c.logger).Log("msg", "error locking the KV store directory", "keys", fmt.Sprint(keys), "err", err)
+		return true, err
+	}
+	defer unlock()
+
+	intermediate := make([]interface{}, len(keys))
+	for i, key := range keys {
+		intermediate[i], err = c.read(key)
+		if err != nil {
+			level.Error(c.logger).Log("msg", "error getting key", "key", key, "err", err)
+			return true, err
+		}
+	}
+
+	intermediate, retry, err := f(intermediate)
+	if err != nil {
+		return retry, err
+	}
+
+	// Callback returning nil means it doesn't want to CAS anymore.
+	if intermediate == nil {
+		return false, nil
+	}
+	if len(intermediate) != len(keys) {
+		return false, fmt.Errorf("the multi-key CAS callback returned %d values for %d keys", len(intermediate), len(keys))
+	}
+
+	// Encode all the values before writing any of them.
+	bufs := make([][]byte, len(keys))
+	for i, key := range keys {
+		if intermediate[i] == nil {
+			continue
+		}
+		bufs[i], err = c.codec.Encode(intermediate[i])
+		if err != nil {
+			level.Error(c.logger).Log("msg", "error serialising value", "key", key, "err", err)
+			return true, err
+		}
+	}
+
+	txn := make(map[string][]byte, len(keys))
+	for i, key := range keys {
+		if bufs[i] != nil {
+			txn[key] = bufs[i]
+		}
+	}
+	if len(txn) == 0 {
+		return false, nil
+	}
+
+	// Once the journal has been written, the new values are committed: if they can't be applied to
+	// the value files now, they will be by the next operation acquiring the lock.
+	if err := c.writeTxn(txn); err != nil {
+		level.Error(c.logger).Log("msg", "error CASing", "keys", fmt.Sprint(keys), "err", err)
+		return true, err
+	}
+	if err := c.applyTxn(); err != nil {
+		level.Warn(c.logger).Log("msg", "failed to apply the multi-key CAS journal, it will be applied by the next operation", "keys", fmt.Sprint(keys), "err", err)
+	}
+	return false, nil
+}
+
+// WatchKey implements kv.Client. The current value is notified first, if the key exists.
+func (c *Client) WatchKey(ctx context.Context, key string, f func(interface{}) bool) {
+	var last os.FileInfo
+
+	c.watch(ctx, func() bool {
+		info, err := os.Stat(c.path(key))
+		if err != nil {
+			if !errors.Is(err, fs.ErrNotExist) {
+				level.Error(c.logger).Log("msg", "error checking key", "key", key, "err", err)
+			}
+			last = nil
+			return true
+		}
+
+		if !changed(last, info) {
+			return true
+		}
+
+		value, info, err := c.readWithInfo(key)
+		if err != nil {
+			level.Error(c.logger).Log("msg", "error getting key", "key", key, "err", err)
+			return true
+		}
+		if value == nil {
+			// Deleted meanwhile.
+			return true
+		}
+		last = info
+		return f(value)
+	})
+}
+
+// WatchPrefix implements kv.Client. The current values are notified first.
+func (c *Client) WatchPrefix(ctx context.Context, prefix string, f func(string, interface{}) bool) {
+	last := map[string]os.FileInfo{}
+
+	c.watch(ctx, func() bool {
+		entries, err := os.ReadDir(c.cfg.Dir)
+		if err != nil {
+			level.Error(c.logger).Log("msg", "error listing keys", "prefix", prefix, "err", err)
+			return true
+		}
+
+		current := make(map[string]os.FileInfo, len(last))
+		for _, entry := range entries {
+			key, ok := keyFromFileName(entry.Name())
+			if !ok || entry.IsDir() || !strings.HasPrefix(key, prefix) {
+				continue
+			}
+
+			info, err := entry.Info()
+			if err != nil {
+				// Deleted meanwhile.
+				continue
+			}
+			if !changed(last[key], info) {
+				current[key] = last[key]
+				continue
+			}
+
+			value, info, err := c.readWithInfo(key)
+			if err != nil {
+				level.Error(c.logger).Log("msg", "error getting key", "key", key, "err", err)
+				continue
+			}
+			if value == nil {
+				continue
+			}
+			current[key] = info
+			if !f(key, value) {
+				return false
+			}
+		}
+
+		last = current
+		return true
+	})
+}
+
+// watch calls check once, and then every time the directory may have changed, until check returns
+// false or the context is done.
+func (c *Client) watch(ctx context.Context, check func() bool) {
+	notifications := make(chan struct{}, 1)
+	notify := func() {
+		select {
+		case notifications <- struct{}{}:
+		default:
+			// There's already a pending notification.
+		}
+	}
+
+	// The watcher is registered before the first check, to not miss any change.
+	watcher, err := fsnotify.NewWatcher()
+	if err == nil {
+		err = watcher.Add(c.cfg.Dir)
+	}
+	if err != nil {
+		level.Warn(c.logger).Log("msg", "failed to watch the KV store directory, falling back to polling", "dir", c.cfg.Dir, "err", err)
+	}
+	if watcher != nil {
+		defer watcher.Close()
+
+		go func() {
+			for {
+				select {
+				case _, ok := <-watcher.Events:
+					if !ok {
+						return
+					}
+					notify()
+				case err, ok := <-watcher.Errors:
+					if !ok {
+						return
+					}
+					// Events may have been lost.
+					level.Debug(c.logger).Log("msg", "error watching the KV store directory", "dir", c.cfg.Dir, "err", err)
+					notify()
+				}
+			}
+		}()
+	}
+
+	var poll <-chan time.Time
+	if c.cfg.PollInterval > 0 {
+		ticker := time.NewTicker(c.cfg.PollInterval)
+		defer ticker.Stop()
+		poll = ticker.C
+	}
+
+	for {
+		if !check() {
+			return
+		}
+
+		select {
+		case <-notifications:
+		case <-poll:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// changed returns whether the file has been changed since the previous info was observed. Values are
+// replaced by renaming a new file, so a change is detected even if the size and modification time don't change.
+func changed(previous, current os.FileInfo) bool {
+	return previous == nil || !os.SameFile(previous, current) || !previous.ModTime().Equal(current.ModTime()) || previous.Size() != current.Size()
+}
+
+// read returns the decoded value of the key, or nil if the key doesn't exist.
+func (c *Client) read(key string) (interface{}, error) {
+	txn, err := c.readTxn()
+	if err != nil {
+		return nil, err
+	}
+	if buf, ok := txn[key]; ok {
+		return c.codec.Decode(buf)
+	}
+
+	buf, err := os.ReadFile(c.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return c.codec.Decode(buf)
+}
+
+// readWithInfo returns the decoded value of the key and the info of the file it has been read from,
+// or a nil value if the key doesn't exist. The info always matches the returned value, even if the
+// value is concurrently replaced.
+func (c *Client) readWithInfo(key string) (interface{}, os.FileInfo, error) {
+	txn, txnInfo, err := c.readTxnWithInfo()
+	if err != nil {
+		return nil, nil, err
+	}
+	if buf, ok := txn[key]; ok {
+		value, err := c.codec.Decode(buf)
+		return value, txnInfo, err
+	}
+
+	f, err := os.Open(c.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, nil, err
+	}
+	buf, err := io.ReadAll(f)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	value, err := c.codec.Decode(buf)
+	return value, info, err
+}
+
+// write atomically replaces the value of the key.
+func (c *Client) write(key string, buf []byte) error {
+	return c.writeFile(c.path(key), buf)
+}
+
+// writeTxn atomically writes the journal of a multi-key CAS operation, with the encoded values by key.
+func (c *Client) writeTxn(txn map[string][]byte) error {
+	buf, err := json.Marshal(txn)
+	if err != nil {
+		return err
+	}
+	return c.writeFile(filepath.Join(c.cfg.Dir, txnFileName), buf)
+}
+
+// readTxn returns the encoded values by key of the pending journal, or nil if there's no journal.
+func (c *Client) readTxn() (map[string][]byte, error) {
+	txn, _, err := c.readTxnWithInfo()
+	return txn, err
+}
+
+// readTxnWithInfo is like readTxn, but also returns the info of the journal file.
+func (c *Client) readTxnWithInfo() (map[string][]byte, os.FileInfo, error) {
+	f, err := os.Open(filepath.Join(c.cfg.Dir, txnFileName))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var txn map[string][]byte
+	if err := json.NewDecoder(f).Decode(&txn); err != nil {
+		return nil, nil, fmt.Errorf("failed to decode the multi-key CAS journal: %w", err)
+	}
+	return txn, info, nil
+}
+
+// applyTxn writes the values of the pending journal, if any, to the value files, and then removes
+// the journal. It must be called holding the lock.
+func (c *Client) applyTxn() error {
+	txn, err := c.readTxn()
+	if err != nil || txn == nil {
+		return err
+	}
+
+	for key, buf := range txn {
+		if err := c.write(key, buf); err != nil {
+			return err
+		}
+	}
+	return os.Remove(filepath.Join(c.cfg.Dir, txnFileName))
+}
+
+// writeFile atomically replaces the content of the file at path.
+func (c *Client) writeFile(path string, buf []byte) error {
+	tmp, err := os.CreateTemp(c.cfg.Dir, tempFilePattern)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// Nothing to remove if the file has been renamed.
+		_ = os.Remove(tmp.Name())
+	}()
+
+	if _, err := tmp.Write(buf); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// lock acquires the lock of the directory, both across goroutines and processes, and applies the
+// journal left by a multi-key CAS operation which failed to apply it. The returned function releases it.
+func (c *Client) lock() (func(), error) {
+	c.mtx.Lock()
+
+	f, err := os.OpenFile(filepath.Join(c.cfg.Dir, lockFileName), os.O_CREATE|os.O_RDWR, 0o600)
+	if err != nil {
+		c.mtx.Unlock()
+		return nil, err
+	}
+
+	if err := lockFile(f); err != nil {
+		_ = f.Close()
+		c.mtx.Unlock()
+		return nil, fmt.Errorf("failed to lock %s: %w", f.Name(), err)
+	}
+
+	unlock := func() {
+		if err := unlockFile(f); err != nil {
+			level.Warn(c.logger).Log("msg", "failed to unlock the KV store directory", "err", err)
+		}
+		_ = f.Close()
+		c.mtx.Unlock()
+	}
+
+	if err := c.applyTxn(); err != nil {
+		unlock()
+		return nil, fmt.Errorf("failed to apply the multi-key CAS journal: %w", err)
+	}
+
+	return unlock, nil
+}
+
+func (c *Client) path(key string) string {
+	return filepath.Join(c.cfg.Dir, fileName(key))
+}
+
+// fileName returns the name of the file storing the key. All the bytes except lowercase ASCII letters,
+// digits, '-' and '_' are percent-encoded, so names are valid on all platforms and never start with a dot,
+// which is reserved for the lock and temporary files. Uppercase letters are percent-encoded too, so that
+// keys differing only by case are stored in different files on case-insensitive filesystems.
+func fileName(key string) string {
+	var sb strings.Builder
+	for i := 0; i < len(key); i++ {
+		b := key[i]
+		if (b >= 'a' && b <= 'z') || (b >= '0' && b <= '9') || b == '-' || b == '_' {
+			sb.WriteByte(b)
+		} else {
+			fmt.Fprintf(&sb, "%%%02X", b)
+		}
+	}
+	return sb.String()
+}
+
+// keyFromFileName returns the key stored in the file, and false if the file doesn't store a key.
+func keyFromFileName(name string) (string, bool) {
+	if name == "" || strings.HasPrefix(name, ".") {
+		return "", false
+	}
+
+	var sb strings.Builder
+	for i := 0; i < len(name); i++ {
+		if name[i] != '%' {
+			sb.WriteByte(name[i])
+			continue
+		}
+
+		if i+2 >= len(name) {
+			return "", false
+		}
+		b, err := hex.DecodeString(name[i+1 : i+3])
+		if err != nil {
+			return "", false
+		}
+		sb.Write(b)
+		i += 2
+	}
+	return sb.String(), true
+}
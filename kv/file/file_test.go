@@ -0,0 +1,248 @@
+package file
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/dskit/flagext"
+	"github.com/grafana/dskit/kv/codec"
+)
+
+func TestClient_CASShouldBeConsistentAcrossClientsSharingTheDirectory(t *testing.T) {
+	const concurrency = 10
+
+	cfg := Config{}
+	flagext.DefaultValues(&cfg)
+	cfg.Dir = t.TempDir()
+
+	ctx := context.Background()
+	wg := sync.WaitGroup{}
+	wg.Add(concurrency)
+
+	// Each client has its own lock, like clients in different processes.
+	for i := 0; i < concurrency; i++ {
+		client, err := New(cfg, codec.String{}, log.NewNopLogger())
+		require.NoError(t, err)
+
+		go func() {
+			defer wg.Done()
+
+			err := client.CAS(ctx, "counter", func(in interface{}) (interface{}, bool, error) {
+				if in == nil {
+					return "1", true, nil
+				}
+				value, err := strconv.Atoi(in.(string))
+				if err != nil {
+					return nil, false, err
+				}
+				return strconv.Itoa(value + 1), true, nil
+			})
+			assert.NoError(t, err)
+		}()
+	}
+
+	wg.Wait()
+
+	client, err := New(cfg, codec.String{}, log.NewNopLogger())
+	require.NoError(t, err)
+	value, err := client.Get(ctx, "counter")
+	require.NoError(t, err)
+	assert.Equal(t, strconv.Itoa(concurrency), value)
+}
+
+func TestClient_ShouldStoreKeysInFiles(t *testing.T) {
+	cfg := Config{}
+	flagext.DefaultValues(&cfg)
+	cfg.Dir = filepath.Join(t.TempDir(), "kv")
+
+	client, err := New(cfg, codec.String{}, log.NewNopLogger())
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	for _, key := range []string{"collectors/ring", "../escape", ".hidden"} {
+		require.NoError(t, client.CAS(ctx, key, func(interface{}) (interface{}, bool, error) {
+			return "value of " + key, false, nil
+		}))
+	}
+
+	// Leftover temporary files should be ignored.
+	require.NoError(t, os.WriteFile(filepath.Join(cfg.Dir, ".tmp-leftover"), []byte("partial"), 0o600))
+
+	entries, err := os.ReadDir(cfg.Dir)
+	require.NoError(t, err)
+	names := []string{}
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	assert.ElementsMatch(t, []string{".lock", ".tmp-leftover", "%2Ehidden", "%2E%2E%2Fescape", "collectors%2Fring"}, names)
+
+	value, err := os.ReadFile(filepath.Join(cfg.Dir, "collectors%2Fring"))
+	require.NoError(t, err)
+	assert.Equal(t, "value of collectors/ring", string(value))
+
+	keys, err := client.List(ctx, "")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"../escape", ".hidden", "collectors/ring"}, keys)
+
+	require.NoError(t, client.Delete(ctx, "collectors/ring"))
+	require.NoError(t, client.Delete(ctx, "collectors/ring"), "deleting a missing key should not fail")
+
+	actual, err := client.Get(ctx, "collectors/ring")
+	require.NoError(t, err)
+	assert.Nil(t, actual)
+}
+
+func TestClient_CASMultiShouldCommitAllTheValuesAtOnce(t *testing.T) {
+	cfg := Config{}
+	flagext.DefaultValues(&cfg)
+	cfg.Dir = t.TempDir()
+
+	client, err := New(cfg, codec.String{}, log.NewNopLogger())
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	require.NoError(t, client.CAS(ctx, "a", func(interface{}) (interface{}, bool, error) {
+		return "a1", false, nil
+	}))
+
+	require.NoError(t, client.CASMulti(ctx, []string{"a", "b"}, func(in []interface{}) ([]interface{}, bool, error) {
+		assert.Equal(t, []interface{}{"a1", nil}, in)
+		return []interface{}{"a2", "b2"}, false, nil
+	}))
+
+	// The journal should have been applied and removed.
+	_, err = os.Stat(filepath.Join(cfg.Dir, txnFileName))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	for key, expected := range map[string]string{"a": "a2", "b": "b2"} {
+		value, err := os.ReadFile(filepath.Join(cfg.Dir, key))
+		require.NoError(t, err)
+		assert.Equal(t, expected, string(value))
+	}
+}
+
+func TestClient_ShouldReadAndApplyTheJournalLeftByACrashedCASMulti(t *testing.T) {
+	cfg := Config{}
+	flagext.DefaultValues(&cfg)
+	cfg.Dir = t.TempDir()
+
+	client, err := New(cfg, codec.String{}, log.NewNopLogger())
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	require.NoError(t, client.CAS(ctx, "a", func(interface{}) (interface{}, bool, error) {
+		return "a1", false, nil
+	}))
+
+	// Simulate a process crashed after committing the journal, but before applying it.
+	require.NoError(t, client.writeTxn(map[string][]byte{"a": []byte("a2"), "b": []byte("b2")}))
+
+	// Readers should observe all the committed values.
+	for key, expected := range map[string]string{"a": "a2", "b": "b2"} {
+		value, err := client.Get(ctx, key)
+		require.NoError(t, err)
+		assert.Equal(t, expected, value)
+	}
+	keys, err := client.List(ctx, "")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, keys)
+
+	// The next operation acquiring the lock should apply the journal before running.
+	require.NoError(t, client.CAS(ctx, "b", func(in interface{}) (interface{}, bool, error) {
+		assert.Equal(t, "b2", in)
+		return "b3", false, nil
+	}))
+
+	_, err = os.Stat(filepath.Join(cfg.Dir, txnFileName))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	for key, expected := range map[string]string{"a": "a2", "b": "b3"} {
+		value, err := os.ReadFile(filepath.Join(cfg.Dir, key))
+		require.NoError(t, err)
+		assert.Equal(t, expected, string(value))
+	}
+}
+
+func TestClient_WatchShouldNotifyUpdatesFromOtherClients(t *testing.T) {
+	cfg := Config{}
+	flagext.DefaultValues(&cfg)
+	cfg.Dir = t.TempDir()
+
+	writer, err := New(cfg, codec.String{}, log.NewNopLogger())
+	require.NoError(t, err)
+	watcher, err := New(cfg, codec.String{}, log.NewNopLogger())
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	set := func(key, value string) {
+		require.NoError(t, writer.CAS(ctx, key, func(interface{}) (interface{}, bool, error) {
+			return value, false, nil
+		}))
+	}
+	set("prefix/key", "initial")
+
+	keyUpdates := make(chan string, 10)
+	prefixUpdates := make(chan string, 10)
+	go watcher.WatchKey(ctx, "prefix/key", func(value interface{}) bool {
+		keyUpdates <- value.(string)
+		return true
+	})
+	go watcher.WatchPrefix(ctx, "prefix/", func(key string, value interface{}) bool {
+		prefixUpdates <- key + "=" + value.(string)
+		return true
+	})
+
+	// The current values should be notified first.
+	assert.Equal(t, "initial", receive(t, keyUpdates))
+	assert.Equal(t, "prefix/key=initial", receive(t, prefixUpdates))
+
+	set("other", "ignored")
+	set("prefix/key", "updated")
+	assert.Equal(t, "updated", receive(t, keyUpdates))
+	assert.Equal(t, "prefix/key=updated", receive(t, prefixUpdates))
+
+	set("prefix/new", "created")
+	assert.Equal(t, "prefix/new=created", receive(t, prefixUpdates))
+}
+
+func TestFileName(t *testing.T) {
+	for _, key := range []string{"a", "A", "collectors/ring", "Collectors/Ring", "..", "100%", "ünïcode", "with space"} {
+		name := fileName(key)
+		assert.NotEqual(t, '.', name[0])
+		assert.NotContains(t, name, "/")
+
+		actual, ok := keyFromFileName(name)
+		assert.True(t, ok)
+		assert.Equal(t, key, actual)
+	}
+
+	// Keys differing only by case should be stored in files whose names don't differ only by case,
+	// to not clash on case-insensitive filesystems.
+	assert.NotEqual(t, strings.ToLower(fileName("collectors/ring")), strings.ToLower(fileName("Collectors/Ring")))
+	assert.Equal(t, "%41", fileName("A"))
+
+	for _, name := range []string{"", ".lock", ".tmp-123", "invalid%", "invalid%2", "invalid%zz"} {
+		_, ok := keyFromFileName(name)
+		assert.False(t, ok, name)
+	}
+}
+
+func receive(t *testing.T, ch chan string) string {
+	select {
+	case value := <-ch:
+		return value
+	case <-time.After(5 * time.Second):
+		t.Fatal("no notification received")
+		return ""
+	}
+}
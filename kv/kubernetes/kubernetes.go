@@ -0,0 +1,478 @@
+package kubernetes
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+
+	"github.com/grafana/dskit/backoff"
+	dstls "github.com/grafana/dskit/crypto/tls"
+	"github.com/grafana/dskit/kv/codec"
+)
+
+const (
+	// managedByLabel is the label set on all ConfigMaps created by the Client, and used to list and watch them.
+	managedByLabel      = "app.kubernetes.io/managed-by"
+	managedByLabelValue = "dskit"
+
+	// keyAnnotation is the annotation storing the key of a ConfigMap, because keys are not valid ConfigMap names.
+	keyAnnotation = "dskit.grafana.com/kv-key"
+
+	// valueDataKey is the ConfigMap binaryData entry storing the encoded value.
+	valueDataKey = "value"
+
+	configMapNamePrefix = "dskit-kv-"
+
+	// maxConfigMapValueSize is the maximum size of the data stored in a ConfigMap, enforced by the API server.
+	maxConfigMapValueSize = 1 << 20
+
+	watchTimeout = 5 * time.Minute
+)
+
+var (
+	backoffConfig = backoff.Config{
+		MinBackoff: 1 * time.Second,
+		MaxBackoff: 1 * time.Minute,
+	}
+
+	// ErrValueTooLarge is returned by CAS when the encoded value doesn't fit in a ConfigMap.
+	ErrValueTooLarge = fmt.Errorf("the encoded value exceeds the ConfigMap size limit of %d bytes", maxConfigMapValueSize)
+)
+
+// Config for a new kubernetes.Client.
+type Config struct {
+	URL             string             `yaml:"url"`
+	Namespace       string             `yaml:"namespace"`
+	BearerTokenFile string             `yaml:"bearer_token_file" category:"advanced"`
+	RequestTimeout  time.Duration      `yaml:"request_timeout" category:"advanced"`
+	MaxCASRetries   int                `yaml:"max_cas_retries" category:"advanced"`
+	CASRetryDelay   time.Duration      `yaml:"cas_retry_delay" category:"advanced"`
+	EnableTLS       bool               `yaml:"tls_enabled" category:"advanced"`
+	TLS             dstls.ClientConfig `yaml:",inline"`
+}
+
+func (cfg *Config) RegisterFlags(f *flag.FlagSet) {
+	cfg.RegisterFlagsWithPrefix(f, "")
+}
+
+// RegisterFlagsWithPrefix adds the flags required to config this to the given FlagSet.
+func (cfg *Config) RegisterFlagsWithPrefix(f *flag.FlagSet, prefix string) {
+	f.StringVar(&cfg.URL, prefix+"kubernetes.url", "https://kubernetes.default.svc", "The URL of the Kubernetes API server, or of any HTTP server exposing a compatible ConfigMaps API.")
+	f.StringVar(&cfg.Namespace, prefix+"kubernetes.namespace", "default", "The namespace where ConfigMaps are stored.")
+	f.StringVar(&cfg.BearerTokenFile, prefix+"kubernetes.bearer-token-file", "", "Path to the file containing the bearer token used to authenticate to the API server. The file is read on every request, so that the token can be rotated.")
+	f.DurationVar(&cfg.RequestTimeout, prefix+"kubernetes.request-timeout", 10*time.Second, "The timeout of the requests to the API server, except watches.")
+	f.IntVar(&cfg.MaxCASRetries, prefix+"kubernetes.max-cas-retries", 10, "The maximum number of retries of a CAS operation, when it fails because of a concurrent update or an error.")
+	f.DurationVar(&cfg.CASRetryDelay, prefix+"kubernetes.cas-retry-delay", 1*time.Second, "Maximum duration to wait before retrying a CAS operation. The actual delay is picked randomly between 0 and this value.")
+	f.BoolVar(&cfg.EnableTLS, prefix+"kubernetes.tls-enabled", false, "Enable TLS.")
+	cfg.TLS.RegisterFlagsWithPrefix(prefix+"kubernetes", f)
+}
+
+// Client implements kv.Client storing each key in a Kubernetes ConfigMap. Updates use the ConfigMap
+// resourceVersion for optimistic concurrency, and WatchKey and WatchPrefix use watch streams.
+//
+// Keys are not valid ConfigMap names, so each ConfigMap is named after the hash of its key, while
+// the key itself is stored in an annotation. Values are limited to the 1 MiB ConfigMap size limit.
+type Client struct {
+	cfg    Config
+	codec  codec.Codec
+	client *http.Client
+	logger log.Logger
+}
+
+// New makes a new Client.
+func New(cfg Config, codec codec.Codec, logger log.Logger) (*Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	if cfg.EnableTLS {
+		tlsConfig, err := cfg.TLS.GetTLSConfig()
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to initialise TLS configuration for kubernetes")
+		}
+		transport.TLSClientConfig = tlsConfig
+	}
+
+	return &Client{
+		cfg:    cfg,
+		codec:  codec,
+		client: &http.Client{Transport: transport},
+		logger: logger,
+	}, nil
+}
+
+// CAS implements kv.Client.
+func (c *Client) CAS(ctx context.Context, key string, f func(in interface{}) (out interface{}, retry bool, err error)) error {
+	var lastErr error
+	name := configMapName(key)
+
+	for i := 0; i < c.cfg.MaxCASRetries; i++ {
+		// Wait before retrying, so that concurrent updates of the same key don't conflict over and over.
+		if i > 0 && c.cfg.CASRetryDelay > 0 {
+			select {
+			case <-time.After(time.Duration(rand.Int63n(c.cfg.CASRetryDelay.Nanoseconds()))):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		cm, err := c.getConfigMap(ctx, name)
+		if err != nil {
+			level.Error(c.logger).Log("msg", "error getting key", "key", key, "err", err)
+			lastErr = err
+			continue
+		}
+
+		var intermediate interface{}
+		if cm != nil {
+			intermediate, err = c.codec.Decode(cm.BinaryData[valueDataKey])
+			if err != nil {
+				level.Error(c.logger).Log("msg", "error decoding key", "key", key, "err", err)
+				lastErr = err
+				continue
+			}
+		}
+
+		var retry bool
+		intermediate, retry, err = f(intermediate)
+		if err != nil {
+			if !retry {
+				return err
+			}
+			lastErr = err
+			continue
+		}
+
+		// Callback returning nil means it doesn't want to CAS anymore.
+		if intermediate == nil {
+			return nil
+		}
+
+		buf, err := c.codec.Encode(intermediate)
+		if err != nil {
+			level.Error(c.logger).Log("msg", "error serialising value", "key", key, "err", err)
+			lastErr = err
+			continue
+		}
+
+		// The API server would reject the update anyway, so there's no point in retrying.
+		if len(buf) > maxConfigMapValueSize {
+			return errors.Wrapf(ErrValueTooLarge, "key %s: %d bytes", key, len(buf))
+		}
+
+		if cm == nil {
+			err = c.do(ctx, http.MethodPost, c.configMapsURL(""), newConfigMap(c.cfg.Namespace, key, buf), nil)
+		} else {
+			// The update is rejected with a conflict if the resourceVersion doesn't match the stored one anymore.
+			cm.BinaryData = map[string][]byte{valueDataKey: buf}
+			err = c.do(ctx, http.MethodPut, c.configMapsURL(name), cm, nil)
+		}
+
+		if isStatusReason(err, statusReasonConflict) || isStatusReason(err, statusReasonAlreadyExists) {
+			level.Debug(c.logger).Log("msg", "failed to CAS, resource version did not match", "key", key)
+			continue
+		}
+		if err != nil {
+			level.Error(c.logger).Log("msg", "error CASing", "key", key, "err", err)
+			lastErr = err
+			continue
+		}
+
+		return nil
+	}
+
+	if lastErr != nil {
+		return lastErr
+	}
+	return fmt.Errorf("failed to CAS %s", key)
+}
+
+// WatchKey implements kv.Client.
+func (c *Client) WatchKey(ctx context.Context, key string, f func(interface{}) bool) {
+	c.watch(ctx, "metadata.name="+configMapName(key), func(k string) bool { return k == key }, func(_ string, value interface{}) bool {
+		return f(value)
+	})
+}
+
+// WatchPrefix implements kv.Client.
+func (c *Client) WatchPrefix(ctx context.Context, prefix string, f func(string, interface{}) bool) {
+	c.watch(ctx, "", func(k string) bool { return strings.HasPrefix(k, prefix) }, f)
+}
+
+// watch lists the ConfigMaps matching the input field selector, and then watches them, calling f for
+// each ConfigMap whose key matches. When the watch stream ends or fails, the ConfigMaps are listed again.
+func (c *Client) watch(ctx context.Context, fieldSelector string, match func(string) bool, f func(string, interface{}) bool) {
+	backoff := backoff.New(ctx, backoffConfig)
+
+	// The resource version of the last notified value of each ConfigMap, used to not notify the same
+	// value again when listing the ConfigMaps after the watch stream ended.
+	notified := map[string]string{}
+
+	notify := func(cm *configMap) bool {
+		key, ok := cm.Metadata.Annotations[keyAnnotation]
+		if !ok || !match(key) || notified[cm.Metadata.Name] == cm.Metadata.ResourceVersion {
+			return true
+		}
+		notified[cm.Metadata.Name] = cm.Metadata.ResourceVersion
+
+		out, err := c.codec.Decode(cm.BinaryData[valueDataKey])
+		if err != nil {
+			level.Error(c.logger).Log("msg", "error decoding key", "key", key, "err", err)
+			return true
+		}
+
+		return f(key, out)
+	}
+
+	for backoff.Ongoing() {
+		list, err := c.listConfigMaps(ctx, fieldSelector)
+		if err != nil {
+			level.Error(c.logger).Log("msg", "error listing keys", "err", err)
+			backoff.Wait()
+			continue
+		}
+
+		for i := range list.Items {
+			if !notify(&list.Items[i]) {
+				return
+			}
+		}
+
+		stopped, err := c.watchFrom(ctx, fieldSelector, list.Metadata.ResourceVersion, notify, notified)
+		if stopped {
+			return
+		}
+		if err != nil {
+			level.Error(c.logger).Log("msg", "watch error", "err", err)
+			backoff.Wait()
+			continue
+		}
+
+		backoff.Reset()
+	}
+}
+
+// watchFrom watches the ConfigMaps changed after resourceVersion until the watch stream ends. It returns
+// whether the watch has been stopped by notify.
+func (c *Client) watchFrom(ctx context.Context, fieldSelector, resourceVersion string, notify func(*configMap) bool, notified map[string]string) (bool, error) {
+	query := c.selectorQuery(fieldSelector)
+	query.Set("watch", "true")
+	query.Set("resourceVersion", resourceVersion)
+	query.Set("timeoutSeconds", fmt.Sprintf("%d", int(watchTimeout.Seconds())))
+
+	req, err := c.newRequest(ctx, http.MethodGet, c.configMapsURL("")+"?"+query.Encode(), nil)
+	if err != nil {
+		return false, err
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return false, readStatusError(resp)
+	}
+
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var event watchEvent
+		if err := decoder.Decode(&event); err != nil {
+			if errors.Is(err, io.EOF) || ctx.Err() != nil {
+				return false, nil
+			}
+			return false, err
+		}
+
+		switch event.Type {
+		case watchEventAdded, watchEventModified:
+			var cm configMap
+			if err := json.Unmarshal(event.Object, &cm); err != nil {
+				return false, err
+			}
+			if !notify(&cm) {
+				return true, nil
+			}
+
+		case watchEventDeleted:
+			// Delete notification. Since not all KV store clients (and codecs) support this, we ignore it.
+			var cm configMap
+			if err := json.Unmarshal(event.Object, &cm); err == nil {
+				delete(notified, cm.Metadata.Name)
+			}
+
+		case watchEventError:
+			// The resource version is too old (or any other error): list again.
+			var s status
+			if err := json.Unmarshal(event.Object, &s); err != nil {
+				return false, err
+			}
+			return false, &s
+		}
+	}
+}
+
+// List implements kv.Client.
+func (c *Client) List(ctx context.Context, prefix string) ([]string, error) {
+	list, err := c.listConfigMaps(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(list.Items))
+	for _, cm := range list.Items {
+		if key, ok := cm.Metadata.Annotations[keyAnnotation]; ok && strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+// Get implements kv.Client.
+func (c *Client) Get(ctx context.Context, key string) (interface{}, error) {
+	cm, err := c.getConfigMap(ctx, configMapName(key))
+	if err != nil || cm == nil {
+		return nil, err
+	}
+	return c.codec.Decode(cm.BinaryData[valueDataKey])
+}
+
+// Delete implements kv.Client.
+func (c *Client) Delete(ctx context.Context, key string) error {
+	err := c.do(ctx, http.MethodDelete, c.configMapsURL(configMapName(key)), nil, nil)
+	if isStatusReason(err, statusReasonNotFound) {
+		return nil
+	}
+	return err
+}
+
+// getConfigMap returns the ConfigMap with the input name, or nil if it doesn't exist.
+func (c *Client) getConfigMap(ctx context.Context, name string) (*configMap, error) {
+	cm := &configMap{}
+	err := c.do(ctx, http.MethodGet, c.configMapsURL(name), nil, cm)
+	if isStatusReason(err, statusReasonNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return cm, nil
+}
+
+func (c *Client) listConfigMaps(ctx context.Context, fieldSelector string) (*configMapList, error) {
+	list := &configMapList{}
+	if err := c.do(ctx, http.MethodGet, c.configMapsURL("")+"?"+c.selectorQuery(fieldSelector).Encode(), nil, list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func (c *Client) selectorQuery(fieldSelector string) url.Values {
+	query := url.Values{}
+	query.Set("labelSelector", managedByLabel+"="+managedByLabelValue)
+	if fieldSelector != "" {
+		query.Set("fieldSelector", fieldSelector)
+	}
+	return query
+}
+
+func (c *Client) configMapsURL(name string) string {
+	u := strings.TrimSuffix(c.cfg.URL, "/") + "/api/v1/namespaces/" + url.PathEscape(c.cfg.Namespace) + "/configmaps"
+	if name != "" {
+		u += "/" + url.PathEscape(name)
+	}
+	return u
+}
+
+// do sends a request with the input JSON body, and decodes the JSON response into out, if not nil.
+// An error of type *status is returned if the API server rejects the request.
+func (c *Client) do(ctx context.Context, method, url string, in, out interface{}) error {
+	ctx, cancel := context.WithTimeout(ctx, c.cfg.RequestTimeout)
+	defer cancel()
+
+	var body io.Reader
+	if in != nil {
+		buf, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(buf)
+	}
+
+	req, err := c.newRequest(ctx, method, url, body)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return readStatusError(resp)
+	}
+
+	if out == nil {
+		_, err = io.Copy(io.Discard, resp.Body)
+		return err
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (c *Client) newRequest(ctx context.Context, method, url string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	if c.cfg.BearerTokenFile != "" {
+		token, err := os.ReadFile(c.cfg.BearerTokenFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "read bearer token file")
+		}
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+
+	return req, nil
+}
+
+// configMapName returns the name of the ConfigMap storing key.
+func configMapName(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return configMapNamePrefix + hex.EncodeToString(hash[:16])
+}
+
+func newConfigMap(namespace, key string, value []byte) *configMap {
+	return &configMap{
+		APIVersion: "v1",
+		Kind:       "ConfigMap",
+		Metadata: objectMeta{
+			Name:        configMapName(key),
+			Namespace:   namespace,
+			Labels:      map[string]string{managedByLabel: managedByLabelValue},
+			Annotations: map[string]string{keyAnnotation: key},
+		},
+		BinaryData: map[string][]byte{valueDataKey: value},
+	}
+}
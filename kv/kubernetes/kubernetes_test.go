@@ -0,0 +1,190 @@
+package kubernetes
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/dskit/flagext"
+	"github.com/grafana/dskit/kv/codec"
+)
+
+func TestClient_CASShouldBeConsistentOnConcurrentUpdates(t *testing.T) {
+	const concurrency = 10
+
+	server := NewFakeAPIServer()
+	t.Cleanup(func() { _ = server.Close() })
+
+	cfg := Config{}
+	flagext.DefaultValues(&cfg)
+	cfg.URL = server.URL()
+	cfg.MaxCASRetries = 100
+	cfg.CASRetryDelay = 10 * time.Millisecond
+
+	client, err := New(cfg, codec.String{}, log.NewNopLogger())
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	wg := sync.WaitGroup{}
+	wg.Add(concurrency)
+
+	for i := 0; i < concurrency; i++ {
+		go func() {
+			defer wg.Done()
+
+			err := client.CAS(ctx, "counter", func(in interface{}) (interface{}, bool, error) {
+				if in == nil {
+					return "1", true, nil
+				}
+				value, err := strconv.Atoi(in.(string))
+				if err != nil {
+					return nil, false, err
+				}
+				return strconv.Itoa(value + 1), true, nil
+			})
+			assert.NoError(t, err)
+		}()
+	}
+
+	wg.Wait()
+
+	value, err := client.Get(ctx, "counter")
+	require.NoError(t, err)
+	assert.Equal(t, strconv.Itoa(concurrency), value)
+}
+
+func TestClient_CASShouldFailOnValuesExceedingTheConfigMapSizeLimit(t *testing.T) {
+	client, closer := NewInMemoryClient(codec.String{}, log.NewNopLogger())
+	t.Cleanup(func() { _ = closer.Close() })
+
+	ctx := context.Background()
+	calls := 0
+	err := client.CAS(ctx, "large", func(interface{}) (interface{}, bool, error) {
+		calls++
+		return strings.Repeat("x", maxConfigMapValueSize+1), true, nil
+	})
+	require.ErrorIs(t, err, ErrValueTooLarge)
+
+	// The CAS should not be retried, and nothing should be stored.
+	assert.Equal(t, 1, calls)
+	value, err := client.Get(ctx, "large")
+	require.NoError(t, err)
+	assert.Nil(t, value)
+
+	// A value within the limit should be stored.
+	require.NoError(t, client.CAS(ctx, "large", func(interface{}) (interface{}, bool, error) {
+		return strings.Repeat("x", maxConfigMapValueSize), false, nil
+	}))
+}
+
+func TestClient_ShouldStoreKeysInConfigMaps(t *testing.T) {
+	client, closer := NewInMemoryClient(codec.String{}, log.NewNopLogger())
+	t.Cleanup(func() { _ = closer.Close() })
+
+	ctx := context.Background()
+	require.NoError(t, client.CAS(ctx, "collectors/ring", func(interface{}) (interface{}, bool, error) {
+		return "value", false, nil
+	}))
+
+	resp, err := http.Get(client.configMapsURL(configMapName("collectors/ring")))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = resp.Body.Close() })
+
+	// The ConfigMap should be compatible with the Kubernetes API.
+	var actual map[string]interface{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&actual))
+	assert.Equal(t, "ConfigMap", actual["kind"])
+	assert.Equal(t, map[string]interface{}{
+		"name":            configMapName("collectors/ring"),
+		"namespace":       "default",
+		"resourceVersion": "1",
+		"labels":          map[string]interface{}{managedByLabel: managedByLabelValue},
+		"annotations":     map[string]interface{}{keyAnnotation: "collectors/ring"},
+	}, actual["metadata"])
+	assert.Equal(t, map[string]interface{}{valueDataKey: "dmFsdWU="}, actual["binaryData"])
+
+	require.NoError(t, client.Delete(ctx, "collectors/ring"))
+	require.NoError(t, client.Delete(ctx, "collectors/ring"), "deleting a missing key should not fail")
+
+	value, err := client.Get(ctx, "collectors/ring")
+	require.NoError(t, err)
+	assert.Nil(t, value)
+}
+
+func TestClient_WatchKeyShouldNotifyCurrentValueAndUpdates(t *testing.T) {
+	client, closer := NewInMemoryClient(codec.String{}, log.NewNopLogger())
+	t.Cleanup(func() { _ = closer.Close() })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	set := func(key, value string) {
+		require.NoError(t, client.CAS(ctx, key, func(interface{}) (interface{}, bool, error) {
+			return value, false, nil
+		}))
+	}
+	set("key", "initial")
+
+	observed := make(chan string, 10)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		client.WatchKey(ctx, "key", func(value interface{}) bool {
+			observed <- value.(string)
+			return value != "last"
+		})
+	}()
+
+	assert.Equal(t, "initial", <-observed)
+
+	set("other", "ignored")
+	set("key", "updated")
+	assert.Equal(t, "updated", <-observed)
+
+	set("key", "last")
+	assert.Equal(t, "last", <-observed)
+
+	// The watch should stop once the callback returns false.
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("WatchKey() didn't return")
+	}
+}
+
+func TestClient_ShouldAuthenticateWithBearerToken(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(tokenFile, []byte("my-token\n"), 0o600))
+
+	var authorization string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		writeStatus(w, newStatus(http.StatusNotFound, statusReasonNotFound, "not found"))
+	}))
+	t.Cleanup(server.Close)
+
+	cfg := Config{}
+	flagext.DefaultValues(&cfg)
+	cfg.URL = server.URL
+	cfg.BearerTokenFile = tokenFile
+
+	client, err := New(cfg, codec.String{}, log.NewNopLogger())
+	require.NoError(t, err)
+
+	value, err := client.Get(context.Background(), "key")
+	require.NoError(t, err)
+	assert.Nil(t, value)
+	assert.Equal(t, "Bearer my-token", authorization)
+}
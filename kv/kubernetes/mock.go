@@ -0,0 +1,379 @@
+package kubernetes
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+
+	"github.com/grafana/dskit/flagext"
+	"github.com/grafana/dskit/kv/codec"
+)
+
+// NewInMemoryClient creates a Client connected to an in-process FakeAPIServer.
+// The returned io.Closer stops the FakeAPIServer.
+func NewInMemoryClient(codec codec.Codec, logger log.Logger) (*Client, io.Closer) {
+	server := NewFakeAPIServer()
+
+	// Make sure to set default values for the config including number of retries,
+	// otherwise the client won't even attempt a CAS operation
+	cfg := Config{}
+	flagext.DefaultValues(&cfg)
+	cfg.URL = server.URL()
+
+	client, err := New(cfg, codec, logger)
+	if err != nil {
+		// Can't happen, because TLS is disabled.
+		panic(err)
+	}
+
+	return client, server
+}
+
+// FakeAPIServer is an in-process HTTP server implementing the subset of the Kubernetes ConfigMaps
+// API used by the Client: get, list, create, update, delete and watch, with resourceVersion based
+// optimistic concurrency. It exists to test the Client without a Kubernetes cluster.
+//
+// Known limitations:
+//
+//   - Only equality-based label and field selectors are supported, and the only supported field is metadata.name
+//   - Watch history is never compacted
+//   - Authentication is not implemented
+type FakeAPIServer struct {
+	server *httptest.Server
+
+	mtx             sync.Mutex
+	resourceVersion int64
+	configMaps      map[string]configMap // By namespace/name.
+	events          []fakeEvent
+	changed         chan struct{} // Closed and replaced on every change.
+	closed          chan struct{}
+}
+
+type fakeEvent struct {
+	resourceVersion int64
+	eventType       string
+	configMap       configMap
+}
+
+// NewFakeAPIServer makes and starts a new FakeAPIServer.
+func NewFakeAPIServer() *FakeAPIServer {
+	s := &FakeAPIServer{
+		configMaps: map[string]configMap{},
+		changed:    make(chan struct{}),
+		closed:     make(chan struct{}),
+	}
+	s.server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// URL returns the base URL of the server.
+func (s *FakeAPIServer) URL() string {
+	return s.server.URL
+}
+
+// Close stops the server, terminating all the watch streams.
+func (s *FakeAPIServer) Close() error {
+	close(s.closed)
+	s.server.Close()
+	return nil
+}
+
+func (s *FakeAPIServer) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	// Path: /api/v1/namespaces/<namespace>/configmaps[/<name>]
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) < 5 || len(parts) > 6 || parts[0] != "api" || parts[1] != "v1" || parts[2] != "namespaces" || parts[4] != "configmaps" {
+		writeStatus(w, newStatus(http.StatusNotFound, statusReasonNotFound, "the server could not find the requested resource"))
+		return
+	}
+
+	namespace := parts[3]
+	if len(parts) == 5 {
+		switch r.Method {
+		case http.MethodGet:
+			if r.URL.Query().Get("watch") == "true" {
+				s.watch(w, r, namespace)
+			} else {
+				s.list(w, r, namespace)
+			}
+		case http.MethodPost:
+			s.create(w, r, namespace)
+		default:
+			writeStatus(w, newStatus(http.StatusMethodNotAllowed, "MethodNotAllowed", "method not allowed"))
+		}
+		return
+	}
+
+	name := parts[5]
+	switch r.Method {
+	case http.MethodGet:
+		s.get(w, namespace, name)
+	case http.MethodPut:
+		s.update(w, r, namespace, name)
+	case http.MethodDelete:
+		s.delete(w, namespace, name)
+	default:
+		writeStatus(w, newStatus(http.StatusMethodNotAllowed, "MethodNotAllowed", "method not allowed"))
+	}
+}
+
+func (s *FakeAPIServer) get(w http.ResponseWriter, namespace, name string) {
+	s.mtx.Lock()
+	cm, ok := s.configMaps[namespace+"/"+name]
+	s.mtx.Unlock()
+
+	if !ok {
+		writeStatus(w, newStatus(http.StatusNotFound, statusReasonNotFound, "configmaps \""+name+"\" not found"))
+		return
+	}
+	writeJSON(w, http.StatusOK, cm)
+}
+
+func (s *FakeAPIServer) list(w http.ResponseWriter, r *http.Request, namespace string) {
+	selector, ok := parseFakeSelector(w, r)
+	if !ok {
+		return
+	}
+
+	s.mtx.Lock()
+	list := configMapList{
+		APIVersion: "v1",
+		Kind:       "ConfigMapList",
+		Metadata:   listMeta{ResourceVersion: strconv.FormatInt(s.resourceVersion, 10)},
+		Items:      []configMap{},
+	}
+	for _, cm := range s.configMaps {
+		if cm.Metadata.Namespace == namespace && selector.matches(cm) {
+			list.Items = append(list.Items, cm)
+		}
+	}
+	s.mtx.Unlock()
+
+	sort.Slice(list.Items, func(i, j int) bool { return list.Items[i].Metadata.Name < list.Items[j].Metadata.Name })
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (s *FakeAPIServer) create(w http.ResponseWriter, r *http.Request, namespace string) {
+	cm, ok := decodeFakeConfigMap(w, r)
+	if !ok {
+		return
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	id := namespace + "/" + cm.Metadata.Name
+	if _, exists := s.configMaps[id]; exists {
+		writeStatus(w, newStatus(http.StatusConflict, statusReasonAlreadyExists, "configmaps \""+cm.Metadata.Name+"\" already exists"))
+		return
+	}
+
+	cm.Metadata.Namespace = namespace
+	s.storeLocked(id, cm, watchEventAdded)
+	writeJSON(w, http.StatusCreated, s.configMaps[id])
+}
+
+func (s *FakeAPIServer) update(w http.ResponseWriter, r *http.Request, namespace, name string) {
+	cm, ok := decodeFakeConfigMap(w, r)
+	if !ok {
+		return
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	id := namespace + "/" + name
+	stored, exists := s.configMaps[id]
+	if !exists {
+		writeStatus(w, newStatus(http.StatusNotFound, statusReasonNotFound, "configmaps \""+name+"\" not found"))
+		return
+	}
+	if cm.Metadata.ResourceVersion != stored.Metadata.ResourceVersion {
+		writeStatus(w, newStatus(http.StatusConflict, statusReasonConflict, "the object has been modified; please apply your changes to the latest version and try again"))
+		return
+	}
+
+	cm.Metadata.Name = name
+	cm.Metadata.Namespace = namespace
+	s.storeLocked(id, cm, watchEventModified)
+	writeJSON(w, http.StatusOK, s.configMaps[id])
+}
+
+func (s *FakeAPIServer) delete(w http.ResponseWriter, namespace, name string) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	id := namespace + "/" + name
+	cm, exists := s.configMaps[id]
+	if !exists {
+		writeStatus(w, newStatus(http.StatusNotFound, statusReasonNotFound, "configmaps \""+name+"\" not found"))
+		return
+	}
+
+	delete(s.configMaps, id)
+	cm.Metadata.ResourceVersion = s.nextResourceVersionLocked()
+	s.appendEventLocked(watchEventDeleted, cm)
+	writeJSON(w, http.StatusOK, &status{APIVersion: "v1", Kind: "Status", Status: "Success", Code: http.StatusOK})
+}
+
+// storeLocked stores cm with a new resource version, and records the event. Must be called with the lock held.
+func (s *FakeAPIServer) storeLocked(id string, cm configMap, eventType string) {
+	cm.Metadata.ResourceVersion = s.nextResourceVersionLocked()
+	s.configMaps[id] = cm
+	s.appendEventLocked(eventType, cm)
+}
+
+func (s *FakeAPIServer) nextResourceVersionLocked() string {
+	s.resourceVersion++
+	return strconv.FormatInt(s.resourceVersion, 10)
+}
+
+// appendEventLocked records the event, and notifies the watchers. Must be called with the lock held.
+func (s *FakeAPIServer) appendEventLocked(eventType string, cm configMap) {
+	s.events = append(s.events, fakeEvent{resourceVersion: s.resourceVersion, eventType: eventType, configMap: cm})
+
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+func (s *FakeAPIServer) watch(w http.ResponseWriter, r *http.Request, namespace string) {
+	selector, ok := parseFakeSelector(w, r)
+	if !ok {
+		return
+	}
+
+	from, err := strconv.ParseInt(r.URL.Query().Get("resourceVersion"), 10, 64)
+	if err != nil {
+		writeStatus(w, newStatus(http.StatusBadRequest, "BadRequest", "invalid resourceVersion"))
+		return
+	}
+
+	timeout := time.Duration(0)
+	if seconds, err := strconv.Atoi(r.URL.Query().Get("timeoutSeconds")); err == nil {
+		timeout = time.Duration(seconds) * time.Second
+	}
+	var timeoutCh <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		timeoutCh = timer.C
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	if flusher != nil {
+		flusher.Flush()
+	}
+
+	encoder := json.NewEncoder(w)
+	for {
+		s.mtx.Lock()
+		var pending []fakeEvent
+		for _, event := range s.events {
+			if event.resourceVersion > from {
+				pending = append(pending, event)
+			}
+		}
+		changed := s.changed
+		s.mtx.Unlock()
+
+		for _, event := range pending {
+			from = event.resourceVersion
+			if event.configMap.Metadata.Namespace != namespace || !selector.matches(event.configMap) {
+				continue
+			}
+
+			object, err := json.Marshal(event.configMap)
+			if err != nil {
+				return
+			}
+			if err := encoder.Encode(watchEvent{Type: event.eventType, Object: object}); err != nil {
+				return
+			}
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+
+		select {
+		case <-changed:
+		case <-timeoutCh:
+			return
+		case <-r.Context().Done():
+			return
+		case <-s.closed:
+			return
+		}
+	}
+}
+
+// fakeSelector is an equality-based label and field selector.
+type fakeSelector struct {
+	labels map[string]string
+	name   string
+}
+
+func parseFakeSelector(w http.ResponseWriter, r *http.Request) (fakeSelector, bool) {
+	selector := fakeSelector{labels: map[string]string{}}
+
+	if labelSelector := r.URL.Query().Get("labelSelector"); labelSelector != "" {
+		for _, requirement := range strings.Split(labelSelector, ",") {
+			label, value, ok := strings.Cut(requirement, "=")
+			if !ok {
+				writeStatus(w, newStatus(http.StatusBadRequest, "BadRequest", "unsupported label selector"))
+				return selector, false
+			}
+			selector.labels[label] = value
+		}
+	}
+
+	if fieldSelector := r.URL.Query().Get("fieldSelector"); fieldSelector != "" {
+		field, value, ok := strings.Cut(fieldSelector, "=")
+		if !ok || field != "metadata.name" {
+			writeStatus(w, newStatus(http.StatusBadRequest, "BadRequest", "unsupported field selector"))
+			return selector, false
+		}
+		selector.name = value
+	}
+
+	return selector, true
+}
+
+func (s fakeSelector) matches(cm configMap) bool {
+	if s.name != "" && cm.Metadata.Name != s.name {
+		return false
+	}
+	for label, value := range s.labels {
+		if cm.Metadata.Labels[label] != value {
+			return false
+		}
+	}
+	return true
+}
+
+func decodeFakeConfigMap(w http.ResponseWriter, r *http.Request) (configMap, bool) {
+	var cm configMap
+	if err := json.NewDecoder(r.Body).Decode(&cm); err != nil {
+		writeStatus(w, newStatus(http.StatusBadRequest, "BadRequest", err.Error()))
+		return cm, false
+	}
+	return cm, true
+}
+
+func writeStatus(w http.ResponseWriter, s *status) {
+	writeJSON(w, s.Code, s)
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
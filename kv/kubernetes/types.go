@@ -0,0 +1,92 @@
+package kubernetes
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// The subset of the Kubernetes API types used by the Client.
+
+const (
+	watchEventAdded    = "ADDED"
+	watchEventModified = "MODIFIED"
+	watchEventDeleted  = "DELETED"
+	watchEventError    = "ERROR"
+
+	statusReasonNotFound      = "NotFound"
+	statusReasonAlreadyExists = "AlreadyExists"
+	statusReasonConflict      = "Conflict"
+)
+
+type objectMeta struct {
+	Name            string            `json:"name"`
+	Namespace       string            `json:"namespace,omitempty"`
+	ResourceVersion string            `json:"resourceVersion,omitempty"`
+	Labels          map[string]string `json:"labels,omitempty"`
+	Annotations     map[string]string `json:"annotations,omitempty"`
+}
+
+type listMeta struct {
+	ResourceVersion string `json:"resourceVersion,omitempty"`
+}
+
+type configMap struct {
+	APIVersion string     `json:"apiVersion"`
+	Kind       string     `json:"kind"`
+	Metadata   objectMeta `json:"metadata"`
+
+	// BinaryData values are base64 encoded in JSON, like in the Kubernetes API.
+	BinaryData map[string][]byte `json:"binaryData,omitempty"`
+}
+
+type configMapList struct {
+	APIVersion string      `json:"apiVersion"`
+	Kind       string      `json:"kind"`
+	Metadata   listMeta    `json:"metadata"`
+	Items      []configMap `json:"items"`
+}
+
+type watchEvent struct {
+	Type   string          `json:"type"`
+	Object json.RawMessage `json:"object"`
+}
+
+// status is returned by the API server when a request fails.
+type status struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Status     string `json:"status"`
+	Message    string `json:"message"`
+	Reason     string `json:"reason"`
+	Code       int    `json:"code"`
+}
+
+func (s *status) Error() string {
+	return fmt.Sprintf("kubernetes API error (code: %d, reason: %s): %s", s.Code, s.Reason, s.Message)
+}
+
+func newStatus(code int, reason, message string) *status {
+	return &status{APIVersion: "v1", Kind: "Status", Status: "Failure", Message: message, Reason: reason, Code: code}
+}
+
+// readStatusError returns the error of a failed response.
+func readStatusError(resp *http.Response) error {
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	s := &status{}
+	if err := json.Unmarshal(body, s); err != nil || s.Kind != "Status" {
+		return newStatus(resp.StatusCode, http.StatusText(resp.StatusCode), string(body))
+	}
+	return s
+}
+
+func isStatusReason(err error, reason string) bool {
+	var s *status
+	return errors.As(err, &s) && s.Reason == reason
+}
@@ -15,6 +15,8 @@ import (
 	"github.com/grafana/dskit/kv/codec"
 	"github.com/grafana/dskit/kv/consul"
 	"github.com/grafana/dskit/kv/etcd"
+	"github.com/grafana/dskit/kv/file"
+	"github.com/grafana/dskit/kv/kubernetes"
 )
 
 func withFixtures(t *testing.T, f func(*testing.T, Client)) {
@@ -32,6 +34,14 @@ func withFixtures(t *testing.T, f func(*testing.T, Client)) {
 			client, closer := etcd.NewInMemoryClient(codec.String{}, testLogger{})
 			return client, closer, nil
 		}},
+		{"file", func() (Client, io.Closer, error) {
+			client, err := file.New(file.Config{Dir: t.TempDir(), PollInterval: time.Second, MaxCASRetries: 10}, codec.String{}, testLogger{})
+			return client, io.NopCloser(nil), err
+		}},
+		{"kubernetes", func() (Client, io.Closer, error) {
+			client, closer := kubernetes.NewInMemoryClient(codec.String{}, testLogger{})
+			return client, closer, nil
+		}},
 	} {
 		t.Run(fixture.name, func(t *testing.T) {
 			client, closer, err := fixture.factory()
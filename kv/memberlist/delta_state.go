@@ -0,0 +1,314 @@
+package memberlist
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/go-kit/log/level"
+	"github.com/hashicorp/memberlist"
+)
+
+const (
+	// deltaStateCodecID is the codec of the first KV pair of a delta state sent by LocalState. The key of
+	// the pair is the name of the sending node, and the following pairs are the digests of its keys.
+	deltaStateCodecID = "memberlist-delta-state"
+
+	// deltaValuesQueueSize is the size of the buffered channel of the requests to send the values missing
+	// from other members after a delta push/pull.
+	deltaValuesQueueSize = 16
+)
+
+// deltaValuesRequest is a request to send to a node the values it's missing, according to its digests.
+type deltaValuesRequest struct {
+	nodeName     string
+	remoteClocks map[string]vectorClock
+}
+
+// vectorClock tracks, for each node, the sequence number of the latest update of a value originated
+// by the node which is included in the local value.
+type vectorClock map[string]uint64
+
+func vectorClockFromEntries(entries []ClockEntry) vectorClock {
+	if len(entries) == 0 {
+		return nil
+	}
+	c := make(vectorClock, len(entries))
+	for _, e := range entries {
+		c[e.Node] = e.Seq
+	}
+	return c
+}
+
+// entries returns the entries of the clock, sorted by node.
+func (c vectorClock) entries() []ClockEntry {
+	if len(c) == 0 {
+		return nil
+	}
+	result := make([]ClockEntry, 0, len(c))
+	for node, seq := range c {
+		result = append(result, ClockEntry{Node: node, Seq: seq})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Node < result[j].Node })
+	return result
+}
+
+func (c vectorClock) clone() vectorClock {
+	if c == nil {
+		return nil
+	}
+	result := make(vectorClock, len(c))
+	for node, seq := range c {
+		result[node] = seq
+	}
+	return result
+}
+
+// merge updates each entry of the clock to the maximum of both clocks. The clock must not be nil.
+func (c vectorClock) merge(other vectorClock) {
+	for node, seq := range other {
+		if seq > c[node] {
+			c[node] = seq
+		}
+	}
+}
+
+// apply advances the clock with the update described by origin, only if the clock already includes
+// the previous update originated by the same node. The clock must not be nil.
+func (c vectorClock) apply(origin *Origin) {
+	if c[origin.Node] >= origin.PrevSeq && origin.Seq > c[origin.Node] {
+		c[origin.Node] = origin.Seq
+	}
+}
+
+// aheadOf returns true if the clock includes any update not included in other. Entries for which
+// ignore returns true are not compared.
+func (c vectorClock) aheadOf(other vectorClock, ignore func(node string, seq uint64) bool) bool {
+	for node, seq := range c {
+		if seq > other[node] && !ignore(node, seq) {
+			return true
+		}
+	}
+	return false
+}
+
+// prune removes the entries for which remove returns true.
+func (c vectorClock) prune(remove func(node string, seq uint64) bool) {
+	for node, seq := range c {
+		if remove(node, seq) {
+			delete(c, node)
+		}
+	}
+}
+
+// clockUpdate describes how merging a value updates the vector clock of the key.
+type clockUpdate struct {
+	// Clock of the incoming value, if it's a full value.
+	clock vectorClock
+
+	// Origin of the incoming value, if it's a gossiped update.
+	origin *Origin
+
+	// If true, the value has been generated by a local CAS operation, so a new update is originated.
+	local bool
+
+	// Size of the encoded incoming value, if it's a full value. Used to estimate the bytes saved by
+	// not sending the value on push/pull.
+	encodedSize int
+}
+
+// updateClock applies the clock update to the value, and returns the origin to gossip the change with.
+// Must be called with storeMu held.
+func (m *KV) updateClock(val *ValueDesc, cu clockUpdate, changed bool) *Origin {
+	if !m.cfg.DeltaPushPullEnabled {
+		return nil
+	}
+
+	if val.clock == nil {
+		val.clock = vectorClock{}
+	}
+	if cu.encodedSize > 0 {
+		val.encodedSize = cu.encodedSize
+	}
+	val.clock.merge(cu.clock)
+
+	if cu.origin != nil {
+		val.clock.apply(cu.origin)
+		return cu.origin
+	}
+
+	if !cu.local || !changed || m.nodeName == "" {
+		return nil
+	}
+
+	// Sequence numbers are based on time, so that they keep growing after restarts.
+	seq := uint64(time.Now().UnixNano())
+	if seq <= m.lastSeq {
+		seq = m.lastSeq + 1
+	}
+	m.lastSeq = seq
+
+	origin := &Origin{Node: m.nodeName, Seq: seq, PrevSeq: val.clock[m.nodeName]}
+	val.clock[m.nodeName] = seq
+	return origin
+}
+
+// storeClockUpdate applies the clock update to a value which hasn't been changed by the merge.
+// Must be called with storeMu held.
+func (m *KV) storeClockUpdate(key string, val ValueDesc, cu clockUpdate) {
+	if !m.cfg.DeltaPushPullEnabled || val.value == nil {
+		return
+	}
+	m.updateClock(&val, cu, false)
+	m.store[key] = val
+}
+
+// useDeltaState returns true if LocalState should only send the digests of the keys.
+// Must be called with storeMu held.
+func (m *KV) useDeltaState(join bool, now time.Time) bool {
+	if !m.cfg.DeltaPushPullEnabled || join {
+		return false
+	}
+	return m.cfg.DeltaPushPullFullSyncInterval <= 0 || now.Sub(m.lastFullSync) < m.cfg.DeltaPushPullFullSyncInterval
+}
+
+// clockEntryExpired returns a function returning true for the clock entries of nodes which are not
+// members of the cluster anymore, and haven't originated any update for longer than LeftIngestersTimeout.
+func (m *KV) clockEntryExpired(now time.Time) func(node string, seq uint64) bool {
+	members := map[string]struct{}{}
+	for _, n := range m.memberlist.Members() {
+		members[n.Name] = struct{}{}
+	}
+
+	return func(node string, seq uint64) bool {
+		if m.cfg.LeftIngestersTimeout <= 0 {
+			return false
+		}
+		if _, ok := members[node]; ok {
+			return false
+		}
+		return seq < uint64(now.Add(-m.cfg.LeftIngestersTimeout).UnixNano())
+	}
+}
+
+// localDeltaState returns the digests of all keys: their codecs and vector clocks, but no values.
+// Must be called with storeMu held.
+func (m *KV) localDeltaState(expired func(node string, seq uint64) bool) []byte {
+	buf := bytes.Buffer{}
+	m.writeKVPair(&buf, KeyValuePair{Key: m.nodeName, Codec: deltaStateCodecID})
+
+	for key, val := range m.store {
+		if val.value == nil {
+			continue
+		}
+		val.clock.prune(expired)
+		m.writeKVPair(&buf, KeyValuePair{Key: key, Codec: val.CodecID, Clock: val.clock.entries()})
+	}
+
+	m.totalSizeOfDeltaStateDigests.Add(float64(buf.Len()))
+	return buf.Bytes()
+}
+
+// writeKVPair writes the length-prefixed KV pair to the buffer, in the format read by MergeRemoteState.
+func (m *KV) writeKVPair(buf *bytes.Buffer, kvPair KeyValuePair) {
+	ser, err := m.marshalKVPair(&kvPair)
+	if err != nil || uint(len(ser)) > math.MaxUint32 {
+		return
+	}
+	_ = binary.Write(buf, binary.BigEndian, uint32(len(ser)))
+	buf.Write(ser)
+}
+
+// queueDeltaValues queues a request to send to the node the values it's missing, without waiting for the
+// values to be sent. If the queue is full, the request is dropped: the values will be sent after the next
+// push/pull with the node.
+func (m *KV) queueDeltaValues(nodeName string, remoteClocks map[string]vectorClock) {
+	select {
+	case m.deltaValuesCh <- deltaValuesRequest{nodeName: nodeName, remoteClocks: remoteClocks}:
+	default:
+		level.Warn(m.logger).Log("msg", "dropped request to send values to node after push/pull, because the queue is full", "node", nodeName)
+	}
+}
+
+// processDeltaValues sends the values requested via queueDeltaValues, until shutdown.
+func (m *KV) processDeltaValues() {
+	for {
+		select {
+		case req := <-m.deltaValuesCh:
+			m.sendDeltaValues(req.nodeName, req.remoteClocks)
+
+		case <-m.shutdown:
+			return
+		}
+	}
+}
+
+// sendDeltaValues sends to the node, as single messages, the full values of the keys for which the
+// local value includes updates not included in the remote one, according to the remote digests.
+func (m *KV) sendDeltaValues(nodeName string, remoteClocks map[string]vectorClock) {
+	var node *memberlist.Node
+	for _, n := range m.memberlist.Members() {
+		if n.Name == nodeName {
+			node = n
+			break
+		}
+	}
+	if node == nil {
+		level.Warn(m.logger).Log("msg", "failed to send values to node after push/pull, unknown node", "node", nodeName)
+		return
+	}
+
+	expired := m.clockEntryExpired(time.Now())
+
+	m.storeMu.Lock()
+	var msgs []Message
+	for key, val := range m.store {
+		if val.value == nil {
+			continue
+		}
+
+		remoteClock, ok := remoteClocks[key]
+		if ok && !val.clock.aheadOf(remoteClock, expired) {
+			m.deltaPushPullSavedBytes.Add(float64(val.encodedSize))
+			continue
+		}
+
+		codec := m.GetCodec(val.CodecID)
+		if codec == nil {
+			level.Error(m.logger).Log("msg", "failed to encode value: unknown codec for key", "codec", val.CodecID, "key", key)
+			continue
+		}
+		encoded, err := codec.Encode(val.value)
+		if err != nil {
+			level.Error(m.logger).Log("msg", "failed to encode value", "key", key, "err", err)
+			continue
+		}
+
+		val.encodedSize = len(encoded)
+		m.store[key] = val
+		msgs = append(msgs, Message{
+			Pair:    KeyValuePair{Key: key, Value: encoded, Codec: val.CodecID, Clock: val.clock.entries()},
+			Version: val.Version,
+		})
+	}
+	m.storeMu.Unlock()
+
+	for _, msg := range msgs {
+		data, err := m.marshalKVPair(&msg.Pair)
+		if err != nil {
+			level.Error(m.logger).Log("msg", "failed to serialize KV pair", "key", msg.Pair.Key, "err", err)
+			continue
+		}
+		if err := m.memberlist.SendReliable(node, data); err != nil {
+			level.Warn(m.logger).Log("msg", "failed to send value to node after push/pull", "key", msg.Pair.Key, "node", nodeName, "err", err)
+			continue
+		}
+
+		m.deltaPushPullSentValuesBytes.Add(float64(len(data)))
+		msg.Time = time.Now()
+		msg.Size = len(data)
+		m.addSentMessage(msg)
+	}
+}
@@ -0,0 +1,165 @@
+package memberlist
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/dskit/flagext"
+	"github.com/grafana/dskit/kv/codec"
+	"github.com/grafana/dskit/services"
+)
+
+func TestVectorClock(t *testing.T) {
+	c := vectorClock{"a": 5}
+	c.merge(vectorClock{"a": 3, "b": 2})
+	assert.Equal(t, vectorClock{"a": 5, "b": 2}, c)
+	assert.Equal(t, []ClockEntry{{Node: "a", Seq: 5}, {Node: "b", Seq: 2}}, c.entries())
+	assert.Equal(t, c, vectorClockFromEntries(c.entries()))
+
+	// Updates are only applied if the clock includes the previous update of the same node.
+	c.apply(&Origin{Node: "b", Seq: 4, PrevSeq: 2})
+	c.apply(&Origin{Node: "a", Seq: 9, PrevSeq: 7})
+	c.apply(&Origin{Node: "c", Seq: 1, PrevSeq: 0})
+	assert.Equal(t, vectorClock{"a": 5, "b": 4, "c": 1}, c)
+
+	never := func(string, uint64) bool { return false }
+	assert.False(t, c.aheadOf(c.clone(), never))
+	assert.True(t, c.aheadOf(vectorClock{"a": 5, "b": 4}, never))
+	assert.False(t, vectorClock{"a": 5}.aheadOf(c, never))
+	assert.False(t, c.aheadOf(vectorClock{"a": 5, "b": 4}, func(node string, _ uint64) bool { return node == "c" }))
+
+	c.prune(func(node string, _ uint64) bool { return node != "a" })
+	assert.Equal(t, vectorClock{"a": 5}, c)
+}
+
+func TestMergeValueForKey_ShouldUpdateVectorClock(t *testing.T) {
+	cfg := KVConfig{DeltaPushPullEnabled: true}
+	kv := NewKV(cfg, log.NewNopLogger(), &dnsProviderMock{}, prometheus.NewPedanticRegistry())
+	kv.nodeName = "local"
+
+	now := time.Now().Unix()
+	merge := func(d *data, cu clockUpdate) *Origin {
+		_, _, origin, err := kv.mergeValueForKey(key, d, false, 0, dataCodec{}, cu)
+		require.NoError(t, err)
+		return origin
+	}
+
+	// A complete gossiped change is forwarded with its origin.
+	origin := &Origin{Node: "remote", Seq: 10}
+	assert.Equal(t, origin, merge(&data{Members: map[string]member{"a": {Timestamp: now}}}, clockUpdate{origin: origin}))
+	assert.Equal(t, vectorClock{"remote": 10}, kv.store[key].clock)
+
+	// A change which is already included in the local value still updates the clock.
+	merge(&data{Members: map[string]member{"a": {Timestamp: now}}}, clockUpdate{origin: &Origin{Node: "remote", Seq: 12, PrevSeq: 10}})
+	assert.Equal(t, vectorClock{"remote": 12}, kv.store[key].clock)
+
+	// A change which is only partially new is forwarded without origin.
+	origin = merge(&data{Members: map[string]member{"a": {Timestamp: now}, "b": {Timestamp: now}}}, clockUpdate{origin: &Origin{Node: "remote", Seq: 15, PrevSeq: 12}})
+	assert.Nil(t, origin)
+	assert.Equal(t, vectorClock{"remote": 15}, kv.store[key].clock)
+
+	// Local changes originate a new update.
+	origin = merge(&data{Members: map[string]member{"c": {Timestamp: now}}}, clockUpdate{local: true})
+	require.NotNil(t, origin)
+	assert.Equal(t, "local", origin.Node)
+	assert.Zero(t, origin.PrevSeq)
+	assert.Equal(t, vectorClock{"remote": 15, "local": origin.Seq}, kv.store[key].clock)
+
+	next := merge(&data{Members: map[string]member{"d": {Timestamp: now}}}, clockUpdate{local: true})
+	require.NotNil(t, next)
+	assert.Equal(t, origin.Seq, next.PrevSeq)
+	assert.Greater(t, next.Seq, origin.Seq)
+
+	// Full values are merged with their clock.
+	merge(&data{Members: map[string]member{"a": {Timestamp: now}}}, clockUpdate{clock: vectorClock{"remote": 20, "other": 3}})
+	assert.Equal(t, vectorClock{"remote": 20, "local": next.Seq, "other": 3}, kv.store[key].clock)
+}
+
+func TestDeltaPushPull_ShouldOnlySendMissingValues(t *testing.T) {
+	var cfg KVConfig
+	flagext.DefaultValues(&cfg)
+	cfg.TCPTransport = TCPTransportConfig{
+		BindAddrs: getLocalhostAddrs(),
+		BindPort:  0, // randomize
+	}
+	cfg.PushPullInterval = time.Hour // Push/pull is triggered by the test.
+	cfg.DeltaPushPullEnabled = true
+	cfg.Codecs = []codec.Codec{dataCodec{}}
+
+	mkv1 := NewKV(cfg, log.NewNopLogger(), &dnsProviderMock{}, prometheus.NewPedanticRegistry())
+	require.NoError(t, services.StartAndAwaitRunning(context.Background(), mkv1))
+	defer services.StopAndAwaitTerminated(context.Background(), mkv1) //nolint:errcheck
+
+	client1, err := NewClient(mkv1, dataCodec{})
+	require.NoError(t, err)
+
+	now := time.Now().Unix()
+	require.NoError(t, client1.CAS(context.Background(), "shared", func(in interface{}) (out interface{}, retry bool, err error) {
+		d := getOrCreateData(in)
+		d.Members["a"] = member{Timestamp: now, State: ACTIVE}
+		return d, true, nil
+	}))
+
+	mkv2 := NewKV(cfg, log.NewNopLogger(), &dnsProviderMock{}, prometheus.NewPedanticRegistry())
+	require.NoError(t, services.StartAndAwaitRunning(context.Background(), mkv2))
+	defer services.StopAndAwaitTerminated(context.Background(), mkv2) //nolint:errcheck
+
+	// Joining sends the full state.
+	_, err = mkv2.JoinMembers([]string{net.JoinHostPort("127.0.0.1", strconv.Itoa(mkv1.GetListeningPort()))})
+	require.NoError(t, err)
+
+	client2, err := NewClient(mkv2, dataCodec{})
+	require.NoError(t, err)
+	require.NotNil(t, getData(t, client2, "shared"))
+
+	// Store a value in the first KV without gossiping it, as if the gossiped update was lost.
+	_, _, _, err = mkv1.mergeValueForKey("missing", &data{Members: map[string]member{"b": {Timestamp: now, State: ACTIVE}}}, true, 0, dataCodec{}, clockUpdate{local: true})
+	require.NoError(t, err)
+
+	// The second KV only sends the clocks of its keys, and the first one replies with the missing value.
+	full := mkv2.LocalState(true)
+	delta := mkv2.LocalState(false)
+	assert.Less(t, len(delta), len(full))
+	mkv1.MergeRemoteState(delta, false)
+
+	// The values are sent in the background.
+	require.Eventually(t, func() bool {
+		val, err := mkv2.Get("missing", dataCodec{})
+		return err == nil && val != nil && testutil.ToFloat64(mkv1.deltaPushPullSentValuesBytes) > 0
+	}, 5*time.Second, 10*time.Millisecond)
+
+	assert.Greater(t, testutil.ToFloat64(mkv1.deltaPushPullSavedBytes), float64(0))
+	assert.Greater(t, testutil.ToFloat64(mkv2.totalSizeOfDeltaStateDigests), float64(0))
+
+	// Both KVs now have the same values, so nothing is sent anymore.
+	sent := testutil.ToFloat64(mkv1.deltaPushPullSentValuesBytes)
+	saved := testutil.ToFloat64(mkv1.deltaPushPullSavedBytes)
+	mkv1.MergeRemoteState(mkv2.LocalState(false), false)
+	require.Eventually(t, func() bool {
+		return testutil.ToFloat64(mkv1.deltaPushPullSavedBytes) > saved
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, sent, testutil.ToFloat64(mkv1.deltaPushPullSentValuesBytes))
+}
+
+func TestKV_QueueDeltaValuesShouldNotBlockWhenTheQueueIsFull(t *testing.T) {
+	var cfg KVConfig
+	flagext.DefaultValues(&cfg)
+	cfg.DeltaPushPullEnabled = true
+
+	// The KV isn't started, so the queued requests are never processed.
+	mkv := NewKV(cfg, log.NewNopLogger(), &dnsProviderMock{}, prometheus.NewPedanticRegistry())
+
+	for i := 0; i < deltaValuesQueueSize+1; i++ {
+		mkv.queueDeltaValues("node", nil)
+	}
+	assert.Len(t, mkv.deltaValuesCh, deltaValuesQueueSize)
+}
@@ -29,6 +29,7 @@ type StatusPageData struct {
 	MessageHistoryBufferBytes int
 	SentMessages              []Message
 	ReceivedMessages          []Message
+	RejectedUpdates           []RejectedUpdates
 }
 
 // NewHTTPStatusHandler creates a new HTTPStatusHandler that will render the provided template using the data from StatusPageData.
@@ -48,6 +49,7 @@ func (h HTTPStatusHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	const (
 		downloadKeyParam    = "downloadKey"
 		viewKeyParam        = "viewKey"
+		inspectKeyParam     = "inspectKey"
 		viewMsgParam        = "viewMsg"
 		deleteMessagesParam = "deleteMessages"
 	)
@@ -63,6 +65,11 @@ func (h HTTPStatusHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 			return
 		}
 
+		if req.Form[inspectKeyParam] != nil {
+			serveKeyInspection(w, req, kv, req.Form[inspectKeyParam][0])
+			return
+		}
+
 		if req.Form[viewMsgParam] != nil {
 			msgID, err := strconv.Atoi(req.Form[viewMsgParam][0])
 			if err != nil {
@@ -108,6 +115,7 @@ func (h HTTPStatusHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		MessageHistoryBufferBytes: kv.cfg.MessageHistoryBufferBytes,
 		SentMessages:              sent,
 		ReceivedMessages:          received,
+		RejectedUpdates:           kv.getRejectedUpdates(),
 	}
 
 	accept := req.Header.Get("Accept")
@@ -0,0 +1,153 @@
+package memberlist
+
+import (
+	_ "embed"
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultKeyHistory is the number of updates of a key returned by the key inspector, if not specified.
+const defaultKeyHistory = 10
+
+// KeyInspection describes a key of the KV store and its latest received updates.
+// Fields are exported for templating and JSON encoding to work.
+type KeyInspection struct {
+	Key     string
+	Codec   string
+	Version uint
+
+	// Value decoded by the codec.
+	Value interface{}
+
+	// Latest received updates of the key, newest first. Only available if the message
+	// history is enabled via MessageHistoryBufferBytes.
+	Updates []KeyUpdate
+}
+
+// KeyUpdate is a received update of a key, and how it changed the local value.
+type KeyUpdate struct {
+	MessageID int
+	Time      time.Time
+	Size      int
+
+	// Local version of the value after applying the update, 0 if the update didn't change it.
+	Version uint
+
+	// Entries of the update, as returned by Mergeable.MergeContent, which changed the local value.
+	Changed []string
+
+	// Entries of the update which were already included in the local value.
+	Unchanged []string
+
+	// Update decoded by the codec.
+	Value interface{}
+
+	// Error decoding the update, if any.
+	Error string `json:",omitempty"`
+}
+
+// inspectKey returns the inspection of the key with up to limit updates, or nil if the key doesn't exist.
+func (m *KV) inspectKey(key string, limit int) *KeyInspection {
+	m.storeMu.Lock()
+	val, ok := m.store[key]
+	if ok {
+		val = val.Clone()
+	}
+	m.storeMu.Unlock()
+
+	if !ok || val.value == nil {
+		return nil
+	}
+
+	result := &KeyInspection{Key: key, Codec: val.CodecID, Version: val.Version, Value: val.value}
+
+	_, received := m.getSentAndReceivedMessages()
+	for i := len(received) - 1; i >= 0 && len(result.Updates) < limit; i-- {
+		if received[i].Pair.Key == key {
+			result.Updates = append(result.Updates, m.describeUpdate(received[i]))
+		}
+	}
+	return result
+}
+
+func (m *KV) describeUpdate(msg Message) KeyUpdate {
+	update := KeyUpdate{
+		MessageID: msg.ID,
+		Time:      msg.Time,
+		Size:      msg.Size,
+		Version:   msg.Version,
+		Changed:   msg.Changes,
+	}
+
+	c := m.GetCodec(msg.Pair.Codec)
+	if c == nil {
+		update.Error = "unknown codec: " + msg.Pair.Codec
+		return update
+	}
+	val, err := c.Decode(msg.Pair.Value)
+	if err != nil {
+		update.Error = "failed to decode: " + err.Error()
+		return update
+	}
+	update.Value = val
+
+	mergeable, ok := val.(Mergeable)
+	if !ok {
+		return update
+	}
+
+	changed := make(map[string]struct{}, len(msg.Changes))
+	for _, c := range msg.Changes {
+		changed[c] = struct{}{}
+	}
+	for _, c := range mergeable.MergeContent() {
+		if _, ok := changed[c]; !ok {
+			update.Unchanged = append(update.Unchanged, c)
+		}
+	}
+	return update
+}
+
+func serveKeyInspection(w http.ResponseWriter, req *http.Request, kv *KV, key string) {
+	limit := defaultKeyHistory
+	if v := req.Form.Get("history"); v != "" {
+		var err error
+		if limit, err = strconv.Atoi(v); err != nil || limit < 0 {
+			http.Error(w, "invalid history", http.StatusBadRequest)
+			return
+		}
+	}
+
+	inspection := kv.inspectKey(key, limit)
+	if inspection == nil {
+		http.Error(w, "value not found", http.StatusNotFound)
+		return
+	}
+
+	if strings.Contains(req.Header.Get("Accept"), "application/json") || getFormat(req) == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(inspection); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	if err := keyInspectorTemplate.Execute(w, inspection); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+//go:embed key_inspector.gohtml
+var keyInspectorContent string
+var keyInspectorTemplate = template.Must(template.New("keyinspector").Funcs(template.FuncMap{
+	"StringsJoin": strings.Join,
+	"JSON": func(v interface{}) (string, error) {
+		out, err := json.MarshalIndent(v, "", "    ")
+		return string(out), err
+	},
+}).Parse(keyInspectorContent))
@@ -0,0 +1,88 @@
+package memberlist
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/dskit/kv/codec"
+	"github.com/grafana/dskit/services"
+)
+
+func TestKeyInspector(t *testing.T) {
+	c := dataCodec{}
+	cfg := KVConfig{
+		TCPTransport: TCPTransportConfig{
+			BindAddrs: getLocalhostAddrs(),
+		},
+		MessageHistoryBufferBytes: 1024 * 1024,
+		Codecs:                    []codec.Codec{c},
+	}
+
+	kv := NewKV(cfg, log.NewNopLogger(), &dnsProviderMock{}, prometheus.NewPedanticRegistry())
+	require.NoError(t, services.StartAndAwaitRunning(context.Background(), kv))
+	defer services.StopAndAwaitTerminated(context.Background(), kv) //nolint:errcheck
+
+	now := time.Now().Unix()
+	kv.NotifyMsg(marshalKeyValuePair(t, key, c, &data{Members: map[string]member{
+		"a": {Timestamp: now, State: ACTIVE},
+	}}))
+	kv.NotifyMsg(marshalKeyValuePair(t, key, c, &data{Members: map[string]member{
+		"a": {Timestamp: now, State: ACTIVE},
+		"b": {Timestamp: now, State: JOINING},
+	}}))
+
+	require.Eventually(t, func() bool {
+		_, received := kv.getSentAndReceivedMessages()
+		return len(received) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+
+	inspection := kv.inspectKey(key, 10)
+	require.NotNil(t, inspection)
+	assert.Equal(t, c.CodecID(), inspection.Codec)
+	assert.Equal(t, uint(2), inspection.Version)
+	assert.Len(t, inspection.Value.(*data).Members, 2)
+
+	// Newest updates first.
+	require.Len(t, inspection.Updates, 2)
+	assert.Equal(t, uint(2), inspection.Updates[0].Version)
+	assert.Equal(t, []string{"b"}, inspection.Updates[0].Changed)
+	assert.Equal(t, []string{"a"}, inspection.Updates[0].Unchanged)
+	assert.Len(t, inspection.Updates[0].Value.(*data).Members, 2)
+	assert.Equal(t, []string{"a"}, inspection.Updates[1].Changed)
+	assert.Empty(t, inspection.Updates[1].Unchanged)
+
+	assert.Len(t, kv.inspectKey(key, 1).Updates, 1)
+	assert.Nil(t, kv.inspectKey("unknown", 10))
+
+	serve := func(url string, accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, url, nil)
+		req.Header.Set("Accept", accept)
+		require.NoError(t, req.ParseForm())
+		rec := httptest.NewRecorder()
+		serveKeyInspection(rec, req, kv, req.Form.Get("inspectKey"))
+		return rec
+	}
+
+	rec := serve("/?inspectKey="+key+"&history=1", "application/json")
+	require.Equal(t, http.StatusOK, rec.Code)
+	decoded := map[string]interface{}{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &decoded))
+	assert.Equal(t, key, decoded["Key"])
+	assert.Len(t, decoded["Updates"], 1)
+
+	rec = serve("/?inspectKey="+key, "text/html")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "<h1>Memberlist Key: "+key+"</h1>")
+
+	assert.Equal(t, http.StatusNotFound, serve("/?inspectKey=unknown", "").Code)
+	assert.Equal(t, http.StatusBadRequest, serve("/?inspectKey="+key+"&history=x", "").Code)
+}
@@ -76,6 +76,17 @@ type KeyValuePair struct {
 	Value []byte `protobuf:"bytes,2,opt,name=value,proto3" json:"value,omitempty"`
 	// ID of the codec used to write the value
 	Codec string `protobuf:"bytes,3,opt,name=codec,proto3" json:"codec,omitempty"`
+	// Vector clock of the value. Only set by members with delta push/pull enabled, when the value
+	// is sent as part of a push/pull sync.
+	Clock []ClockEntry `protobuf:"bytes,4,rep,name=clock,proto3" json:"clock"`
+	// Update originated by a member which is entirely carried by this value. Only set by members
+	// with delta push/pull enabled, when the value is gossiped.
+	Origin *Origin `protobuf:"bytes,5,opt,name=origin,proto3" json:"origin,omitempty"`
+	// ID of the key used to sign the pair, empty if the pair isn't signed.
+	KeyId string `protobuf:"bytes,6,opt,name=key_id,json=keyId,proto3" json:"key_id,omitempty"`
+	// Signature of the pair, computed over the pair marshalled without signature. The signature
+	// is marshalled as the last field, right after the signed bytes.
+	Signature []byte `protobuf:"bytes,7,opt,name=signature,proto3" json:"signature,omitempty"`
 }
 
 func (m *KeyValuePair) Reset()      { *m = KeyValuePair{} }
@@ -131,30 +142,182 @@ func (m *KeyValuePair) GetCodec() string {
 	return ""
 }
 
+func (m *KeyValuePair) GetClock() []ClockEntry {
+	if m != nil {
+		return m.Clock
+	}
+	return nil
+}
+
+func (m *KeyValuePair) GetOrigin() *Origin {
+	if m != nil {
+		return m.Origin
+	}
+	return nil
+}
+
+func (m *KeyValuePair) GetKeyId() string {
+	if m != nil {
+		return m.KeyId
+	}
+	return ""
+}
+
+func (m *KeyValuePair) GetSignature() []byte {
+	if m != nil {
+		return m.Signature
+	}
+	return nil
+}
+
+// Sequence number of the latest update of a key originated by a member.
+type ClockEntry struct {
+	Node string `protobuf:"bytes,1,opt,name=node,proto3" json:"node,omitempty"`
+	Seq  uint64 `protobuf:"varint,2,opt,name=seq,proto3" json:"seq,omitempty"`
+}
+
+func (m *ClockEntry) Reset()      { *m = ClockEntry{} }
+func (*ClockEntry) ProtoMessage() {}
+func (*ClockEntry) Descriptor() ([]byte, []int) {
+	return fileDescriptor_2216fe83c9c12408, []int{2}
+}
+func (m *ClockEntry) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *ClockEntry) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_ClockEntry.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalToSizedBuffer(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+}
+func (m *ClockEntry) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ClockEntry.Merge(m, src)
+}
+func (m *ClockEntry) XXX_Size() int {
+	return m.Size()
+}
+func (m *ClockEntry) XXX_DiscardUnknown() {
+	xxx_messageInfo_ClockEntry.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ClockEntry proto.InternalMessageInfo
+
+func (m *ClockEntry) GetNode() string {
+	if m != nil {
+		return m.Node
+	}
+	return ""
+}
+
+func (m *ClockEntry) GetSeq() uint64 {
+	if m != nil {
+		return m.Seq
+	}
+	return 0
+}
+
+// Update of a key originated by a member. The previous update of the key originated by the same
+// member has sequence number prev_seq, 0 if unknown.
+type Origin struct {
+	Node    string `protobuf:"bytes,1,opt,name=node,proto3" json:"node,omitempty"`
+	Seq     uint64 `protobuf:"varint,2,opt,name=seq,proto3" json:"seq,omitempty"`
+	PrevSeq uint64 `protobuf:"varint,3,opt,name=prev_seq,json=prevSeq,proto3" json:"prev_seq,omitempty"`
+}
+
+func (m *Origin) Reset()      { *m = Origin{} }
+func (*Origin) ProtoMessage() {}
+func (*Origin) Descriptor() ([]byte, []int) {
+	return fileDescriptor_2216fe83c9c12408, []int{3}
+}
+func (m *Origin) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *Origin) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_Origin.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalToSizedBuffer(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+}
+func (m *Origin) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Origin.Merge(m, src)
+}
+func (m *Origin) XXX_Size() int {
+	return m.Size()
+}
+func (m *Origin) XXX_DiscardUnknown() {
+	xxx_messageInfo_Origin.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Origin proto.InternalMessageInfo
+
+func (m *Origin) GetNode() string {
+	if m != nil {
+		return m.Node
+	}
+	return ""
+}
+
+func (m *Origin) GetSeq() uint64 {
+	if m != nil {
+		return m.Seq
+	}
+	return 0
+}
+
+func (m *Origin) GetPrevSeq() uint64 {
+	if m != nil {
+		return m.PrevSeq
+	}
+	return 0
+}
+
 func init() {
 	proto.RegisterType((*KeyValueStore)(nil), "memberlist.KeyValueStore")
 	proto.RegisterType((*KeyValuePair)(nil), "memberlist.KeyValuePair")
+	proto.RegisterType((*ClockEntry)(nil), "memberlist.ClockEntry")
+	proto.RegisterType((*Origin)(nil), "memberlist.Origin")
 }
 
 func init() { proto.RegisterFile("kv.proto", fileDescriptor_2216fe83c9c12408) }
 
 var fileDescriptor_2216fe83c9c12408 = []byte{
-	// 236 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xe2, 0xe2, 0xc8, 0x2e, 0xd3, 0x2b,
-	0x28, 0xca, 0x2f, 0xc9, 0x17, 0xe2, 0xca, 0x4d, 0xcd, 0x4d, 0x4a, 0x2d, 0xca, 0xc9, 0x2c, 0x2e,
-	0x91, 0xd2, 0x4d, 0xcf, 0x2c, 0xc9, 0x28, 0x4d, 0xd2, 0x4b, 0xce, 0xcf, 0xd5, 0x4f, 0xcf, 0x4f,
-	0xcf, 0xd7, 0x07, 0x2b, 0x49, 0x2a, 0x4d, 0x03, 0xf3, 0xc0, 0x1c, 0x30, 0x0b, 0xa2, 0x55, 0xc9,
-	0x9e, 0x8b, 0xd7, 0x3b, 0xb5, 0x32, 0x2c, 0x31, 0xa7, 0x34, 0x35, 0xb8, 0x24, 0xbf, 0x28, 0x55,
-	0x48, 0x8f, 0x8b, 0xb5, 0x20, 0x31, 0xb3, 0xa8, 0x58, 0x82, 0x51, 0x81, 0x59, 0x83, 0xdb, 0x48,
-	0x42, 0x0f, 0x61, 0xb6, 0x1e, 0x4c, 0x65, 0x40, 0x62, 0x66, 0x51, 0x10, 0x44, 0x99, 0x92, 0x0f,
-	0x17, 0x0f, 0xb2, 0xb0, 0x90, 0x00, 0x17, 0x73, 0x76, 0x6a, 0xa5, 0x04, 0xa3, 0x02, 0xa3, 0x06,
-	0x67, 0x10, 0x88, 0x29, 0x24, 0xc2, 0xc5, 0x5a, 0x06, 0x92, 0x96, 0x60, 0x52, 0x60, 0xd4, 0xe0,
-	0x09, 0x82, 0x70, 0x40, 0xa2, 0xc9, 0xf9, 0x29, 0xa9, 0xc9, 0x12, 0xcc, 0x60, 0x95, 0x10, 0x8e,
-	0x93, 0xc9, 0x85, 0x87, 0x72, 0x0c, 0x37, 0x1e, 0xca, 0x31, 0x7c, 0x78, 0x28, 0xc7, 0xd8, 0xf0,
-	0x48, 0x8e, 0x71, 0xc5, 0x23, 0x39, 0xc6, 0x13, 0x8f, 0xe4, 0x18, 0x2f, 0x3c, 0x92, 0x63, 0x7c,
-	0xf0, 0x48, 0x8e, 0xf1, 0xc5, 0x23, 0x39, 0x86, 0x0f, 0x8f, 0xe4, 0x18, 0x27, 0x3c, 0x96, 0x63,
-	0xb8, 0xf0, 0x58, 0x8e, 0xe1, 0xc6, 0x63, 0x39, 0x86, 0x24, 0x36, 0xb0, 0x5f, 0x8c, 0x01, 0x01,
-	0x00, 0x00, 0xff, 0xff, 0x7a, 0x22, 0xdf, 0xec, 0x12, 0x01, 0x00, 0x00,
+	// 373 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x8c, 0x91, 0xcd, 0xea, 0xd3, 0x40,
+	0x14, 0xc5, 0x33, 0xe6, 0xe3, 0xff, 0xef, 0x6d, 0x05, 0x19, 0x54, 0x46, 0x91, 0x31, 0x64, 0x15,
+	0x04, 0x53, 0x88, 0xee, 0x85, 0x8a, 0x8b, 0xe2, 0x42, 0x49, 0xc1, 0x6d, 0xc9, 0xc7, 0x18, 0x87,
+	0xb4, 0x99, 0x76, 0x92, 0x14, 0xb2, 0xf3, 0x11, 0x7c, 0x0c, 0x1f, 0xa5, 0xcb, 0x2e, 0xbb, 0x12,
+	0x93, 0x6e, 0x5c, 0xf6, 0x11, 0x64, 0x26, 0x95, 0x76, 0xe9, 0xee, 0x9c, 0x73, 0x7f, 0x37, 0x27,
+	0x97, 0x81, 0xfb, 0x62, 0x17, 0x6c, 0xa4, 0xa8, 0x05, 0x86, 0x35, 0x5b, 0x27, 0x4c, 0xae, 0x78,
+	0x55, 0x3f, 0x7f, 0x9d, 0xf3, 0xfa, 0x5b, 0x93, 0x04, 0xa9, 0x58, 0x4f, 0x73, 0x91, 0x8b, 0xa9,
+	0x46, 0x92, 0xe6, 0xab, 0x76, 0xda, 0x68, 0x35, 0xac, 0x7a, 0xef, 0xe0, 0xe1, 0x47, 0xd6, 0x7e,
+	0x89, 0x57, 0x0d, 0x5b, 0xd4, 0x42, 0x32, 0x1c, 0x80, 0xbd, 0x89, 0xb9, 0xac, 0x08, 0x72, 0x4d,
+	0x7f, 0x1c, 0x92, 0xe0, 0xfa, 0xed, 0xe0, 0x1f, 0xf9, 0x39, 0xe6, 0x32, 0x1a, 0x30, 0xaf, 0x43,
+	0x30, 0xb9, 0xcd, 0xf1, 0x23, 0x30, 0x0b, 0xd6, 0x12, 0xe4, 0x22, 0x7f, 0x14, 0x29, 0x89, 0x1f,
+	0x83, 0xbd, 0x53, 0x63, 0xf2, 0xc0, 0x45, 0xfe, 0x24, 0x1a, 0x8c, 0x4a, 0x53, 0x91, 0xb1, 0x94,
+	0x98, 0x9a, 0x1c, 0x0c, 0x0e, 0xc1, 0x4e, 0x57, 0x22, 0x2d, 0x88, 0xa5, 0xeb, 0x9f, 0xde, 0xd6,
+	0xbf, 0x57, 0x83, 0x0f, 0x65, 0x2d, 0xdb, 0x99, 0xb5, 0xff, 0xf5, 0xd2, 0x88, 0x06, 0x14, 0xbf,
+	0x02, 0x47, 0x48, 0x9e, 0xf3, 0x92, 0xd8, 0x2e, 0xf2, 0xc7, 0x21, 0xbe, 0x5d, 0xfa, 0xa4, 0x27,
+	0xd1, 0x85, 0xc0, 0x4f, 0xc0, 0x29, 0x58, 0xbb, 0xe4, 0x19, 0x71, 0x86, 0xda, 0x82, 0xb5, 0xf3,
+	0x0c, 0xbf, 0x80, 0x51, 0xc5, 0xf3, 0x32, 0xae, 0x1b, 0xc9, 0xc8, 0x9d, 0xfe, 0xcd, 0x6b, 0xe0,
+	0x85, 0x00, 0xd7, 0x6e, 0x8c, 0xc1, 0x2a, 0x45, 0xc6, 0x2e, 0x17, 0x6a, 0xad, 0x8e, 0xae, 0xd8,
+	0x56, 0x1f, 0x68, 0x45, 0x4a, 0x7a, 0x73, 0x70, 0x86, 0xea, 0xff, 0xe3, 0xf1, 0x33, 0xb8, 0xdf,
+	0x48, 0xb6, 0x5b, 0xaa, 0xd8, 0xd4, 0xf1, 0x9d, 0xf2, 0x0b, 0xb6, 0x9d, 0xbd, 0x3d, 0x74, 0xd4,
+	0x38, 0x76, 0xd4, 0x38, 0x77, 0x14, 0x7d, 0xef, 0x29, 0xfa, 0xd9, 0x53, 0xb4, 0xef, 0x29, 0x3a,
+	0xf4, 0x14, 0xfd, 0xee, 0x29, 0xfa, 0xd3, 0x53, 0xe3, 0xdc, 0x53, 0xf4, 0xe3, 0x44, 0x8d, 0xc3,
+	0x89, 0x1a, 0xc7, 0x13, 0x35, 0x12, 0x47, 0x3f, 0xf0, 0x9b, 0xbf, 0x03, 0x00, 0x2d, 0x09, 0xba,
+	0xf2, 0x27, 0x02, 0x00, 0x00,
 }
 
 func (this *KeyValueStore) Equal(that interface{}) bool {
@@ -214,6 +377,80 @@ func (this *KeyValuePair) Equal(that interface{}) bool {
 	if this.Codec != that1.Codec {
 		return false
 	}
+	if len(this.Clock) != len(that1.Clock) {
+		return false
+	}
+	for i := range this.Clock {
+		if !this.Clock[i].Equal(&that1.Clock[i]) {
+			return false
+		}
+	}
+	if !this.Origin.Equal(that1.Origin) {
+		return false
+	}
+	if this.KeyId != that1.KeyId {
+		return false
+	}
+	if !bytes.Equal(this.Signature, that1.Signature) {
+		return false
+	}
+	return true
+}
+func (this *ClockEntry) Equal(that interface{}) bool {
+	if that == nil {
+		return this == nil
+	}
+
+	that1, ok := that.(*ClockEntry)
+	if !ok {
+		that2, ok := that.(ClockEntry)
+		if ok {
+			that1 = &that2
+		} else {
+			return false
+		}
+	}
+	if that1 == nil {
+		return this == nil
+	} else if this == nil {
+		return false
+	}
+	if this.Node != that1.Node {
+		return false
+	}
+	if this.Seq != that1.Seq {
+		return false
+	}
+	return true
+}
+func (this *Origin) Equal(that interface{}) bool {
+	if that == nil {
+		return this == nil
+	}
+
+	that1, ok := that.(*Origin)
+	if !ok {
+		that2, ok := that.(Origin)
+		if ok {
+			that1 = &that2
+		} else {
+			return false
+		}
+	}
+	if that1 == nil {
+		return this == nil
+	} else if this == nil {
+		return false
+	}
+	if this.Node != that1.Node {
+		return false
+	}
+	if this.Seq != that1.Seq {
+		return false
+	}
+	if this.PrevSeq != that1.PrevSeq {
+		return false
+	}
 	return true
 }
 func (this *KeyValueStore) GoString() string {
@@ -232,11 +469,46 @@ func (this *KeyValuePair) GoString() string {
 	if this == nil {
 		return "nil"
 	}
-	s := make([]string, 0, 7)
+	s := make([]string, 0, 11)
 	s = append(s, "&memberlist.KeyValuePair{")
 	s = append(s, "Key: "+fmt.Sprintf("%#v", this.Key)+",\n")
 	s = append(s, "Value: "+fmt.Sprintf("%#v", this.Value)+",\n")
 	s = append(s, "Codec: "+fmt.Sprintf("%#v", this.Codec)+",\n")
+	if this.Clock != nil {
+		vs := make([]ClockEntry, len(this.Clock))
+		for i := range vs {
+			vs[i] = this.Clock[i]
+		}
+		s = append(s, "Clock: "+fmt.Sprintf("%#v", vs)+",\n")
+	}
+	if this.Origin != nil {
+		s = append(s, "Origin: "+fmt.Sprintf("%#v", this.Origin)+",\n")
+	}
+	s = append(s, "KeyId: "+fmt.Sprintf("%#v", this.KeyId)+",\n")
+	s = append(s, "Signature: "+fmt.Sprintf("%#v", this.Signature)+",\n")
+	s = append(s, "}")
+	return strings.Join(s, "")
+}
+func (this *ClockEntry) GoString() string {
+	if this == nil {
+		return "nil"
+	}
+	s := make([]string, 0, 6)
+	s = append(s, "&memberlist.ClockEntry{")
+	s = append(s, "Node: "+fmt.Sprintf("%#v", this.Node)+",\n")
+	s = append(s, "Seq: "+fmt.Sprintf("%#v", this.Seq)+",\n")
+	s = append(s, "}")
+	return strings.Join(s, "")
+}
+func (this *Origin) GoString() string {
+	if this == nil {
+		return "nil"
+	}
+	s := make([]string, 0, 7)
+	s = append(s, "&memberlist.Origin{")
+	s = append(s, "Node: "+fmt.Sprintf("%#v", this.Node)+",\n")
+	s = append(s, "Seq: "+fmt.Sprintf("%#v", this.Seq)+",\n")
+	s = append(s, "PrevSeq: "+fmt.Sprintf("%#v", this.PrevSeq)+",\n")
 	s = append(s, "}")
 	return strings.Join(s, "")
 }
@@ -305,6 +577,46 @@ func (m *KeyValuePair) MarshalToSizedBuffer(dAtA []byte) (int, error) {
 	_ = i
 	var l int
 	_ = l
+	if len(m.Signature) > 0 {
+		i -= len(m.Signature)
+		copy(dAtA[i:], m.Signature)
+		i = encodeVarintKv(dAtA, i, uint64(len(m.Signature)))
+		i--
+		dAtA[i] = 0x3a
+	}
+	if len(m.KeyId) > 0 {
+		i -= len(m.KeyId)
+		copy(dAtA[i:], m.KeyId)
+		i = encodeVarintKv(dAtA, i, uint64(len(m.KeyId)))
+		i--
+		dAtA[i] = 0x32
+	}
+	if m.Origin != nil {
+		{
+			size, err := m.Origin.MarshalToSizedBuffer(dAtA[:i])
+			if err != nil {
+				return 0, err
+			}
+			i -= size
+			i = encodeVarintKv(dAtA, i, uint64(size))
+		}
+		i--
+		dAtA[i] = 0x2a
+	}
+	if len(m.Clock) > 0 {
+		for iNdEx := len(m.Clock) - 1; iNdEx >= 0; iNdEx-- {
+			{
+				size, err := m.Clock[iNdEx].MarshalToSizedBuffer(dAtA[:i])
+				if err != nil {
+					return 0, err
+				}
+				i -= size
+				i = encodeVarintKv(dAtA, i, uint64(size))
+			}
+			i--
+			dAtA[i] = 0x22
+		}
+	}
 	if len(m.Codec) > 0 {
 		i -= len(m.Codec)
 		copy(dAtA[i:], m.Codec)
@@ -329,6 +641,81 @@ func (m *KeyValuePair) MarshalToSizedBuffer(dAtA []byte) (int, error) {
 	return len(dAtA) - i, nil
 }
 
+func (m *ClockEntry) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalToSizedBuffer(dAtA[:size])
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *ClockEntry) MarshalTo(dAtA []byte) (int, error) {
+	size := m.Size()
+	return m.MarshalToSizedBuffer(dAtA[:size])
+}
+
+func (m *ClockEntry) MarshalToSizedBuffer(dAtA []byte) (int, error) {
+	i := len(dAtA)
+	_ = i
+	var l int
+	_ = l
+	if m.Seq != 0 {
+		i = encodeVarintKv(dAtA, i, uint64(m.Seq))
+		i--
+		dAtA[i] = 0x10
+	}
+	if len(m.Node) > 0 {
+		i -= len(m.Node)
+		copy(dAtA[i:], m.Node)
+		i = encodeVarintKv(dAtA, i, uint64(len(m.Node)))
+		i--
+		dAtA[i] = 0xa
+	}
+	return len(dAtA) - i, nil
+}
+
+func (m *Origin) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalToSizedBuffer(dAtA[:size])
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *Origin) MarshalTo(dAtA []byte) (int, error) {
+	size := m.Size()
+	return m.MarshalToSizedBuffer(dAtA[:size])
+}
+
+func (m *Origin) MarshalToSizedBuffer(dAtA []byte) (int, error) {
+	i := len(dAtA)
+	_ = i
+	var l int
+	_ = l
+	if m.PrevSeq != 0 {
+		i = encodeVarintKv(dAtA, i, uint64(m.PrevSeq))
+		i--
+		dAtA[i] = 0x18
+	}
+	if m.Seq != 0 {
+		i = encodeVarintKv(dAtA, i, uint64(m.Seq))
+		i--
+		dAtA[i] = 0x10
+	}
+	if len(m.Node) > 0 {
+		i -= len(m.Node)
+		copy(dAtA[i:], m.Node)
+		i = encodeVarintKv(dAtA, i, uint64(len(m.Node)))
+		i--
+		dAtA[i] = 0xa
+	}
+	return len(dAtA) - i, nil
+}
+
 func encodeVarintKv(dAtA []byte, offset int, v uint64) int {
 	offset -= sovKv(v)
 	base := offset
@@ -373,22 +760,75 @@ func (m *KeyValuePair) Size() (n int) {
 	if l > 0 {
 		n += 1 + l + sovKv(uint64(l))
 	}
+	if len(m.Clock) > 0 {
+		for _, e := range m.Clock {
+			l = e.Size()
+			n += 1 + l + sovKv(uint64(l))
+		}
+	}
+	if m.Origin != nil {
+		l = m.Origin.Size()
+		n += 1 + l + sovKv(uint64(l))
+	}
+	l = len(m.KeyId)
+	if l > 0 {
+		n += 1 + l + sovKv(uint64(l))
+	}
+	l = len(m.Signature)
+	if l > 0 {
+		n += 1 + l + sovKv(uint64(l))
+	}
 	return n
 }
 
-func sovKv(x uint64) (n int) {
-	return (math_bits.Len64(x|1) + 6) / 7
-}
-func sozKv(x uint64) (n int) {
-	return sovKv(uint64((x << 1) ^ uint64((int64(x) >> 63))))
-}
-func (this *KeyValueStore) String() string {
-	if this == nil {
-		return "nil"
-	}
-	repeatedStringForPairs := "[]*KeyValuePair{"
-	for _, f := range this.Pairs {
-		repeatedStringForPairs += strings.Replace(f.String(), "KeyValuePair", "KeyValuePair", 1) + ","
+func (m *ClockEntry) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	l = len(m.Node)
+	if l > 0 {
+		n += 1 + l + sovKv(uint64(l))
+	}
+	if m.Seq != 0 {
+		n += 1 + sovKv(uint64(m.Seq))
+	}
+	return n
+}
+
+func (m *Origin) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	l = len(m.Node)
+	if l > 0 {
+		n += 1 + l + sovKv(uint64(l))
+	}
+	if m.Seq != 0 {
+		n += 1 + sovKv(uint64(m.Seq))
+	}
+	if m.PrevSeq != 0 {
+		n += 1 + sovKv(uint64(m.PrevSeq))
+	}
+	return n
+}
+
+func sovKv(x uint64) (n int) {
+	return (math_bits.Len64(x|1) + 6) / 7
+}
+func sozKv(x uint64) (n int) {
+	return sovKv(uint64((x << 1) ^ uint64((int64(x) >> 63))))
+}
+func (this *KeyValueStore) String() string {
+	if this == nil {
+		return "nil"
+	}
+	repeatedStringForPairs := "[]*KeyValuePair{"
+	for _, f := range this.Pairs {
+		repeatedStringForPairs += strings.Replace(f.String(), "KeyValuePair", "KeyValuePair", 1) + ","
 	}
 	repeatedStringForPairs += "}"
 	s := strings.Join([]string{`&KeyValueStore{`,
@@ -401,10 +841,42 @@ func (this *KeyValuePair) String() string {
 	if this == nil {
 		return "nil"
 	}
+	repeatedStringForClock := "[]ClockEntry{"
+	for _, f := range this.Clock {
+		repeatedStringForClock += strings.Replace(strings.Replace(f.String(), "ClockEntry", "ClockEntry", 1), `&`, ``, 1) + ","
+	}
+	repeatedStringForClock += "}"
 	s := strings.Join([]string{`&KeyValuePair{`,
 		`Key:` + fmt.Sprintf("%v", this.Key) + `,`,
 		`Value:` + fmt.Sprintf("%v", this.Value) + `,`,
 		`Codec:` + fmt.Sprintf("%v", this.Codec) + `,`,
+		`Clock:` + repeatedStringForClock + `,`,
+		`Origin:` + strings.Replace(this.Origin.String(), "Origin", "Origin", 1) + `,`,
+		`KeyId:` + fmt.Sprintf("%v", this.KeyId) + `,`,
+		`Signature:` + fmt.Sprintf("%v", this.Signature) + `,`,
+		`}`,
+	}, "")
+	return s
+}
+func (this *ClockEntry) String() string {
+	if this == nil {
+		return "nil"
+	}
+	s := strings.Join([]string{`&ClockEntry{`,
+		`Node:` + fmt.Sprintf("%v", this.Node) + `,`,
+		`Seq:` + fmt.Sprintf("%v", this.Seq) + `,`,
+		`}`,
+	}, "")
+	return s
+}
+func (this *Origin) String() string {
+	if this == nil {
+		return "nil"
+	}
+	s := strings.Join([]string{`&Origin{`,
+		`Node:` + fmt.Sprintf("%v", this.Node) + `,`,
+		`Seq:` + fmt.Sprintf("%v", this.Seq) + `,`,
+		`PrevSeq:` + fmt.Sprintf("%v", this.PrevSeq) + `,`,
 		`}`,
 	}, "")
 	return s
@@ -631,6 +1103,363 @@ func (m *KeyValuePair) Unmarshal(dAtA []byte) error {
 			}
 			m.Codec = string(dAtA[iNdEx:postIndex])
 			iNdEx = postIndex
+		case 4:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Clock", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowKv
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthKv
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthKv
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Clock = append(m.Clock, ClockEntry{})
+			if err := m.Clock[len(m.Clock)-1].Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		case 5:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Origin", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowKv
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthKv
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthKv
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			if m.Origin == nil {
+				m.Origin = &Origin{}
+			}
+			if err := m.Origin.Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		case 6:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field KeyId", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowKv
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthKv
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthKv
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.KeyId = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 7:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Signature", wireType)
+			}
+			var byteLen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowKv
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				byteLen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if byteLen < 0 {
+				return ErrInvalidLengthKv
+			}
+			postIndex := iNdEx + byteLen
+			if postIndex < 0 {
+				return ErrInvalidLengthKv
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Signature = append(m.Signature[:0], dAtA[iNdEx:postIndex]...)
+			if m.Signature == nil {
+				m.Signature = []byte{}
+			}
+			iNdEx = postIndex
+		default:
+			iNdEx = preIndex
+			skippy, err := skipKv(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if (skippy < 0) || (iNdEx+skippy) < 0 {
+				return ErrInvalidLengthKv
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *ClockEntry) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowKv
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= uint64(b&0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: ClockEntry: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: ClockEntry: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Node", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowKv
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthKv
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthKv
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Node = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 2:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Seq", wireType)
+			}
+			m.Seq = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowKv
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.Seq |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		default:
+			iNdEx = preIndex
+			skippy, err := skipKv(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if (skippy < 0) || (iNdEx+skippy) < 0 {
+				return ErrInvalidLengthKv
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *Origin) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowKv
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= uint64(b&0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: Origin: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: Origin: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Node", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowKv
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthKv
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthKv
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Node = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 2:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Seq", wireType)
+			}
+			m.Seq = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowKv
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.Seq |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 3:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field PrevSeq", wireType)
+			}
+			m.PrevSeq = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowKv
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.PrevSeq |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
 		default:
 			iNdEx = preIndex
 			skippy, err := skipKv(dAtA[iNdEx:])
@@ -10,6 +10,7 @@ import (
 	"fmt"
 	"math"
 	math_rand "math/rand"
+	"os"
 	"strings"
 	"sync"
 	"time"
@@ -164,6 +165,18 @@ type KVConfig struct {
 	// How much space to use to keep received and sent messages in memory (for troubleshooting).
 	MessageHistoryBufferBytes int `yaml:"message_history_buffer_bytes" category:"advanced"`
 
+	// Signing of messages and write policies.
+	Signing SigningConfig `yaml:"signing"`
+
+	// Snapshot of the KV store on disk, loaded on startup.
+	SnapshotDir      string        `yaml:"snapshot_dir" category:"experimental"`
+	SnapshotInterval time.Duration `yaml:"snapshot_interval" category:"experimental"`
+	SnapshotMaxAge   time.Duration `yaml:"snapshot_max_age" category:"experimental"`
+
+	// Exchange only the values the other member is missing on push/pull sync.
+	DeltaPushPullEnabled          bool          `yaml:"delta_push_pull_enabled" category:"experimental"`
+	DeltaPushPullFullSyncInterval time.Duration `yaml:"delta_push_pull_full_sync_interval" category:"experimental"`
+
 	TCPTransport TCPTransportConfig `yaml:",inline"`
 
 	MetricsNamespace string `yaml:"-"`
@@ -195,6 +208,11 @@ func (cfg *KVConfig) RegisterFlagsWithPrefix(f *flag.FlagSet, prefix string) {
 	f.DurationVar(&cfg.GossipToTheDeadTime, prefix+"memberlist.gossip-to-dead-nodes-time", mlDefaults.GossipToTheDeadTime, "How long to keep gossiping to dead nodes, to give them chance to refute their death.")
 	f.DurationVar(&cfg.DeadNodeReclaimTime, prefix+"memberlist.dead-node-reclaim-time", mlDefaults.DeadNodeReclaimTime, "How soon can dead node's name be reclaimed with new address. 0 to disable.")
 	f.IntVar(&cfg.MessageHistoryBufferBytes, prefix+"memberlist.message-history-buffer-bytes", 0, "How much space to use for keeping received and sent messages in memory for troubleshooting (two buffers). 0 to disable.")
+	f.BoolVar(&cfg.DeltaPushPullEnabled, prefix+"memberlist.delta-push-pull-enabled", false, "If true, push/pull sync only exchanges the vector clocks of the keys, and each member then sends the values the other member is missing, instead of sending all values. Must be enabled on all members of the cluster, because members with it disabled can't parse the exchanged state.")
+	f.DurationVar(&cfg.DeltaPushPullFullSyncInterval, prefix+"memberlist.delta-push-pull-full-sync-interval", 10*time.Minute, "How often push/pull sync sends all values when delta push/pull is enabled, as safety net against diverging vector clocks. 0 to never send all values, except when joining the cluster.")
+	f.StringVar(&cfg.SnapshotDir, prefix+"memberlist.snapshot-dir", "", "Directory to periodically store a snapshot of the KV store into, which is loaded on startup before joining the cluster. Empty to disable snapshots.")
+	f.DurationVar(&cfg.SnapshotInterval, prefix+"memberlist.snapshot-interval", time.Minute, "How often to store the snapshot of the KV store. The snapshot is also stored on shutdown.")
+	f.DurationVar(&cfg.SnapshotMaxAge, prefix+"memberlist.snapshot-max-age", 0, "Snapshots older than this are not loaded on startup. It shouldn't exceed -memberlist.left-ingesters-timeout, because tombstones are removed after this timeout, and older snapshots could resurrect forgotten entries. 0 to use -memberlist.left-ingesters-timeout.")
 	f.BoolVar(&cfg.EnableCompression, prefix+"memberlist.compression-enabled", mlDefaults.EnableCompression, "Enable message compression. This can be used to reduce bandwidth usage at the cost of slightly more CPU utilization.")
 	f.DurationVar(&cfg.NotifyInterval, prefix+"memberlist.notify-interval", 0, "How frequently to notify watchers when a key changes. Can reduce CPU activity in large memberlist deployments. 0 to notify without delay.")
 	f.StringVar(&cfg.AdvertiseAddr, prefix+"memberlist.advertise-addr", mlDefaults.AdvertiseAddr, "Gossip address to advertise to other members in the cluster. Used for NAT traversal.")
@@ -203,6 +221,7 @@ func (cfg *KVConfig) RegisterFlagsWithPrefix(f *flag.FlagSet, prefix string) {
 	f.BoolVar(&cfg.ClusterLabelVerificationDisabled, prefix+"memberlist.cluster-label-verification-disabled", mlDefaults.SkipInboundLabelCheck, "When true, memberlist doesn't verify that inbound packets and gossip streams have the cluster label matching the configured one. This verification should be disabled while rolling out the change to the configured cluster label in a live memberlist cluster.")
 	f.DurationVar(&cfg.BroadcastTimeoutForLocalUpdatesOnShutdown, prefix+"memberlist.broadcast-timeout-for-local-updates-on-shutdown", 10*time.Second, "Timeout for broadcasting all remaining locally-generated updates to other nodes when shutting down. Only used if there are nodes left in the memberlist cluster, and only applies to locally-generated updates, not to broadcast messages that are result of incoming gossip updates. 0 = no timeout, wait until all locally-generated updates are sent.")
 
+	cfg.Signing.RegisterFlagsWithPrefix(f, prefix)
 	cfg.TCPTransport.RegisterFlagsWithPrefix(f, prefix)
 }
 
@@ -245,9 +264,21 @@ type KV struct {
 	storeMu sync.Mutex
 	store   map[string]ValueDesc
 
+	// Delta push/pull state, protected by storeMu.
+	nodeName     string    // Name of the local node, origin of the updates generated by CAS.
+	lastSeq      uint64    // Sequence number of the last update originated by the local node.
+	lastFullSync time.Time // Last time LocalState sent all values.
+
 	// Codec registry
 	codecs map[string]codec.Codec
 
+	// Keys used to sign and verify messages.
+	keyring keyring
+
+	// Statistics of the rejected updates by reason, for the status page.
+	rejectedMu    sync.Mutex
+	rejectedStats map[string]*RejectedUpdates
+
 	// Key watchers
 	watchersMu     sync.Mutex
 	watchers       map[string][]chan string
@@ -270,6 +301,10 @@ type KV struct {
 	workersMu       sync.Mutex
 	workersChannels map[string]chan valueUpdate
 
+	// Requests to send the values missing from other members after a delta push/pull, processed
+	// in the background to not block the push/pull sync.
+	deltaValuesCh chan deltaValuesRequest
+
 	// closed on shutdown
 	shutdown chan struct{}
 
@@ -290,6 +325,12 @@ type KV struct {
 	casFailures                         prometheus.Counter
 	casSuccesses                        prometheus.Counter
 	watchPrefixDroppedNotifications     *prometheus.CounterVec
+	totalSizeOfDeltaStateDigests        prometheus.Counter
+	deltaPushPullSentValuesBytes        prometheus.Counter
+	deltaPushPullSavedBytes             prometheus.Counter
+	rejectedUpdates                     *prometheus.CounterVec
+	snapshotKeys                        prometheus.Gauge
+	snapshotFailures                    prometheus.Counter
 
 	storeValuesDesc        *prometheus.Desc
 	storeTombstones        *prometheus.GaugeVec
@@ -330,6 +371,13 @@ type ValueDesc struct {
 
 	// ID of codec used to write this value. Only used when sending full state.
 	CodecID string
+
+	// Updates included in the value, only tracked when delta push/pull is enabled.
+	clock vectorClock
+
+	// Size of the value when it was last sent or received as full value, used to
+	// estimate the bytes saved by delta push/pull.
+	encodedSize int
 }
 
 func (v ValueDesc) Clone() (result ValueDesc) {
@@ -337,6 +385,7 @@ func (v ValueDesc) Clone() (result ValueDesc) {
 	if v.value != nil {
 		result.value = v.value.Clone()
 	}
+	result.clock = v.clock.clone()
 	return
 }
 
@@ -344,6 +393,11 @@ type valueUpdate struct {
 	value       []byte
 	codec       codec.Codec
 	messageSize int
+	clock       []ClockEntry
+	origin      *Origin
+
+	// Received message, if signed. Forwarded as is, to keep the signature of the original sender.
+	signedMsg []byte
 }
 
 func (v ValueDesc) String() string {
@@ -375,6 +429,8 @@ func NewKV(cfg KVConfig, logger log.Logger, dnsProvider DNSProvider, registerer
 		keyNotifications: make(map[string]struct{}),
 		prefixWatchers:   make(map[string][]chan string),
 		workersChannels:  make(map[string]chan valueUpdate),
+		deltaValuesCh:    make(chan deltaValuesRequest, deltaValuesQueueSize),
+		rejectedStats:    make(map[string]*RejectedUpdates),
 		shutdown:         make(chan struct{}),
 		maxCasRetries:    maxCasRetries,
 	}
@@ -395,9 +451,19 @@ func defaultMemberlistConfig() *memberlist.Config {
 }
 
 func (m *KV) buildMemberlistConfig() (*memberlist.Config, error) {
-	tr, err := NewTCPTransport(m.cfg.TCPTransport, m.logger, m.registerer)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create transport: %v", err)
+	var tr memberlist.Transport
+	if m.cfg.TCPTransport.MultiplexedConnectionsEnabled {
+		mtr, err := NewMultiplexedTransport(m.cfg.TCPTransport, m.logger, m.registerer)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create transport: %v", err)
+		}
+		tr = mtr
+	} else {
+		ttr, err := NewTCPTransport(m.cfg.TCPTransport, m.logger, m.registerer)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create transport: %v", err)
+		}
+		tr = ttr
 	}
 
 	mlCfg := defaultMemberlistConfig()
@@ -449,6 +515,23 @@ func (m *KV) buildMemberlistConfig() (*memberlist.Config, error) {
 }
 
 func (m *KV) starting(ctx context.Context) error {
+	if err := m.cfg.Signing.Validate(m.cfg.DeltaPushPullEnabled); err != nil {
+		return fmt.Errorf("invalid signing config: %v", err)
+	}
+	if err := m.keyring.load(m.cfg.Signing); err != nil {
+		return fmt.Errorf("failed to load signing keys: %v", err)
+	}
+
+	// Load the snapshot before joining the cluster, so that it's merged with the state of other members.
+	if m.cfg.SnapshotDir != "" {
+		if err := os.MkdirAll(m.cfg.SnapshotDir, 0o750); err != nil {
+			return fmt.Errorf("failed to create snapshot directory: %v", err)
+		}
+		if err := m.loadSnapshot(); err != nil {
+			level.Warn(m.logger).Log("msg", "failed to load memberlist KV snapshot", "dir", m.cfg.SnapshotDir, "err", err)
+		}
+	}
+
 	mlCfg, err := m.buildMemberlistConfig()
 	if err != nil {
 		return err
@@ -467,6 +550,9 @@ func (m *KV) starting(ctx context.Context) error {
 	}
 	// Finish delegate initialization.
 	m.memberlist = list
+	m.storeMu.Lock()
+	m.nodeName = list.LocalNode().Name
+	m.storeMu.Unlock()
 	m.localBroadcasts = &memberlist.TransmitLimitedQueue{
 		NumNodes:       list.NumMembers,
 		RetransmitMult: mlCfg.RetransmitMult,
@@ -475,6 +561,9 @@ func (m *KV) starting(ctx context.Context) error {
 		NumNodes:       list.NumMembers,
 		RetransmitMult: mlCfg.RetransmitMult,
 	}
+	if m.cfg.DeltaPushPullEnabled {
+		go m.processDeltaValues()
+	}
 	m.delegateReady.Store(true)
 
 	// Try to fast-join memberlist cluster in Starting state, so that we don't start with empty KV store.
@@ -508,9 +597,33 @@ func (m *KV) running(ctx context.Context) error {
 		tickerChan = t.C
 	}
 
+	var reloadChan <-chan time.Time
+	if m.cfg.Signing.ReloadInterval > 0 && (m.cfg.Signing.KeyFile != "" || m.cfg.Signing.TrustedKeysFile != "") {
+		t := time.NewTicker(m.cfg.Signing.ReloadInterval)
+		defer t.Stop()
+
+		reloadChan = t.C
+	}
+
+	var snapshotChan <-chan time.Time
+	if m.cfg.SnapshotDir != "" && m.cfg.SnapshotInterval > 0 {
+		t := time.NewTicker(m.cfg.SnapshotInterval)
+		defer t.Stop()
+
+		snapshotChan = t.C
+	}
+
 	logger := log.With(m.logger, "phase", "periodic_rejoin")
 	for {
 		select {
+		case <-snapshotChan:
+			m.snapshot()
+
+		case <-reloadChan:
+			if err := m.keyring.load(m.cfg.Signing); err != nil {
+				level.Warn(m.logger).Log("msg", "failed to reload signing keys, keeping current keys", "err", err)
+			}
+
 		case <-tickerChan:
 			const numAttempts = 1 // don't retry if resolution fails, we will try again next time
 			reached, err := m.joinMembersWithRetries(ctx, numAttempts, logger)
@@ -761,6 +874,10 @@ func (m *KV) stopping(_ error) error {
 		level.Warn(m.logger).Log("msg", "locally-generated broadcast messages left the queue", "count", msgs, "nodes", nodes)
 	}
 
+	if m.cfg.SnapshotDir != "" {
+		m.snapshot()
+	}
+
 	err := m.memberlist.Leave(m.cfg.LeaveTimeout)
 	if err != nil {
 		level.Error(m.logger).Log("msg", "error when leaving memberlist cluster", "err", err)
@@ -1035,7 +1152,7 @@ outer:
 			}
 		}
 
-		change, newver, retry, err := m.trySingleCas(key, codec, f)
+		change, newver, origin, retry, err := m.trySingleCas(key, codec, f)
 		if err != nil {
 			level.Debug(m.logger).Log("msg", "CAS attempt failed", "err", err, "retry", retry)
 
@@ -1050,7 +1167,7 @@ outer:
 			m.casSuccesses.Inc()
 			m.notifyWatchers(key)
 
-			m.broadcastNewValue(key, change, newver, codec, true)
+			m.broadcastNewValue(key, change, newver, codec, true, origin)
 		}
 
 		return nil
@@ -1065,52 +1182,54 @@ outer:
 	return fmt.Errorf("failed to CAS-update key %s: %v", key, lastError)
 }
 
-// returns change, error (or nil, if CAS succeeded), and whether to retry or not.
+// returns change, origin of the change, error (or nil, if CAS succeeded), and whether to retry or not.
 // returns errNoChangeDetected if merge failed to detect change in f's output.
-func (m *KV) trySingleCas(key string, codec codec.Codec, f func(in interface{}) (out interface{}, retry bool, err error)) (Mergeable, uint, bool, error) {
+func (m *KV) trySingleCas(key string, codec codec.Codec, f func(in interface{}) (out interface{}, retry bool, err error)) (Mergeable, uint, *Origin, bool, error) {
 	val, ver, err := m.get(key, codec)
 	if err != nil {
-		return nil, 0, false, fmt.Errorf("failed to get value: %v", err)
+		return nil, 0, nil, false, fmt.Errorf("failed to get value: %v", err)
 	}
 
 	out, retry, err := f(val)
 	if err != nil {
-		return nil, 0, retry, fmt.Errorf("fn returned error: %v", err)
+		return nil, 0, nil, retry, fmt.Errorf("fn returned error: %v", err)
 	}
 
 	if out == nil {
 		// no change to be done
-		return nil, 0, false, nil
+		return nil, 0, nil, false, nil
 	}
 
 	// Don't even try
 	incomingValue, ok := out.(Mergeable)
 	if !ok || incomingValue == nil {
-		return nil, 0, retry, fmt.Errorf("invalid type: %T, expected Mergeable", out)
+		return nil, 0, nil, retry, fmt.Errorf("invalid type: %T, expected Mergeable", out)
 	}
 
 	// To support detection of removed items from value, we will only allow CAS operation to
 	// succeed if version hasn't changed, i.e. state hasn't changed since running 'f'.
 	// Supplied function may have kept a reference to the returned "incoming value".
 	// If KV store will keep this value as well, it needs to make a clone.
-	change, newver, err := m.mergeValueForKey(key, incomingValue, true, ver, codec)
+	change, newver, origin, err := m.mergeValueForKey(key, incomingValue, true, ver, codec, clockUpdate{local: true})
 	if err == errVersionMismatch {
-		return nil, 0, retry, err
+		return nil, 0, nil, retry, err
 	}
 
 	if err != nil {
-		return nil, 0, retry, fmt.Errorf("merge failed: %v", err)
+		return nil, 0, nil, retry, fmt.Errorf("merge failed: %v", err)
 	}
 
 	if newver == 0 {
 		// CAS method reacts on this error
-		return nil, 0, retry, errNoChangeDetected
+		return nil, 0, nil, retry, errNoChangeDetected
 	}
 
-	return change, newver, retry, nil
+	return change, newver, origin, retry, nil
 }
 
-func (m *KV) broadcastNewValue(key string, change Mergeable, version uint, codec codec.Codec, locallyGenerated bool) {
+// broadcastNewValue queues the change for gossiping. If origin is not nil, it's the update
+// of the vector clock of the key described by the change.
+func (m *KV) broadcastNewValue(key string, change Mergeable, version uint, codec codec.Codec, locallyGenerated bool, origin *Origin) {
 	if locallyGenerated && m.State() != services.Running {
 		level.Warn(m.logger).Log("msg", "skipped broadcasting of locally-generated update because memberlist KV is shutting down", "key", key)
 		return
@@ -1123,14 +1242,20 @@ func (m *KV) broadcastNewValue(key string, change Mergeable, version uint, codec
 		return
 	}
 
-	kvPair := KeyValuePair{Key: key, Value: data, Codec: codec.CodecID()}
-	pairData, err := kvPair.Marshal()
+	kvPair := KeyValuePair{Key: key, Value: data, Codec: codec.CodecID(), Origin: origin}
+	pairData, err := m.marshalKVPair(&kvPair)
 	if err != nil {
 		level.Error(m.logger).Log("msg", "failed to serialize KV pair", "key", key, "version", version, "err", err)
 		m.numberOfBroadcastMessagesDropped.Inc()
 		return
 	}
 
+	m.queueBroadcast(kvPair, pairData, change, version, locallyGenerated)
+}
+
+// queueBroadcast queues the marshalled KV pair, which carries the change.
+func (m *KV) queueBroadcast(kvPair KeyValuePair, pairData []byte, change Mergeable, version uint, locallyGenerated bool) {
+	key := kvPair.Key
 	m.addSentMessage(Message{
 		Time:    time.Now(),
 		Size:    len(pairData),
@@ -1191,6 +1316,16 @@ func (m *KV) NotifyMsg(msg []byte) {
 		return
 	}
 
+	if !m.acceptKVPair(&kvPair, msg, false) {
+		return
+	}
+
+	// The message buffer may be reused by memberlist.
+	var signedMsg []byte
+	if kvPair.KeyId != "" {
+		signedMsg = append([]byte(nil), msg...)
+	}
+
 	codec := m.GetCodec(kvPair.GetCodec())
 	if codec == nil {
 		m.numberOfInvalidReceivedMessages.Inc()
@@ -1200,7 +1335,7 @@ func (m *KV) NotifyMsg(msg []byte) {
 
 	ch := m.getKeyWorkerChannel(kvPair.Key)
 	select {
-	case ch <- valueUpdate{value: kvPair.Value, codec: codec, messageSize: len(msg)}:
+	case ch <- valueUpdate{value: kvPair.Value, codec: codec, messageSize: len(msg), clock: kvPair.Clock, origin: kvPair.Origin, signedMsg: signedMsg}:
 	default:
 		m.numberOfDroppedMessages.Inc()
 		level.Warn(m.logger).Log("msg", "notify queue full, dropping message", "key", kvPair.Key)
@@ -1227,7 +1362,7 @@ func (m *KV) processValueUpdate(workerCh <-chan valueUpdate, key string) {
 		select {
 		case update := <-workerCh:
 			// we have a value update! Let's merge it with our current version for given key
-			mod, version, err := m.mergeBytesValueForKey(key, update.value, update.codec)
+			mod, version, origin, err := m.mergeBytesValueForKey(key, update.value, update.codec, update.clock, update.origin)
 
 			changes := []string(nil)
 			if mod != nil {
@@ -1238,9 +1373,11 @@ func (m *KV) processValueUpdate(workerCh <-chan valueUpdate, key string) {
 				Time: time.Now(),
 				Size: update.messageSize,
 				Pair: KeyValuePair{
-					Key:   key,
-					Value: update.value,
-					Codec: update.codec.CodecID(),
+					Key:    key,
+					Value:  update.value,
+					Codec:  update.codec.CodecID(),
+					Clock:  update.clock,
+					Origin: update.origin,
 				},
 				Version: version,
 				Changes: changes,
@@ -1251,8 +1388,16 @@ func (m *KV) processValueUpdate(workerCh <-chan valueUpdate, key string) {
 			} else if version > 0 {
 				m.notifyWatchers(key)
 
-				// Don't resend original message, but only changes.
-				m.broadcastNewValue(key, mod, version, update.codec, false)
+				if update.signedMsg != nil {
+					// Resend the original message, as the changes can't be signed by the original sender.
+					pair := KeyValuePair{}
+					if err := pair.Unmarshal(update.signedMsg); err == nil {
+						m.queueBroadcast(pair, update.signedMsg, mod, version, false)
+					}
+				} else {
+					// Don't resend original message, but only changes.
+					m.broadcastNewValue(key, mod, version, update.codec, false, origin)
+				}
 			}
 
 		case <-m.shutdown:
@@ -1288,21 +1433,37 @@ func (m *KV) GetBroadcasts(overhead, limit int) [][]byte {
 // This is "pull" part of push/pull sync (either periodic, or when new node joins the cluster).
 // Here we dump our entire state -- all keys and their values. There is no limit on message size here,
 // as Memberlist uses 'stream' operations for transferring this state.
-func (m *KV) LocalState(_ bool) []byte {
+//
+// If delta push/pull is enabled, we only send the vector clocks of our keys, except when joining
+// the cluster and every DeltaPushPullFullSyncInterval.
+func (m *KV) LocalState(join bool) []byte {
 	if !m.delegateReady.Load() {
 		return nil
 	}
 
 	m.numberOfPulls.Inc()
 
+	sent := time.Now()
+	var expired func(node string, seq uint64) bool
+	if m.cfg.DeltaPushPullEnabled {
+		// Reads the members before locking the store.
+		expired = m.clockEntryExpired(sent)
+	}
+
 	m.storeMu.Lock()
 	defer m.storeMu.Unlock()
 
+	if m.useDeltaState(join, sent) {
+		data := m.localDeltaState(expired)
+		m.totalSizeOfPulls.Add(float64(len(data)))
+		return data
+	}
+	m.lastFullSync = sent
+
 	// For each Key/Value pair in our store, we write
 	// [4-bytes length of marshalled KV pair] [marshalled KV pair]
 
 	buf := bytes.Buffer{}
-	sent := time.Now()
 
 	kvPair := KeyValuePair{}
 	for key, val := range m.store {
@@ -1326,8 +1487,14 @@ func (m *KV) LocalState(_ bool) []byte {
 		kvPair.Key = key
 		kvPair.Value = encoded
 		kvPair.Codec = val.CodecID
+		kvPair.Clock = val.clock.entries()
 
-		ser, err := kvPair.Marshal()
+		if m.cfg.DeltaPushPullEnabled {
+			val.encodedSize = len(encoded)
+			m.store[key] = val
+		}
+
+		ser, err := m.marshalKVPair(&kvPair)
 		if err != nil {
 			level.Error(m.logger).Log("msg", "failed to serialize KV Pair", "err", err)
 			continue
@@ -1362,6 +1529,7 @@ func (m *KV) LocalState(_ bool) []byte {
 // This is 'push' part of push/pull sync. We merge incoming KV store (all keys and values) with ours.
 //
 // Data is full state of remote KV store, as generated by LocalState method (run on another node).
+// If it's a delta state instead, we send to the remote node the values it's missing.
 func (m *KV) MergeRemoteState(data []byte, _ bool) {
 	if !m.delegateReady.Load() {
 		return
@@ -1374,6 +1542,10 @@ func (m *KV) MergeRemoteState(data []byte, _ bool) {
 
 	kvPair := KeyValuePair{}
 
+	// Set if data is a delta state, which only contains the vector clocks of the remote keys.
+	deltaStateNode := ""
+	var remoteClocks map[string]vectorClock
+
 	var err error
 	// Data contains individual KV pairs (encoded as protobuf messages), each prefixed with 4 bytes length of KV pair:
 	// [4-bytes length of marshalled KV pair] [marshalled KV pair] [4-bytes length] [KV pair]...
@@ -1392,8 +1564,9 @@ func (m *KV) MergeRemoteState(data []byte, _ bool) {
 			break
 		}
 
+		pairData := data[:kvPairLength]
 		kvPair.Reset()
-		err = kvPair.Unmarshal(data[:kvPairLength])
+		err = kvPair.Unmarshal(pairData)
 		if err != nil {
 			err = fmt.Errorf("failed to parse KV Pair: %v", err)
 			break
@@ -1401,6 +1574,20 @@ func (m *KV) MergeRemoteState(data []byte, _ bool) {
 
 		data = data[kvPairLength:]
 
+		if !m.acceptKVPair(&kvPair, pairData, true) {
+			continue
+		}
+
+		if kvPair.Codec == deltaStateCodecID {
+			deltaStateNode = kvPair.Key
+			remoteClocks = map[string]vectorClock{}
+			continue
+		}
+		if remoteClocks != nil {
+			remoteClocks[kvPair.Key] = vectorClockFromEntries(kvPair.Clock)
+			continue
+		}
+
 		codec := m.GetCodec(kvPair.GetCodec())
 		if codec == nil {
 			level.Error(m.logger).Log("msg", "failed to parse remote state: unknown codec for key", "codec", kvPair.GetCodec(), "key", kvPair.GetKey())
@@ -1408,7 +1595,7 @@ func (m *KV) MergeRemoteState(data []byte, _ bool) {
 		}
 
 		// we have both key and value, try to merge it with our state
-		change, newver, err := m.mergeBytesValueForKey(kvPair.Key, kvPair.Value, codec)
+		change, newver, _, err := m.mergeBytesValueForKey(kvPair.Key, kvPair.Value, codec, kvPair.Clock, nil)
 
 		changes := []string(nil)
 		if change != nil {
@@ -1427,54 +1614,76 @@ func (m *KV) MergeRemoteState(data []byte, _ bool) {
 			level.Error(m.logger).Log("msg", "failed to store received value", "key", kvPair.Key, "err", err)
 		} else if newver > 0 {
 			m.notifyWatchers(kvPair.Key)
-			m.broadcastNewValue(kvPair.Key, change, newver, codec, false)
+
+			if kvPair.KeyId != "" {
+				// Resend the received value, as the changes can't be signed by the original sender.
+				m.queueBroadcast(kvPair, append([]byte(nil), pairData...), change, newver, false)
+			} else {
+				m.broadcastNewValue(kvPair.Key, change, newver, codec, false, nil)
+			}
 		}
 	}
 
 	if err != nil {
 		level.Error(m.logger).Log("msg", "failed to parse remote state", "err", err)
+	} else if deltaStateNode != "" {
+		m.queueDeltaValues(deltaStateNode, remoteClocks)
 	}
 }
 
-func (m *KV) mergeBytesValueForKey(key string, incomingData []byte, codec codec.Codec) (Mergeable, uint, error) {
+// mergeBytesValueForKey decodes and merges the incoming value. Clock is the vector clock of the incoming
+// value if it's a full value, origin is the update described by the incoming value if it's a gossiped change.
+func (m *KV) mergeBytesValueForKey(key string, incomingData []byte, codec codec.Codec, clock []ClockEntry, origin *Origin) (Mergeable, uint, *Origin, error) {
 	decodedValue, err := codec.Decode(incomingData)
 	if err != nil {
-		return nil, 0, fmt.Errorf("failed to decode value: %v", err)
+		return nil, 0, nil, fmt.Errorf("failed to decode value: %v", err)
 	}
 
 	incomingValue, ok := decodedValue.(Mergeable)
 	if !ok {
-		return nil, 0, fmt.Errorf("expected Mergeable, got: %T", decodedValue)
+		return nil, 0, nil, fmt.Errorf("expected Mergeable, got: %T", decodedValue)
+	}
+
+	cu := clockUpdate{clock: vectorClockFromEntries(clock), origin: origin}
+	if len(clock) > 0 {
+		cu.encodedSize = len(incomingData)
 	}
 
 	// No need to clone this "incomingValue", since we have just decoded it from bytes, and won't be using it.
-	return m.mergeValueForKey(key, incomingValue, false, 0, codec)
+	return m.mergeValueForKey(key, incomingValue, false, 0, codec, cu)
 }
 
 // Merges incoming value with value we have in our store. Returns "a change" that can be sent to other
-// cluster members to update their state, and new version of the value.
+// cluster members to update their state, new version of the value, and the origin to gossip the change with.
 // If CAS version is specified, then merging will fail if state has changed already, and errVersionMismatch is reported.
 // If no modification occurred, new version is 0.
-func (m *KV) mergeValueForKey(key string, incomingValue Mergeable, incomingValueRequiresClone bool, casVersion uint, codec codec.Codec) (Mergeable, uint, error) {
+func (m *KV) mergeValueForKey(key string, incomingValue Mergeable, incomingValueRequiresClone bool, casVersion uint, codec codec.Codec, cu clockUpdate) (Mergeable, uint, *Origin, error) {
 	m.storeMu.Lock()
 	defer m.storeMu.Unlock()
 
+	// The origin is only gossiped further if the change contains the whole incoming value.
+	incomingContent := 0
+	if cu.origin != nil {
+		incomingContent = len(incomingValue.MergeContent())
+	}
+
 	// Note that we do not take a deep copy of curr.value here, it is modified in-place.
 	// This is safe because the entire function runs under the store lock; we do not return
 	// the full state anywhere as is done elsewhere (i.e. Get/WatchKey/CAS).
 	curr := m.store[key]
 	// if casVersion is 0, then there was no previous value, so we will just do normal merge, without localCAS flag set.
 	if casVersion > 0 && curr.Version != casVersion {
-		return nil, 0, errVersionMismatch
+		return nil, 0, nil, errVersionMismatch
 	}
 	result, change, err := computeNewValue(incomingValue, incomingValueRequiresClone, curr.value, casVersion > 0)
 	if err != nil {
-		return nil, 0, err
+		return nil, 0, nil, err
 	}
 
 	// No change, don't store it.
 	if change == nil || len(change.MergeContent()) == 0 {
-		return nil, 0, nil
+		m.storeClockUpdate(key, curr, cu)
+		return nil, 0, nil, nil
 	}
 
 	if m.cfg.LeftIngestersTimeout > 0 {
@@ -1491,22 +1700,30 @@ func (m *KV) mergeValueForKey(key string, incomingValue Mergeable, incomingValue
 		// RemoveTombstones twice with same limit should be noop.
 		change.RemoveTombstones(limit)
 		if len(change.MergeContent()) == 0 {
-			return nil, 0, nil
+			m.storeClockUpdate(key, curr, cu)
+			return nil, 0, nil, nil
 		}
 	}
 
 	newVersion := curr.Version + 1
-	m.store[key] = ValueDesc{
-		value:   result,
-		Version: newVersion,
-		CodecID: codec.CodecID(),
+	newVal := ValueDesc{
+		value:       result,
+		Version:     newVersion,
+		CodecID:     codec.CodecID(),
+		clock:       curr.clock,
+		encodedSize: curr.encodedSize,
+	}
+	origin := m.updateClock(&newVal, cu, true)
+	if cu.origin != nil && len(change.MergeContent()) != incomingContent {
+		origin = nil
 	}
+	m.store[key] = newVal
 
 	// The "changes" returned by Merge() can contain references to the "result"
 	// state. Therefore, make sure we clone it before releasing the lock.
 	change = change.Clone()
 
-	return change, newVersion, nil
+	return change, newVersion, origin, nil
 }
 
 // returns [result, change, error]
@@ -189,6 +189,48 @@ func (m *KV) createAndRegisterMetrics() {
 		Help:      "Number of dropped notifications in WatchPrefix function",
 	}, []string{"prefix"})
 
+	m.totalSizeOfDeltaStateDigests = promauto.With(m.registerer).NewCounter(prometheus.CounterOpts{
+		Namespace: m.cfg.MetricsNamespace,
+		Subsystem: subsystem,
+		Name:      "delta_push_pull_digests_bytes_total",
+		Help:      "Total size of the vector clocks of the keys sent instead of the values by delta push/pull",
+	})
+
+	m.deltaPushPullSentValuesBytes = promauto.With(m.registerer).NewCounter(prometheus.CounterOpts{
+		Namespace: m.cfg.MetricsNamespace,
+		Subsystem: subsystem,
+		Name:      "delta_push_pull_sent_values_bytes_total",
+		Help:      "Total size of the values sent by delta push/pull to members missing them",
+	})
+
+	m.deltaPushPullSavedBytes = promauto.With(m.registerer).NewCounter(prometheus.CounterOpts{
+		Namespace: m.cfg.MetricsNamespace,
+		Subsystem: subsystem,
+		Name:      "delta_push_pull_saved_bytes_total",
+		Help:      "Estimated size of the values not sent by delta push/pull because the other member already had them",
+	})
+
+	m.rejectedUpdates = promauto.With(m.registerer).NewCounterVec(prometheus.CounterOpts{
+		Namespace: m.cfg.MetricsNamespace,
+		Subsystem: subsystem,
+		Name:      "rejected_updates_total",
+		Help:      "Number of received KV pairs rejected because of their signature or the write policy of their key",
+	}, []string{"reason"})
+
+	m.snapshotKeys = promauto.With(m.registerer).NewGauge(prometheus.GaugeOpts{
+		Namespace: m.cfg.MetricsNamespace,
+		Subsystem: subsystem,
+		Name:      "snapshot_keys",
+		Help:      "Number of keys in the last snapshot of the KV store written to disk",
+	})
+
+	m.snapshotFailures = promauto.With(m.registerer).NewCounter(prometheus.CounterOpts{
+		Namespace: m.cfg.MetricsNamespace,
+		Subsystem: subsystem,
+		Name:      "snapshot_failures_total",
+		Help:      "Number of failures to write the snapshot of the KV store to disk",
+	})
+
 	if m.registerer == nil {
 		return
 	}
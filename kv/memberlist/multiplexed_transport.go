@@ -0,0 +1,426 @@
+package memberlist
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/hashicorp/memberlist"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/atomic"
+)
+
+const (
+	// multiplexedAck is sent by the receiver of a multiplexed connection once it has read the header.
+	multiplexedAck = 1
+
+	// multiplexedMaxPacketSize is the maximum size of a packet received over a multiplexed connection.
+	// It matches the UDPBufferSize configured in memberlist.
+	multiplexedMaxPacketSize = 10 * 1024 * 1024
+
+	// legacyNodeRetryInterval is how long to send packets over new connections to a node which doesn't
+	// support multiplexed connections, before trying again. Each failed attempt doubles the interval,
+	// up to legacyNodeMaxRetryInterval, because old nodes log an error for each attempt.
+	legacyNodeRetryInterval    = time.Minute
+	legacyNodeMaxRetryInterval = time.Hour
+)
+
+var errMultiplexingNotSupported = errors.New("node doesn't support multiplexed connections")
+
+// MultiplexedTransport is a TCPTransport which sends the packets to each node over a persistent
+// connection, multiplexing all packets sent to the node, instead of opening a new connection for
+// each packet. Packets are sent by a goroutine per node, so a slow node doesn't delay packets to
+// other nodes.
+//
+// Nodes running TCPTransport accept multiplexed connections too. Packets to nodes not supporting
+// them (running older versions) are sent over new connections, like TCPTransport does.
+//
+// Streams, used by push/pull sync, still use a new connection each, so that transferring a large
+// state doesn't delay packets (e.g. probes) sent to the same node.
+type MultiplexedTransport struct {
+	*TCPTransport
+
+	nodesMu sync.Mutex
+	nodes   map[string]*multiplexedNode
+	closed  atomic.Bool
+	nodesWG sync.WaitGroup
+
+	openConnections   prometheus.Gauge
+	openedConnections prometheus.Counter
+	connectionErrors  prometheus.Counter
+	fallbackPackets   prometheus.Counter
+}
+
+// multiplexedNode holds the persistent connection to a node. Only used by the goroutine of the node.
+type multiplexedNode struct {
+	addr  string
+	queue chan []byte
+
+	conn        net.Conn
+	legacyUntil time.Time
+	legacyRetry time.Duration
+}
+
+// NewMultiplexedTransport returns a new multiplexed transport with the given configuration.
+// On success all the network listeners will be created and listening.
+func NewMultiplexedTransport(config TCPTransportConfig, logger log.Logger, registerer prometheus.Registerer) (*MultiplexedTransport, error) {
+	tcp, err := NewTCPTransport(config, logger, registerer)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &MultiplexedTransport{
+		TCPTransport: tcp,
+		nodes:        map[string]*multiplexedNode{},
+	}
+	t.registerMultiplexedMetrics(registerer)
+	return t, nil
+}
+
+// WriteTo is a packet-oriented interface that queues the given payload to be sent to the given address.
+func (t *MultiplexedTransport) WriteTo(b []byte, addr string) (time.Time, error) {
+	t.nodesMu.Lock()
+	defer t.nodesMu.Unlock()
+
+	if t.closed.Load() {
+		return time.Time{}, errors.New("transport is shutting down")
+	}
+
+	n := t.nodes[addr]
+	if n == nil {
+		queueSize := t.cfg.MultiplexedQueueSize
+		if queueSize <= 0 {
+			queueSize = 1
+		}
+		n = &multiplexedNode{addr: addr, queue: make(chan []byte, queueSize)}
+		t.nodes[addr] = n
+
+		t.nodesWG.Add(1)
+		go t.runNode(n)
+	}
+
+	select {
+	case n.queue <- b:
+	default:
+		// Dropped packets are not an issue, the memberlist protocol will retry later.
+		level.Debug(t.logger).Log("msg", "WriteTo queue full. Dropping message", "addr", addr)
+		t.droppedPackets.Inc()
+	}
+	return time.Now(), nil
+}
+
+// runNode sends the packets queued for the node, until the connection has been idle for
+// MultiplexedConnectionIdleTime or the transport is shut down.
+func (t *MultiplexedTransport) runNode(n *multiplexedNode) {
+	defer t.nodesWG.Done()
+	defer t.closeNodeConnection(n)
+
+	var idleChan <-chan time.Time
+	if t.cfg.MultiplexedConnectionIdleTime > 0 {
+		idle := time.NewTicker(t.cfg.MultiplexedConnectionIdleTime)
+		defer idle.Stop()
+		idleChan = idle.C
+	}
+
+	sent := false
+	for {
+		select {
+		case b, ok := <-n.queue:
+			if !ok {
+				return
+			}
+			// Don't wait for slow nodes on shutdown.
+			if t.closed.Load() {
+				continue
+			}
+
+			sent = true
+			t.sentPackets.Inc()
+			t.sentPacketsBytes.Add(float64(len(b)))
+			t.sendPacket(n, b)
+
+		case <-idleChan:
+			if sent {
+				sent = false
+				continue
+			}
+
+			t.nodesMu.Lock()
+			// Keep legacy nodes until the retry, to not lose the backoff.
+			if len(n.queue) > 0 || t.closed.Load() || time.Now().Before(n.legacyUntil) {
+				t.nodesMu.Unlock()
+				continue
+			}
+			delete(t.nodes, n.addr)
+			t.nodesMu.Unlock()
+			return
+		}
+	}
+}
+
+// sendPacket sends the packet over the persistent connection to the node, opening it if needed.
+// If the node doesn't support multiplexed connections, or the connection is broken, the packet
+// is sent over a new connection.
+func (t *MultiplexedTransport) sendPacket(n *multiplexedNode, b []byte) {
+	if n.conn == nil && time.Now().After(n.legacyUntil) {
+		conn, err := t.openMultiplexedConnection(n.addr)
+		switch {
+		case errors.Is(err, errMultiplexingNotSupported):
+			n.legacyRetry = nextLegacyNodeRetry(n.legacyRetry)
+			n.legacyUntil = time.Now().Add(n.legacyRetry)
+			level.Debug(t.logger).Log("msg", "node doesn't support multiplexed connections, sending packets over new connections", "addr", n.addr, "retry_in", n.legacyRetry)
+		case err != nil:
+			t.connectionErrors.Inc()
+			t.sentPacketsErrors.Inc()
+			t.logWriteError(n.addr, err)
+			return
+		default:
+			n.conn = conn
+			n.legacyRetry = 0
+			t.openedConnections.Inc()
+			t.openConnections.Inc()
+		}
+	}
+
+	if n.conn != nil {
+		err := t.writeFrame(n.conn, b)
+		if err == nil {
+			t.debugLog().Log("msg", "WriteTo: packet sent over multiplexed connection", "addr", n.addr, "size", len(b))
+			return
+		}
+
+		// The node may have been restarted, try again over a new connection.
+		t.connectionErrors.Inc()
+		level.Debug(t.logger).Log("msg", "multiplexed connection failed", "addr", n.addr, "err", err)
+		t.closeNodeConnection(n)
+	}
+
+	t.fallbackPackets.Inc()
+	if err := t.writeTo(b, n.addr); err != nil {
+		t.sentPacketsErrors.Inc()
+		t.logWriteError(n.addr, err)
+	}
+}
+
+// nextLegacyNodeRetry returns how long to wait before retrying a multiplexed connection to a
+// legacy node, given the previous interval.
+func nextLegacyNodeRetry(prev time.Duration) time.Duration {
+	if prev <= 0 {
+		return legacyNodeRetryInterval
+	}
+	if next := 2 * prev; next < legacyNodeMaxRetryInterval {
+		return next
+	}
+	return legacyNodeMaxRetryInterval
+}
+
+func (t *MultiplexedTransport) logWriteError(addr string, err error) {
+	logLevel := level.Warn(t.logger)
+	if strings.Contains(err.Error(), "connection refused") {
+		// The connection refused is a common error that could happen during normal operations when a node
+		// shutdown (or crash). It shouldn't be considered a warning condition on the sender side.
+		logLevel = t.debugLog()
+	}
+	logLevel.Log("msg", "WriteTo failed", "addr", addr, "err", err)
+}
+
+func (t *MultiplexedTransport) closeNodeConnection(n *multiplexedNode) {
+	if n.conn != nil {
+		_ = n.conn.Close()
+		n.conn = nil
+		t.openConnections.Dec()
+	}
+}
+
+// openMultiplexedConnection connects to the node, and sends the header of a multiplexed connection:
+// [message type] [length of our address] [our address]. Returns errMultiplexingNotSupported if the
+// node closes the connection instead of acknowledging the header.
+func (t *MultiplexedTransport) openMultiplexedConnection(addr string) (net.Conn, error) {
+	// Like packets, the header carries our advertised address, so that memberlist on the receiving side
+	// can match the packets with the correct node.
+	ourAddr := t.getAdvertisedAddr()
+	if len(ourAddr) > 255 {
+		return nil, fmt.Errorf("local address too long")
+	}
+
+	c, err := t.getConnection(addr, t.cfg.PacketDialTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	header := bytes.Buffer{}
+	header.WriteByte(byte(multiplexed))
+	header.WriteByte(byte(len(ourAddr)))
+	header.WriteString(ourAddr)
+
+	if t.cfg.PacketDialTimeout > 0 {
+		if err := c.SetDeadline(time.Now().Add(t.cfg.PacketDialTimeout)); err != nil {
+			_ = c.Close()
+			return nil, fmt.Errorf("setting deadline: %v", err)
+		}
+	}
+
+	if _, err := c.Write(header.Bytes()); err != nil {
+		_ = c.Close()
+		return nil, fmt.Errorf("sending header: %v", err)
+	}
+
+	ack := []byte{0}
+	if _, err := io.ReadFull(c, ack); err != nil || ack[0] != multiplexedAck {
+		_ = c.Close()
+		return nil, errMultiplexingNotSupported
+	}
+
+	if err := c.SetDeadline(time.Time{}); err != nil {
+		_ = c.Close()
+		return nil, fmt.Errorf("clearing deadline: %v", err)
+	}
+	return c, nil
+}
+
+// writeFrame writes the packet to the multiplexed connection: [4-bytes length of packet] [packet].
+// The integrity of the packet is ensured by TCP, so unlike packets sent over new connections,
+// there is no digest.
+func (t *MultiplexedTransport) writeFrame(c net.Conn, b []byte) error {
+	if t.cfg.PacketWriteTimeout > 0 {
+		if err := c.SetWriteDeadline(time.Now().Add(t.cfg.PacketWriteTimeout)); err != nil {
+			return fmt.Errorf("setting deadline: %v", err)
+		}
+	}
+
+	frame := make([]byte, 4+len(b))
+	binary.BigEndian.PutUint32(frame, uint32(len(b)))
+	copy(frame[4:], b)
+
+	_, err := c.Write(frame)
+	return err
+}
+
+// Shutdown is called when memberlist is shutting down. Packets still queued are dropped.
+func (t *MultiplexedTransport) Shutdown() error {
+	t.nodesMu.Lock()
+	if !t.closed.Load() {
+		t.closed.Store(true)
+		for _, n := range t.nodes {
+			close(n.queue)
+		}
+	}
+	t.nodesMu.Unlock()
+
+	t.nodesWG.Wait()
+	return t.TCPTransport.Shutdown()
+}
+
+// handleMultiplexedConnection reads the packets sent over a multiplexed connection, after the message
+// type has been read, until the connection is closed.
+func (t *TCPTransport) handleMultiplexedConnection(conn net.Conn) {
+	t.shutdownMu.RLock()
+	if t.shutdown {
+		t.shutdownMu.RUnlock()
+		return
+	}
+	t.multiplexedConnsMu.Lock()
+	t.multiplexedConns[conn] = struct{}{}
+	t.multiplexedConnsMu.Unlock()
+	t.shutdownMu.RUnlock()
+
+	defer func() {
+		t.multiplexedConnsMu.Lock()
+		delete(t.multiplexedConns, conn)
+		t.multiplexedConnsMu.Unlock()
+	}()
+
+	addrLengthBuf := []byte{0}
+	if _, err := io.ReadFull(conn, addrLengthBuf); err != nil {
+		level.Warn(t.logger).Log("msg", "error while reading node address length from multiplexed connection", "err", err, "remote", conn.RemoteAddr())
+		return
+	}
+	addrBuf := make([]byte, addrLengthBuf[0])
+	if _, err := io.ReadFull(conn, addrBuf); err != nil {
+		level.Warn(t.logger).Log("msg", "error while reading node address from multiplexed connection", "err", err, "remote", conn.RemoteAddr())
+		return
+	}
+
+	if _, err := conn.Write([]byte{multiplexedAck}); err != nil {
+		level.Warn(t.logger).Log("msg", "error while acknowledging multiplexed connection", "err", err, "remote", conn.RemoteAddr())
+		return
+	}
+
+	t.debugLog().Log("msg", "New multiplexed connection", "addr", addr(addrBuf), "remote", conn.RemoteAddr())
+
+	lengthBuf := make([]byte, 4)
+	for {
+		if _, err := io.ReadFull(conn, lengthBuf); err != nil {
+			// The connection is closed by the sender when idle.
+			if !errors.Is(err, io.EOF) {
+				t.debugLog().Log("msg", "multiplexed connection closed", "err", err, "remote", conn.RemoteAddr())
+			}
+			return
+		}
+
+		length := binary.BigEndian.Uint32(lengthBuf)
+		if length > multiplexedMaxPacketSize {
+			t.receivedPacketsErrors.Inc()
+			level.Warn(t.logger).Log("msg", "packet too large received over multiplexed connection", "size", length, "remote", conn.RemoteAddr())
+			return
+		}
+
+		t.receivedPackets.Inc()
+		buf := make([]byte, length)
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			t.receivedPacketsErrors.Inc()
+			level.Warn(t.logger).Log("msg", "error while reading packet data from multiplexed connection", "err", err, "remote", conn.RemoteAddr())
+			return
+		}
+
+		t.debugLog().Log("msg", "Received packet over multiplexed connection", "addr", addr(addrBuf), "size", len(buf))
+		t.receivedPacketsBytes.Add(float64(len(buf)))
+
+		t.packetCh <- &memberlist.Packet{
+			Buf:       buf,
+			From:      addr(addrBuf),
+			Timestamp: time.Now(),
+		}
+	}
+}
+
+func (t *MultiplexedTransport) registerMultiplexedMetrics(registerer prometheus.Registerer) {
+	const subsystem = "memberlist_tcp_transport"
+
+	t.openConnections = promauto.With(registerer).NewGauge(prometheus.GaugeOpts{
+		Namespace: t.cfg.MetricsNamespace,
+		Subsystem: subsystem,
+		Name:      "multiplexed_connections",
+		Help:      "Number of open outgoing multiplexed connections",
+	})
+
+	t.openedConnections = promauto.With(registerer).NewCounter(prometheus.CounterOpts{
+		Namespace: t.cfg.MetricsNamespace,
+		Subsystem: subsystem,
+		Name:      "multiplexed_connections_opened_total",
+		Help:      "Number of outgoing multiplexed connections opened",
+	})
+
+	t.connectionErrors = promauto.With(registerer).NewCounter(prometheus.CounterOpts{
+		Namespace: t.cfg.MetricsNamespace,
+		Subsystem: subsystem,
+		Name:      "multiplexed_connection_errors_total",
+		Help:      "Number of errors when opening or writing to outgoing multiplexed connections",
+	})
+
+	t.fallbackPackets = promauto.With(registerer).NewCounter(prometheus.CounterOpts{
+		Namespace: t.cfg.MetricsNamespace,
+		Subsystem: subsystem,
+		Name:      "multiplexed_fallback_packets_total",
+		Help:      "Number of packets sent over a new connection, because the node doesn't support multiplexed connections or the multiplexed connection failed",
+	})
+}
@@ -0,0 +1,201 @@
+package memberlist
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/dskit/flagext"
+	"github.com/grafana/dskit/kv/codec"
+	"github.com/grafana/dskit/services"
+)
+
+func newTestMultiplexedTransportConfig() TCPTransportConfig {
+	cfg := TCPTransportConfig{}
+	flagext.DefaultValues(&cfg)
+	cfg.BindAddrs = getLocalhostAddrs()
+	cfg.BindPort = 0
+	cfg.MultiplexedConnectionsEnabled = true
+	return cfg
+}
+
+func TestMultiplexedTransport_ShouldSendPacketsOverSingleConnection(t *testing.T) {
+	sender, err := NewMultiplexedTransport(newTestMultiplexedTransportConfig(), log.NewNopLogger(), prometheus.NewPedanticRegistry())
+	require.NoError(t, err)
+	defer sender.Shutdown() //nolint:errcheck
+
+	_, _, err = sender.FinalAdvertiseAddr("127.0.0.1", sender.GetAutoBindPort())
+	require.NoError(t, err)
+
+	receiver, err := NewTCPTransport(newTestMultiplexedTransportConfig(), log.NewNopLogger(), nil)
+	require.NoError(t, err)
+	defer receiver.Shutdown() //nolint:errcheck
+
+	receiverAddr := fmt.Sprintf("127.0.0.1:%d", receiver.GetAutoBindPort())
+	for i := 0; i < 10; i++ {
+		_, err := sender.WriteTo([]byte(fmt.Sprintf("packet-%d", i)), receiverAddr)
+		require.NoError(t, err)
+	}
+
+	for i := 0; i < 10; i++ {
+		select {
+		case p := <-receiver.PacketCh():
+			assert.Equal(t, fmt.Sprintf("packet-%d", i), string(p.Buf))
+			assert.Equal(t, fmt.Sprintf("127.0.0.1:%d", sender.GetAutoBindPort()), p.From.String())
+		case <-time.After(5 * time.Second):
+			require.FailNow(t, "packet not received")
+		}
+	}
+
+	assert.Equal(t, float64(1), testutil.ToFloat64(sender.openedConnections))
+	assert.Equal(t, float64(1), testutil.ToFloat64(sender.openConnections))
+	assert.Equal(t, float64(0), testutil.ToFloat64(sender.fallbackPackets))
+	assert.Equal(t, float64(10), testutil.ToFloat64(receiver.receivedPackets))
+}
+
+func TestMultiplexedTransport_ShouldCloseIdleConnections(t *testing.T) {
+	cfg := newTestMultiplexedTransportConfig()
+	cfg.MultiplexedConnectionIdleTime = 100 * time.Millisecond
+
+	sender, err := NewMultiplexedTransport(cfg, log.NewNopLogger(), prometheus.NewPedanticRegistry())
+	require.NoError(t, err)
+	defer sender.Shutdown() //nolint:errcheck
+
+	receiver, err := NewTCPTransport(newTestMultiplexedTransportConfig(), log.NewNopLogger(), nil)
+	require.NoError(t, err)
+	defer receiver.Shutdown() //nolint:errcheck
+
+	receiverAddr := fmt.Sprintf("127.0.0.1:%d", receiver.GetAutoBindPort())
+	for i := 0; i < 2; i++ {
+		_, err := sender.WriteTo([]byte("packet"), receiverAddr)
+		require.NoError(t, err)
+		<-receiver.PacketCh()
+
+		require.Eventually(t, func() bool {
+			return testutil.ToFloat64(sender.openConnections) == 0
+		}, 5*time.Second, 10*time.Millisecond)
+	}
+
+	// A new connection is opened after the idle one has been closed.
+	assert.Equal(t, float64(2), testutil.ToFloat64(sender.openedConnections))
+	require.Eventually(t, func() bool {
+		receiver.multiplexedConnsMu.Lock()
+		defer receiver.multiplexedConnsMu.Unlock()
+		return len(receiver.multiplexedConns) == 0
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestMultiplexedTransport_ShouldFallbackToNewConnectionsForLegacyNodes(t *testing.T) {
+	sender, err := NewMultiplexedTransport(newTestMultiplexedTransportConfig(), log.NewNopLogger(), prometheus.NewPedanticRegistry())
+	require.NoError(t, err)
+	defer sender.Shutdown() //nolint:errcheck
+
+	// Emulates a node not supporting multiplexed connections, which closes connections of unknown type.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close() //nolint:errcheck
+
+	packets := make(chan []byte, 10)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close() //nolint:errcheck
+				msgType := []byte{0}
+				if _, err := io.ReadFull(conn, msgType); err != nil || messageType(msgType[0]) != packet {
+					return
+				}
+				data, _ := io.ReadAll(conn)
+				packets <- data
+			}()
+		}
+	}()
+
+	for i := 0; i < 3; i++ {
+		_, err := sender.WriteTo([]byte("packet"), ln.Addr().String())
+		require.NoError(t, err)
+	}
+
+	for i := 0; i < 3; i++ {
+		select {
+		case data := <-packets:
+			assert.Contains(t, string(data), "packet")
+		case <-time.After(5 * time.Second):
+			require.FailNow(t, "packet not received")
+		}
+	}
+
+	assert.Equal(t, float64(3), testutil.ToFloat64(sender.fallbackPackets))
+	assert.Equal(t, float64(0), testutil.ToFloat64(sender.openedConnections))
+	assert.Equal(t, float64(0), testutil.ToFloat64(sender.sentPacketsErrors))
+}
+
+func TestNextLegacyNodeRetry(t *testing.T) {
+	var retries []time.Duration
+	retry := time.Duration(0)
+	for i := 0; i < 9; i++ {
+		retry = nextLegacyNodeRetry(retry)
+		retries = append(retries, retry)
+	}
+
+	expected := []time.Duration{
+		time.Minute, 2 * time.Minute, 4 * time.Minute, 8 * time.Minute, 16 * time.Minute, 32 * time.Minute,
+		time.Hour, time.Hour, time.Hour,
+	}
+	assert.Equal(t, expected, retries)
+}
+
+func TestMultiplexedTransport_KVShouldPropagateUpdates(t *testing.T) {
+	newKV := func(joinMembers []string) *KV {
+		var cfg KVConfig
+		flagext.DefaultValues(&cfg)
+		cfg.TCPTransport = newTestMultiplexedTransportConfig()
+		cfg.Codecs = []codec.Codec{dataCodec{}}
+		cfg.GossipInterval = 100 * time.Millisecond
+		cfg.PushPullInterval = time.Hour // Updates must be propagated by gossip.
+		cfg.JoinMembers = joinMembers
+
+		kv := NewKV(cfg, log.NewNopLogger(), &dnsProviderMock{}, prometheus.NewPedanticRegistry())
+		require.NoError(t, services.StartAndAwaitRunning(context.Background(), kv))
+		t.Cleanup(func() {
+			require.NoError(t, services.StopAndAwaitTerminated(context.Background(), kv))
+		})
+		return kv
+	}
+
+	kv1 := newKV(nil)
+	kv2 := newKV([]string{fmt.Sprintf("127.0.0.1:%d", kv1.GetListeningPort())})
+
+	require.Eventually(t, func() bool {
+		return kv1.memberlist.NumMembers() == 2
+	}, 5*time.Second, 10*time.Millisecond)
+
+	client1, err := NewClient(kv1, dataCodec{})
+	require.NoError(t, err)
+	client2, err := NewClient(kv2, dataCodec{})
+	require.NoError(t, err)
+
+	now := time.Now().Unix()
+	require.NoError(t, client1.CAS(context.Background(), key, func(in interface{}) (out interface{}, retry bool, err error) {
+		d := getOrCreateData(in)
+		d.Members["a"] = member{Timestamp: now, State: ACTIVE}
+		return d, true, nil
+	}))
+
+	require.Eventually(t, func() bool {
+		val, err := client2.Get(context.Background(), key)
+		return err == nil && val != nil && len(val.(*data).Members) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,351 @@
+package memberlist
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log/level"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// SigningKeyTypeHMAC is a shared secret used to compute HMAC-SHA256 signatures.
+	SigningKeyTypeHMAC = "hmac"
+
+	// SigningKeyTypeEd25519 is an ed25519 key pair. Members sign with the private key,
+	// and verify signatures with the public key.
+	SigningKeyTypeEd25519 = "ed25519"
+)
+
+// Reasons for rejecting a received update.
+const (
+	rejectReasonUnsigned         = "unsigned"
+	rejectReasonUnknownKey       = "unknown_key"
+	rejectReasonInvalidSignature = "invalid_signature"
+	rejectReasonForbidden        = "forbidden"
+
+	// Push/pull sync is signed by the sending member, so the keys covered by a write policy are
+	// expected to be rejected from the push/pull sync of members without the roles.
+	rejectReasonForbiddenPushPull = "forbidden_push_pull"
+)
+
+// SigningConfig configures the signing of the messages exchanged by members, and the roles
+// required to update keys.
+type SigningConfig struct {
+	KeyFile         string        `yaml:"key_file" category:"experimental"`
+	TrustedKeysFile string        `yaml:"trusted_keys_file" category:"experimental"`
+	ReloadInterval  time.Duration `yaml:"reload_interval" category:"experimental"`
+	RejectUnsigned  bool          `yaml:"reject_unsigned" category:"experimental"`
+
+	// Write policies can only be set in the YAML config.
+	WritePolicies []WritePolicy `yaml:"write_policies" category:"experimental"`
+}
+
+// RegisterFlagsWithPrefix registers flags.
+func (cfg *SigningConfig) RegisterFlagsWithPrefix(f *flag.FlagSet, prefix string) {
+	f.StringVar(&cfg.KeyFile, prefix+"memberlist.signing.key-file", "", "Path to a YAML file with the key used to sign the messages sent to other members, with fields id, type (hmac or ed25519) and key (base64-encoded HMAC secret or ed25519 private key). If empty, messages are not signed.")
+	f.StringVar(&cfg.TrustedKeysFile, prefix+"memberlist.signing.trusted-keys-file", "", "Path to a YAML file with the keys used to verify the messages received from other members, as a list of keys with fields id, type, key (base64-encoded HMAC secret or ed25519 public key) and roles. If empty, signatures are not verified.")
+	f.DurationVar(&cfg.ReloadInterval, prefix+"memberlist.signing.reload-interval", time.Minute, "How often to reload the key files, to rotate keys. 0 to disable reloading.")
+	f.BoolVar(&cfg.RejectUnsigned, prefix+"memberlist.signing.reject-unsigned", false, "If true, messages which are not signed are rejected. Enable it once all members sign their messages.")
+}
+
+// Validate validates the config. Write policies can't be used with delta push/pull, because the
+// values missing from other members after a delta push/pull are signed by the sending member.
+func (cfg *SigningConfig) Validate(deltaPushPullEnabled bool) error {
+	if (cfg.RejectUnsigned || len(cfg.WritePolicies) > 0) && cfg.TrustedKeysFile == "" {
+		return fmt.Errorf("rejecting unsigned messages and write policies require a trusted keys file")
+	}
+	if len(cfg.WritePolicies) > 0 && deltaPushPullEnabled {
+		return fmt.Errorf("write policies can't be used with delta push/pull")
+	}
+	for _, p := range cfg.WritePolicies {
+		if len(p.Roles) == 0 {
+			return fmt.Errorf("write policy for key prefix %q has no roles", p.KeyPrefix)
+		}
+	}
+	return nil
+}
+
+// WritePolicy restricts the updates of the keys with the given prefix to the messages
+// signed with a trusted key having any of the roles. Gossiped updates and the values changed by
+// a push/pull sync are forwarded with the signature of the original sender, but push/pull sync
+// is signed by the sending member, so the keys are only accepted from the push/pull sync of
+// members having any of the roles.
+type WritePolicy struct {
+	KeyPrefix string   `yaml:"key_prefix"`
+	Roles     []string `yaml:"roles"`
+}
+
+// SigningKey is a key of the key file or trusted keys file.
+type SigningKey struct {
+	ID   string `yaml:"id"`
+	Type string `yaml:"type"`
+
+	// Base64-encoded key: the HMAC secret, or the ed25519 private key in the key file and
+	// public key in the trusted keys file.
+	Key string `yaml:"key"`
+
+	// Roles of the members signing with the key. Only used in the trusted keys file.
+	Roles []string `yaml:"roles"`
+}
+
+type trustedKeys struct {
+	Keys []SigningKey `yaml:"keys"`
+}
+
+// RejectedUpdates describes the updates rejected for the same reason.
+type RejectedUpdates struct {
+	Reason string
+	Count  int
+
+	// Last rejected update.
+	LastTime  time.Time
+	LastKey   string
+	LastKeyID string
+}
+
+type signingKey struct {
+	id      string
+	secret  []byte
+	private ed25519.PrivateKey
+	public  ed25519.PublicKey
+	roles   []string
+}
+
+func parseSigningKey(k SigningKey, private bool) (*signingKey, error) {
+	if k.ID == "" {
+		return nil, fmt.Errorf("key has no id")
+	}
+	data, err := base64.StdEncoding.DecodeString(k.Key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode key %s: %w", k.ID, err)
+	}
+
+	result := &signingKey{id: k.ID, roles: k.Roles}
+	switch {
+	case k.Type == SigningKeyTypeHMAC && len(data) > 0:
+		result.secret = data
+	case k.Type == SigningKeyTypeEd25519 && private && len(data) == ed25519.SeedSize:
+		result.private = ed25519.NewKeyFromSeed(data)
+	case k.Type == SigningKeyTypeEd25519 && private && len(data) == ed25519.PrivateKeySize:
+		result.private = ed25519.PrivateKey(data)
+	case k.Type == SigningKeyTypeEd25519 && !private && len(data) == ed25519.PublicKeySize:
+		result.public = ed25519.PublicKey(data)
+	default:
+		return nil, fmt.Errorf("invalid key %s of type %q with %d bytes", k.ID, k.Type, len(data))
+	}
+	return result, nil
+}
+
+func (k *signingKey) sign(payload []byte) []byte {
+	if k.private != nil {
+		return ed25519.Sign(k.private, payload)
+	}
+	mac := hmac.New(sha256.New, k.secret)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+func (k *signingKey) verify(payload, signature []byte) bool {
+	if k.public != nil {
+		return ed25519.Verify(k.public, payload, signature)
+	}
+	mac := hmac.New(sha256.New, k.secret)
+	mac.Write(payload)
+	return hmac.Equal(mac.Sum(nil), signature)
+}
+
+func (k *signingKey) hasAnyRole(roles []string) bool {
+	for _, r := range roles {
+		for _, kr := range k.roles {
+			if r == kr {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// keyring holds the keys loaded from the key files, which are reloaded periodically to rotate keys.
+type keyring struct {
+	mu      sync.RWMutex
+	signer  *signingKey
+	trusted map[string]*signingKey
+}
+
+// load replaces the keys with the ones read from the files. On error, the current keys are kept.
+func (r *keyring) load(cfg SigningConfig) error {
+	var signer *signingKey
+	if cfg.KeyFile != "" {
+		k := SigningKey{}
+		if err := readYAMLFile(cfg.KeyFile, &k); err != nil {
+			return err
+		}
+		var err error
+		if signer, err = parseSigningKey(k, true); err != nil {
+			return fmt.Errorf("%s: %w", cfg.KeyFile, err)
+		}
+	}
+
+	var trusted map[string]*signingKey
+	if cfg.TrustedKeysFile != "" {
+		keys := trustedKeys{}
+		if err := readYAMLFile(cfg.TrustedKeysFile, &keys); err != nil {
+			return err
+		}
+		trusted = make(map[string]*signingKey, len(keys.Keys))
+		for _, k := range keys.Keys {
+			key, err := parseSigningKey(k, false)
+			if err != nil {
+				return fmt.Errorf("%s: %w", cfg.TrustedKeysFile, err)
+			}
+			trusted[key.id] = key
+		}
+	}
+
+	r.mu.Lock()
+	r.signer, r.trusted = signer, trusted
+	r.mu.Unlock()
+	return nil
+}
+
+func (r *keyring) signingKey() *signingKey {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.signer
+}
+
+// trustedKey returns the trusted key with the given ID, and whether signatures are verified at all.
+func (r *keyring) trustedKey(id string) (*signingKey, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.trusted[id], r.trusted != nil
+}
+
+func readYAMLFile(path string, out interface{}) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := yaml.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return nil
+}
+
+// signatureFieldTag is the protobuf tag of the KeyValuePair signature field, which has number 7
+// and wire type 2 (length-delimited).
+const signatureFieldTag = 7<<3 | 2
+
+// encodeSignatureField returns the signature encoded as the KeyValuePair signature field.
+func encodeSignatureField(signature []byte) []byte {
+	field := binary.AppendUvarint([]byte{signatureFieldTag}, uint64(len(signature)))
+	return append(field, signature...)
+}
+
+// marshalKVPair marshals the KV pair, signing it if a signing key is configured. The signature
+// is computed over the marshalled pair without signature, and appended to it as last field.
+func (m *KV) marshalKVPair(kvPair *KeyValuePair) ([]byte, error) {
+	signer := m.keyring.signingKey()
+	if signer == nil {
+		return kvPair.Marshal()
+	}
+
+	kvPair.KeyId = signer.id
+	kvPair.Signature = nil
+	payload, err := kvPair.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	kvPair.Signature = signer.sign(payload)
+	return append(payload, encodeSignatureField(kvPair.Signature)...), nil
+}
+
+// acceptKVPair verifies the signature of the received KV pair, unmarshalled from data, and the write
+// policy of its key. PushPull is whether the pair has been received by a push/pull sync. Returns false
+// if the pair must be ignored.
+func (m *KV) acceptKVPair(kvPair *KeyValuePair, data []byte, pushPull bool) bool {
+	reason := m.verifyKVPair(kvPair, data)
+	if reason == "" {
+		return true
+	}
+
+	if reason == rejectReasonForbidden && pushPull {
+		// Expected on legitimate traffic, so it's counted separately to keep forbidden updates meaningful.
+		reason = rejectReasonForbiddenPushPull
+		level.Debug(m.logger).Log("msg", "rejected KV pair received by push/pull", "key", kvPair.Key, "key_id", kvPair.KeyId, "reason", reason)
+	} else {
+		level.Warn(m.logger).Log("msg", "rejected received KV pair", "key", kvPair.Key, "key_id", kvPair.KeyId, "reason", reason)
+	}
+	m.rejectedUpdates.WithLabelValues(reason).Inc()
+
+	m.rejectedMu.Lock()
+	defer m.rejectedMu.Unlock()
+	stats := m.rejectedStats[reason]
+	if stats == nil {
+		stats = &RejectedUpdates{Reason: reason}
+		m.rejectedStats[reason] = stats
+	}
+	stats.Count++
+	stats.LastTime = time.Now()
+	stats.LastKey = kvPair.Key
+	stats.LastKeyID = kvPair.KeyId
+	return false
+}
+
+// verifyKVPair returns the reason for rejecting the KV pair, or an empty string if it's accepted.
+// The signature is verified over the received bytes, as re-marshalling the pair may not produce them.
+func (m *KV) verifyKVPair(kvPair *KeyValuePair, data []byte) string {
+	var key *signingKey
+	if kvPair.KeyId == "" {
+		if m.cfg.Signing.RejectUnsigned {
+			return rejectReasonUnsigned
+		}
+	} else if trusted, verify := m.keyring.trustedKey(kvPair.KeyId); verify {
+		if trusted == nil {
+			return rejectReasonUnknownKey
+		}
+
+		// The signature must be the last field, following the signed payload.
+		field := encodeSignatureField(kvPair.Signature)
+		if !bytes.HasSuffix(data, field) || !trusted.verify(data[:len(data)-len(field)], kvPair.Signature) {
+			return rejectReasonInvalidSignature
+		}
+		key = trusted
+	}
+
+	// Pairs without value are digests of delta push/pull, which don't update the key.
+	if len(kvPair.Value) == 0 {
+		return ""
+	}
+	for _, p := range m.cfg.Signing.WritePolicies {
+		if strings.HasPrefix(kvPair.Key, p.KeyPrefix) && (key == nil || !key.hasAnyRole(p.Roles)) {
+			return rejectReasonForbidden
+		}
+	}
+	return ""
+}
+
+// getRejectedUpdates returns the statistics of the rejected updates, sorted by reason.
+func (m *KV) getRejectedUpdates() []RejectedUpdates {
+	m.rejectedMu.Lock()
+	defer m.rejectedMu.Unlock()
+
+	result := make([]RejectedUpdates, 0, len(m.rejectedStats))
+	for _, s := range m.rejectedStats {
+		result = append(result, *s)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Reason < result[j].Reason })
+	return result
+}
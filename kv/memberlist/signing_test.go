@@ -0,0 +1,306 @@
+package memberlist
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/hashicorp/memberlist"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/dskit/flagext"
+	"github.com/grafana/dskit/kv/codec"
+	"github.com/grafana/dskit/services"
+	"github.com/grafana/dskit/test"
+)
+
+func TestKeyring_Load(t *testing.T) {
+	dir := t.TempDir()
+	public, private, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	secret := base64.StdEncoding.EncodeToString([]byte("secret"))
+
+	for name, testData := range map[string]struct {
+		key     string
+		trusted string
+		err     bool
+	}{
+		"hmac": {
+			key:     fmt.Sprintf("id: k1\ntype: hmac\nkey: %s\n", secret),
+			trusted: fmt.Sprintf("keys:\n  - id: k1\n    type: hmac\n    key: %s\n    roles: [ingester]\n", secret),
+		},
+		"ed25519": {
+			key:     fmt.Sprintf("id: k1\ntype: ed25519\nkey: %s\n", base64.StdEncoding.EncodeToString(private.Seed())),
+			trusted: fmt.Sprintf("keys:\n  - id: k1\n    type: ed25519\n    key: %s\n    roles: [ingester]\n", base64.StdEncoding.EncodeToString(public)),
+		},
+		"ed25519 private key as trusted key": {
+			key:     fmt.Sprintf("id: k1\ntype: ed25519\nkey: %s\n", base64.StdEncoding.EncodeToString(private)),
+			trusted: fmt.Sprintf("keys:\n  - id: k1\n    type: ed25519\n    key: %s\n", base64.StdEncoding.EncodeToString(private)),
+			err:     true,
+		},
+		"unknown type": {
+			key: fmt.Sprintf("id: k1\ntype: rsa\nkey: %s\n", secret),
+			err: true,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			cfg := SigningConfig{KeyFile: filepath.Join(dir, "key.yaml")}
+			require.NoError(t, os.WriteFile(cfg.KeyFile, []byte(testData.key), 0o600))
+			if testData.trusted != "" {
+				cfg.TrustedKeysFile = filepath.Join(dir, "trusted.yaml")
+				require.NoError(t, os.WriteFile(cfg.TrustedKeysFile, []byte(testData.trusted), 0o600))
+			}
+
+			r := keyring{}
+			err := r.load(cfg)
+			if testData.err {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			trusted, verify := r.trustedKey("k1")
+			require.True(t, verify)
+			require.NotNil(t, trusted)
+			assert.Equal(t, []string{"ingester"}, trusted.roles)
+
+			signature := r.signingKey().sign([]byte("payload"))
+			assert.True(t, trusted.verify([]byte("payload"), signature))
+			assert.False(t, trusted.verify([]byte("other"), signature))
+		})
+	}
+}
+
+func TestKV_ShouldRejectUnauthorizedUpdates(t *testing.T) {
+	dir := t.TempDir()
+	trustedFile := filepath.Join(dir, "trusted.yaml")
+	require.NoError(t, os.WriteFile(trustedFile, []byte(fmt.Sprintf(`keys:
+  - id: ingester
+    type: hmac
+    key: %s
+    roles: [ingester]
+  - id: querier
+    type: hmac
+    key: %s
+    roles: [querier]
+`, base64.StdEncoding.EncodeToString([]byte("ingester-secret")), base64.StdEncoding.EncodeToString([]byte("querier-secret")))), 0o600))
+
+	cfg := KVConfig{Signing: SigningConfig{
+		TrustedKeysFile: trustedFile,
+		RejectUnsigned:  true,
+		WritePolicies:   []WritePolicy{{KeyPrefix: "partition", Roles: []string{"ingester"}}},
+	}}
+	require.NoError(t, cfg.Signing.Validate(false))
+	require.Error(t, cfg.Signing.Validate(true))
+
+	kv := NewKV(cfg, log.NewNopLogger(), &dnsProviderMock{}, prometheus.NewPedanticRegistry())
+	require.NoError(t, kv.keyring.load(cfg.Signing))
+
+	sign := func(keyID, secret string, pair KeyValuePair) []byte {
+		sender := NewKV(KVConfig{}, log.NewNopLogger(), &dnsProviderMock{}, nil)
+		sender.keyring.signer = &signingKey{id: keyID, secret: []byte(secret)}
+		data, err := sender.marshalKVPair(&pair)
+		require.NoError(t, err)
+		return data
+	}
+	marshal := func(pair KeyValuePair) []byte {
+		data, err := pair.Marshal()
+		require.NoError(t, err)
+		return data
+	}
+
+	for name, testData := range map[string]struct {
+		pair     []byte
+		pushPull bool
+		expected string
+	}{
+		"signed by a role allowed by the policy": {
+			pair: sign("ingester", "ingester-secret", KeyValuePair{Key: "partition-ring", Value: []byte("value")}),
+		},
+		"signed by a role not allowed by the policy": {
+			pair:     sign("querier", "querier-secret", KeyValuePair{Key: "partition-ring", Value: []byte("value")}),
+			expected: rejectReasonForbidden,
+		},
+		"received by push/pull and signed by a role not allowed by the policy": {
+			pair:     sign("querier", "querier-secret", KeyValuePair{Key: "partition-ring", Value: []byte("value")}),
+			pushPull: true,
+			expected: rejectReasonForbiddenPushPull,
+		},
+		"received by push/pull with an invalid signature": {
+			pair:     sign("ingester", "querier-secret", KeyValuePair{Key: "partition-ring", Value: []byte("value")}),
+			pushPull: true,
+			expected: rejectReasonInvalidSignature,
+		},
+		"key without policy": {
+			pair: sign("querier", "querier-secret", KeyValuePair{Key: "ring", Value: []byte("value")}),
+		},
+		"digest without value": {
+			pair: sign("querier", "querier-secret", KeyValuePair{Key: "partition-ring"}),
+		},
+		"unsigned": {
+			pair:     marshal(KeyValuePair{Key: "ring", Value: []byte("value")}),
+			expected: rejectReasonUnsigned,
+		},
+		"unknown key": {
+			pair:     sign("unknown", "ingester-secret", KeyValuePair{Key: "ring", Value: []byte("value")}),
+			expected: rejectReasonUnknownKey,
+		},
+		"invalid signature": {
+			pair:     sign("ingester", "querier-secret", KeyValuePair{Key: "ring", Value: []byte("value")}),
+			expected: rejectReasonInvalidSignature,
+		},
+		"modified after signing": {
+			pair: func() []byte {
+				p := KeyValuePair{}
+				require.NoError(t, p.Unmarshal(sign("ingester", "ingester-secret", KeyValuePair{Key: "ring", Value: []byte("value")})))
+				p.Key = "partition-ring"
+				return marshal(p)
+			}(),
+			expected: rejectReasonInvalidSignature,
+		},
+		"signature not in last field": {
+			pair: func() []byte {
+				// Appends the value field again after the signature, which overrides the signed value.
+				data := sign("ingester", "ingester-secret", KeyValuePair{Key: "ring", Value: []byte("value")})
+				return append(data, marshal(KeyValuePair{Value: []byte("other")})...)
+			}(),
+			expected: rejectReasonInvalidSignature,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			pair := KeyValuePair{}
+			require.NoError(t, pair.Unmarshal(testData.pair))
+
+			before := testutil.ToFloat64(kv.rejectedUpdates.WithLabelValues(testData.expected))
+			assert.Equal(t, testData.expected == "", kv.acceptKVPair(&pair, testData.pair, testData.pushPull))
+			if testData.expected != "" {
+				assert.Equal(t, before+1, testutil.ToFloat64(kv.rejectedUpdates.WithLabelValues(testData.expected)))
+			}
+		})
+	}
+
+	rejected := kv.getRejectedUpdates()
+	require.Len(t, rejected, 5)
+	assert.Equal(t, rejectReasonForbidden, rejected[0].Reason)
+	assert.Equal(t, 1, rejected[0].Count)
+	assert.Equal(t, "partition-ring", rejected[0].LastKey)
+	assert.Equal(t, "querier", rejected[0].LastKeyID)
+	assert.Equal(t, rejectReasonForbiddenPushPull, rejected[1].Reason)
+	assert.Equal(t, 1, rejected[1].Count)
+}
+
+func TestKV_ShouldPropagateSignedUpdates(t *testing.T) {
+	dir := t.TempDir()
+	public, private, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+
+	var cfg KVConfig
+	flagext.DefaultValues(&cfg)
+	cfg.TCPTransport = TCPTransportConfig{
+		BindAddrs: getLocalhostAddrs(),
+		BindPort:  0, // randomize
+	}
+	cfg.Codecs = []codec.Codec{dataCodec{}}
+	// The update may be gossiped before the members know each other, so it's also propagated by push/pull.
+	cfg.GossipInterval = 100 * time.Millisecond
+	cfg.PushPullInterval = time.Second
+	cfg.Signing.KeyFile = filepath.Join(dir, "key.yaml")
+	cfg.Signing.TrustedKeysFile = filepath.Join(dir, "trusted.yaml")
+	cfg.Signing.RejectUnsigned = true
+	cfg.Signing.WritePolicies = []WritePolicy{{KeyPrefix: "data", Roles: []string{"writer"}}}
+	require.NoError(t, os.WriteFile(cfg.Signing.KeyFile, []byte(fmt.Sprintf("id: node\ntype: ed25519\nkey: %s\n", base64.StdEncoding.EncodeToString(private.Seed()))), 0o600))
+	require.NoError(t, os.WriteFile(cfg.Signing.TrustedKeysFile, []byte(fmt.Sprintf("keys:\n  - id: node\n    type: ed25519\n    key: %s\n    roles: [writer]\n", base64.StdEncoding.EncodeToString(public))), 0o600))
+
+	mkv1 := NewKV(cfg, log.NewNopLogger(), &dnsProviderMock{}, prometheus.NewPedanticRegistry())
+	require.NoError(t, services.StartAndAwaitRunning(context.Background(), mkv1))
+	defer services.StopAndAwaitTerminated(context.Background(), mkv1) //nolint:errcheck
+
+	mkv2 := NewKV(cfg, log.NewNopLogger(), &dnsProviderMock{}, prometheus.NewPedanticRegistry())
+	require.NoError(t, services.StartAndAwaitRunning(context.Background(), mkv2))
+	defer services.StopAndAwaitTerminated(context.Background(), mkv2) //nolint:errcheck
+
+	_, err = mkv2.JoinMembers([]string{net.JoinHostPort("127.0.0.1", strconv.Itoa(mkv1.GetListeningPort()))})
+	require.NoError(t, err)
+
+	client1, err := NewClient(mkv1, dataCodec{})
+	require.NoError(t, err)
+	require.NoError(t, client1.CAS(context.Background(), "data", func(in interface{}) (out interface{}, retry bool, err error) {
+		d := getOrCreateData(in)
+		d.Members["a"] = member{Timestamp: time.Now().Unix(), State: ACTIVE}
+		return d, true, nil
+	}))
+
+	require.Eventually(t, func() bool {
+		val, err := mkv2.Get("data", dataCodec{})
+		return err == nil && val != nil
+	}, 10*time.Second, 10*time.Millisecond)
+	assert.Empty(t, mkv2.getRejectedUpdates())
+}
+
+func TestKV_ShouldRelaySignedValuesMergedFromPushPullWithTheOriginalSignature(t *testing.T) {
+	dir := t.TempDir()
+	trustedFile := filepath.Join(dir, "trusted.yaml")
+	require.NoError(t, os.WriteFile(trustedFile, []byte(fmt.Sprintf(`keys:
+  - id: writer
+    type: hmac
+    key: %s
+    roles: [writer]
+  - id: relay
+    type: hmac
+    key: %s
+`, base64.StdEncoding.EncodeToString([]byte("writer-secret")), base64.StdEncoding.EncodeToString([]byte("relay-secret")))), 0o600))
+
+	cfg := KVConfig{
+		Codecs: []codec.Codec{dataCodec{}},
+		Signing: SigningConfig{
+			TrustedKeysFile: trustedFile,
+			RejectUnsigned:  true,
+			WritePolicies:   []WritePolicy{{KeyPrefix: "data", Roles: []string{"writer"}}},
+		},
+	}
+
+	newKV := func(keyID, secret string) *KV {
+		kv := NewKV(cfg, log.NewNopLogger(), &dnsProviderMock{}, prometheus.NewPedanticRegistry())
+		require.NoError(t, kv.keyring.load(cfg.Signing))
+		kv.keyring.signer = &signingKey{id: keyID, secret: []byte(secret)}
+		kv.gossipBroadcasts = &memberlist.TransmitLimitedQueue{NumNodes: func() int { return 1 }, RetransmitMult: 1}
+		kv.delegateReady.Store(true)
+		return kv
+	}
+	writer := newKV("writer", "writer-secret")
+	relay := newKV("relay", "relay-secret")
+	receiver := newKV("receiver", "receiver-secret")
+
+	value, err := dataCodec{}.Encode(&data{Members: map[string]member{"a": {Timestamp: time.Now().Unix(), State: ACTIVE}}})
+	require.NoError(t, err)
+	state := bytes.Buffer{}
+	writer.writeKVPair(&state, KeyValuePair{Key: "data", Value: value, Codec: dataCodec{}.CodecID()})
+
+	// The relay merges the state pushed by the writer, and gossips the change to the receiver.
+	relay.MergeRemoteState(state.Bytes(), false)
+	msgs := relay.gossipBroadcasts.GetBroadcasts(0, math.MaxInt32)
+	require.Len(t, msgs, 1)
+	for _, msg := range msgs {
+		receiver.NotifyMsg(msg)
+	}
+
+	assert.Empty(t, relay.getRejectedUpdates())
+	assert.Empty(t, receiver.getRejectedUpdates())
+	test.Poll(t, 5*time.Second, true, func() interface{} {
+		val, err := receiver.Get("data", dataCodec{})
+		return err == nil && val != nil
+	})
+}
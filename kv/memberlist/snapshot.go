@@ -0,0 +1,132 @@
+package memberlist
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/go-kit/log/level"
+)
+
+// snapshotFileName is the name of the file storing the snapshot of the KV store in the snapshot directory.
+const snapshotFileName = "memberlist-kv.snapshot"
+
+// snapshotMaxAge returns the age after which a snapshot is not loaded anymore. Tombstones are removed
+// from the cluster after LeftIngestersTimeout, so older snapshots may contain entries whose tombstones
+// are gone, and which would be resurrected.
+func (m *KV) snapshotMaxAge() time.Duration {
+	if m.cfg.SnapshotMaxAge > 0 {
+		return m.cfg.SnapshotMaxAge
+	}
+	return m.cfg.LeftIngestersTimeout
+}
+
+// writeSnapshot writes the values of the store to the snapshot file, encoded with their codecs.
+func (m *KV) writeSnapshot() error {
+	store := KeyValueStore{}
+
+	m.storeMu.Lock()
+	for key, val := range m.store {
+		if val.value == nil {
+			continue
+		}
+
+		codec := m.GetCodec(val.CodecID)
+		if codec == nil {
+			level.Error(m.logger).Log("msg", "failed to snapshot value: unknown codec for key", "codec", val.CodecID, "key", key)
+			continue
+		}
+
+		encoded, err := codec.Encode(val.value)
+		if err != nil {
+			level.Error(m.logger).Log("msg", "failed to snapshot value", "key", key, "err", err)
+			continue
+		}
+		store.Pairs = append(store.Pairs, &KeyValuePair{Key: key, Value: encoded, Codec: val.CodecID, Clock: val.clock.entries()})
+	}
+	m.storeMu.Unlock()
+
+	data, err := store.Marshal()
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first, and sync it before renaming, so that a crash never leaves a partial snapshot.
+	path := filepath.Join(m.cfg.SnapshotDir, snapshotFileName)
+	tmp, err := os.OpenFile(path+".tmp", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+
+	m.snapshotKeys.Set(float64(len(store.Pairs)))
+	return nil
+}
+
+// loadSnapshot merges the values of the snapshot file into the store. The modification time of the
+// file is the time of the snapshot, and snapshots older than snapshotMaxAge are ignored.
+func (m *KV) loadSnapshot() error {
+	path := filepath.Join(m.cfg.SnapshotDir, snapshotFileName)
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if maxAge := m.snapshotMaxAge(); maxAge > 0 && time.Since(info.ModTime()) > maxAge {
+		level.Warn(m.logger).Log("msg", "ignoring memberlist KV snapshot because it's too old", "path", path, "snapshot_time", info.ModTime(), "max_age", maxAge)
+		return nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	store := KeyValueStore{}
+	if err := store.Unmarshal(data); err != nil {
+		return fmt.Errorf("failed to parse snapshot %s: %v", path, err)
+	}
+
+	loaded := 0
+	for _, pair := range store.Pairs {
+		codec := m.GetCodec(pair.Codec)
+		if codec == nil {
+			level.Warn(m.logger).Log("msg", "failed to load snapshot value: unknown codec for key", "codec", pair.Codec, "key", pair.Key)
+			continue
+		}
+
+		// Tombstones older than LeftIngestersTimeout are removed while merging.
+		if _, _, _, err := m.mergeBytesValueForKey(pair.Key, pair.Value, codec, pair.Clock, nil); err != nil {
+			level.Warn(m.logger).Log("msg", "failed to load snapshot value", "key", pair.Key, "err", err)
+			continue
+		}
+		loaded++
+	}
+
+	level.Info(m.logger).Log("msg", "loaded memberlist KV snapshot", "path", path, "snapshot_time", info.ModTime(), "keys", loaded)
+	return nil
+}
+
+// snapshot writes the snapshot file, logging failures.
+func (m *KV) snapshot() {
+	if err := m.writeSnapshot(); err != nil {
+		m.snapshotFailures.Inc()
+		level.Warn(m.logger).Log("msg", "failed to write memberlist KV snapshot", "dir", m.cfg.SnapshotDir, "err", err)
+	}
+}
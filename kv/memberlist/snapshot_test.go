@@ -0,0 +1,101 @@
+package memberlist
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/dskit/flagext"
+	"github.com/grafana/dskit/kv/codec"
+	"github.com/grafana/dskit/services"
+)
+
+func TestSnapshot_ShouldRestoreValuesOnRestart(t *testing.T) {
+	var cfg KVConfig
+	flagext.DefaultValues(&cfg)
+	cfg.TCPTransport = TCPTransportConfig{
+		BindAddrs: getLocalhostAddrs(),
+		BindPort:  0, // randomize
+	}
+	cfg.Codecs = []codec.Codec{dataCodec{}}
+	cfg.SnapshotDir = filepath.Join(t.TempDir(), "snapshot")
+
+	now := time.Now().Unix()
+
+	mkv1 := NewKV(cfg, log.NewNopLogger(), &dnsProviderMock{}, prometheus.NewPedanticRegistry())
+	require.NoError(t, services.StartAndAwaitRunning(context.Background(), mkv1))
+
+	client1, err := NewClient(mkv1, dataCodec{})
+	require.NoError(t, err)
+	require.NoError(t, client1.CAS(context.Background(), key, func(in interface{}) (out interface{}, retry bool, err error) {
+		d := getOrCreateData(in)
+		d.Members["a"] = member{Timestamp: now, State: ACTIVE, Tokens: []uint32{1, 2}}
+		return d, true, nil
+	}))
+
+	// The snapshot is written on shutdown.
+	require.NoError(t, services.StopAndAwaitTerminated(context.Background(), mkv1))
+	require.FileExists(t, filepath.Join(cfg.SnapshotDir, snapshotFileName))
+
+	mkv2 := NewKV(cfg, log.NewNopLogger(), &dnsProviderMock{}, prometheus.NewPedanticRegistry())
+	require.NoError(t, services.StartAndAwaitRunning(context.Background(), mkv2))
+	defer services.StopAndAwaitTerminated(context.Background(), mkv2) //nolint:errcheck
+
+	client2, err := NewClient(mkv2, dataCodec{})
+	require.NoError(t, err)
+	assert.Equal(t, &data{Members: map[string]member{
+		"a": {Timestamp: now, State: ACTIVE, Tokens: []uint32{1, 2}},
+	}}, getData(t, client2, key))
+}
+
+func TestSnapshot_ShouldAgeStaleEntries(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+
+	// Tombstones are not removed when writing the snapshot.
+	writer := NewKV(KVConfig{SnapshotDir: dir, Codecs: []codec.Codec{dataCodec{}}}, log.NewNopLogger(), &dnsProviderMock{}, nil)
+	_, _, _, err := writer.mergeValueForKey(key, &data{Members: map[string]member{
+		"active":        {Timestamp: now.Unix(), State: ACTIVE},
+		"old-tombstone": {Timestamp: now.Add(-2 * time.Hour).Unix(), State: LEFT},
+		"new-tombstone": {Timestamp: now.Unix(), State: LEFT},
+	}}, false, 0, dataCodec{}, clockUpdate{})
+	require.NoError(t, err)
+	require.NoError(t, writer.writeSnapshot())
+
+	load := func(cfg KVConfig) *data {
+		cfg.SnapshotDir = dir
+		cfg.Codecs = []codec.Codec{dataCodec{}}
+		reader := NewKV(cfg, log.NewNopLogger(), &dnsProviderMock{}, nil)
+		require.NoError(t, reader.loadSnapshot())
+
+		val, _, err := reader.get(key, dataCodec{})
+		require.NoError(t, err)
+		if val == nil {
+			return nil
+		}
+		return val.(*data)
+	}
+
+	// Tombstones older than the left ingesters timeout are removed.
+	d := load(KVConfig{LeftIngestersTimeout: time.Hour})
+	require.NotNil(t, d)
+	assert.Contains(t, d.Members, "active")
+	assert.Contains(t, d.Members, "new-tombstone")
+	assert.NotContains(t, d.Members, "old-tombstone")
+
+	// Snapshots older than the left ingesters timeout are ignored, as the cluster may have
+	// removed tombstones of their entries.
+	path := filepath.Join(dir, snapshotFileName)
+	require.NoError(t, os.Chtimes(path, now.Add(-90*time.Minute), now.Add(-90*time.Minute)))
+	assert.Nil(t, load(KVConfig{LeftIngestersTimeout: time.Hour}))
+
+	// Unless a longer max age is configured.
+	assert.NotNil(t, load(KVConfig{LeftIngestersTimeout: time.Hour, SnapshotMaxAge: 2 * time.Hour}))
+}
@@ -31,6 +31,7 @@ const (
 	_ messageType = iota // don't use 0
 	packet
 	stream
+	multiplexed // persistent connection carrying multiple packets, see MultiplexedTransport
 )
 
 const zeroZeroZeroZero = "0.0.0.0"
@@ -57,6 +58,11 @@ type TCPTransportConfig struct {
 	// Timeout for acquiring one of the concurrent write slots.
 	AcquireWriterTimeout time.Duration `yaml:"acquire_writer_timeout" category:"advanced"`
 
+	// Send packets over persistent connections. See MultiplexedTransport.
+	MultiplexedConnectionsEnabled bool          `yaml:"multiplexed_connections_enabled" category:"experimental"`
+	MultiplexedConnectionIdleTime time.Duration `yaml:"multiplexed_connection_idle_timeout" category:"experimental"`
+	MultiplexedQueueSize          int           `yaml:"multiplexed_queue_size" category:"experimental"`
+
 	// Transport logs lots of messages at debug level, so it deserves an extra flag for turning it on
 	TransportDebug bool `yaml:"-" category:"advanced"`
 
@@ -80,6 +86,9 @@ func (cfg *TCPTransportConfig) RegisterFlagsWithPrefix(f *flag.FlagSet, prefix s
 	f.DurationVar(&cfg.PacketWriteTimeout, prefix+"memberlist.packet-write-timeout", 5*time.Second, "Timeout for writing 'packet' data.")
 	f.IntVar(&cfg.MaxConcurrentWrites, prefix+"memberlist.max-concurrent-writes", 3, "Maximum number of concurrent writes to other nodes.")
 	f.DurationVar(&cfg.AcquireWriterTimeout, prefix+"memberlist.acquire-writer-timeout", 250*time.Millisecond, "Timeout for acquiring one of the concurrent write slots. After this time, the message will be dropped.")
+	f.BoolVar(&cfg.MultiplexedConnectionsEnabled, prefix+"memberlist.multiplexed-connections-enabled", false, "If true, packets are sent to each node over a persistent connection, instead of opening a new connection for each packet. Packets to nodes not supporting persistent connections are sent over new connections. Streams used by push/pull sync always use new connections. During a rolling upgrade, nodes not supporting persistent connections log an \"unknown message type\" error each time a node tries to open one, which is retried with exponential backoff from 1m up to 1h.")
+	f.DurationVar(&cfg.MultiplexedConnectionIdleTime, prefix+"memberlist.multiplexed-connection-idle-timeout", 5*time.Minute, "Persistent connections to nodes which haven't been sent any packet for this long are closed.")
+	f.IntVar(&cfg.MultiplexedQueueSize, prefix+"memberlist.multiplexed-queue-size", 128, "Maximum number of packets waiting to be sent to each node over a persistent connection. Further packets are dropped.")
 	f.BoolVar(&cfg.TransportDebug, prefix+"memberlist.transport-debug", false, "Log debug transport messages. Note: global log.level must be at debug level as well.")
 
 	f.BoolVar(&cfg.TLSEnabled, prefix+"memberlist.tls-enabled", false, "Enable TLS on the memberlist transport layer.")
@@ -112,6 +121,10 @@ type TCPTransport struct {
 	advertiseMu   sync.RWMutex
 	advertiseAddr string
 
+	// Incoming multiplexed connections, closed on shutdown.
+	multiplexedConnsMu sync.Mutex
+	multiplexedConns   map[net.Conn]struct{}
+
 	// metrics
 	incomingStreams      prometheus.Counter
 	outgoingStreams      prometheus.Counter
@@ -146,6 +159,8 @@ func NewTCPTransport(config TCPTransportConfig, logger log.Logger, registerer pr
 		packetCh: make(chan *memberlist.Packet),
 		connCh:   make(chan net.Conn),
 		writeCh:  make(chan writeRequest),
+
+		multiplexedConns: map[net.Conn]struct{}{},
 	}
 
 	for i := 0; i < concurrentWrites; i++ {
@@ -347,6 +362,8 @@ func (t *TCPTransport) handleConnection(conn net.Conn) {
 			From:      addr(addrBuf),
 			Timestamp: time.Now(),
 		}
+	} else if messageType(msgType[0]) == multiplexed {
+		t.handleMultiplexedConnection(conn)
 	} else {
 		t.unknownConnections.Inc()
 		level.Error(t.logger).Log("msg", "unknown message type", "msgType", msgType, "remote", conn.RemoteAddr())
@@ -628,6 +645,11 @@ func (t *TCPTransport) Shutdown() error {
 	for _, conn := range t.tcpListeners {
 		_ = conn.Close()
 	}
+	t.multiplexedConnsMu.Lock()
+	for conn := range t.multiplexedConns {
+		_ = conn.Close()
+	}
+	t.multiplexedConnsMu.Unlock()
 
 	// Wait until all write workers have finished.
 	t.writeWG.Wait()
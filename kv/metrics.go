@@ -99,6 +99,12 @@ func (m metrics) CAS(ctx context.Context, key string, f func(in interface{}) (ou
 	})
 }
 
+func (m metrics) CASMulti(ctx context.Context, keys []string, f func(in []interface{}) (out []interface{}, retry bool, err error)) error {
+	return instrument.CollectedRequest(ctx, "CASMulti", m.requestDuration, getCasErrorCode, func(ctx context.Context) error {
+		return CASMulti(ctx, m.c, keys, f)
+	})
+}
+
 func (m metrics) WatchKey(ctx context.Context, key string, f func(interface{}) bool) {
 	_ = instrument.CollectedRequest(ctx, "WatchKey", m.requestDuration, instrument.ErrorCode, func(ctx context.Context) error {
 		m.c.WatchKey(ctx, key, f)
@@ -33,6 +33,10 @@ func (m mockClient) CAS(_ context.Context, _ string, _ func(in interface{}) (out
 	return nil
 }
 
+func (m mockClient) CASMulti(_ context.Context, _ []string, _ func(in []interface{}) (out []interface{}, retry bool, err error)) error {
+	return nil
+}
+
 func (m mockClient) WatchKey(_ context.Context, _ string, _ func(interface{}) bool) {
 }
 
@@ -48,6 +52,7 @@ type MockCountingClient struct {
 	GetCalls         *atomic.Uint32
 	DeleteCalls      *atomic.Uint32
 	CASCalls         *atomic.Uint32
+	CASMultiCalls    *atomic.Uint32
 	WatchKeyCalls    *atomic.Uint32
 	WatchPrefixCalls *atomic.Uint32
 }
@@ -59,6 +64,7 @@ func NewMockCountingClient(client Client) *MockCountingClient {
 		GetCalls:         atomic.NewUint32(0),
 		DeleteCalls:      atomic.NewUint32(0),
 		CASCalls:         atomic.NewUint32(0),
+		CASMultiCalls:    atomic.NewUint32(0),
 		WatchKeyCalls:    atomic.NewUint32(0),
 		WatchPrefixCalls: atomic.NewUint32(0),
 	}
@@ -87,6 +93,12 @@ func (mc *MockCountingClient) CAS(ctx context.Context, key string, f func(in int
 	return mc.client.CAS(ctx, key, f)
 }
 
+func (mc *MockCountingClient) CASMulti(ctx context.Context, keys []string, f func(in []interface{}) (out []interface{}, retry bool, err error)) error {
+	mc.CASMultiCalls.Inc()
+
+	return CASMulti(ctx, mc.client, keys, f)
+}
+
 func (mc *MockCountingClient) WatchKey(ctx context.Context, key string, f func(interface{}) bool) {
 	mc.WatchKeyCalls.Inc()
 
@@ -1,9 +1,12 @@
 package kv
 
 import (
+	"bytes"
 	"context"
 	"flag"
 	"fmt"
+	"reflect"
+	"sort"
 	"sync"
 	"time"
 
@@ -12,6 +15,8 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	"go.uber.org/atomic"
+
+	"github.com/grafana/dskit/kv/codec"
 )
 
 // MultiConfig is a configuration for MultiClient.
@@ -22,6 +27,9 @@ type MultiConfig struct {
 	MirrorEnabled bool          `yaml:"mirror_enabled" category:"advanced"`
 	MirrorTimeout time.Duration `yaml:"mirror_timeout" category:"advanced"`
 
+	VerifyInterval  time.Duration `yaml:"verify_interval" category:"advanced"`
+	BackfillEnabled bool          `yaml:"backfill_enabled" category:"advanced"`
+
 	// ConfigProvider returns channel with MultiRuntimeConfig updates.
 	ConfigProvider func() <-chan MultiRuntimeConfig `yaml:"-"`
 }
@@ -32,6 +40,8 @@ func (cfg *MultiConfig) RegisterFlagsWithPrefix(f *flag.FlagSet, prefix string)
 	f.StringVar(&cfg.Secondary, prefix+"multi.secondary", "", "Secondary backend storage used by multi-client.")
 	f.BoolVar(&cfg.MirrorEnabled, prefix+"multi.mirror-enabled", false, "Mirror writes to secondary store.")
 	f.DurationVar(&cfg.MirrorTimeout, prefix+"multi.mirror-timeout", 2*time.Second, "Timeout for storing value to secondary store.")
+	f.DurationVar(&cfg.VerifyInterval, prefix+"multi.verify-interval", 0, "How often to verify that the values in the secondary store match the primary store, before switching the primary store. 0 to disable.")
+	f.BoolVar(&cfg.BackfillEnabled, prefix+"multi.backfill-enabled", false, "Write the values of the primary store to the secondary store when the verification finds them diverging.")
 }
 
 // MultiRuntimeConfig has values that can change in runtime (via overrides)
@@ -42,6 +52,21 @@ type MultiRuntimeConfig struct {
 
 	// Mirroring enabled or not. Nil = no change.
 	Mirroring *bool `yaml:"mirror_enabled"`
+
+	// Backfilling of the diverging values found by the verification enabled or not. Nil = no change.
+	Backfill *bool `yaml:"backfill_enabled"`
+
+	// VerifyTrigger triggers a one-shot verification of the secondary stores, backfilling the diverging
+	// values if backfilling is enabled, every time it's set to a new non-empty value. Empty = no verification.
+	VerifyTrigger string `yaml:"verify_trigger"`
+}
+
+// MultiComparable can be implemented by the values stored in the KV store to customize how the
+// MultiClient verification compares the values of the primary and the secondary stores. Other
+// values match if they're encoded the same by the codec.
+type MultiComparable interface {
+	// MultiEqual returns whether the value of the secondary store matches this value of the primary store.
+	MultiEqual(secondary interface{}) bool
 }
 
 type kvclient struct {
@@ -62,6 +87,11 @@ type MultiClient struct {
 
 	mirrorTimeout    time.Duration
 	mirroringEnabled *atomic.Bool
+	backfillEnabled  *atomic.Bool
+
+	// prefix of the keys compared by the verification, and codec used to compare them.
+	prefix string
+	codec  codec.Codec
 
 	// logger with "multikv" component
 	logger log.Logger
@@ -82,11 +112,24 @@ type MultiClient struct {
 	mirrorEnabledGauge    prometheus.Gauge
 	mirrorWritesCounter   prometheus.Counter
 	mirrorFailuresCounter prometheus.Counter
+
+	verifyRunsCounter       prometheus.Counter
+	verifyFailuresCounter   prometheus.Counter
+	verifyDivergentKeys     *prometheus.GaugeVec
+	backfillWritesCounter   prometheus.Counter
+	backfillFailuresCounter prometheus.Counter
 }
 
 // NewMultiClient creates new MultiClient with given KV Clients.
 // First client in the slice is the primary client.
 func NewMultiClient(cfg MultiConfig, clients []kvclient, logger log.Logger, registerer prometheus.Registerer) *MultiClient {
+	return newMultiClient(cfg, clients, "", nil, logger, registerer)
+}
+
+// newMultiClient creates new MultiClient whose verification compares the keys with the prefix,
+// using the codec. If codec is nil, values which don't implement MultiComparable are compared
+// with reflect.DeepEqual.
+func newMultiClient(cfg MultiConfig, clients []kvclient, prefix string, codec codec.Codec, logger log.Logger, registerer prometheus.Registerer) *MultiClient {
 	c := &MultiClient{
 		clients:    clients,
 		primaryID:  atomic.NewInt32(0),
@@ -94,6 +137,10 @@ func NewMultiClient(cfg MultiConfig, clients []kvclient, logger log.Logger, regi
 
 		mirrorTimeout:    cfg.MirrorTimeout,
 		mirroringEnabled: atomic.NewBool(cfg.MirrorEnabled),
+		backfillEnabled:  atomic.NewBool(cfg.BackfillEnabled),
+
+		prefix: prefix,
+		codec:  codec,
 
 		logger: log.With(logger, "component", "multikv"),
 	}
@@ -108,11 +155,18 @@ func NewMultiClient(cfg MultiConfig, clients []kvclient, logger log.Logger, regi
 	if cfg.ConfigProvider != nil {
 		go c.watchConfigChannel(ctx, cfg.ConfigProvider())
 	}
+	if cfg.VerifyInterval > 0 {
+		go c.runVerification(ctx, cfg.VerifyInterval)
+	}
 
 	return c
 }
 
 func (m *MultiClient) watchConfigChannel(ctx context.Context, configChannel <-chan MultiRuntimeConfig) {
+	// The last verify trigger, to run the verification only once for each trigger value, because
+	// the same runtime config may be received multiple times.
+	lastVerifyTrigger := ""
+
 	for {
 		select {
 		case cfg, ok := <-configChannel:
@@ -129,6 +183,14 @@ func (m *MultiClient) watchConfigChannel(ctx context.Context, configChannel <-ch
 				m.updateMirrorEnabledGauge()
 			}
 
+			if cfg.Backfill != nil {
+				enabled := *cfg.Backfill
+				old := m.backfillEnabled.Swap(enabled)
+				if old != enabled {
+					level.Info(m.logger).Log("msg", "toggled backfilling", "enabled", enabled)
+				}
+			}
+
 			if cfg.PrimaryStore != "" {
 				switched, err := m.setNewPrimaryClient(cfg.PrimaryStore)
 				if switched {
@@ -139,6 +201,14 @@ func (m *MultiClient) watchConfigChannel(ctx context.Context, configChannel <-ch
 				}
 			}
 
+			if cfg.VerifyTrigger != "" && cfg.VerifyTrigger != lastVerifyTrigger {
+				lastVerifyTrigger = cfg.VerifyTrigger
+				level.Info(m.logger).Log("msg", "triggered verification of secondary stores", "trigger", cfg.VerifyTrigger)
+
+				// The verification may take a while, so it runs in the background to not delay config updates.
+				go m.verifyAndLog(ctx)
+			}
+
 		case <-ctx.Done():
 			return
 		}
@@ -203,6 +273,31 @@ func (m *MultiClient) registerMetrics(registerer prometheus.Registerer) {
 		Name: "multikv_mirror_write_errors_total",
 		Help: "Number of failures to mirror-write to secondary store",
 	})
+
+	m.verifyRunsCounter = promauto.With(registerer).NewCounter(prometheus.CounterOpts{
+		Name: "multikv_verify_runs_total",
+		Help: "Number of verifications of the secondary stores",
+	})
+
+	m.verifyFailuresCounter = promauto.With(registerer).NewCounter(prometheus.CounterOpts{
+		Name: "multikv_verify_failures_total",
+		Help: "Number of verifications of the secondary stores which failed",
+	})
+
+	m.verifyDivergentKeys = promauto.With(registerer).NewGaugeVec(prometheus.GaugeOpts{
+		Name: "multikv_verify_divergent_keys",
+		Help: "Number of keys whose value in the secondary store didn't match the primary store in the last verification",
+	}, []string{"store"})
+
+	m.backfillWritesCounter = promauto.With(registerer).NewCounter(prometheus.CounterOpts{
+		Name: "multikv_backfill_writes_total",
+		Help: "Number of backfill writes to secondary store",
+	})
+
+	m.backfillFailuresCounter = promauto.With(registerer).NewCounter(prometheus.CounterOpts{
+		Name: "multikv_backfill_write_errors_total",
+		Help: "Number of failures to backfill-write to secondary store",
+	})
 }
 
 func (m *MultiClient) updatePrimaryStoreGauge() {
@@ -320,6 +415,30 @@ func (m *MultiClient) CAS(ctx context.Context, key string, f func(in interface{}
 	return err
 }
 
+// CASMulti is a part of kv.TxnClient interface. Returns ErrTxnNotSupported if the primary
+// store doesn't support it. When mirroring is enabled, the updated values are written to the
+// secondary stores one key at a time.
+func (m *MultiClient) CASMulti(ctx context.Context, keys []string, f func(in []interface{}) (out []interface{}, retry bool, err error)) error {
+	_, kv := m.getPrimaryClient()
+
+	updatedValues := []interface{}(nil)
+	err := CASMulti(ctx, kv.client, keys, func(in []interface{}) ([]interface{}, bool, error) {
+		out, retry, err := f(in)
+		updatedValues = out
+		return out, retry, err
+	})
+
+	if err == nil && m.mirroringEnabled.Load() {
+		for i, value := range updatedValues {
+			if value != nil && i < len(keys) {
+				m.writeToSecondary(ctx, kv, keys[i], value)
+			}
+		}
+	}
+
+	return err
+}
+
 // WatchKey is a part of kv.Client interface.
 func (m *MultiClient) WatchKey(ctx context.Context, key string, f func(interface{}) bool) {
 	_ = m.runWithPrimaryClient(ctx, func(newCtx context.Context, primary kvclient) error {
@@ -363,3 +482,169 @@ func (m *MultiClient) writeToSecondary(ctx context.Context, primary kvclient, ke
 		}
 	}
 }
+
+func (m *MultiClient) runVerification(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			m.verifyAndLog(ctx)
+
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// verifyAndLog runs a verification of the secondary stores, backfilling the diverging values if
+// backfilling is enabled, and logs the outcome.
+func (m *MultiClient) verifyAndLog(ctx context.Context) {
+	divergent, err := m.verify(ctx, m.backfillEnabled.Load())
+	if err != nil {
+		level.Warn(m.logger).Log("msg", "failed to verify secondary stores", "err", err)
+		return
+	}
+	for store, keys := range divergent {
+		level.Warn(m.logger).Log("msg", "values in secondary store don't match primary store", "secondary", store, "keys", fmt.Sprint(keys))
+	}
+}
+
+// Verify compares the values of the primary store with each secondary store. Returns the keys
+// whose values don't match, by secondary store.
+func (m *MultiClient) Verify(ctx context.Context) (map[string][]string, error) {
+	return m.verify(ctx, false)
+}
+
+// Backfill compares the values of the primary store with each secondary store, and writes the
+// values of the primary store to the secondary stores where they don't match. Keys which only
+// exist in a secondary store are deleted. Returns the backfilled keys, by secondary store.
+func (m *MultiClient) Backfill(ctx context.Context) (map[string][]string, error) {
+	return m.verify(ctx, true)
+}
+
+func (m *MultiClient) verify(ctx context.Context, backfill bool) (map[string][]string, error) {
+	m.verifyRunsCounter.Inc()
+	divergent, err := m.compareStores(ctx, backfill)
+	if err != nil {
+		m.verifyFailuresCounter.Inc()
+	}
+	return divergent, err
+}
+
+func (m *MultiClient) compareStores(ctx context.Context, backfill bool) (map[string][]string, error) {
+	_, primary := m.getPrimaryClient()
+
+	primaryKeys, err := primary.client.List(ctx, m.prefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list keys of primary store %s: %w", primary.name, err)
+	}
+
+	// The values of the primary store are read once, and shared by all the secondary stores.
+	primaryValues := map[string]interface{}{}
+	getPrimaryValue := func(key string) (interface{}, error) {
+		if value, ok := primaryValues[key]; ok {
+			return value, nil
+		}
+		value, err := primary.client.Get(ctx, key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get key %s from primary store %s: %w", key, primary.name, err)
+		}
+		primaryValues[key] = value
+		return value, nil
+	}
+
+	divergent := map[string][]string{}
+	for _, secondary := range m.clients {
+		if secondary == primary {
+			continue
+		}
+
+		secondaryKeys, err := secondary.client.List(ctx, m.prefix)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list keys of secondary store %s: %w", secondary.name, err)
+		}
+
+		keys := make(map[string]struct{}, len(primaryKeys)+len(secondaryKeys))
+		for _, key := range primaryKeys {
+			keys[key] = struct{}{}
+		}
+		for _, key := range secondaryKeys {
+			keys[key] = struct{}{}
+		}
+
+		var diverging []string
+		for key := range keys {
+			primaryValue, err := getPrimaryValue(key)
+			if err != nil {
+				return nil, err
+			}
+			secondaryValue, err := secondary.client.Get(ctx, key)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get key %s from secondary store %s: %w", key, secondary.name, err)
+			}
+
+			if m.valuesMatch(primaryValue, secondaryValue) {
+				continue
+			}
+			diverging = append(diverging, key)
+
+			if backfill {
+				m.backfillKey(ctx, primary, secondary, key, primaryValue)
+			}
+		}
+
+		sort.Strings(diverging)
+		m.verifyDivergentKeys.WithLabelValues(secondary.name).Set(float64(len(diverging)))
+		if len(diverging) > 0 {
+			divergent[secondary.name] = diverging
+		}
+	}
+
+	return divergent, nil
+}
+
+// valuesMatch returns whether the value of the secondary store matches the value of the primary store.
+func (m *MultiClient) valuesMatch(primary, secondary interface{}) bool {
+	if primary == nil || secondary == nil {
+		return primary == nil && secondary == nil
+	}
+	if c, ok := primary.(MultiComparable); ok {
+		return c.MultiEqual(secondary)
+	}
+	if m.codec == nil {
+		return reflect.DeepEqual(primary, secondary)
+	}
+
+	primaryBytes, err := m.codec.Encode(primary)
+	if err != nil {
+		return false
+	}
+	secondaryBytes, err := m.codec.Encode(secondary)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(primaryBytes, secondaryBytes)
+}
+
+func (m *MultiClient) backfillKey(ctx context.Context, primary, secondary kvclient, key string, value interface{}) {
+	m.backfillWritesCounter.Inc()
+
+	var err error
+	if value == nil {
+		err = secondary.client.Delete(ctx, key)
+	} else {
+		err = secondary.client.CAS(ctx, key, func(interface{}) (out interface{}, retry bool, err error) {
+			// try once
+			return value, false, nil
+		})
+	}
+
+	if err != nil {
+		m.backfillFailuresCounter.Inc()
+		level.Warn(m.logger).Log("msg", "failed to backfill value in secondary store", "key", key, "err", err, "primary", primary.name, "secondary", secondary.name)
+	} else {
+		level.Info(m.logger).Log("msg", "backfilled value in secondary store", "key", key, "primary", primary.name, "secondary", secondary.name)
+	}
+}
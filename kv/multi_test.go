@@ -1,11 +1,20 @@
 package kv
 
 import (
+	"context"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"gopkg.in/yaml.v2"
+
+	"github.com/grafana/dskit/kv/codec"
+	"github.com/grafana/dskit/kv/consul"
 )
 
 func boolPtr(b bool) *bool {
@@ -48,3 +57,172 @@ func TestNewMulti(t *testing.T) {
 	t.Log("canceling MultiClient")
 	mc.cancel()
 }
+
+func TestMultiClient_VerifyAndBackfill(t *testing.T) {
+	ctx := context.Background()
+	primary := newMultiTestStore(t, codec.String{})
+	secondary := newMultiTestStore(t, codec.String{})
+
+	set := func(client Client, key, value string) {
+		require.NoError(t, client.CAS(ctx, key, func(interface{}) (interface{}, bool, error) {
+			return value, false, nil
+		}))
+	}
+	set(primary, "prefix/same", "value")
+	set(secondary, "prefix/same", "value")
+	set(primary, "prefix/different", "primary")
+	set(secondary, "prefix/different", "secondary")
+	set(primary, "prefix/missing", "value")
+	set(secondary, "prefix/extra", "value")
+	set(primary, "ignored", "primary")
+
+	reg := prometheus.NewPedanticRegistry()
+	clients := []kvclient{{client: primary, name: "primary"}, {client: secondary, name: "secondary"}}
+	mc := newMultiClient(MultiConfig{}, clients, "prefix/", codec.String{}, log.NewNopLogger(), reg)
+	t.Cleanup(mc.cancel)
+
+	expected := map[string][]string{"secondary": {"prefix/different", "prefix/extra", "prefix/missing"}}
+	divergent, err := mc.Verify(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, expected, divergent)
+
+	// The verification shouldn't change the secondary store.
+	value, err := secondary.Get(ctx, "prefix/different")
+	require.NoError(t, err)
+	assert.Equal(t, "secondary", value)
+
+	backfilled, err := mc.Backfill(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, expected, backfilled)
+
+	divergent, err = mc.Verify(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, divergent)
+
+	keys, err := secondary.List(ctx, "")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"prefix/same", "prefix/different", "prefix/missing"}, keys)
+
+	require.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(`
+		# HELP multikv_verify_runs_total Number of verifications of the secondary stores
+		# TYPE multikv_verify_runs_total counter
+		multikv_verify_runs_total 3
+		# HELP multikv_verify_divergent_keys Number of keys whose value in the secondary store didn't match the primary store in the last verification
+		# TYPE multikv_verify_divergent_keys gauge
+		multikv_verify_divergent_keys{store="secondary"} 0
+		# HELP multikv_backfill_writes_total Number of backfill writes to secondary store
+		# TYPE multikv_backfill_writes_total counter
+		multikv_backfill_writes_total 3
+		# HELP multikv_backfill_write_errors_total Number of failures to backfill-write to secondary store
+		# TYPE multikv_backfill_write_errors_total counter
+		multikv_backfill_write_errors_total 0
+	`), "multikv_verify_runs_total", "multikv_verify_divergent_keys", "multikv_backfill_writes_total", "multikv_backfill_write_errors_total"))
+}
+
+func TestMultiClient_VerifyShouldUseMultiComparableValues(t *testing.T) {
+	ctx := context.Background()
+	primary := newMultiTestStore(t, testComparableCodec{})
+	secondary := newMultiTestStore(t, testComparableCodec{})
+
+	for client, value := range map[Client]string{primary: "value", secondary: "VALUE"} {
+		require.NoError(t, client.CAS(ctx, "key", func(interface{}) (interface{}, bool, error) {
+			return testComparable(value), false, nil
+		}))
+	}
+
+	clients := []kvclient{{client: primary, name: "primary"}, {client: secondary, name: "secondary"}}
+	mc := newMultiClient(MultiConfig{}, clients, "", testComparableCodec{}, log.NewNopLogger(), nil)
+	t.Cleanup(mc.cancel)
+
+	divergent, err := mc.Verify(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, divergent)
+}
+
+func TestMultiClient_ShouldBackfillPeriodicallyWhenEnabledInRuntimeConfig(t *testing.T) {
+	ctx := context.Background()
+	primary := newMultiTestStore(t, codec.String{})
+	secondary := newMultiTestStore(t, codec.String{})
+	require.NoError(t, primary.CAS(ctx, "key", func(interface{}) (interface{}, bool, error) {
+		return "value", false, nil
+	}))
+
+	configs := make(chan MultiRuntimeConfig, 1)
+	cfg := MultiConfig{
+		VerifyInterval: 10 * time.Millisecond,
+		ConfigProvider: func() <-chan MultiRuntimeConfig { return configs },
+	}
+	clients := []kvclient{{client: primary, name: "primary"}, {client: secondary, name: "secondary"}}
+	mc := newMultiClient(cfg, clients, "", codec.String{}, log.NewNopLogger(), nil)
+	t.Cleanup(mc.cancel)
+
+	// The verification alone shouldn't backfill.
+	time.Sleep(100 * time.Millisecond)
+	value, err := secondary.Get(ctx, "key")
+	require.NoError(t, err)
+	require.Nil(t, value)
+
+	configs <- MultiRuntimeConfig{Backfill: boolPtr(true)}
+	require.Eventually(t, func() bool {
+		value, err := secondary.Get(ctx, "key")
+		return err == nil && value == "value"
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestMultiClient_ShouldVerifyOnceForEachVerifyTriggerInRuntimeConfig(t *testing.T) {
+	ctx := context.Background()
+	primary := newMultiTestStore(t, codec.String{})
+	secondary := newMultiTestStore(t, codec.String{})
+	require.NoError(t, primary.CAS(ctx, "key", func(interface{}) (interface{}, bool, error) {
+		return "value", false, nil
+	}))
+
+	// The periodic verification is disabled, so it's only run when triggered via the runtime config.
+	configs := make(chan MultiRuntimeConfig)
+	cfg := MultiConfig{ConfigProvider: func() <-chan MultiRuntimeConfig { return configs }}
+	clients := []kvclient{{client: primary, name: "primary"}, {client: secondary, name: "secondary"}}
+	mc := newMultiClient(cfg, clients, "", codec.String{}, log.NewNopLogger(), nil)
+	t.Cleanup(mc.cancel)
+
+	configs <- MultiRuntimeConfig{VerifyTrigger: "1"}
+	require.Eventually(t, func() bool {
+		return testutil.ToFloat64(mc.verifyRunsCounter) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, float64(1), testutil.ToFloat64(mc.verifyDivergentKeys.WithLabelValues("secondary")))
+
+	// The same trigger received again shouldn't run the verification again.
+	configs <- MultiRuntimeConfig{VerifyTrigger: "1"}
+	configs <- MultiRuntimeConfig{}
+
+	// A new trigger should run the verification again, backfilling the secondary store if enabled.
+	configs <- MultiRuntimeConfig{VerifyTrigger: "2", Backfill: boolPtr(true)}
+	require.Eventually(t, func() bool {
+		value, err := secondary.Get(ctx, "key")
+		return err == nil && value == "value"
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, float64(2), testutil.ToFloat64(mc.verifyRunsCounter))
+}
+
+func newMultiTestStore(t *testing.T, c codec.Codec) Client {
+	store, closer := consul.NewInMemoryClient(c, log.NewNopLogger(), nil)
+	t.Cleanup(func() { _ = closer.Close() })
+	return store
+}
+
+// testComparable is a string whose values match regardless of the case.
+type testComparable string
+
+func (c testComparable) MultiEqual(secondary interface{}) bool {
+	other, ok := secondary.(testComparable)
+	return ok && strings.EqualFold(string(c), string(other))
+}
+
+type testComparableCodec struct{}
+
+func (testComparableCodec) CodecID() string { return "testComparable" }
+
+func (testComparableCodec) Decode(b []byte) (interface{}, error) { return testComparable(b), nil }
+
+func (testComparableCodec) Encode(v interface{}) ([]byte, error) {
+	return []byte(v.(testComparable)), nil
+}
@@ -40,6 +40,16 @@ func (c *prefixedKVClient) CAS(ctx context.Context, key string, f func(in interf
 	return c.client.CAS(ctx, c.prefix+key, f)
 }
 
+// CASMulti atomically modifies multiple values in a callback. Returns ErrTxnNotSupported
+// if the underlying client doesn't support it.
+func (c *prefixedKVClient) CASMulti(ctx context.Context, keys []string, f func(in []interface{}) (out []interface{}, retry bool, err error)) error {
+	prefixed := make([]string, 0, len(keys))
+	for _, key := range keys {
+		prefixed = append(prefixed, c.prefix+key)
+	}
+	return CASMulti(ctx, c.client, prefixed, f)
+}
+
 // WatchKey watches a key.
 func (c *prefixedKVClient) WatchKey(ctx context.Context, key string, f func(interface{}) bool) {
 	c.client.WatchKey(ctx, c.prefix+key, f)
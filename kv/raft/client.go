@@ -0,0 +1,224 @@
+package raft
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-kit/log/level"
+
+	"github.com/grafana/dskit/backoff"
+	"github.com/grafana/dskit/kv/codec"
+)
+
+// Client implements kv.Client interface on top of a Raft KV store.
+type Client struct {
+	kv    *KV
+	codec codec.Codec
+}
+
+// NewClient creates a new Client for the given KV store and codec.
+func NewClient(kv *KV, codec codec.Codec) (*Client, error) {
+	return &Client{
+		kv:    kv,
+		codec: codec,
+	}, nil
+}
+
+// List implements kv.Client.
+func (c *Client) List(ctx context.Context, prefix string) ([]string, error) {
+	return c.kv.list(ctx, prefix)
+}
+
+// Get implements kv.Client.
+func (c *Client) Get(ctx context.Context, key string) (interface{}, error) {
+	value, _, err := c.kv.get(ctx, key)
+	if err != nil || value == nil {
+		return nil, err
+	}
+	return c.codec.Decode(value)
+}
+
+// Delete implements kv.Client.
+func (c *Client) Delete(ctx context.Context, key string) error {
+	return c.kv.apply(ctx, command{Op: opDelete, Key: key})
+}
+
+// CAS implements kv.Client. The update is only applied if the key hasn't been modified since it
+// was read, otherwise it's retried.
+func (c *Client) CAS(ctx context.Context, key string, f func(in interface{}) (out interface{}, retry bool, err error)) error {
+	var lastErr error
+
+	// Backoff on errors, which usually happen while there's no leader.
+	retries := backoff.New(ctx, backoff.Config{
+		MinBackoff: 50 * time.Millisecond,
+		MaxBackoff: time.Second,
+	})
+
+	for i := 0; i < c.kv.cfg.MaxCASRetries; i++ {
+		value, version, err := c.kv.get(ctx, key)
+		if err != nil {
+			level.Error(c.kv.logger).Log("msg", "error getting key", "key", key, "err", err)
+			lastErr = err
+			retries.Wait()
+			continue
+		}
+
+		var intermediate interface{}
+		if value != nil {
+			intermediate, err = c.codec.Decode(value)
+			if err != nil {
+				level.Error(c.kv.logger).Log("msg", "error decoding key", "key", key, "err", err)
+				lastErr = err
+				continue
+			}
+		}
+
+		var retry bool
+		intermediate, retry, err = f(intermediate)
+		if err != nil {
+			if !retry {
+				return err
+			}
+			lastErr = err
+			continue
+		}
+
+		// Callback returning nil means it doesn't want to CAS anymore.
+		if intermediate == nil {
+			return nil
+		}
+
+		buf, err := c.codec.Encode(intermediate)
+		if err != nil {
+			level.Error(c.kv.logger).Log("msg", "error serialising value", "key", key, "err", err)
+			lastErr = err
+			continue
+		}
+
+		err = c.kv.apply(ctx, command{Op: opCAS, Key: key, Value: buf, Version: version})
+		if errors.Is(err, errVersionMismatch) {
+			level.Debug(c.kv.logger).Log("msg", "failed to CAS, the key has been modified", "key", key, "version", version)
+			continue
+		}
+		if err != nil {
+			level.Error(c.kv.logger).Log("msg", "error CASing", "key", key, "err", err)
+			lastErr = err
+			retries.Wait()
+			continue
+		}
+
+		return nil
+	}
+
+	if lastErr != nil {
+		return lastErr
+	}
+	return fmt.Errorf("failed to CAS %s", key)
+}
+
+// CASMulti implements kv.TxnClient. The update is only applied if none of the keys has been
+// modified since they were read, otherwise it's retried.
+func (c *Client) CASMulti(ctx context.Context, keys []string, f func(in []interface{}) (out []interface{}, retry bool, err error)) error {
+	var lastErr error
+
+	// Backoff on errors, which usually happen while there's no leader.
+	retries := backoff.New(ctx, backoff.Config{
+		MinBackoff: 50 * time.Millisecond,
+		MaxBackoff: time.Second,
+	})
+
+attempts:
+	for i := 0; i < c.kv.cfg.MaxCASRetries; i++ {
+		// The keys are read one by one, but the transaction fails if any of them has been modified meanwhile.
+		intermediate := make([]interface{}, len(keys))
+		versions := make([]uint64, len(keys))
+		for j, key := range keys {
+			value, version, err := c.kv.get(ctx, key)
+			if err != nil {
+				level.Error(c.kv.logger).Log("msg", "error getting key", "key", key, "err", err)
+				lastErr = err
+				retries.Wait()
+				continue attempts
+			}
+			versions[j] = version
+
+			if value != nil {
+				intermediate[j], err = c.codec.Decode(value)
+				if err != nil {
+					level.Error(c.kv.logger).Log("msg", "error decoding key", "key", key, "err", err)
+					lastErr = err
+					continue attempts
+				}
+			}
+		}
+
+		intermediate, retry, err := f(intermediate)
+		if err != nil {
+			if !retry {
+				return err
+			}
+			lastErr = err
+			continue
+		}
+
+		// Callback returning nil means it doesn't want to CAS anymore.
+		if intermediate == nil {
+			return nil
+		}
+		if len(intermediate) != len(keys) {
+			return fmt.Errorf("the multi-key CAS callback returned %d values for %d keys", len(intermediate), len(keys))
+		}
+
+		txn := command{Op: opTxn}
+		updated := false
+		for j, key := range keys {
+			if intermediate[j] == nil {
+				txn.Ops = append(txn.Ops, command{Op: opCheck, Key: key, Version: versions[j]})
+				continue
+			}
+
+			buf, err := c.codec.Encode(intermediate[j])
+			if err != nil {
+				level.Error(c.kv.logger).Log("msg", "error serialising value", "key", key, "err", err)
+				lastErr = err
+				continue attempts
+			}
+			txn.Ops = append(txn.Ops, command{Op: opCAS, Key: key, Value: buf, Version: versions[j]})
+			updated = true
+		}
+		if !updated {
+			return nil
+		}
+
+		err = c.kv.apply(ctx, txn)
+		if errors.Is(err, errVersionMismatch) {
+			level.Debug(c.kv.logger).Log("msg", "failed to CAS, some keys have been modified", "keys", fmt.Sprint(keys))
+			continue
+		}
+		if err != nil {
+			level.Error(c.kv.logger).Log("msg", "error CASing", "keys", fmt.Sprint(keys), "err", err)
+			lastErr = err
+			retries.Wait()
+			continue
+		}
+
+		return nil
+	}
+
+	if lastErr != nil {
+		return lastErr
+	}
+	return fmt.Errorf("failed to CAS %v", keys)
+}
+
+// WatchKey implements kv.Client.
+func (c *Client) WatchKey(ctx context.Context, key string, f func(interface{}) bool) {
+	c.kv.WatchKey(ctx, key, c.codec, f)
+}
+
+// WatchPrefix implements kv.Client.
+func (c *Client) WatchPrefix(ctx context.Context, prefix string, f func(string, interface{}) bool) {
+	c.kv.WatchPrefix(ctx, prefix, c.codec, f)
+}
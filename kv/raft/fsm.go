@@ -0,0 +1,191 @@
+package raft
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+
+	hraft "github.com/hashicorp/raft"
+)
+
+const (
+	opCAS    = "cas"
+	opDelete = "delete"
+	opTxn    = "txn"
+
+	// opCheck is only allowed in transactions, to check the version of a key without updating it.
+	opCheck = "check"
+)
+
+var errVersionMismatch = errors.New("version mismatch")
+
+// command is an entry of the Raft log.
+type command struct {
+	Op  string `json:"op"`
+	Key string `json:"key"`
+
+	// Value and Version are only used by CAS and check commands. The command is applied only if the current
+	// version of the key is Version, where version 0 means that the key doesn't exist.
+	Value   []byte `json:"value,omitempty"`
+	Version uint64 `json:"version,omitempty"`
+
+	// Ops are the CAS and check commands of a transaction, which is applied only if all of them match.
+	Ops []command `json:"ops,omitempty"`
+}
+
+// entry is a value stored in the FSM. The version of an entry is the index of the Raft log entry
+// which last updated it, so it's unique and increases with every update.
+type entry struct {
+	Value   []byte `json:"value"`
+	Version uint64 `json:"version"`
+}
+
+// fsm is the replicated state machine: a map of keys to versioned values.
+type fsm struct {
+	mtx     sync.RWMutex
+	entries map[string]entry
+
+	// notify is called after a key has been updated.
+	notify func(key string)
+}
+
+func newFSM(notify func(key string)) *fsm {
+	return &fsm{
+		entries: map[string]entry{},
+		notify:  notify,
+	}
+}
+
+// Apply implements hraft.FSM. It returns errVersionMismatch if a CAS command doesn't match the
+// current version of the key.
+func (f *fsm) Apply(l *hraft.Log) interface{} {
+	var cmd command
+	if err := json.Unmarshal(l.Data, &cmd); err != nil {
+		return fmt.Errorf("failed to decode command: %w", err)
+	}
+
+	switch cmd.Op {
+	case opCAS:
+		f.mtx.Lock()
+		if f.entries[cmd.Key].Version != cmd.Version {
+			f.mtx.Unlock()
+			return errVersionMismatch
+		}
+		f.entries[cmd.Key] = entry{Value: cmd.Value, Version: l.Index}
+		f.mtx.Unlock()
+
+		f.notify(cmd.Key)
+		return nil
+
+	case opTxn:
+		f.mtx.Lock()
+		for _, op := range cmd.Ops {
+			if f.entries[op.Key].Version != op.Version {
+				f.mtx.Unlock()
+				return errVersionMismatch
+			}
+		}
+		var updated []string
+		for _, op := range cmd.Ops {
+			if op.Op == opCAS {
+				f.entries[op.Key] = entry{Value: op.Value, Version: l.Index}
+				updated = append(updated, op.Key)
+			}
+		}
+		f.mtx.Unlock()
+
+		for _, key := range updated {
+			f.notify(key)
+		}
+		return nil
+
+	case opDelete:
+		f.mtx.Lock()
+		delete(f.entries, cmd.Key)
+		f.mtx.Unlock()
+		return nil
+
+	default:
+		return fmt.Errorf("unknown command: %s", cmd.Op)
+	}
+}
+
+// get returns the value and version of the key, or a nil value and version 0 if the key doesn't exist.
+func (f *fsm) get(key string) ([]byte, uint64) {
+	f.mtx.RLock()
+	defer f.mtx.RUnlock()
+
+	e := f.entries[key]
+	return e.Value, e.Version
+}
+
+// list returns the sorted keys with the given prefix.
+func (f *fsm) list(prefix string) []string {
+	f.mtx.RLock()
+	keys := make([]string, 0, len(f.entries))
+	for key := range f.entries {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	f.mtx.RUnlock()
+
+	sort.Strings(keys)
+	return keys
+}
+
+// Snapshot implements hraft.FSM.
+func (f *fsm) Snapshot() (hraft.FSMSnapshot, error) {
+	f.mtx.RLock()
+	defer f.mtx.RUnlock()
+
+	// Values are never modified in place, so a shallow copy is enough.
+	entries := make(map[string]entry, len(f.entries))
+	for key, e := range f.entries {
+		entries[key] = e
+	}
+	return &fsmSnapshot{entries: entries}, nil
+}
+
+// Restore implements hraft.FSM.
+func (f *fsm) Restore(snapshot io.ReadCloser) error {
+	defer snapshot.Close()
+
+	entries := map[string]entry{}
+	if err := json.NewDecoder(snapshot).Decode(&entries); err != nil {
+		return fmt.Errorf("failed to decode snapshot: %w", err)
+	}
+
+	f.mtx.Lock()
+	previous := f.entries
+	f.entries = entries
+	f.mtx.Unlock()
+
+	// Notify the keys that changed.
+	for key, e := range entries {
+		if previous[key].Version != e.Version {
+			f.notify(key)
+		}
+	}
+	return nil
+}
+
+type fsmSnapshot struct {
+	entries map[string]entry
+}
+
+// Persist implements hraft.FSMSnapshot.
+func (s *fsmSnapshot) Persist(sink hraft.SnapshotSink) error {
+	if err := json.NewEncoder(sink).Encode(s.entries); err != nil {
+		_ = sink.Cancel()
+		return err
+	}
+	return sink.Close()
+}
+
+// Release implements hraft.FSMSnapshot.
+func (s *fsmSnapshot) Release() {}
@@ -0,0 +1,750 @@
+package raft
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	hraft "github.com/hashicorp/raft"
+	raftboltdb "github.com/hashicorp/raft-boltdb/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	dstls "github.com/grafana/dskit/crypto/tls"
+	"github.com/grafana/dskit/flagext"
+	"github.com/grafana/dskit/kv/codec"
+	"github.com/grafana/dskit/netutil"
+	"github.com/grafana/dskit/services"
+)
+
+var (
+	errNoLeader        = errors.New("no Raft leader")
+	errNotLeader       = errors.New("not the Raft leader")
+	errInvalidNodeID   = errors.New("the Raft node ID must not be empty")
+	errInvalidDataDir  = errors.New("the Raft data directory must not be empty")
+	errInvalidExpected = errors.New("the number of members expected to bootstrap the Raft cluster must be greater than 0")
+	errTLSCertMissing  = errors.New("TLS for Raft requires a certificate and key, used both as server and client certificate")
+	errTLSCAMissing    = errors.New("requiring Raft client certificates requires TLS to be enabled with a CA")
+)
+
+// Config for the Raft KV store.
+type Config struct {
+	NodeID        string `yaml:"node_id" category:"experimental"`
+	BindAddr      string `yaml:"bind_addr" category:"experimental"`
+	BindPort      int    `yaml:"bind_port" category:"experimental"`
+	AdvertiseAddr string `yaml:"advertise_addr" category:"experimental"`
+	AdvertisePort int    `yaml:"advertise_port" category:"experimental"`
+
+	JoinMembers       flagext.StringSliceCSV `yaml:"join_members" category:"experimental"`
+	BootstrapExpect   int                    `yaml:"bootstrap_expect" category:"experimental"`
+	DiscoveryInterval time.Duration          `yaml:"discovery_interval" category:"experimental"`
+
+	DataDir           string        `yaml:"data_dir" category:"experimental"`
+	SnapshotInterval  time.Duration `yaml:"snapshot_interval" category:"experimental"`
+	SnapshotThreshold uint64        `yaml:"snapshot_threshold" category:"experimental"`
+	SnapshotRetain    int           `yaml:"snapshot_retain" category:"experimental"`
+
+	ApplyTimeout  time.Duration `yaml:"apply_timeout" category:"experimental"`
+	MaxCASRetries int           `yaml:"max_cas_retries" category:"experimental"`
+
+	TLSEnabled           bool               `yaml:"tls_enabled" category:"experimental"`
+	TLSRequireClientCert bool               `yaml:"tls_require_client_cert" category:"experimental"`
+	TLS                  dstls.ClientConfig `yaml:",inline"`
+}
+
+// RegisterFlags adds the flags required to config this to the given FlagSet.
+func (cfg *Config) RegisterFlags(f *flag.FlagSet) {
+	cfg.RegisterFlagsWithPrefix(f, "")
+}
+
+// RegisterFlagsWithPrefix adds the flags required to config this to the given FlagSet.
+func (cfg *Config) RegisterFlagsWithPrefix(f *flag.FlagSet, prefix string) {
+	hostname, _ := os.Hostname()
+
+	f.StringVar(&cfg.NodeID, prefix+"raft.node-id", hostname, "Unique ID of this member in the Raft cluster. It must not change across restarts.")
+	f.StringVar(&cfg.BindAddr, prefix+"raft.bind-addr", "0.0.0.0", "IP address to listen on for Raft communication and for requests forwarded to the leader.")
+	f.IntVar(&cfg.BindPort, prefix+"raft.bind-port", 7947, "Port to listen on for Raft communication and for requests forwarded to the leader. Unless TLS with client certificates is required, any host which can connect to this port can read and update any key, so it must only be reachable by the cluster members.")
+	f.StringVar(&cfg.AdvertiseAddr, prefix+"raft.advertise-addr", "", "IP address to advertise to other members of the Raft cluster. If empty, the bind address is used, or the first private network address if the bind address is unspecified.")
+	f.IntVar(&cfg.AdvertisePort, prefix+"raft.advertise-port", 0, "Port to advertise to other members of the Raft cluster. 0 = the port listened on.")
+	f.Var(&cfg.JoinMembers, prefix+"raft.join-members", "Other cluster members to join, as comma-separated list of host:port. Members can be resolved with DNS service discovery using the same syntax as memberlist, e.g. dns+raft.namespace.svc.cluster.local:7947.")
+	f.IntVar(&cfg.BootstrapExpect, prefix+"raft.bootstrap-expect", 3, "Number of members expected to bootstrap a new Raft cluster. The cluster is bootstrapped once this number of members has been discovered, and members discovered later are added to it.")
+	f.DurationVar(&cfg.DiscoveryInterval, prefix+"raft.discovery-interval", 10*time.Second, "How frequently to discover the cluster members.")
+	f.StringVar(&cfg.DataDir, prefix+"raft.data-dir", "./data-raft/", "Directory where the Raft log and snapshots are stored.")
+	f.DurationVar(&cfg.SnapshotInterval, prefix+"raft.snapshot-interval", 2*time.Minute, "How frequently to check whether a snapshot should be taken.")
+	f.Uint64Var(&cfg.SnapshotThreshold, prefix+"raft.snapshot-threshold", 8192, "Number of Raft log entries after which a snapshot is taken.")
+	f.IntVar(&cfg.SnapshotRetain, prefix+"raft.snapshot-retain", 2, "Number of snapshots to retain on disk.")
+	f.DurationVar(&cfg.ApplyTimeout, prefix+"raft.apply-timeout", 10*time.Second, "Timeout of writes and linearizable reads.")
+	f.IntVar(&cfg.MaxCASRetries, prefix+"raft.max-cas-retries", 10, "Maximum number of retries for CAS operations.")
+	f.BoolVar(&cfg.TLSEnabled, prefix+"raft.tls-enabled", false, "Enable TLS on the Raft communication and on the requests forwarded to the leader. The certificate is used both as server and client certificate.")
+	f.BoolVar(&cfg.TLSRequireClientCert, prefix+"raft.tls-require-client-cert", false, "Require the members to present a client certificate signed by the CA of the TLS CA path. If false, any host which can connect to the Raft port can read and update any key.")
+	cfg.TLS.RegisterFlagsWithPrefix(prefix+"raft", f)
+}
+
+// Validate the Config.
+func (cfg *Config) Validate() error {
+	if cfg.NodeID == "" {
+		return errInvalidNodeID
+	}
+	if cfg.DataDir == "" {
+		return errInvalidDataDir
+	}
+	if cfg.BootstrapExpect < 1 {
+		return errInvalidExpected
+	}
+	if cfg.TLSEnabled && (cfg.TLS.CertPath == "" || cfg.TLS.KeyPath == "") {
+		return errTLSCertMissing
+	}
+	if cfg.TLSRequireClientCert && (!cfg.TLSEnabled || cfg.TLS.CAPath == "") {
+		return errTLSCAMissing
+	}
+	return nil
+}
+
+// DNSProvider supports storing or resolving a list of addresses.
+type DNSProvider interface {
+	// Resolve stores a list of provided addresses or their DNS records if requested.
+	// Implementations may have specific ways of interpreting addresses.
+	Resolve(ctx context.Context, addrs []string) error
+
+	// Addresses returns the latest addresses present in the DNSProvider.
+	Addresses() []string
+}
+
+// KV implements a Key-Value store replicated with Raft among the cluster members. Writes and
+// reads are served by the leader, so they are linearizable: members which are not the leader
+// forward them to the leader. Watches are served from the local replica.
+//
+// Members are discovered from the configured join members, like memberlist does. A new cluster is
+// bootstrapped once the expected number of members has been discovered, and the leader adds the
+// members discovered later. Members are never removed automatically.
+//
+// KV is a Service. It needs to be started first, and is only usable once it enters Running state.
+type KV struct {
+	services.Service
+
+	cfg      Config
+	logger   log.Logger
+	provider DNSProvider
+
+	raft      *hraft.Raft
+	fsm       *fsm
+	transport *hraft.NetworkTransport
+	store     *raftboltdb.BoltStore
+
+	// Address advertised to the other members, as host:port.
+	advertiseAddr string
+
+	// TLS config used to connect to the other members, nil if TLS is disabled.
+	tlsConfig *tls.Config
+
+	// Whether this member has joined a cluster, either bootstrapping it or being added to it.
+	bootstrapped bool
+
+	watchersMu     sync.Mutex
+	watchers       map[string][]chan string
+	prefixWatchers map[string][]chan string
+
+	// Closed when the service is stopping.
+	shutdown chan struct{}
+
+	isLeader          prometheus.Gauge
+	clusterMembers    prometheus.Gauge
+	forwardedRequests *prometheus.CounterVec
+}
+
+// NewKV creates a new Raft KV store. The KV must be started before use.
+func NewKV(cfg Config, logger log.Logger, dnsProvider DNSProvider, reg prometheus.Registerer) *KV {
+	kv := &KV{
+		cfg:            cfg,
+		logger:         logger,
+		provider:       dnsProvider,
+		watchers:       map[string][]chan string{},
+		prefixWatchers: map[string][]chan string{},
+		shutdown:       make(chan struct{}),
+
+		isLeader: promauto.With(reg).NewGauge(prometheus.GaugeOpts{
+			Name: "kv_raft_leader",
+			Help: "Whether this member is the leader of the Raft cluster.",
+		}),
+		clusterMembers: promauto.With(reg).NewGauge(prometheus.GaugeOpts{
+			Name: "kv_raft_cluster_members",
+			Help: "Number of members of the Raft cluster.",
+		}),
+		forwardedRequests: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "kv_raft_forwarded_requests_total",
+			Help: "Total number of requests forwarded to the leader of the Raft cluster.",
+		}, []string{"operation"}),
+	}
+	kv.fsm = newFSM(kv.notifyWatchers)
+
+	kv.Service = services.NewBasicService(kv.starting, kv.running, kv.stopping)
+	return kv
+}
+
+func (k *KV) starting(_ context.Context) error {
+	if err := k.cfg.Validate(); err != nil {
+		return err
+	}
+
+	raftLogger := newRaftLogger(k.logger)
+
+	if k.cfg.TLSEnabled {
+		var err error
+		if k.tlsConfig, err = k.cfg.TLS.GetTLSConfig(); err != nil {
+			return fmt.Errorf("failed to create the Raft TLS config: %w", err)
+		}
+	}
+
+	listener, err := net.Listen("tcp", net.JoinHostPort(k.cfg.BindAddr, strconv.Itoa(k.cfg.BindPort)))
+	if err != nil {
+		return fmt.Errorf("failed to listen for Raft communication: %w", err)
+	}
+	if k.tlsConfig != nil {
+		serverConfig := k.tlsConfig.Clone()
+		if k.cfg.TLSRequireClientCert {
+			serverConfig.ClientAuth = tls.RequireAndVerifyClientCert
+			serverConfig.ClientCAs = k.tlsConfig.RootCAs
+		}
+		listener = tls.NewListener(listener, serverConfig)
+	}
+
+	advertise, err := k.advertiseAddress(listener.Addr().(*net.TCPAddr).Port)
+	if err != nil {
+		_ = listener.Close()
+		return err
+	}
+	k.advertiseAddr = advertise.String()
+
+	stream := newStreamLayer(listener, advertise, k.tlsConfig, k.handleForward, k.logger)
+	k.transport = hraft.NewNetworkTransportWithConfig(&hraft.NetworkTransportConfig{
+		Stream:  stream,
+		MaxPool: 3,
+		Timeout: k.cfg.ApplyTimeout,
+		Logger:  raftLogger,
+	})
+
+	if err := os.MkdirAll(k.cfg.DataDir, 0o750); err != nil {
+		_ = k.transport.Close()
+		return fmt.Errorf("failed to create the Raft data directory: %w", err)
+	}
+
+	k.store, err = raftboltdb.NewBoltStore(filepath.Join(k.cfg.DataDir, "raft.db"))
+	if err != nil {
+		_ = k.transport.Close()
+		return fmt.Errorf("failed to open the Raft log: %w", err)
+	}
+
+	snapshots, err := hraft.NewFileSnapshotStoreWithLogger(k.cfg.DataDir, k.cfg.SnapshotRetain, raftLogger)
+	if err != nil {
+		_ = k.transport.Close()
+		_ = k.store.Close()
+		return fmt.Errorf("failed to open the Raft snapshots: %w", err)
+	}
+
+	k.bootstrapped, err = hraft.HasExistingState(k.store, k.store, snapshots)
+	if err != nil {
+		_ = k.transport.Close()
+		_ = k.store.Close()
+		return fmt.Errorf("failed to check the Raft state: %w", err)
+	}
+
+	raftCfg := hraft.DefaultConfig()
+	raftCfg.LocalID = hraft.ServerID(k.cfg.NodeID)
+	raftCfg.Logger = raftLogger
+	raftCfg.SnapshotInterval = k.cfg.SnapshotInterval
+	raftCfg.SnapshotThreshold = k.cfg.SnapshotThreshold
+
+	k.raft, err = hraft.NewRaft(raftCfg, k.fsm, k.store, k.store, snapshots, k.transport)
+	if err != nil {
+		_ = k.transport.Close()
+		_ = k.store.Close()
+		return fmt.Errorf("failed to start Raft: %w", err)
+	}
+
+	level.Info(k.logger).Log("msg", "Raft started", "node_id", k.cfg.NodeID, "addr", k.advertiseAddr, "existing_state", k.bootstrapped)
+	return nil
+}
+
+// advertiseAddress returns the address to advertise to the other members.
+func (k *KV) advertiseAddress(listenPort int) (*net.TCPAddr, error) {
+	addr := k.cfg.AdvertiseAddr
+	if addr == "" {
+		if ip := net.ParseIP(k.cfg.BindAddr); ip != nil && !ip.IsUnspecified() {
+			addr = k.cfg.BindAddr
+		} else {
+			var err error
+			addr, err = netutil.GetFirstAddressOf(netutil.PrivateNetworkInterfacesWithFallback([]string{"eth0", "en0"}, k.logger), k.logger, false)
+			if err != nil {
+				return nil, fmt.Errorf("failed to find the Raft advertise address: %w", err)
+			}
+		}
+	}
+
+	port := k.cfg.AdvertisePort
+	if port == 0 {
+		port = listenPort
+	}
+
+	return net.ResolveTCPAddr("tcp", net.JoinHostPort(addr, strconv.Itoa(port)))
+}
+
+func (k *KV) running(ctx context.Context) error {
+	ticker := time.NewTicker(k.cfg.DiscoveryInterval)
+	defer ticker.Stop()
+
+	k.reconcileMembers(ctx)
+
+	for {
+		select {
+		case <-ticker.C:
+			k.reconcileMembers(ctx)
+		case leader := <-k.raft.LeaderCh():
+			if leader {
+				k.isLeader.Set(1)
+				// Add the members discovered while there was no leader.
+				k.reconcileMembers(ctx)
+			} else {
+				k.isLeader.Set(0)
+			}
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func (k *KV) stopping(_ error) error {
+	close(k.shutdown)
+
+	// Hand over the leadership, to avoid waiting for an election timeout.
+	if k.raft.State() == hraft.Leader {
+		if err := k.raft.LeadershipTransfer().Error(); err != nil {
+			level.Debug(k.logger).Log("msg", "failed to transfer the Raft leadership", "err", err)
+		}
+	}
+
+	err := k.raft.Shutdown().Error()
+	if closeErr := k.transport.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := k.store.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// reconcileMembers discovers the members, and either bootstraps the cluster with them or adds
+// them to the cluster.
+func (k *KV) reconcileMembers(ctx context.Context) {
+	members := k.discoverMembers(ctx)
+
+	if k.bootstrapped || k.hasConfiguration() {
+		k.bootstrapped = true
+		k.addMembers(members)
+		return
+	}
+
+	if len(members) < k.cfg.BootstrapExpect {
+		level.Debug(k.logger).Log("msg", "waiting for members to bootstrap the Raft cluster", "discovered", len(members), "expected", k.cfg.BootstrapExpect)
+		return
+	}
+
+	// Every member bootstraps the cluster with the same configuration, which is safe. Only
+	// bootstrap if all the discovered members are reachable, and none of them has already
+	// joined a cluster: in that case this member is added by the leader.
+	servers := make([]hraft.Server, 0, len(members))
+	for _, addr := range members {
+		status, err := k.status(addr)
+		if err != nil {
+			level.Debug(k.logger).Log("msg", "failed to get the status of a member, not bootstrapping the Raft cluster", "member", addr, "err", err)
+			return
+		}
+		if status.Bootstrapped {
+			level.Debug(k.logger).Log("msg", "discovered an existing Raft cluster, waiting to be added by the leader", "member", addr)
+			return
+		}
+		servers = append(servers, hraft.Server{ID: hraft.ServerID(status.ID), Address: hraft.ServerAddress(addr)})
+	}
+
+	err := k.raft.BootstrapCluster(hraft.Configuration{Servers: servers}).Error()
+	if err != nil && !errors.Is(err, hraft.ErrCantBootstrap) {
+		level.Warn(k.logger).Log("msg", "failed to bootstrap the Raft cluster", "err", err)
+		return
+	}
+
+	level.Info(k.logger).Log("msg", "bootstrapped the Raft cluster", "members", strings.Join(members, ","))
+	k.bootstrapped = true
+}
+
+// addMembers adds the members which are not part of the cluster yet. It only runs on the leader.
+func (k *KV) addMembers(members []string) {
+	if k.raft.State() != hraft.Leader {
+		return
+	}
+
+	future := k.raft.GetConfiguration()
+	if err := future.Error(); err != nil {
+		level.Warn(k.logger).Log("msg", "failed to get the Raft configuration", "err", err)
+		return
+	}
+
+	known := map[hraft.ServerAddress]bool{}
+	for _, server := range future.Configuration().Servers {
+		known[server.Address] = true
+	}
+	k.clusterMembers.Set(float64(len(known)))
+
+	for _, addr := range members {
+		if known[hraft.ServerAddress(addr)] {
+			continue
+		}
+
+		status, err := k.status(addr)
+		if err != nil {
+			level.Debug(k.logger).Log("msg", "failed to get the status of a member", "member", addr, "err", err)
+			continue
+		}
+
+		// Adding a member with an existing ID updates its address.
+		if err := k.raft.AddVoter(hraft.ServerID(status.ID), hraft.ServerAddress(addr), 0, k.cfg.ApplyTimeout).Error(); err != nil {
+			level.Warn(k.logger).Log("msg", "failed to add a member to the Raft cluster", "member", addr, "id", status.ID, "err", err)
+			continue
+		}
+		level.Info(k.logger).Log("msg", "added a member to the Raft cluster", "member", addr, "id", status.ID)
+	}
+}
+
+// discoverMembers returns the sorted addresses of the members, including this one.
+func (k *KV) discoverMembers(ctx context.Context) []string {
+	var members, resolve []string
+	for _, member := range k.cfg.JoinMembers {
+		if strings.Contains(member, "+") {
+			resolve = append(resolve, member)
+		} else {
+			// No DNS SRV record to lookup, just append member
+			members = append(members, member)
+		}
+	}
+
+	if len(resolve) > 0 && k.provider != nil {
+		if err := k.provider.Resolve(ctx, resolve); err != nil {
+			level.Error(k.logger).Log("msg", "failed to resolve members", "addrs", strings.Join(resolve, ","), "err", err)
+		}
+		members = append(members, k.provider.Addresses()...)
+	}
+
+	unique := map[string]struct{}{k.advertiseAddr: {}}
+	for _, member := range members {
+		unique[member] = struct{}{}
+	}
+
+	members = make([]string, 0, len(unique))
+	for member := range unique {
+		members = append(members, member)
+	}
+	sort.Strings(members)
+	return members
+}
+
+func (k *KV) hasConfiguration() bool {
+	future := k.raft.GetConfiguration()
+	return future.Error() == nil && len(future.Configuration().Servers) > 0
+}
+
+// status returns the status of the member at the given address.
+func (k *KV) status(addr string) (forwardResponse, error) {
+	if addr == k.advertiseAddr {
+		return k.localStatus(), nil
+	}
+	return sendForwardRequest(addr, forwardRequest{Op: forwardOpStatus}, time.Now().Add(k.cfg.ApplyTimeout), k.tlsConfig)
+}
+
+func (k *KV) localStatus() forwardResponse {
+	return forwardResponse{ID: k.cfg.NodeID, Bootstrapped: k.hasConfiguration()}
+}
+
+// get returns the value and version of the key, reading it from the leader.
+func (k *KV) get(ctx context.Context, key string) ([]byte, uint64, error) {
+	if k.raft.State() == hraft.Leader {
+		if err := k.raft.Barrier(k.cfg.ApplyTimeout).Error(); err != nil {
+			return nil, 0, err
+		}
+		value, version := k.fsm.get(key)
+		return value, version, nil
+	}
+
+	resp, err := k.forward(ctx, forwardRequest{Op: forwardOpGet, Key: key})
+	return resp.Value, resp.Version, err
+}
+
+// list returns the keys with the given prefix, reading them from the leader.
+func (k *KV) list(ctx context.Context, prefix string) ([]string, error) {
+	if k.raft.State() == hraft.Leader {
+		if err := k.raft.Barrier(k.cfg.ApplyTimeout).Error(); err != nil {
+			return nil, err
+		}
+		return k.fsm.list(prefix), nil
+	}
+
+	resp, err := k.forward(ctx, forwardRequest{Op: forwardOpList, Prefix: prefix})
+	return resp.Keys, err
+}
+
+// apply applies the command to the Raft log, and returns the result of applying it to the FSM.
+func (k *KV) apply(ctx context.Context, cmd command) error {
+	if k.raft.State() == hraft.Leader {
+		return k.applyLocal(cmd)
+	}
+
+	_, err := k.forward(ctx, forwardRequest{Op: forwardOpApply, Command: &cmd})
+	return err
+}
+
+func (k *KV) applyLocal(cmd command) error {
+	data, err := json.Marshal(cmd)
+	if err != nil {
+		return err
+	}
+
+	future := k.raft.Apply(data, k.cfg.ApplyTimeout)
+	if err := future.Error(); err != nil {
+		return err
+	}
+	if err, ok := future.Response().(error); ok {
+		return err
+	}
+	return nil
+}
+
+// forward sends the request to the leader.
+func (k *KV) forward(ctx context.Context, req forwardRequest) (forwardResponse, error) {
+	leader, _ := k.raft.LeaderWithID()
+	if leader == "" {
+		return forwardResponse{}, errNoLeader
+	}
+
+	deadline := time.Now().Add(k.cfg.ApplyTimeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+
+	k.forwardedRequests.WithLabelValues(req.Op).Inc()
+	return sendForwardRequest(string(leader), req, deadline, k.tlsConfig)
+}
+
+// handleForward serves a request received from another member.
+func (k *KV) handleForward(conn net.Conn) {
+	defer conn.Close()
+
+	// Leave some room to the leader to respond before the sender gives up.
+	_ = conn.SetDeadline(time.Now().Add(2 * k.cfg.ApplyTimeout))
+
+	var req forwardRequest
+	if err := json.NewDecoder(conn).Decode(&req); err != nil {
+		level.Debug(k.logger).Log("msg", "failed to decode forwarded request", "remote", conn.RemoteAddr(), "err", err)
+		return
+	}
+
+	resp := k.serveForward(req)
+	if err := json.NewEncoder(conn).Encode(resp); err != nil {
+		level.Debug(k.logger).Log("msg", "failed to send forwarded response", "remote", conn.RemoteAddr(), "err", err)
+	}
+}
+
+func (k *KV) serveForward(req forwardRequest) forwardResponse {
+	if req.Op == forwardOpStatus {
+		return k.localStatus()
+	}
+
+	// Only the leader serves the other requests, to not forward them again.
+	if k.raft.State() != hraft.Leader {
+		return forwardResponse{Error: errNotLeader.Error()}
+	}
+
+	var resp forwardResponse
+	var err error
+	switch req.Op {
+	case forwardOpApply:
+		if req.Command == nil {
+			err = errors.New("missing command")
+			break
+		}
+		err = k.applyLocal(*req.Command)
+	case forwardOpGet:
+		if err = k.raft.Barrier(k.cfg.ApplyTimeout).Error(); err == nil {
+			resp.Value, resp.Version = k.fsm.get(req.Key)
+		}
+	case forwardOpList:
+		if err = k.raft.Barrier(k.cfg.ApplyTimeout).Error(); err == nil {
+			resp.Keys = k.fsm.list(req.Prefix)
+		}
+	default:
+		err = fmt.Errorf("unknown operation: %s", req.Op)
+	}
+
+	if errors.Is(err, errVersionMismatch) {
+		resp.VersionMismatch = true
+	} else if err != nil {
+		resp.Error = err.Error()
+	}
+	return resp
+}
+
+// WatchKey watches for value changes for given key. When value changes, 'f' function is called with the
+// latest value. Notifications that arrive while 'f' is running are coalesced into one subsequent 'f' call.
+// Values are read from the local replica.
+//
+// Watching ends when 'f' returns false, context is done, or this KV is stopped.
+func (k *KV) WatchKey(ctx context.Context, key string, codec codec.Codec, f func(interface{}) bool) {
+	// keep one extra notification, to avoid missing notification if we're busy running the function
+	w := make(chan string, 1)
+
+	// register watcher
+	k.watchersMu.Lock()
+	k.watchers[key] = append(k.watchers[key], w)
+	k.watchersMu.Unlock()
+
+	defer func() {
+		// unregister watcher on exit
+		k.watchersMu.Lock()
+		defer k.watchersMu.Unlock()
+
+		removeWatcherChannel(key, w, k.watchers)
+	}()
+
+	for {
+		select {
+		case <-w:
+			// value changed
+			val, err := k.localValue(key, codec)
+			if err != nil {
+				level.Warn(k.logger).Log("msg", "failed to decode value while watching for changes", "key", key, "err", err)
+				continue
+			}
+
+			if !f(val) {
+				return
+			}
+
+		case <-k.shutdown:
+			// stop watching on shutdown
+			return
+
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// WatchPrefix watches for any change of values stored under keys with given prefix. When change occurs,
+// function 'f' is called with key and current value. Values are read from the local replica.
+// Each change of the key results in one notification. If there are too many pending notifications ('f' is slow),
+// some notifications may be lost.
+//
+// Watching ends when 'f' returns false, context is done, or this KV is stopped.
+func (k *KV) WatchPrefix(ctx context.Context, prefix string, codec codec.Codec, f func(string, interface{}) bool) {
+	// we use bigger buffer here, since keys are interesting and we don't want to lose them.
+	w := make(chan string, 16)
+
+	// register watcher
+	k.watchersMu.Lock()
+	k.prefixWatchers[prefix] = append(k.prefixWatchers[prefix], w)
+	k.watchersMu.Unlock()
+
+	defer func() {
+		// unregister watcher on exit
+		k.watchersMu.Lock()
+		defer k.watchersMu.Unlock()
+
+		removeWatcherChannel(prefix, w, k.prefixWatchers)
+	}()
+
+	for {
+		select {
+		case key := <-w:
+			val, err := k.localValue(key, codec)
+			if err != nil {
+				level.Warn(k.logger).Log("msg", "failed to decode value while watching for changes", "key", key, "err", err)
+				continue
+			}
+
+			if !f(key, val) {
+				return
+			}
+
+		case <-k.shutdown:
+			// stop watching on shutdown
+			return
+
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// localValue returns the decoded value of the key from the local replica.
+func (k *KV) localValue(key string, codec codec.Codec) (interface{}, error) {
+	value, _ := k.fsm.get(key)
+	if value == nil {
+		return nil, nil
+	}
+	return codec.Decode(value)
+}
+
+func removeWatcherChannel(k string, w chan string, watchers map[string][]chan string) {
+	ws := watchers[k]
+	for ix, kw := range ws {
+		if kw == w {
+			ws = append(ws[:ix], ws[ix+1:]...)
+			break
+		}
+	}
+
+	if len(ws) > 0 {
+		watchers[k] = ws
+	} else {
+		delete(watchers, k)
+	}
+}
+
+// notifyWatchers sends notification to all watchers of given key.
+func (k *KV) notifyWatchers(key string) {
+	k.watchersMu.Lock()
+	defer k.watchersMu.Unlock()
+
+	for _, kw := range k.watchers[key] {
+		select {
+		case kw <- key:
+			// notification sent.
+		default:
+			// cannot send notification to this watcher at the moment
+			// but since this is a buffered channel, it means that
+			// there is already a pending notification anyway
+		}
+	}
+
+	for p, ws := range k.prefixWatchers {
+		if strings.HasPrefix(key, p) {
+			for _, pw := range ws {
+				select {
+				case pw <- key:
+					// notification sent.
+				default:
+					// the watcher is too slow, the notification is lost.
+				}
+			}
+		}
+	}
+}
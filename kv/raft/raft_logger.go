@@ -0,0 +1,70 @@
+package raft
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/hashicorp/go-hclog"
+)
+
+// newRaftLogger returns a hclog.Logger, as required by the Raft library, writing to the given logger.
+func newRaftLogger(logger log.Logger) hclog.Logger {
+	return hclog.New(&hclog.LoggerOptions{
+		Name:   "raft",
+		Level:  hclog.Debug,
+		Output: loggerAdapter{Logger: logger},
+
+		// JSON lines are easy to parse back into key-value pairs. The timestamp is added by the logger.
+		JSONFormat:  true,
+		DisableTime: true,
+	})
+}
+
+// loggerAdapter parses the JSON lines produced by hclog, and logs them to the wrapped Logger.
+type loggerAdapter struct {
+	log.Logger
+}
+
+func (a loggerAdapter) Write(p []byte) (int, error) {
+	fields := map[string]interface{}{}
+	if err := json.Unmarshal(bytes.TrimSpace(p), &fields); err != nil {
+		// Not a JSON line, log it as is.
+		if err := a.Logger.Log("msg", string(bytes.TrimSpace(p))); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+
+	keyvals := []interface{}{}
+	switch fields["@level"] {
+	case "trace", "debug":
+		keyvals = append(keyvals, "level", level.DebugValue())
+	case "info":
+		keyvals = append(keyvals, "level", level.InfoValue())
+	case "warn":
+		keyvals = append(keyvals, "level", level.WarnValue())
+	case "error":
+		keyvals = append(keyvals, "level", level.ErrorValue())
+	}
+	keyvals = append(keyvals, "msg", fields["@message"])
+
+	// Log the other fields in a stable order.
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		if key != "@level" && key != "@message" && key != "@module" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		keyvals = append(keyvals, key, fields[key])
+	}
+
+	if err := a.Logger.Log(keyvals...); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,412 @@
+package raft
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	hraft "github.com/hashicorp/raft"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	dstls "github.com/grafana/dskit/crypto/tls"
+	"github.com/grafana/dskit/flagext"
+	"github.com/grafana/dskit/kv/codec"
+	"github.com/grafana/dskit/services"
+	"github.com/grafana/dskit/test"
+)
+
+func TestKV_CASShouldBeConsistentAcrossMembers(t *testing.T) {
+	const concurrency = 5
+
+	provider := &staticProvider{}
+	members := startCluster(t, 3, provider)
+
+	ctx := context.Background()
+	wg := sync.WaitGroup{}
+
+	// Update the same key concurrently from all members: followers forward to the leader.
+	for _, kv := range members {
+		client, err := NewClient(kv, codec.String{})
+		require.NoError(t, err)
+
+		for i := 0; i < concurrency; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				err := client.CAS(ctx, "counter", func(in interface{}) (interface{}, bool, error) {
+					if in == nil {
+						return "1", true, nil
+					}
+					value, err := strconv.Atoi(in.(string))
+					if err != nil {
+						return nil, false, err
+					}
+					return strconv.Itoa(value + 1), true, nil
+				})
+				assert.NoError(t, err)
+			}()
+		}
+	}
+	wg.Wait()
+
+	// Reads are linearizable, so all the members should return the latest value.
+	for _, kv := range members {
+		client, err := NewClient(kv, codec.String{})
+		require.NoError(t, err)
+
+		value, err := client.Get(ctx, "counter")
+		require.NoError(t, err)
+		assert.Equal(t, strconv.Itoa(len(members)*concurrency), value)
+	}
+
+	// A member discovered after the cluster has been bootstrapped should be added to it.
+	late := startKV(t, "member-late", t.TempDir(), 0, 3, provider)
+	provider.add(late.advertiseAddr)
+
+	test.Poll(t, 10*time.Second, strconv.Itoa(len(members)*concurrency), func() interface{} {
+		value, _ := late.fsm.get("counter")
+		return string(value)
+	})
+	client, err := NewClient(late, codec.String{})
+	require.NoError(t, err)
+	value, err := client.Get(ctx, "counter")
+	require.NoError(t, err)
+	assert.Equal(t, strconv.Itoa(len(members)*concurrency), value)
+}
+
+func TestKV_ListAndDelete(t *testing.T) {
+	members := startCluster(t, 3, &staticProvider{})
+	ctx := context.Background()
+
+	leader, err := NewClient(members[0], codec.String{})
+	require.NoError(t, err)
+	for _, key := range []string{"prefix/b", "prefix/a", "other"} {
+		require.NoError(t, leader.CAS(ctx, key, func(interface{}) (interface{}, bool, error) {
+			return "value", false, nil
+		}))
+	}
+
+	follower, err := NewClient(members[1], codec.String{})
+	require.NoError(t, err)
+
+	keys, err := follower.List(ctx, "prefix/")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"prefix/a", "prefix/b"}, keys)
+
+	require.NoError(t, follower.Delete(ctx, "prefix/a"))
+	require.NoError(t, follower.Delete(ctx, "missing"), "deleting a missing key should not fail")
+
+	value, err := leader.Get(ctx, "prefix/a")
+	require.NoError(t, err)
+	assert.Nil(t, value)
+
+	keys, err = leader.List(ctx, "")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"other", "prefix/b"}, keys)
+}
+
+func TestClient_CASMultiShouldUpdateAllKeysOrNone(t *testing.T) {
+	members := startCluster(t, 3, &staticProvider{})
+	ctx := context.Background()
+
+	// Run the transactions from a follower, so they're forwarded to the leader.
+	client, err := NewClient(members[1], codec.String{})
+	require.NoError(t, err)
+
+	require.NoError(t, client.CASMulti(ctx, []string{"a", "b"}, func(in []interface{}) ([]interface{}, bool, error) {
+		assert.Equal(t, []interface{}{nil, nil}, in)
+		return []interface{}{"a-0", "b-0"}, false, nil
+	}))
+
+	// Modify "b" while the transaction is running: the first attempt should be rejected.
+	attempts := 0
+	require.NoError(t, client.CASMulti(ctx, []string{"a", "b"}, func(in []interface{}) ([]interface{}, bool, error) {
+		attempts++
+		if attempts == 1 {
+			require.NoError(t, client.CAS(ctx, "b", func(interface{}) (interface{}, bool, error) {
+				return "b-1", false, nil
+			}))
+			return []interface{}{"a-1", nil}, true, nil
+		}
+		assert.Equal(t, []interface{}{"a-0", "b-1"}, in)
+		return []interface{}{"a-2", nil}, true, nil
+	}))
+	assert.Equal(t, 2, attempts)
+
+	for key, expected := range map[string]string{"a": "a-2", "b": "b-1"} {
+		value, err := client.Get(ctx, key)
+		require.NoError(t, err)
+		assert.Equal(t, expected, value)
+	}
+}
+
+func TestKV_WatchShouldNotifyUpdatesFromOtherMembers(t *testing.T) {
+	members := startCluster(t, 3, &staticProvider{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	writer, err := NewClient(members[0], codec.String{})
+	require.NoError(t, err)
+	watcher, err := NewClient(members[2], codec.String{})
+	require.NoError(t, err)
+
+	keyUpdates := make(chan string, 10)
+	prefixUpdates := make(chan string, 10)
+	go watcher.WatchKey(ctx, "prefix/key", func(value interface{}) bool {
+		keyUpdates <- value.(string)
+		return true
+	})
+	go watcher.WatchPrefix(ctx, "prefix/", func(key string, value interface{}) bool {
+		prefixUpdates <- key + "=" + value.(string)
+		return true
+	})
+
+	// Wait until the watchers are registered.
+	test.Poll(t, time.Second, 2, func() interface{} {
+		members[2].watchersMu.Lock()
+		defer members[2].watchersMu.Unlock()
+		return len(members[2].watchers) + len(members[2].prefixWatchers)
+	})
+
+	for _, update := range []struct{ key, value string }{{"prefix/key", "1"}, {"other", "ignored"}, {"prefix/other", "2"}} {
+		require.NoError(t, writer.CAS(ctx, update.key, func(interface{}) (interface{}, bool, error) {
+			return update.value, false, nil
+		}))
+	}
+
+	assert.Equal(t, "1", <-keyUpdates)
+	assert.Equal(t, "prefix/key=1", <-prefixUpdates)
+	assert.Equal(t, "prefix/other=2", <-prefixUpdates)
+}
+
+func TestKV_ShouldRestoreStateFromDisk(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	kv := startKV(t, "member-1", dir, 0, 1, &staticProvider{})
+	waitForLeader(t, kv)
+
+	client, err := NewClient(kv, codec.String{})
+	require.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		require.NoError(t, client.CAS(ctx, fmt.Sprintf("key-%d", i), func(interface{}) (interface{}, bool, error) {
+			return strconv.Itoa(i), false, nil
+		}))
+	}
+
+	// Take a snapshot, so that the state is restored from both the snapshot and the log.
+	require.NoError(t, kv.raft.Snapshot().Error())
+	require.NoError(t, client.CAS(ctx, "key-10", func(interface{}) (interface{}, bool, error) {
+		return "10", false, nil
+	}))
+
+	// Restart on the same port, so that the member keeps its address.
+	_, port, err := net.SplitHostPort(kv.advertiseAddr)
+	require.NoError(t, err)
+	bindPort, err := strconv.Atoi(port)
+	require.NoError(t, err)
+	require.NoError(t, services.StopAndAwaitTerminated(ctx, kv))
+
+	restarted := startKV(t, "member-1", dir, bindPort, 1, &staticProvider{})
+	waitForLeader(t, restarted)
+
+	client, err = NewClient(restarted, codec.String{})
+	require.NoError(t, err)
+	for i := 0; i <= 10; i++ {
+		value, err := client.Get(ctx, fmt.Sprintf("key-%d", i))
+		require.NoError(t, err)
+		assert.Equal(t, strconv.Itoa(i), value)
+	}
+}
+
+func TestKV_ShouldRequireClientCertificatesWithTLS(t *testing.T) {
+	caFile, certFile, keyFile := writeTestCertificates(t)
+	withTLS := func(cfg *Config) {
+		cfg.TLSEnabled = true
+		cfg.TLSRequireClientCert = true
+		cfg.TLS = dstls.ClientConfig{CAPath: caFile, CertPath: certFile, KeyPath: keyFile}
+	}
+
+	provider := &staticProvider{}
+	members := startCluster(t, 3, provider, withTLS)
+
+	// Requests of followers are forwarded to the leader over TLS.
+	client, err := NewClient(members[1], codec.String{})
+	require.NoError(t, err)
+	require.NoError(t, client.CAS(context.Background(), "key", func(interface{}) (interface{}, bool, error) {
+		return "value", false, nil
+	}))
+
+	value, err := client.Get(context.Background(), "key")
+	require.NoError(t, err)
+	assert.Equal(t, "value", value)
+
+	leader := members[0].advertiseAddr
+	deadline := time.Now().Add(5 * time.Second)
+
+	_, err = sendForwardRequest(leader, forwardRequest{Op: forwardOpStatus}, deadline, members[1].tlsConfig)
+	require.NoError(t, err)
+
+	// Requests without TLS, or without a client certificate, are rejected.
+	_, err = sendForwardRequest(leader, forwardRequest{Op: forwardOpStatus}, deadline, nil)
+	require.Error(t, err)
+	_, err = sendForwardRequest(leader, forwardRequest{Op: forwardOpStatus}, deadline, &tls.Config{RootCAs: members[1].tlsConfig.RootCAs})
+	require.Error(t, err)
+}
+
+func TestConfig_ValidateTLS(t *testing.T) {
+	cfg := Config{}
+	flagext.DefaultValues(&cfg)
+
+	cfg.TLSEnabled = true
+	require.ErrorIs(t, cfg.Validate(), errTLSCertMissing)
+
+	cfg.TLS.CertPath, cfg.TLS.KeyPath = "cert.pem", "key.pem"
+	require.NoError(t, cfg.Validate())
+
+	cfg.TLSRequireClientCert = true
+	require.ErrorIs(t, cfg.Validate(), errTLSCAMissing)
+
+	cfg.TLS.CAPath = "ca.pem"
+	require.NoError(t, cfg.Validate())
+}
+
+// startCluster starts the members of a new cluster, and waits until a leader is elected.
+// The leader is the first returned member.
+func startCluster(t *testing.T, size int, provider *staticProvider, opts ...func(*Config)) []*KV {
+	members := make([]*KV, 0, size)
+	for i := 0; i < size; i++ {
+		kv := startKV(t, fmt.Sprintf("member-%d", i), t.TempDir(), 0, size, provider, opts...)
+		provider.add(kv.advertiseAddr)
+		members = append(members, kv)
+	}
+
+	var leader *KV
+	test.Poll(t, 20*time.Second, true, func() interface{} {
+		for _, kv := range members {
+			if kv.raft.State() == hraft.Leader {
+				leader = kv
+				return true
+			}
+		}
+		return false
+	})
+
+	for i, kv := range members {
+		if kv == leader {
+			members[0], members[i] = members[i], members[0]
+		}
+	}
+	return members
+}
+
+func startKV(t *testing.T, id, dir string, port, bootstrapExpect int, provider *staticProvider, opts ...func(*Config)) *KV {
+	cfg := Config{}
+	flagext.DefaultValues(&cfg)
+	cfg.NodeID = id
+	cfg.BindAddr = "127.0.0.1"
+	cfg.BindPort = port
+	cfg.JoinMembers = []string{"dns+raft.test:7947"}
+	cfg.BootstrapExpect = bootstrapExpect
+	cfg.DiscoveryInterval = 100 * time.Millisecond
+	cfg.DataDir = dir
+	cfg.MaxCASRetries = 100
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	kv := NewKV(cfg, log.NewNopLogger(), provider, nil)
+	require.NoError(t, services.StartAndAwaitRunning(context.Background(), kv))
+	t.Cleanup(func() {
+		_ = services.StopAndAwaitTerminated(context.Background(), kv)
+	})
+	return kv
+}
+
+func waitForLeader(t *testing.T, kv *KV) {
+	test.Poll(t, 20*time.Second, hraft.Leader, func() interface{} {
+		return kv.raft.State()
+	})
+}
+
+// staticProvider is a DNSProvider returning the added addresses.
+type staticProvider struct {
+	mtx   sync.Mutex
+	addrs []string
+}
+
+func (p *staticProvider) add(addr string) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	p.addrs = append(p.addrs, addr)
+}
+
+func (p *staticProvider) Resolve(context.Context, []string) error {
+	return nil
+}
+
+func (p *staticProvider) Addresses() []string {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	return append([]string(nil), p.addrs...)
+}
+
+// writeTestCertificates writes a CA certificate, and a certificate for 127.0.0.1 signed by the CA
+// usable both as server and client certificate. It returns the paths of the CA, certificate and key.
+func writeTestCertificates(t *testing.T) (string, string, string) {
+	dir := t.TempDir()
+	writePEM := func(name, blockType string, data []byte) string {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: data}), 0o600))
+		return path
+	}
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "raft-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caCert, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, caKey.Public(), caKey)
+	require.NoError(t, err)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	cert, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "raft-member"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}, caTemplate, key.Public(), caKey)
+	require.NoError(t, err)
+	keyData, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	return writePEM("ca.pem", "CERTIFICATE", caCert), writePEM("cert.pem", "CERTIFICATE", cert), writePEM("key.pem", "EC PRIVATE KEY", keyData)
+}
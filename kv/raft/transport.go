@@ -0,0 +1,214 @@
+package raft
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	hraft "github.com/hashicorp/raft"
+)
+
+// Raft RPCs and requests forwarded to the leader share the same listener. The first byte
+// written on each connection tells them apart.
+const (
+	connTypeRaft    byte = 1
+	connTypeForward byte = 2
+
+	// connTypeReadTimeout is the maximum time to wait for the connection type byte.
+	connTypeReadTimeout = 10 * time.Second
+)
+
+const (
+	forwardOpApply  = "apply"
+	forwardOpGet    = "get"
+	forwardOpList   = "list"
+	forwardOpStatus = "status"
+)
+
+// forwardRequest is sent by a member to another one over a connTypeForward connection. Each
+// connection carries exactly one request and one response, both JSON encoded.
+type forwardRequest struct {
+	Op      string   `json:"op"`
+	Command *command `json:"command,omitempty"`
+	Key     string   `json:"key,omitempty"`
+	Prefix  string   `json:"prefix,omitempty"`
+}
+
+type forwardResponse struct {
+	Value   []byte   `json:"value,omitempty"`
+	Version uint64   `json:"version,omitempty"`
+	Keys    []string `json:"keys,omitempty"`
+
+	// Only set by status requests.
+	ID           string `json:"id,omitempty"`
+	Bootstrapped bool   `json:"bootstrapped,omitempty"`
+
+	Error           string `json:"error,omitempty"`
+	VersionMismatch bool   `json:"version_mismatch,omitempty"`
+}
+
+// streamLayer implements hraft.StreamLayer on top of a TCP listener, handing the
+// connTypeForward connections to a separate handler. If tlsConfig is set, the listener
+// must be a TLS listener, and connections to other members are dialed with TLS.
+type streamLayer struct {
+	listener  net.Listener
+	advertise net.Addr
+	tlsConfig *tls.Config
+	forward   func(conn net.Conn)
+	logger    log.Logger
+
+	raftConns chan net.Conn
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newStreamLayer(listener net.Listener, advertise net.Addr, tlsConfig *tls.Config, forward func(conn net.Conn), logger log.Logger) *streamLayer {
+	s := &streamLayer{
+		listener:  listener,
+		advertise: advertise,
+		tlsConfig: tlsConfig,
+		forward:   forward,
+		logger:    logger,
+		raftConns: make(chan net.Conn),
+		closed:    make(chan struct{}),
+	}
+	go s.acceptLoop()
+	return s
+}
+
+func (s *streamLayer) acceptLoop() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			select {
+			case <-s.closed:
+				return
+			default:
+			}
+
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			level.Warn(s.logger).Log("msg", "failed to accept connection", "err", err)
+			continue
+		}
+
+		go s.handleConn(conn)
+	}
+}
+
+func (s *streamLayer) handleConn(conn net.Conn) {
+	connType := []byte{0}
+
+	_ = conn.SetReadDeadline(time.Now().Add(connTypeReadTimeout))
+	if _, err := io.ReadFull(conn, connType); err != nil {
+		level.Debug(s.logger).Log("msg", "failed to read connection type", "remote", conn.RemoteAddr(), "err", err)
+		_ = conn.Close()
+		return
+	}
+	_ = conn.SetReadDeadline(time.Time{})
+
+	switch connType[0] {
+	case connTypeRaft:
+		select {
+		case s.raftConns <- conn:
+		case <-s.closed:
+			_ = conn.Close()
+		}
+	case connTypeForward:
+		s.forward(conn)
+	default:
+		level.Warn(s.logger).Log("msg", "unknown connection type", "remote", conn.RemoteAddr(), "type", connType[0])
+		_ = conn.Close()
+	}
+}
+
+// Accept implements net.Listener.
+func (s *streamLayer) Accept() (net.Conn, error) {
+	select {
+	case conn := <-s.raftConns:
+		return conn, nil
+	case <-s.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close implements net.Listener.
+func (s *streamLayer) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		close(s.closed)
+		err = s.listener.Close()
+	})
+	return err
+}
+
+// Addr implements net.Listener. It returns the advertised address, which is used by Raft
+// as the address of the local member.
+func (s *streamLayer) Addr() net.Addr {
+	return s.advertise
+}
+
+// Dial implements hraft.StreamLayer.
+func (s *streamLayer) Dial(address hraft.ServerAddress, timeout time.Duration) (net.Conn, error) {
+	return dial(string(address), connTypeRaft, timeout, s.tlsConfig)
+}
+
+// dial connects to the member at the given address, with TLS if tlsConfig is not nil.
+func dial(address string, connType byte, timeout time.Duration, tlsConfig *tls.Config) (net.Conn, error) {
+	var (
+		conn net.Conn
+		err  error
+	)
+	if tlsConfig != nil {
+		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: timeout}, "tcp", address, tlsConfig)
+	} else {
+		conn, err = net.DialTimeout("tcp", address, timeout)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := conn.Write([]byte{connType}); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// sendForwardRequest sends the request to the member at the given address, and waits for the response
+// until the deadline.
+func sendForwardRequest(address string, req forwardRequest, deadline time.Time, tlsConfig *tls.Config) (forwardResponse, error) {
+	var resp forwardResponse
+
+	conn, err := dial(address, connTypeForward, time.Until(deadline), tlsConfig)
+	if err != nil {
+		return resp, err
+	}
+	defer conn.Close()
+
+	if err := conn.SetDeadline(deadline); err != nil {
+		return resp, err
+	}
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return resp, fmt.Errorf("failed to send %s request to %s: %w", req.Op, address, err)
+	}
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return resp, fmt.Errorf("failed to read %s response from %s: %w", req.Op, address, err)
+	}
+
+	if resp.VersionMismatch {
+		return resp, errVersionMismatch
+	}
+	if resp.Error != "" {
+		return resp, fmt.Errorf("%s request to %s failed: %s", req.Op, address, resp.Error)
+	}
+	return resp, nil
+}
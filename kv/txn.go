@@ -0,0 +1,40 @@
+package kv
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrTxnNotSupported is returned when a multi-key CAS is attempted on a store which doesn't support it.
+var ErrTxnNotSupported = errors.New("the KV store doesn't support multi-key CAS")
+
+// TxnClient is an optional interface implemented by the Clients which can atomically
+// update multiple keys. Use CASMulti to run a multi-key CAS on any Client.
+type TxnClient interface {
+	Client
+
+	// CASMulti is a Compare-And-Swap of multiple keys. Will call provided callback
+	// f with the current values of the keys, in the same order as keys (nil for
+	// the keys which don't exist), and allow callback to return new values in the
+	// same order. A nil new value leaves the key unchanged. The new values are
+	// stored only if none of the keys has been modified meanwhile: either all of
+	// them are stored or none. If that doesn't succeed will try again - callback
+	// will be called again with new values etc. Callback can return nil to
+	// indicate it is happy with existing values.
+	//
+	// If the callback returns an error and true for retry, and the max number of
+	// attempts is not exceeded, the operation will be retried.
+	//
+	// Returns ErrTxnNotSupported if the underlying store doesn't support it.
+	CASMulti(ctx context.Context, keys []string, f func(in []interface{}) (out []interface{}, retry bool, err error)) error
+}
+
+// CASMulti runs a multi-key CAS with the client. Returns ErrTxnNotSupported if the
+// client doesn't implement TxnClient. See TxnClient.CASMulti.
+func CASMulti(ctx context.Context, client Client, keys []string, f func(in []interface{}) (out []interface{}, retry bool, err error)) error {
+	txnClient, ok := client.(TxnClient)
+	if !ok {
+		return ErrTxnNotSupported
+	}
+	return txnClient.CASMulti(ctx, keys, f)
+}
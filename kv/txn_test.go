@@ -0,0 +1,158 @@
+package kv
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/dskit/kv/codec"
+	"github.com/grafana/dskit/kv/consul"
+	"github.com/grafana/dskit/kv/kubernetes"
+)
+
+func TestCASMulti(t *testing.T) {
+	withFixtures(t, func(t *testing.T, client Client) {
+		if _, ok := client.(TxnClient); !ok {
+			require.ErrorIs(t, CASMulti(ctx, client, []string{"a", "b"}, nil), ErrTxnNotSupported)
+			return
+		}
+
+		// Create both keys.
+		err := CASMulti(ctx, client, []string{"a", "b"}, func(in []interface{}) ([]interface{}, bool, error) {
+			require.Equal(t, []interface{}{nil, nil}, in)
+			return []interface{}{"a-0", "b-0"}, true, nil
+		})
+		require.NoError(t, err)
+
+		// Only update one of the keys.
+		err = CASMulti(ctx, client, []string{"a", "b"}, func(in []interface{}) ([]interface{}, bool, error) {
+			require.Equal(t, []interface{}{"a-0", "b-0"}, in)
+			return []interface{}{nil, "b-1"}, true, nil
+		})
+		require.NoError(t, err)
+
+		// Don't update any key.
+		err = CASMulti(ctx, client, []string{"b", "a"}, func(in []interface{}) ([]interface{}, bool, error) {
+			require.Equal(t, []interface{}{"b-1", "a-0"}, in)
+			return nil, false, nil
+		})
+		require.NoError(t, err)
+
+		for key, expected := range map[string]string{"a": "a-0", "b": "b-1"} {
+			value, err := client.Get(ctx, key)
+			require.NoError(t, err)
+			assert.Equal(t, expected, value)
+		}
+
+		// The callback must return a value for each key.
+		err = CASMulti(ctx, client, []string{"a", "b"}, func([]interface{}) ([]interface{}, bool, error) {
+			return []interface{}{"a-2"}, false, nil
+		})
+		require.Error(t, err)
+	})
+}
+
+func TestCASMulti_ShouldBeConsistentOnConcurrentUpdates(t *testing.T) {
+	const concurrency = 10
+
+	withFixtures(t, func(t *testing.T, client Client) {
+		if _, ok := client.(TxnClient); !ok {
+			t.Skip("the client doesn't support multi-key CAS")
+		}
+
+		wg := sync.WaitGroup{}
+		wg.Add(concurrency)
+
+		// Each update increments one counter and decrements the other one, so their sum should stay 0.
+		for i := 0; i < concurrency; i++ {
+			go func() {
+				defer wg.Done()
+
+				err := CASMulti(ctx, client, []string{"inc", "dec"}, func(in []interface{}) ([]interface{}, bool, error) {
+					out := make([]interface{}, len(in))
+					for j, delta := range []int{1, -1} {
+						value := 0
+						if in[j] != nil {
+							var err error
+							if value, err = strconv.Atoi(in[j].(string)); err != nil {
+								return nil, false, err
+							}
+						}
+						out[j] = strconv.Itoa(value + delta)
+					}
+					return out, true, nil
+				})
+				assert.NoError(t, err)
+			}()
+		}
+		wg.Wait()
+
+		for key, expected := range map[string]string{"inc": strconv.Itoa(concurrency), "dec": strconv.Itoa(-concurrency)} {
+			value, err := client.Get(ctx, key)
+			require.NoError(t, err)
+			assert.Equal(t, expected, value)
+		}
+	})
+}
+
+func TestCASMulti_ShouldBeForwardedByPrefixAndMetricsClients(t *testing.T) {
+	backend, closer := consul.NewInMemoryClient(codec.String{}, log.NewNopLogger(), nil)
+	t.Cleanup(func() { _ = closer.Close() })
+
+	reg := prometheus.NewPedanticRegistry()
+	client := newMetricsClient("consul", PrefixClient(backend, "prefix/"), reg)
+
+	require.NoError(t, CASMulti(context.Background(), client, []string{"a", "b"}, func([]interface{}) ([]interface{}, bool, error) {
+		return []interface{}{"a", "b"}, false, nil
+	}))
+
+	keys, err := backend.List(context.Background(), "")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"prefix/a", "prefix/b"}, keys)
+
+	require.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(`
+		# HELP kv_request_duration_seconds Time spent on kv store requests.
+		# TYPE kv_request_duration_seconds histogram
+		kv_request_duration_seconds_count{operation="CASMulti",status_code="200",type="consul"} 1
+	`), "kv_request_duration_seconds_count"))
+
+	t.Run("should return ErrTxnNotSupported if the backend doesn't support multi-key CAS", func(t *testing.T) {
+		backend, closer := kubernetes.NewInMemoryClient(codec.String{}, log.NewNopLogger())
+		t.Cleanup(func() { _ = closer.Close() })
+
+		client := newMetricsClient("kubernetes", PrefixClient(backend, "prefix/"), prometheus.NewPedanticRegistry())
+		require.ErrorIs(t, CASMulti(context.Background(), client, []string{"a"}, nil), ErrTxnNotSupported)
+	})
+}
+
+func TestMultiClient_CASMultiShouldMirrorUpdatedValues(t *testing.T) {
+	primary, primaryCloser := consul.NewInMemoryClient(codec.String{}, log.NewNopLogger(), nil)
+	t.Cleanup(func() { _ = primaryCloser.Close() })
+	secondary, secondaryCloser := consul.NewInMemoryClient(codec.String{}, log.NewNopLogger(), nil)
+	t.Cleanup(func() { _ = secondaryCloser.Close() })
+
+	mc := NewMultiClient(MultiConfig{MirrorEnabled: true}, []kvclient{{client: primary, name: "primary"}, {client: secondary, name: "secondary"}}, log.NewNopLogger(), nil)
+	t.Cleanup(mc.cancel)
+
+	require.NoError(t, mc.CASMulti(context.Background(), []string{"a", "b"}, func([]interface{}) ([]interface{}, bool, error) {
+		return []interface{}{"a", nil}, false, nil
+	}))
+
+	for _, client := range []Client{primary, secondary} {
+		value, err := client.Get(context.Background(), "a")
+		require.NoError(t, err)
+		assert.Equal(t, "a", value)
+
+		value, err = client.Get(context.Background(), "b")
+		require.NoError(t, err)
+		assert.Nil(t, value)
+	}
+}
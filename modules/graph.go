@@ -0,0 +1,173 @@
+package modules
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// DependencyGraph describes the registered modules and their dependencies, as returned by
+// Manager.DependencyGraph.
+type DependencyGraph struct {
+	Modules []ModuleNode `json:"modules"`
+
+	// Targets the graph has been computed for, if any. Only the targets and their
+	// transitive dependencies are included in the graph.
+	Targets []string `json:"targets,omitempty"`
+
+	// Order in which the modules would be initialised and started, or stopped, for the targets.
+	StartOrder []string `json:"start_order,omitempty"`
+	StopOrder  []string `json:"stop_order,omitempty"`
+}
+
+// ModuleNode is a module of the DependencyGraph.
+type ModuleNode struct {
+	Name        string `json:"name"`
+	UserVisible bool   `json:"user_visible"`
+	Targetable  bool   `json:"targetable"`
+
+	// Whether the module is one of the targets of the graph.
+	Target bool `json:"target,omitempty"`
+
+	// Direct dependencies of the module, sorted by name.
+	Dependencies []string `json:"dependencies"`
+}
+
+// DependencyGraph returns the graph of registered modules. If targets are given, the graph only
+// includes the targets and their transitive dependencies, and the order in which the modules would be
+// started and stopped by InitModuleServices for the targets. Init functions are not called.
+func (m *Manager) DependencyGraph(targets ...string) (*DependencyGraph, error) {
+	graph := &DependencyGraph{}
+
+	var names []string
+	if len(targets) == 0 {
+		for name := range m.modules {
+			names = append(names, name)
+		}
+	} else {
+		var err error
+		if graph.StartOrder, err = m.StartOrder(targets...); err != nil {
+			return nil, err
+		}
+		if graph.StopOrder, err = m.StopOrder(targets...); err != nil {
+			return nil, err
+		}
+		graph.Targets = targets
+		names = append(names, graph.StartOrder...)
+	}
+	sort.Strings(names)
+
+	isTarget := map[string]bool{}
+	for _, t := range targets {
+		isTarget[t] = true
+	}
+
+	for _, name := range names {
+		mod := m.modules[name]
+
+		deps := make([]string, 0, len(mod.deps))
+		seen := map[string]bool{}
+		for _, d := range mod.deps {
+			if !seen[d] {
+				seen[d] = true
+				deps = append(deps, d)
+			}
+		}
+		sort.Strings(deps)
+
+		graph.Modules = append(graph.Modules, ModuleNode{
+			Name:         name,
+			UserVisible:  mod.userVisible,
+			Targetable:   mod.targetable,
+			Target:       isTarget[name],
+			Dependencies: deps,
+		})
+	}
+
+	return graph, nil
+}
+
+// WriteDOT writes the graph in the Graphviz DOT format. Edges point from a module to its dependencies.
+// User visible modules are drawn with solid borders, user invisible but targetable modules with
+// dashed borders, and other user invisible modules with dotted borders. Targets are drawn in bold.
+func (g *DependencyGraph) WriteDOT(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+
+	fmt.Fprintln(bw, "digraph modules {")
+	fmt.Fprintln(bw, "  rankdir=BT;")
+	fmt.Fprintln(bw, "  node [shape=box];")
+
+	for _, mod := range g.Modules {
+		var style []string
+		switch {
+		case mod.UserVisible:
+			style = append(style, "solid")
+		case mod.Targetable:
+			style = append(style, "dashed")
+		default:
+			style = append(style, "dotted")
+		}
+		if mod.Target {
+			style = append(style, "bold")
+		}
+		fmt.Fprintf(bw, "  %q [style=%q];\n", mod.Name, strings.Join(style, ","))
+	}
+
+	for _, mod := range g.Modules {
+		for _, d := range mod.Dependencies {
+			fmt.Fprintf(bw, "  %q -> %q;\n", mod.Name, d)
+		}
+	}
+
+	fmt.Fprintln(bw, "}")
+	return bw.Flush()
+}
+
+// DependencyGraphHandler returns a http.Handler serving the dependency graph of the modules.
+// The graph is returned as JSON, or in the DOT format if the "format" query parameter is "dot".
+// The graph can be restricted to the modules started for some targets with one or more "target"
+// query parameters, each one possibly a comma-separated list of modules.
+func (m *Manager) DependencyGraphHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if err := req.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		var targets []string
+		for _, v := range req.Form["target"] {
+			for _, t := range strings.Split(v, ",") {
+				if t = strings.TrimSpace(t); t != "" {
+					targets = append(targets, t)
+				}
+			}
+		}
+
+		graph, err := m.DependencyGraph(targets...)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		switch format := req.Form.Get("format"); format {
+		case "dot":
+			w.Header().Set("Content-Type", "text/vnd.graphviz")
+			if err := graph.WriteDOT(w); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+			}
+
+		case "", "json":
+			w.Header().Set("Content-Type", "application/json")
+			if err := json.NewEncoder(w).Encode(graph); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+			}
+
+		default:
+			http.Error(w, fmt.Sprintf("unknown format: %s", format), http.StatusBadRequest)
+		}
+	})
+}
@@ -0,0 +1,103 @@
+package modules
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newGraphTestManager(t *testing.T) *Manager {
+	mm := NewManager(log.NewNopLogger())
+	mm.RegisterModule("server", mockInitFunc, UserInvisibleModule)
+	mm.RegisterModule("ring", mockInitFunc, UserInvisibleTargetableModule)
+	mm.RegisterModule("distributor", mockInitFunc)
+	mm.RegisterModule("querier", mockInitFunc)
+	require.NoError(t, mm.AddDependency("ring", "server"))
+	require.NoError(t, mm.AddDependency("distributor", "ring", "server"))
+	require.NoError(t, mm.AddDependency("querier", "server"))
+	return mm
+}
+
+func TestManager_DependencyGraph(t *testing.T) {
+	mm := newGraphTestManager(t)
+
+	graph, err := mm.DependencyGraph()
+	require.NoError(t, err)
+	assert.Equal(t, &DependencyGraph{
+		Modules: []ModuleNode{
+			{Name: "distributor", UserVisible: true, Targetable: true, Dependencies: []string{"ring", "server"}},
+			{Name: "querier", UserVisible: true, Targetable: true, Dependencies: []string{"server"}},
+			{Name: "ring", UserVisible: false, Targetable: true, Dependencies: []string{"server"}},
+			{Name: "server", UserVisible: false, Targetable: false, Dependencies: []string{}},
+		},
+	}, graph)
+
+	graph, err = mm.DependencyGraph("distributor")
+	require.NoError(t, err)
+	assert.Equal(t, &DependencyGraph{
+		Modules: []ModuleNode{
+			{Name: "distributor", UserVisible: true, Targetable: true, Target: true, Dependencies: []string{"ring", "server"}},
+			{Name: "ring", UserVisible: false, Targetable: true, Dependencies: []string{"server"}},
+			{Name: "server", UserVisible: false, Targetable: false, Dependencies: []string{}},
+		},
+		Targets:    []string{"distributor"},
+		StartOrder: []string{"server", "ring", "distributor"},
+		StopOrder:  []string{"distributor", "ring", "server"},
+	}, graph)
+
+	_, err = mm.DependencyGraph("unknown")
+	assert.EqualError(t, err, "unrecognised module name: unknown")
+}
+
+func TestDependencyGraph_WriteDOT(t *testing.T) {
+	graph, err := newGraphTestManager(t).DependencyGraph("distributor")
+	require.NoError(t, err)
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, graph.WriteDOT(buf))
+	assert.Equal(t, `digraph modules {
+  rankdir=BT;
+  node [shape=box];
+  "distributor" [style="solid,bold"];
+  "ring" [style="dashed"];
+  "server" [style="dotted"];
+  "distributor" -> "ring";
+  "distributor" -> "server";
+  "ring" -> "server";
+}
+`, buf.String())
+}
+
+func TestManager_DependencyGraphHandler(t *testing.T) {
+	handler := newGraphTestManager(t).DependencyGraphHandler()
+
+	serve := func(url string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, url, nil))
+		return rec
+	}
+
+	rec := serve("/?target=querier&target=distributor,ring")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+	graph := DependencyGraph{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &graph))
+	assert.Equal(t, []string{"querier", "distributor", "ring"}, graph.Targets)
+	assert.Equal(t, []string{"server", "querier", "ring", "distributor"}, graph.StartOrder)
+	assert.Len(t, graph.Modules, 4)
+
+	rec = serve("/?format=dot")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "text/vnd.graphviz", rec.Header().Get("Content-Type"))
+	assert.Contains(t, rec.Body.String(), `"querier" -> "server";`)
+
+	assert.Equal(t, http.StatusBadRequest, serve("/?target=unknown").Code)
+	assert.Equal(t, http.StatusBadRequest, serve("/?format=xml").Code)
+}
@@ -3,6 +3,7 @@ package modules
 import (
 	"fmt"
 	"sort"
+	"strings"
 
 	"github.com/go-kit/log"
 	"github.com/pkg/errors"
@@ -86,10 +87,8 @@ func (m *Manager) AddDependency(name string, dependsOn ...string) error {
 			return fmt.Errorf("no such module: %s", newDep)
 		}
 
-		for _, prevDep := range m.DependenciesForModule(newDep) {
-			if prevDep == name {
-				return fmt.Errorf("found a circular dependency: %s depends on %s", newDep, name)
-			}
+		if path := m.dependencyPath(newDep, name); path != nil {
+			return fmt.Errorf("found a circular dependency: %s", strings.Join(append([]string{name}, path...), " -> "))
 		}
 	}
 
@@ -101,11 +100,14 @@ func (m *Manager) AddDependency(name string, dependsOn ...string) error {
 // in the right order. Modules are wrapped in such a way that they start after their
 // dependencies have been started and stop before their dependencies are stopped.
 func (m *Manager) InitModuleServices(modules ...string) (map[string]services.Service, error) {
-	servicesMap := map[string]services.Service{}
-	initMap := map[string]bool{}
+	order, err := m.StartOrder(modules...)
+	if err != nil {
+		return nil, err
+	}
 
-	for _, module := range modules {
-		if err := m.initModule(module, initMap, servicesMap); err != nil {
+	servicesMap := map[string]services.Service{}
+	for _, n := range order {
+		if err := m.initModule(n, servicesMap); err != nil {
 			return nil, err
 		}
 	}
@@ -113,46 +115,64 @@ func (m *Manager) InitModuleServices(modules ...string) (map[string]services.Ser
 	return servicesMap, nil
 }
 
-func (m *Manager) initModule(name string, initMap map[string]bool, servicesMap map[string]services.Service) error {
-	if _, ok := m.modules[name]; !ok {
-		return fmt.Errorf("unrecognised module name: %s", name)
+func (m *Manager) initModule(name string, servicesMap map[string]services.Service) error {
+	mod := m.modules[name]
+	if mod.initFn == nil {
+		return nil
 	}
 
-	// initialize all of our dependencies first
-	deps := m.orderedDeps(name)
-	deps = append(deps, name) // lastly, initialize the requested module
+	s, err := mod.initFn()
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("error initialising module: %s", name))
+	}
 
-	for _, n := range deps {
-		// Skip already initialized modules
-		if initMap[n] {
-			continue
-		}
+	if s != nil {
+		// We pass servicesMap, which isn't yet complete. By the time service starts,
+		// it will be fully built, so there is no need for extra synchronization.
+		servicesMap[name] = newModuleServiceWrapper(servicesMap, name, m.logger, s, m.DependenciesForModule(name), m.inverseDependenciesForModule(name))
+	}
 
-		mod := m.modules[n]
+	return nil
+}
 
-		var serv services.Service
+// StartOrder returns the modules initialised by InitModuleServices for the given modules,
+// in the order they are initialised: each module comes after all of its dependencies.
+// Init functions are not called. Modules are started in the same order, although
+// modules not depending on each other may start concurrently.
+func (m *Manager) StartOrder(modules ...string) ([]string, error) {
+	var result []string
+	added := map[string]bool{}
 
-		if mod.initFn != nil {
-			s, err := mod.initFn()
-			if err != nil {
-				return errors.Wrap(err, fmt.Sprintf("error initialising module: %s", n))
-			}
+	for _, name := range modules {
+		if _, ok := m.modules[name]; !ok {
+			return nil, fmt.Errorf("unrecognised module name: %s", name)
+		}
 
-			if s != nil {
-				// We pass servicesMap, which isn't yet complete. By the time service starts,
-				// it will be fully built, so there is no need for extra synchronization.
-				serv = newModuleServiceWrapper(servicesMap, n, m.logger, s, m.DependenciesForModule(n), m.inverseDependenciesForModule(n))
+		// all of our dependencies first, lastly the requested module
+		for _, n := range append(m.orderedDeps(name), name) {
+			if !added[n] {
+				added[n] = true
+				result = append(result, n)
 			}
 		}
+	}
 
-		if serv != nil {
-			servicesMap[n] = serv
-		}
+	return result, nil
+}
 
-		initMap[n] = true
+// StopOrder returns the modules initialised by InitModuleServices for the given modules,
+// in the order they are stopped: each module comes before all of its dependencies.
+// Modules not depending on each other may stop concurrently.
+func (m *Manager) StopOrder(modules ...string) ([]string, error) {
+	result, err := m.StartOrder(modules...)
+	if err != nil {
+		return nil, err
 	}
 
-	return nil
+	for i, j := 0, len(result)-1; i < j; i, j = i+1, j-1 {
+		result[i], result[j] = result[j], result[i]
+	}
+	return result, nil
 }
 
 // UserVisibleModuleNames gets list of module names that are
@@ -210,6 +230,36 @@ func (m *Manager) listDeps(mod string) []string {
 	return deps
 }
 
+// dependencyPath returns the shortest chain of dependencies from module from to module to,
+// starting with from and ending with to, or nil if from doesn't depend on to.
+func (m *Manager) dependencyPath(from, to string) []string {
+	prev := map[string]string{from: ""}
+	queue := []string{from}
+
+	for len(queue) > 0 {
+		n := queue[0]
+		queue = queue[1:]
+
+		if n == to {
+			path := []string{n}
+			for n != from {
+				n = prev[n]
+				path = append([]string{n}, path...)
+			}
+			return path
+		}
+
+		for _, d := range m.modules[n].deps {
+			if _, seen := prev[d]; !seen {
+				prev[d] = n
+				queue = append(queue, d)
+			}
+		}
+	}
+
+	return nil
+}
+
 // orderedDeps gets a list of all dependencies ordered so that items are always after any of their dependencies.
 func (m *Manager) orderedDeps(mod string) []string {
 	deps := m.listDeps(mod)
@@ -224,10 +274,17 @@ func (m *Manager) orderedDeps(mod string) []string {
 
 	// keep looping through all modules until they have all been added to the result.
 
+	// sort names, so that the order is stable across calls.
+	names := make([]string, 0, len(uniq))
+	for name := range uniq {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
 	for len(result) < len(uniq) {
 	OUTER:
-		for name, added := range uniq {
-			if added {
+		for _, name := range names {
+			if uniq[name] {
 				continue
 			}
 			for _, dep := range m.modules[name].deps {
@@ -436,3 +436,61 @@ func getStopDependenciesForModule(module string, services map[string]services.Se
 	sort.Strings(deps)
 	return deps
 }
+
+func TestManager_AddDependency_ShouldReportCircularDependencyPath(t *testing.T) {
+	mm := NewManager(log.NewNopLogger())
+	for _, name := range []string{"serviceA", "serviceB", "serviceC"} {
+		mm.RegisterModule(name, mockInitFunc)
+	}
+	require.NoError(t, mm.AddDependency("serviceA", "serviceB"))
+	require.NoError(t, mm.AddDependency("serviceB", "serviceC"))
+
+	assert.EqualError(t, mm.AddDependency("serviceC", "serviceA"), "found a circular dependency: serviceC -> serviceA -> serviceB -> serviceC")
+	assert.EqualError(t, mm.AddDependency("serviceA", "serviceA"), "found a circular dependency: serviceA -> serviceA")
+
+	// Rejected dependencies are not added.
+	assert.Equal(t, []string{"serviceB", "serviceC"}, mm.DependenciesForModule("serviceA"))
+	assert.Empty(t, mm.DependenciesForModule("serviceC"))
+}
+
+func TestManager_StartOrder(t *testing.T) {
+	initialised := 0
+	initFn := func() (services.Service, error) {
+		initialised++
+		return services.NewIdleService(nil, nil), nil
+	}
+
+	mm := NewManager(log.NewNopLogger())
+	for _, name := range []string{"server", "ring", "store", "distributor", "querier", "all"} {
+		mm.RegisterModule(name, initFn)
+	}
+	require.NoError(t, mm.AddDependency("ring", "server"))
+	require.NoError(t, mm.AddDependency("store", "server"))
+	require.NoError(t, mm.AddDependency("distributor", "ring"))
+	require.NoError(t, mm.AddDependency("querier", "store", "ring"))
+	require.NoError(t, mm.AddDependency("all", "querier", "distributor"))
+
+	order, err := mm.StartOrder("querier")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"server", "store", "ring", "querier"}, order)
+
+	order, err = mm.StartOrder("distributor", "querier")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"server", "ring", "distributor", "store", "querier"}, order)
+
+	order, err = mm.StopOrder("all")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"all", "querier", "distributor", "ring", "store", "server"}, order)
+
+	_, err = mm.StartOrder("querier", "unknown")
+	assert.EqualError(t, err, "unrecognised module name: unknown")
+
+	// Init functions are not called.
+	assert.Equal(t, 0, initialised)
+
+	// InitModuleServices initialises the modules in the same order.
+	svcs, err := mm.InitModuleServices("querier")
+	require.NoError(t, err)
+	assert.Len(t, svcs, 4)
+	assert.Equal(t, 4, initialised)
+}
@@ -25,7 +25,8 @@ type BasicLifecyclerDelegate interface {
 	OnRingInstanceRegister(lifecycler *BasicLifecycler, ringDesc Desc, instanceExists bool, instanceID string, instanceDesc InstanceDesc) (InstanceState, Tokens)
 
 	// OnRingInstanceTokens is called once the instance tokens are set and are
-	// stable within the ring (honoring the observe period, if set).
+	// stable within the ring (honoring the observe period, if set), and whenever
+	// the tokens are changed by OnRingInstanceHeartbeat.
 	OnRingInstanceTokens(lifecycler *BasicLifecycler, tokens Tokens)
 
 	// OnRingInstanceStopping is called while the lifecycler is stopping. The lifecycler
@@ -54,6 +55,11 @@ type BasicLifecyclerConfig struct {
 	TokensObservePeriod time.Duration
 	NumTokens           int
 
+	// Weight is the capacity weight of the instance, relative to the other instances in the ring.
+	// Instances with a higher weight own a proportionally larger part of the token space.
+	// Default value is 0, which means DefaultInstanceWeight.
+	Weight uint32
+
 	// If true lifecycler doesn't unregister instance from the ring when it's stopping. Default value is false,
 	// which means unregistering.
 	KeepInstanceInTheRingOnShutdown bool
@@ -104,6 +110,10 @@ type BasicLifecycler struct {
 
 // NewBasicLifecycler makes a new BasicLifecycler.
 func NewBasicLifecycler(cfg BasicLifecyclerConfig, ringName, ringKey string, store kv.Client, delegate BasicLifecyclerDelegate, logger log.Logger, reg prometheus.Registerer) (*BasicLifecycler, error) {
+	if cfg.Weight > MaxInstanceWeight {
+		return nil, fmt.Errorf("the instance weight %d is not valid: it must be lower than or equal to %d", cfg.Weight, MaxInstanceWeight)
+	}
+
 	tokenGenerator := cfg.RingTokenGenerator
 	if tokenGenerator == nil {
 		tokenGenerator = NewRandomTokenGenerator()
@@ -122,7 +132,7 @@ func NewBasicLifecycler(cfg BasicLifecyclerConfig, ringName, ringKey string, sto
 		tokenGenerator:                  tokenGenerator,
 	}
 
-	l.metrics.tokensToOwn.Set(float64(cfg.NumTokens))
+	l.metrics.tokensToOwn.Set(float64(l.GetTokensToOwn()))
 	l.BasicService = services.NewBasicService(l.starting, l.running, l.stopping)
 
 	return l, nil
@@ -140,6 +150,17 @@ func (l *BasicLifecycler) GetInstanceZone() string {
 	return l.cfg.Zone
 }
 
+// GetInstanceWeight returns the capacity weight of the instance, as configured.
+func (l *BasicLifecycler) GetInstanceWeight() uint32 {
+	return l.cfg.Weight
+}
+
+// GetTokensToOwn returns the number of tokens the instance should own, based on the configured
+// number of tokens and the instance weight.
+func (l *BasicLifecycler) GetTokensToOwn() int {
+	return WeightedTokensCount(l.cfg.NumTokens, l.cfg.Weight)
+}
+
 func (l *BasicLifecycler) GetState() InstanceState {
 	l.currState.RLock()
 	defer l.currState.RUnlock()
@@ -318,7 +339,7 @@ func (l *BasicLifecycler) registerInstance(ctx context.Context) error {
 		// Always overwrite the instance in the ring (even if already exists) because some properties
 		// may have changed (stated, tokens, zone, address) and even if they didn't the heartbeat at
 		// least did.
-		instanceDesc = ringDesc.AddIngester(l.cfg.ID, l.cfg.Addr, l.cfg.Zone, tokens, state, registeredAt, false, time.Time{})
+		instanceDesc = ringDesc.AddIngesterWithWeight(l.cfg.ID, l.cfg.Addr, l.cfg.Zone, tokens, state, registeredAt, false, time.Time{}, l.cfg.Weight)
 		return ringDesc, true, nil
 	})
 
@@ -371,7 +392,7 @@ func (l *BasicLifecycler) verifyTokens(ctx context.Context) bool {
 
 	err := l.updateInstance(ctx, func(r *Desc, i *InstanceDesc) bool {
 		// At this point, we should have the same tokens as we have registered before.
-		actualTokens, takenTokens := r.TokensFor(l.cfg.ID)
+		actualTokens := Tokens(r.Ingesters[l.cfg.ID].Tokens)
 
 		if actualTokens.Equals(l.GetTokens()) {
 			// Tokens have been verified. No need to change them.
@@ -380,10 +401,10 @@ func (l *BasicLifecycler) verifyTokens(ctx context.Context) bool {
 		}
 
 		// uh, oh... our tokens are not our anymore. Let's try new ones.
-		needTokens := l.cfg.NumTokens - len(actualTokens)
+		needTokens := l.GetTokensToOwn() - len(actualTokens)
 
 		level.Info(l.logger).Log("msg", "generating new tokens", "count", needTokens, "ring", l.ringName)
-		newTokens := l.tokenGenerator.GenerateTokens(needTokens, takenTokens)
+		newTokens := generateTokens(l.tokenGenerator, needTokens, l.cfg.Weight, r)
 
 		actualTokens = append(actualTokens, newTokens...)
 		sort.Sort(actualTokens)
@@ -445,7 +466,7 @@ func (l *BasicLifecycler) updateInstance(ctx context.Context, update func(*Desc,
 			// a resharding of tenants among instances: to guarantee query correctness we need to update the
 			// registration timestamp to current time.
 			registeredAt := time.Now()
-			instanceDesc = ringDesc.AddIngester(l.cfg.ID, l.cfg.Addr, l.cfg.Zone, l.GetTokens(), l.GetState(), registeredAt, false, time.Time{})
+			instanceDesc = ringDesc.AddIngesterWithWeight(l.cfg.ID, l.cfg.Addr, l.cfg.Zone, l.GetTokens(), l.GetState(), registeredAt, false, time.Time{}, l.cfg.Weight)
 		}
 
 		prevTimestamp := instanceDesc.Timestamp
@@ -478,6 +499,8 @@ func (l *BasicLifecycler) updateInstance(ctx context.Context, update func(*Desc,
 // heartbeat updates the instance timestamp within the ring. This function is guaranteed
 // to be called within the lifecycler main goroutine.
 func (l *BasicLifecycler) heartbeat(ctx context.Context) {
+	prevTokens := l.GetTokens()
+
 	err := l.updateInstance(ctx, func(r *Desc, i *InstanceDesc) bool {
 		l.delegate.OnRingInstanceHeartbeat(l, r, i)
 		i.Timestamp = time.Now().Unix()
@@ -490,6 +513,12 @@ func (l *BasicLifecycler) heartbeat(ctx context.Context) {
 	}
 
 	l.metrics.heartbeats.Inc()
+
+	// The delegate may have changed the tokens during the heartbeat (e.g. TokenRebalancerDelegate).
+	if tokens := l.GetTokens(); !tokens.Equals(prevTokens) {
+		l.metrics.tokensOwned.Set(float64(len(tokens)))
+		l.delegate.OnRingInstanceTokens(l, tokens)
+	}
 }
 
 // changeState of the instance within the ring. This function is guaranteed
@@ -3,10 +3,12 @@ package ring
 import (
 	"context"
 	"os"
+	"sort"
 	"time"
 
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 type LeaveOnStoppingDelegate struct {
@@ -152,7 +154,8 @@ func (d *AutoForgetDelegate) OnRingInstanceHeartbeat(lifecycler *BasicLifecycler
 	d.next.OnRingInstanceHeartbeat(lifecycler, ringDesc, instanceDesc)
 }
 
-// InstanceRegisterDelegate generates a new set of tokenCount tokens on instance register, and returns the registerState InstanceState.
+// InstanceRegisterDelegate generates a new set of tokenCount tokens (scaled by the lifecycler's instance weight)
+// on instance register, and returns the registerState InstanceState.
 type InstanceRegisterDelegate struct {
 	registerState InstanceState
 	tokenCount    int
@@ -172,8 +175,8 @@ func (d InstanceRegisterDelegate) OnRingInstanceRegister(l *BasicLifecycler, rin
 		tokens = instanceDesc.GetTokens()
 	}
 
-	takenTokens := ringDesc.GetTokens()
-	newTokens := l.GetTokenGenerator().GenerateTokens(d.tokenCount-len(tokens), takenTokens)
+	tokenCount := WeightedTokensCount(d.tokenCount, l.GetInstanceWeight())
+	newTokens := generateTokens(l.GetTokenGenerator(), tokenCount-len(tokens), l.GetInstanceWeight(), &ringDesc)
 
 	// Tokens sorting will be enforced by the parent caller.
 	tokens = append(tokens, newTokens...)
@@ -186,3 +189,163 @@ func (d InstanceRegisterDelegate) OnRingInstanceTokens(*BasicLifecycler, Tokens)
 func (d InstanceRegisterDelegate) OnRingInstanceStopping(*BasicLifecycler) {}
 
 func (d InstanceRegisterDelegate) OnRingInstanceHeartbeat(*BasicLifecycler, *Desc, *InstanceDesc) {}
+
+// TokenRebalancerDelegate moves the tokens of the instance to other instances of the same zone, in order to
+// bring the ownership spread of the ring within a target. At every heartbeat the rebalancing plan is computed
+// from the current ring, and up to a configured number of moves giving away tokens of this instance are started.
+//
+// Each instance only updates its own entry of the ring, so a token is handed off in three steps, each one done
+// in the heartbeat of an instance:
+//  1. the donor offers the token to the receiver, while still owning it;
+//  2. the receiver claims the token, adding it to its own tokens;
+//  3. the donor releases the token, once the receiver owns it.
+//
+// This way the token is always owned by at least an instance, and the BasicLifecycler notifies the change of the
+// tokens to the delegates of both instances (e.g. to persist them). All the instances of the ring are expected
+// to run this delegate, otherwise tokens offered to them are never claimed.
+//
+// The rebalancing is skipped while the ring is not stable, i.e. there are instances not ACTIVE or unhealthy,
+// or tokens being handed off.
+type TokenRebalancerDelegate struct {
+	next    BasicLifecyclerDelegate
+	logger  log.Logger
+	cfg     TokenRebalancerConfig
+	metrics *tokenRebalancerMetrics
+}
+
+func NewTokenRebalancerDelegate(cfg TokenRebalancerConfig, next BasicLifecyclerDelegate, ringName string, logger log.Logger, reg prometheus.Registerer) *TokenRebalancerDelegate {
+	return &TokenRebalancerDelegate{
+		next:    next,
+		logger:  logger,
+		cfg:     cfg,
+		metrics: newTokenRebalancerMetrics(ringName, reg),
+	}
+}
+
+func (d *TokenRebalancerDelegate) OnRingInstanceRegister(lifecycler *BasicLifecycler, ringDesc Desc, instanceExists bool, instanceID string, instanceDesc InstanceDesc) (InstanceState, Tokens) {
+	return d.next.OnRingInstanceRegister(lifecycler, ringDesc, instanceExists, instanceID, instanceDesc)
+}
+
+func (d *TokenRebalancerDelegate) OnRingInstanceTokens(lifecycler *BasicLifecycler, tokens Tokens) {
+	d.next.OnRingInstanceTokens(lifecycler, tokens)
+}
+
+func (d *TokenRebalancerDelegate) OnRingInstanceStopping(lifecycler *BasicLifecycler) {
+	d.next.OnRingInstanceStopping(lifecycler)
+}
+
+func (d *TokenRebalancerDelegate) OnRingInstanceHeartbeat(lifecycler *BasicLifecycler, ringDesc *Desc, instanceDesc *InstanceDesc) {
+	d.next.OnRingInstanceHeartbeat(lifecycler, ringDesc, instanceDesc)
+
+	if instanceDesc.State != ACTIVE {
+		return
+	}
+
+	instanceID := lifecycler.GetInstanceID()
+	d.releaseClaimedTokens(ringDesc, instanceDesc)
+	d.claimOfferedTokens(ringDesc, instanceID, instanceDesc)
+
+	// The instance in the ring could be outdated (e.g. it has been re-registered
+	// within the heartbeat), so we do plan on the instance being updated.
+	ringDesc.Ingesters[instanceID] = *instanceDesc
+
+	now := time.Now()
+	if err := ringDesc.IsReady(now, lifecycler.cfg.HeartbeatTimeout); err != nil {
+		level.Debug(d.logger).Log("msg", "skipping tokens rebalancing because the ring is not ready", "err", err)
+		return
+	}
+	if ringDesc.hasOfferedTokens() {
+		level.Debug(d.logger).Log("msg", "skipping tokens rebalancing because tokens are being handed off")
+		return
+	}
+
+	plan := PlanTokenRebalance(ringDesc, d.cfg.TargetSpread, 0)
+	d.metrics.plannedMoves.Set(float64(len(plan.Moves)))
+	d.metrics.ownershipSpread.Set(plan.SpreadBefore)
+
+	if d.cfg.DryRun {
+		return
+	}
+
+	offers := map[uint32]string{}
+	for _, move := range plan.Moves {
+		if len(offers) >= d.cfg.MaxMovesPerHeartbeat {
+			break
+		}
+		if move.From != instanceID {
+			continue
+		}
+
+		level.Info(d.logger).Log("msg", "offered token to another instance to rebalance the ring", "token", move.Token, "to", move.To, "zone", move.Zone)
+		offers[move.Token] = move.To
+	}
+
+	if len(offers) > 0 {
+		instanceDesc.OfferedTokens = offers
+	}
+}
+
+// releaseClaimedTokens removes from the instance the offered tokens which have been claimed by the receiving
+// instance, and cancels the offers which can't be claimed anymore because the receiving instance is not ACTIVE.
+func (d *TokenRebalancerDelegate) releaseClaimedTokens(ringDesc *Desc, instanceDesc *InstanceDesc) {
+	if len(instanceDesc.OfferedTokens) == 0 {
+		return
+	}
+
+	offers := map[uint32]string{}
+	released := map[uint32]bool{}
+	for token, to := range instanceDesc.OfferedTokens {
+		receiver, ok := ringDesc.Ingesters[to]
+		switch {
+		case ok && tokensContain(receiver.Tokens, token):
+			level.Info(d.logger).Log("msg", "released token claimed by another instance", "token", token, "to", to)
+			d.metrics.executedMoves.Inc()
+			released[token] = true
+		case !ok || receiver.State != ACTIVE:
+			level.Warn(d.logger).Log("msg", "cancelled token offer because the receiving instance is not ACTIVE", "token", token, "to", to)
+			d.metrics.failedMoves.Inc()
+		default:
+			offers[token] = to
+		}
+	}
+
+	if len(offers) == 0 {
+		offers = nil
+	}
+	instanceDesc.OfferedTokens = offers
+
+	if len(released) > 0 {
+		tokens := make([]uint32, 0, len(instanceDesc.Tokens))
+		for _, token := range instanceDesc.Tokens {
+			if !released[token] {
+				tokens = append(tokens, token)
+			}
+		}
+		instanceDesc.Tokens = tokens
+	}
+}
+
+// claimOfferedTokens adds to the instance the tokens offered to it by other instances, which still own them.
+func (d *TokenRebalancerDelegate) claimOfferedTokens(ringDesc *Desc, instanceID string, instanceDesc *InstanceDesc) {
+	var claimed []uint32
+	for donorID, donor := range ringDesc.Ingesters {
+		if donorID == instanceID {
+			continue
+		}
+
+		for token, to := range donor.OfferedTokens {
+			if to != instanceID || !tokensContain(donor.Tokens, token) || tokensContain(instanceDesc.Tokens, token) {
+				continue
+			}
+
+			level.Info(d.logger).Log("msg", "claimed token offered by another instance", "token", token, "from", donorID)
+			claimed = append(claimed, token)
+		}
+	}
+
+	if len(claimed) > 0 {
+		tokens := append(append(make([]uint32, 0, len(instanceDesc.Tokens)+len(claimed)), instanceDesc.Tokens...), claimed...)
+		sort.Sort(Tokens(tokens))
+		instanceDesc.Tokens = tokens
+	}
+}
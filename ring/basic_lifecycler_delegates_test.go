@@ -358,4 +358,26 @@ func TestInstanceRegisterDelegate_OnRingInstanceRegister(t *testing.T) {
 			require.NotContains(t, tokens, tok)
 		}
 	})
+	t.Run("weighted instance", func(t *testing.T) {
+		delegate := NewInstanceRegisterDelegate(ACTIVE, 128)
+
+		cfg := prepareBasicLifecyclerConfig()
+		cfg.HeartbeatPeriod = 100 * time.Millisecond
+		cfg.Weight = 2
+		lifecycler, store, err := prepareBasicLifecyclerWithDelegate(t, cfg, delegate)
+		require.NoError(t, err)
+
+		ctx := context.Background()
+		require.NoError(t, services.StartAndAwaitRunning(ctx, lifecycler))
+		t.Cleanup(func() {
+			assert.NoError(t, services.StopAndAwaitTerminated(ctx, lifecycler))
+		})
+
+		instanceDesc, ok := getInstanceFromStore(t, store, testInstanceID)
+		require.True(t, ok)
+		require.Equal(t, ACTIVE, instanceDesc.GetState())
+		require.Equal(t, uint32(2), instanceDesc.GetWeight())
+		require.Len(t, instanceDesc.GetTokens(), 2*tokenCount)
+		require.Equal(t, 2*cfg.NumTokens, lifecycler.GetTokensToOwn())
+	})
 }
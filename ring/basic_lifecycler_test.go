@@ -47,6 +47,18 @@ func TestBasicLifecycler_GetTokenGenerator(t *testing.T) {
 	}
 }
 
+func TestNewBasicLifecycler_ShouldFailOnInvalidWeight(t *testing.T) {
+	cfg := prepareBasicLifecyclerConfig()
+
+	cfg.Weight = MaxInstanceWeight
+	_, _, _, err := prepareBasicLifecycler(t, cfg)
+	require.NoError(t, err)
+
+	cfg.Weight = MaxInstanceWeight + 1
+	_, _, _, err = prepareBasicLifecycler(t, cfg)
+	require.Error(t, err)
+}
+
 func TestBasicLifecycler_RegisterOnStart(t *testing.T) {
 	tests := map[string]struct {
 		initialInstanceID   string
@@ -0,0 +1,164 @@
+// Command kvsnapshot exports the ring descriptors stored in a KV store to a JSON or YAML
+// snapshot, and imports a snapshot into a KV store. It can be used to migrate a ring between
+// KV stores, e.g. from Consul to memberlist:
+//
+//	kvsnapshot export -ring.store=consul -consul.hostname=consul:8500 -file=ring.yaml
+//	kvsnapshot import -ring.store=memberlist -memberlist.join=dns+memberlist:7946 -file=ring.yaml -dry-run
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/grafana/dskit/dns"
+	"github.com/grafana/dskit/kv"
+	"github.com/grafana/dskit/kv/codec"
+	"github.com/grafana/dskit/kv/memberlist"
+	"github.com/grafana/dskit/ring"
+	"github.com/grafana/dskit/services"
+)
+
+var codecs = map[string]codec.Codec{
+	"ring":           ring.GetCodec(),
+	"partition-ring": ring.GetPartitionRingCodec(),
+}
+
+type config struct {
+	KV         kv.Config
+	Memberlist memberlist.KVConfig
+
+	Codec     string
+	KeyPrefix string
+	Format    string
+	Raw       bool
+	File      string
+	DryRun    bool
+	ExitDelay time.Duration
+}
+
+func (cfg *config) registerFlags(f *flag.FlagSet, command string) {
+	cfg.KV.RegisterFlagsWithPrefix("", "collectors/", f)
+	cfg.Memberlist.RegisterFlags(f)
+
+	f.StringVar(&cfg.Codec, "codec", "ring", "Codec of the values: ring or partition-ring.")
+	f.StringVar(&cfg.KeyPrefix, "key-prefix", "", "Only the keys with this prefix are exported. The prefix is relative to -ring.prefix.")
+	f.StringVar(&cfg.Format, "format", ring.KVSnapshotFormatYAML, "Format of the snapshot: json or yaml.")
+
+	switch command {
+	case "export":
+		f.BoolVar(&cfg.Raw, "raw", false, "Store the values as encoded by the codec (snappy-compressed protobuf) instead of JSON.")
+		f.StringVar(&cfg.File, "file", "", "File the snapshot is written to. Defaults to the standard output.")
+	case "import":
+		f.StringVar(&cfg.File, "file", "", "File the snapshot is read from. Defaults to the standard input.")
+		f.BoolVar(&cfg.DryRun, "dry-run", false, "Print the changes without writing them.")
+		f.DurationVar(&cfg.ExitDelay, "exit-delay", 5*time.Second, "Time to wait before exiting, to let memberlist propagate the changes to the other members. Only used by the memberlist store.")
+	}
+}
+
+func main() {
+	logger := level.NewFilter(log.NewLogfmtLogger(log.NewSyncWriter(os.Stderr)), level.AllowInfo())
+
+	if len(os.Args) < 2 || (os.Args[1] != "export" && os.Args[1] != "import") {
+		fmt.Fprintln(os.Stderr, "Usage: kvsnapshot export|import [flags]")
+		os.Exit(2)
+	}
+	command := os.Args[1]
+
+	cfg := config{}
+	f := flag.NewFlagSet(command, flag.ExitOnError)
+	cfg.registerFlags(f, command)
+	_ = f.Parse(os.Args[2:])
+
+	if err := run(context.Background(), command, cfg, logger); err != nil {
+		level.Error(logger).Log("msg", command+" failed", "err", err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, command string, cfg config, logger log.Logger) error {
+	c, ok := codecs[cfg.Codec]
+	if !ok {
+		return fmt.Errorf("unsupported codec: %s", cfg.Codec)
+	}
+
+	if cfg.KV.Store == "memberlist" {
+		cfg.Memberlist.Codecs = []codec.Codec{c}
+		dnsProvider := dns.NewProvider(logger, prometheus.NewRegistry(), dns.GolangResolverType)
+		memberlistKV := memberlist.NewKVInitService(&cfg.Memberlist, logger, dnsProvider, prometheus.NewRegistry())
+		if err := services.StartAndAwaitRunning(ctx, memberlistKV); err != nil {
+			return err
+		}
+		defer func() {
+			if command == "import" && !cfg.DryRun {
+				time.Sleep(cfg.ExitDelay)
+			}
+			_ = services.StopAndAwaitTerminated(context.Background(), memberlistKV)
+		}()
+		cfg.KV.MemberlistKV = memberlistKV.GetMemberlistKV
+	}
+
+	client, err := kv.NewClient(cfg.KV, c, prometheus.NewRegistry(), logger)
+	if err != nil {
+		return err
+	}
+
+	if command == "export" {
+		return export(ctx, client, c, cfg)
+	}
+	return importSnapshot(ctx, client, c, cfg)
+}
+
+func export(ctx context.Context, client kv.Client, c codec.Codec, cfg config) error {
+	snapshot, err := ring.ExportKVSnapshot(ctx, client, c, cfg.KeyPrefix, cfg.Raw)
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if cfg.File != "" {
+		file, err := os.Create(cfg.File)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+	return snapshot.Write(w, cfg.Format)
+}
+
+func importSnapshot(ctx context.Context, client kv.Client, c codec.Codec, cfg config) error {
+	var r io.Reader = os.Stdin
+	if cfg.File != "" {
+		file, err := os.Open(cfg.File)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		r = file
+	}
+
+	snapshot, err := ring.ReadKVSnapshot(r, cfg.Format)
+	if err != nil {
+		return err
+	}
+
+	changes, err := ring.ImportKVSnapshot(ctx, client, c, snapshot, cfg.DryRun)
+	if err != nil {
+		return err
+	}
+	for _, change := range changes {
+		fmt.Printf("%s: %s\n", change.Key, change.Action)
+		if cfg.DryRun && change.Diff != "" {
+			fmt.Println(change.Diff)
+		}
+	}
+	return nil
+}
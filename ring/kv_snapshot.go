@@ -0,0 +1,278 @@
+package ring
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/gogo/protobuf/jsonpb"
+	"github.com/gogo/protobuf/proto"
+	"github.com/pkg/errors"
+	"github.com/pmezard/go-difflib/difflib"
+	"gopkg.in/yaml.v3"
+
+	"github.com/grafana/dskit/kv"
+	"github.com/grafana/dskit/kv/codec"
+)
+
+const (
+	// KVSnapshotFormatJSON is the JSON format of a KVSnapshot.
+	KVSnapshotFormatJSON = "json"
+
+	// KVSnapshotFormatYAML is the YAML format of a KVSnapshot.
+	KVSnapshotFormatYAML = "yaml"
+)
+
+// KVSnapshotAction is the action applied to a key when importing a KVSnapshot.
+type KVSnapshotAction string
+
+const (
+	KVSnapshotCreate    KVSnapshotAction = "create"
+	KVSnapshotUpdate    KVSnapshotAction = "update"
+	KVSnapshotUnchanged KVSnapshotAction = "unchanged"
+)
+
+var (
+	// kvSnapshotFactories are the factories of the values of the codecs which can be exported as JSON.
+	kvSnapshotFactories = map[string]func() proto.Message{
+		GetCodec().CodecID():              ProtoDescFactory,
+		GetPartitionRingCodec().CodecID(): PartitionRingDescFactory,
+	}
+
+	kvSnapshotMarshaler = jsonpb.Marshaler{OrigName: true, EmitDefaults: true, Indent: "  "}
+)
+
+// KVSnapshot is a dump of the keys of a KV store whose values are encoded with the same codec,
+// which can be used to migrate a ring from a KV store to another one.
+type KVSnapshot struct {
+	// Codec is the ID of the codec of the values.
+	Codec   string            `json:"codec"`
+	Entries []KVSnapshotEntry `json:"entries"`
+}
+
+// KVSnapshotEntry is a key of a KVSnapshot. Exactly one of Value and Raw is set.
+type KVSnapshotEntry struct {
+	Key string `json:"key"`
+
+	// Value is the value decoded by the codec, as JSON.
+	Value json.RawMessage `json:"value,omitempty"`
+
+	// Raw is the value as encoded by the codec, which is a snappy-compressed protobuf message
+	// for the ring codecs.
+	Raw []byte `json:"raw,omitempty"`
+}
+
+// KVSnapshotChange is the change of a key when importing a KVSnapshot.
+type KVSnapshotChange struct {
+	Key    string
+	Action KVSnapshotAction
+
+	// Diff is the unified diff between the current value and the value of the snapshot,
+	// empty if the value is unchanged.
+	Diff string
+}
+
+// ExportKVSnapshot reads all the keys with the given prefix from the client, whose values
+// must be encoded with the codec. If raw is false the values are stored as JSON, which is
+// only supported by the ring and partition ring codecs, otherwise they're stored as encoded
+// by the codec.
+func ExportKVSnapshot(ctx context.Context, client kv.Client, c codec.Codec, prefix string, raw bool) (*KVSnapshot, error) {
+	if !raw && kvSnapshotFactories[c.CodecID()] == nil {
+		return nil, fmt.Errorf("the values of codec %s can only be exported as raw values", c.CodecID())
+	}
+
+	keys, err := client.List(ctx, prefix)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list keys")
+	}
+
+	snapshot := &KVSnapshot{Codec: c.CodecID(), Entries: []KVSnapshotEntry{}}
+	for _, key := range keys {
+		value, err := client.Get(ctx, key)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to get key %s", key)
+		}
+		// The key may have been deleted since it was listed.
+		if value == nil {
+			continue
+		}
+
+		entry := KVSnapshotEntry{Key: key}
+		if raw {
+			entry.Raw, err = c.Encode(value)
+		} else {
+			var text string
+			if text, err = renderKVSnapshotValue(value); err == nil {
+				entry.Value = json.RawMessage(text)
+			}
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to encode key %s", key)
+		}
+		snapshot.Entries = append(snapshot.Entries, entry)
+	}
+	return snapshot, nil
+}
+
+// ImportKVSnapshot writes the values of the snapshot to the client, replacing the current ones.
+// The codec must be the codec of the snapshot. If dryRun is true nothing is written. Returns
+// the changes applied to each key, or that would be applied in case of dry run.
+func ImportKVSnapshot(ctx context.Context, client kv.Client, c codec.Codec, snapshot *KVSnapshot, dryRun bool) ([]KVSnapshotChange, error) {
+	if snapshot.Codec != c.CodecID() {
+		return nil, fmt.Errorf("the snapshot has been exported with codec %s, but codec %s is used", snapshot.Codec, c.CodecID())
+	}
+
+	changes := make([]KVSnapshotChange, 0, len(snapshot.Entries))
+	for _, entry := range snapshot.Entries {
+		// Decode the value of the snapshot once to validate it.
+		value, err := decodeKVSnapshotEntry(c, entry)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to decode key %s", entry.Key)
+		}
+		text, err := renderKVSnapshotValue(value)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to decode key %s", entry.Key)
+		}
+
+		change := KVSnapshotChange{Key: entry.Key}
+		err = client.CAS(ctx, entry.Key, func(in interface{}) (out interface{}, retry bool, err error) {
+			change.Action, change.Diff = KVSnapshotCreate, diffKVSnapshotValues("", text)
+			if in != nil {
+				current, err := renderKVSnapshotValue(in)
+				if err != nil {
+					return nil, false, err
+				}
+				if current == text {
+					change.Action, change.Diff = KVSnapshotUnchanged, ""
+				} else {
+					change.Action, change.Diff = KVSnapshotUpdate, diffKVSnapshotValues(current, text)
+				}
+			}
+			if dryRun || change.Action == KVSnapshotUnchanged {
+				return nil, false, nil
+			}
+
+			// The value returned to CAS may be modified by the client, so decode it again.
+			out, err = decodeKVSnapshotEntry(c, entry)
+			return out, true, err
+		})
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to import key %s", entry.Key)
+		}
+		changes = append(changes, change)
+	}
+	return changes, nil
+}
+
+// Write writes the snapshot in the given format.
+func (s *KVSnapshot) Write(w io.Writer, format string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	switch format {
+	case KVSnapshotFormatJSON:
+		_, err = w.Write(append(data, '\n'))
+		return err
+	case KVSnapshotFormatYAML:
+		// The values are converted from JSON, to get the same field names.
+		var doc interface{}
+		if err := json.Unmarshal(data, &doc); err != nil {
+			return err
+		}
+		enc := yaml.NewEncoder(w)
+		enc.SetIndent(2)
+		if err := enc.Encode(doc); err != nil {
+			return err
+		}
+		return enc.Close()
+	default:
+		return fmt.Errorf("unsupported snapshot format: %s", format)
+	}
+}
+
+// ReadKVSnapshot reads a snapshot in the given format.
+func ReadKVSnapshot(r io.Reader, format string) (*KVSnapshot, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	switch format {
+	case KVSnapshotFormatJSON:
+	case KVSnapshotFormatYAML:
+		var doc interface{}
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return nil, err
+		}
+		if data, err = json.Marshal(doc); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported snapshot format: %s", format)
+	}
+
+	snapshot := &KVSnapshot{}
+	if err := json.Unmarshal(data, snapshot); err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+// decodeKVSnapshotEntry returns the value of the entry, as returned by the codec.
+func decodeKVSnapshotEntry(c codec.Codec, entry KVSnapshotEntry) (interface{}, error) {
+	if entry.Raw != nil {
+		return c.Decode(entry.Raw)
+	}
+
+	factory := kvSnapshotFactories[c.CodecID()]
+	if factory == nil {
+		return nil, fmt.Errorf("the values of codec %s can only be imported from raw values", c.CodecID())
+	}
+	if len(entry.Value) == 0 {
+		return nil, errors.New("the entry has no value")
+	}
+
+	msg := factory()
+	if err := jsonpb.Unmarshal(bytes.NewReader(entry.Value), msg); err != nil {
+		return nil, err
+	}
+
+	// Round trip through the codec, to get the same value the codec returns when reading the key.
+	data, err := c.Encode(msg)
+	if err != nil {
+		return nil, err
+	}
+	return c.Decode(data)
+}
+
+// renderKVSnapshotValue returns the indented JSON of the value, used to store and compare values.
+func renderKVSnapshotValue(value interface{}) (string, error) {
+	msg, ok := value.(proto.Message)
+	if !ok {
+		// Values of other codecs are only compared, so any stable representation works.
+		return fmt.Sprintf("%#v", value), nil
+	}
+	return kvSnapshotMarshaler.MarshalToString(msg)
+}
+
+func diffKVSnapshotValues(current, snapshot string) string {
+	// An empty current value means that the key doesn't exist, so it has no lines.
+	var currentLines []string
+	if current != "" {
+		currentLines = difflib.SplitLines(current)
+	}
+
+	diff, _ := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        currentLines,
+		B:        difflib.SplitLines(snapshot),
+		FromFile: "current",
+		ToFile:   "snapshot",
+		Context:  3,
+	})
+	return strings.TrimSuffix(diff, "\n")
+}
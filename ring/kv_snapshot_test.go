@@ -0,0 +1,153 @@
+package ring
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/dskit/kv"
+	"github.com/grafana/dskit/kv/codec"
+	"github.com/grafana/dskit/kv/consul"
+)
+
+func TestKVSnapshot_ShouldMigrateKeysBetweenStores(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+
+	ringDesc := NewDesc()
+	ringDesc.AddIngester("ingester-1", "1.1.1.1:9095", "zone-a", []uint32{1, 100}, ACTIVE, now, false, time.Time{})
+	ringDesc.AddIngesterWithWeight("ingester-2", "2.2.2.2:9095", "zone-b", []uint32{50}, LEAVING, now, true, now, 2)
+
+	partitionDesc := NewPartitionRingDesc()
+	partitionDesc.AddPartition(1, PartitionActive, now)
+	partitionDesc.AddOrUpdateOwner("ingester-1", OwnerActive, 1, now)
+
+	for _, testData := range []struct {
+		codec codec.Codec
+		desc  interface{}
+	}{
+		{codec: GetCodec(), desc: ringDesc},
+		{codec: GetPartitionRingCodec(), desc: partitionDesc},
+	} {
+		for _, format := range []string{KVSnapshotFormatJSON, KVSnapshotFormatYAML} {
+			for _, raw := range []bool{false, true} {
+				t.Run(fmt.Sprintf("%s/%s/raw=%t", testData.codec.CodecID(), format, raw), func(t *testing.T) {
+					ctx := context.Background()
+					source := newKVSnapshotTestStore(t, testData.codec)
+					target := newKVSnapshotTestStore(t, testData.codec)
+
+					for _, key := range []string{"collectors/ring", "collectors/other", "ignored"} {
+						require.NoError(t, source.CAS(ctx, key, func(interface{}) (interface{}, bool, error) {
+							return testData.desc, false, nil
+						}))
+					}
+
+					snapshot, err := ExportKVSnapshot(ctx, source, testData.codec, "collectors/", raw)
+					require.NoError(t, err)
+
+					buf := bytes.Buffer{}
+					require.NoError(t, snapshot.Write(&buf, format))
+					read, err := ReadKVSnapshot(&buf, format)
+					require.NoError(t, err)
+					require.Equal(t, testData.codec.CodecID(), read.Codec)
+					require.Len(t, read.Entries, 2)
+
+					// The values are compared after the import, because the JSON of the values may be reformatted.
+					for i, entry := range snapshot.Entries {
+						assert.Equal(t, entry.Key, read.Entries[i].Key)
+						assert.Equal(t, entry.Raw, read.Entries[i].Raw)
+					}
+
+					// A dry run shouldn't write anything.
+					changes, err := ImportKVSnapshot(ctx, target, testData.codec, read, true)
+					require.NoError(t, err)
+					require.Len(t, changes, 2)
+					for _, change := range changes {
+						assert.Equal(t, KVSnapshotCreate, change.Action)
+						assert.Contains(t, change.Diff, "+++ snapshot")
+					}
+					keys, err := target.List(ctx, "")
+					require.NoError(t, err)
+					assert.Empty(t, keys)
+
+					changes, err = ImportKVSnapshot(ctx, target, testData.codec, read, false)
+					require.NoError(t, err)
+					keys = nil
+					for _, change := range changes {
+						assert.Equal(t, KVSnapshotCreate, change.Action)
+						keys = append(keys, change.Key)
+					}
+					assert.ElementsMatch(t, []string{"collectors/ring", "collectors/other"}, keys)
+
+					for _, key := range []string{"collectors/ring", "collectors/other"} {
+						value, err := target.Get(ctx, key)
+						require.NoError(t, err)
+						assert.Equal(t, testData.desc, value)
+					}
+
+					// Importing again shouldn't change anything.
+					changes, err = ImportKVSnapshot(ctx, target, testData.codec, read, false)
+					require.NoError(t, err)
+					for _, change := range changes {
+						assert.Equal(t, KVSnapshotUnchanged, change.Action)
+						assert.Empty(t, change.Diff)
+					}
+				})
+			}
+		}
+	}
+}
+
+func TestImportKVSnapshot_ShouldDiffUpdatedValues(t *testing.T) {
+	ctx := context.Background()
+	now := time.Unix(1700000000, 0)
+	store := newKVSnapshotTestStore(t, GetCodec())
+
+	desc := NewDesc()
+	desc.AddIngester("ingester-1", "1.1.1.1:9095", "zone-a", []uint32{1}, ACTIVE, now, false, time.Time{})
+	require.NoError(t, store.CAS(ctx, "ring", func(interface{}) (interface{}, bool, error) {
+		return desc, false, nil
+	}))
+
+	snapshot, err := ExportKVSnapshot(ctx, store, GetCodec(), "", false)
+	require.NoError(t, err)
+
+	// Update the stored value after the export.
+	desc.AddIngester("ingester-1", "1.1.1.1:9095", "zone-a", []uint32{1}, LEAVING, now, false, time.Time{})
+	require.NoError(t, store.CAS(ctx, "ring", func(interface{}) (interface{}, bool, error) {
+		return desc, false, nil
+	}))
+
+	changes, err := ImportKVSnapshot(ctx, store, GetCodec(), snapshot, true)
+	require.NoError(t, err)
+	require.Len(t, changes, 1)
+	assert.Equal(t, KVSnapshotUpdate, changes[0].Action)
+	assert.Contains(t, changes[0].Diff, `-      "state": "LEAVING",`)
+	assert.Contains(t, changes[0].Diff, `+      "state": "ACTIVE",`)
+
+	value, err := store.Get(ctx, "ring")
+	require.NoError(t, err)
+	assert.Equal(t, LEAVING, value.(*Desc).Ingesters["ingester-1"].State)
+}
+
+func TestKVSnapshot_ShouldRejectMismatchingCodecs(t *testing.T) {
+	ctx := context.Background()
+
+	_, err := ExportKVSnapshot(ctx, newKVSnapshotTestStore(t, codec.String{}), codec.String{}, "", false)
+	require.Error(t, err)
+
+	snapshot := &KVSnapshot{Codec: GetCodec().CodecID()}
+	_, err = ImportKVSnapshot(ctx, newKVSnapshotTestStore(t, GetPartitionRingCodec()), GetPartitionRingCodec(), snapshot, false)
+	require.Error(t, err)
+}
+
+func newKVSnapshotTestStore(t *testing.T, c codec.Codec) kv.Client {
+	store, closer := consul.NewInMemoryClient(c, log.NewNopLogger(), nil)
+	t.Cleanup(func() { assert.NoError(t, closer.Close()) })
+	return store
+}
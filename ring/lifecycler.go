@@ -32,6 +32,7 @@ type LifecyclerConfig struct {
 
 	// Config for the ingester lifecycle control
 	NumTokens        int           `yaml:"num_tokens" category:"advanced"`
+	Weight           uint32        `yaml:"weight" category:"experimental"`
 	HeartbeatPeriod  time.Duration `yaml:"heartbeat_period" category:"advanced"`
 	HeartbeatTimeout time.Duration `yaml:"heartbeat_timeout" category:"advanced"`
 	ObservePeriod    time.Duration `yaml:"observe_period" category:"advanced"`
@@ -79,6 +80,8 @@ func (cfg *LifecyclerConfig) RegisterFlagsWithPrefix(prefix string, f *flag.Flag
 	}
 
 	f.IntVar(&cfg.NumTokens, prefix+"num-tokens", 128, "Number of tokens for each ingester.")
+	cfg.Weight = DefaultInstanceWeight
+	f.Var((*uint32Value)(&cfg.Weight), prefix+"weight", "Relative capacity of this instance compared to the other instances in the ring. An instance with a higher weight owns a proportionally larger part of the token space: with the random token generator it gets num-tokens multiplied by the weight. Maximum value is "+strconv.Itoa(MaxInstanceWeight)+".")
 	f.DurationVar(&cfg.HeartbeatPeriod, prefix+"heartbeat-period", 5*time.Second, "Period at which to heartbeat to consul. 0 = disabled.")
 	f.DurationVar(&cfg.HeartbeatTimeout, prefix+"heartbeat-timeout", 1*time.Minute, "Heartbeat timeout after which instance is assumed to be unhealthy. 0 = disabled.")
 	f.DurationVar(&cfg.JoinAfter, prefix+"join-after", 0*time.Second, "Period to wait for a claim from another member; will join automatically after this.")
@@ -103,8 +106,28 @@ func (cfg *LifecyclerConfig) RegisterFlagsWithPrefix(prefix string, f *flag.Flag
 	f.BoolVar(&cfg.EnableInet6, prefix+"enable-inet6", false, "Enable IPv6 support. Required to make use of IP addresses from IPv6 interfaces.")
 }
 
+// uint32Value is a flag.Value for uint32 config options.
+type uint32Value uint32
+
+func (v *uint32Value) String() string {
+	return strconv.FormatUint(uint64(*v), 10)
+}
+
+func (v *uint32Value) Set(s string) error {
+	n, err := strconv.ParseUint(s, 10, 32)
+	if err != nil {
+		return err
+	}
+	*v = uint32Value(n)
+	return nil
+}
+
 // Validate checks the consistency of LifecyclerConfig, and fails if this cannot be achieved.
 func (cfg *LifecyclerConfig) Validate() error {
+	if cfg.Weight > MaxInstanceWeight {
+		return fmt.Errorf("the instance weight %d is not valid: it must be lower than or equal to %d", cfg.Weight, MaxInstanceWeight)
+	}
+
 	_, ok := cfg.RingTokenGenerator.(*SpreadMinimizingTokenGenerator)
 	if ok {
 		// If cfg.RingTokenGenerator is a SpreadMinimizingTokenGenerator, we must ensure that
@@ -390,6 +413,16 @@ func (i *Lifecycler) setTokens(tokens Tokens) {
 	}
 }
 
+// getWeight returns the capacity weight of the instance, as configured.
+func (i *Lifecycler) getWeight() uint32 {
+	return i.cfg.Weight
+}
+
+// getTokensToOwn returns the number of tokens the instance should own, based on its weight.
+func (i *Lifecycler) getTokensToOwn() int {
+	return WeightedTokensCount(i.cfg.NumTokens, i.getWeight())
+}
+
 func (i *Lifecycler) getRegisteredAt() time.Time {
 	i.stateMtx.RLock()
 	defer i.stateMtx.RUnlock()
@@ -689,11 +722,11 @@ func (i *Lifecycler) initRing(ctx context.Context) error {
 			// We use the tokens from the file only if it does not exist in the ring yet.
 			if len(tokensFromFile) > 0 {
 				level.Info(i.logger).Log("msg", "adding tokens from file", "num_tokens", len(tokensFromFile))
-				if len(tokensFromFile) >= i.cfg.NumTokens {
+				if len(tokensFromFile) >= i.getTokensToOwn() {
 					i.setState(ACTIVE)
 				}
 				ro, rots := i.GetReadOnlyState()
-				ringDesc.AddIngester(i.ID, i.Addr, i.Zone, tokensFromFile, i.GetState(), i.getRegisteredAt(), ro, rots)
+				ringDesc.AddIngesterWithWeight(i.ID, i.Addr, i.Zone, tokensFromFile, i.GetState(), i.getRegisteredAt(), ro, rots, i.getWeight())
 				i.setTokens(tokensFromFile)
 				return ringDesc, true, nil
 			}
@@ -701,7 +734,7 @@ func (i *Lifecycler) initRing(ctx context.Context) error {
 			// Either we are a new ingester, or consul must have restarted
 			level.Info(i.logger).Log("msg", "instance not found in ring, adding with no tokens", "ring", i.RingName)
 			ro, rots := i.GetReadOnlyState()
-			ringDesc.AddIngester(i.ID, i.Addr, i.Zone, []uint32{}, i.GetState(), i.getRegisteredAt(), ro, rots)
+			ringDesc.AddIngesterWithWeight(i.ID, i.Addr, i.Zone, []uint32{}, i.GetState(), i.getRegisteredAt(), ro, rots, i.getWeight())
 			return ringDesc, true, nil
 		}
 
@@ -730,21 +763,22 @@ func (i *Lifecycler) initRing(ctx context.Context) error {
 		// If the ingester fails to clean its ring entry up or unregister_on_shutdown=false, it can leave behind its
 		// ring state as LEAVING. Make sure to switch to the ACTIVE state.
 		if instanceDesc.State == LEAVING {
-			delta := i.cfg.NumTokens - len(tokens)
+			tokensToOwn := i.getTokensToOwn()
+			delta := tokensToOwn - len(tokens)
 			if delta > 0 {
 				// We need more tokens
 				level.Info(i.logger).Log("msg", "existing instance has too few tokens, adding difference",
-					"current_tokens", len(tokens), "desired_tokens", i.cfg.NumTokens)
-				newTokens := i.tokenGenerator.GenerateTokens(delta, ringDesc.GetTokens())
+					"current_tokens", len(tokens), "desired_tokens", tokensToOwn)
+				newTokens := generateTokens(i.tokenGenerator, delta, i.getWeight(), ringDesc)
 				tokens = append(tokens, newTokens...)
 				sort.Sort(tokens)
 			} else if delta < 0 {
 				// We have too many tokens
 				level.Info(i.logger).Log("msg", "existing instance has too many tokens, removing difference",
-					"current_tokens", len(tokens), "desired_tokens", i.cfg.NumTokens)
+					"current_tokens", len(tokens), "desired_tokens", tokensToOwn)
 				// Make sure we don't pick the N smallest tokens, since that would increase the chance of the instance receiving only smaller hashes.
 				rand.Shuffle(len(tokens), tokens.Swap)
-				tokens = tokens[0:i.cfg.NumTokens]
+				tokens = tokens[0:tokensToOwn]
 				sort.Sort(tokens)
 			}
 
@@ -792,20 +826,20 @@ func (i *Lifecycler) verifyTokens(ctx context.Context) bool {
 		ringDesc := GetOrCreateRingDesc(in)
 
 		// At this point, we should have the same tokens as we have registered before
-		ringTokens, takenTokens := ringDesc.TokensFor(i.ID)
+		ringTokens := Tokens(ringDesc.Ingesters[i.ID].Tokens)
 
 		if !i.compareTokens(ringTokens) {
 			// uh, oh... our tokens are not ours anymore. Let's try new ones.
-			needTokens := i.cfg.NumTokens - len(ringTokens)
+			needTokens := i.getTokensToOwn() - len(ringTokens)
 
 			level.Info(i.logger).Log("msg", "generating new tokens", "count", needTokens, "ring", i.RingName)
-			newTokens := i.tokenGenerator.GenerateTokens(needTokens, takenTokens)
+			newTokens := generateTokens(i.tokenGenerator, needTokens, i.getWeight(), ringDesc)
 
 			ringTokens = append(ringTokens, newTokens...)
 			sort.Sort(ringTokens)
 
 			ro, rots := i.GetReadOnlyState()
-			ringDesc.AddIngester(i.ID, i.Addr, i.Zone, ringTokens, i.GetState(), i.getRegisteredAt(), ro, rots)
+			ringDesc.AddIngesterWithWeight(i.ID, i.Addr, i.Zone, ringTokens, i.GetState(), i.getRegisteredAt(), ro, rots, i.getWeight())
 
 			i.setTokens(ringTokens)
 
@@ -901,12 +935,12 @@ func (i *Lifecycler) autoJoin(ctx context.Context, targetState InstanceState) er
 		ringDesc = GetOrCreateRingDesc(in)
 
 		// At this point, we should not have any tokens, and we should be in PENDING state.
-		myTokens, takenTokens := ringDesc.TokensFor(i.ID)
+		myTokens := Tokens(ringDesc.Ingesters[i.ID].Tokens)
 		if len(myTokens) > 0 {
 			level.Error(i.logger).Log("msg", "tokens already exist for this instance - wasn't expecting any!", "num_tokens", len(myTokens), "ring", i.RingName)
 		}
 
-		newTokens := i.tokenGenerator.GenerateTokens(i.cfg.NumTokens-len(myTokens), takenTokens)
+		newTokens := generateTokens(i.tokenGenerator, i.getTokensToOwn()-len(myTokens), i.getWeight(), ringDesc)
 		i.setState(targetState)
 
 		myTokens = append(myTokens, newTokens...)
@@ -914,7 +948,7 @@ func (i *Lifecycler) autoJoin(ctx context.Context, targetState InstanceState) er
 		i.setTokens(myTokens)
 
 		ro, rots := i.GetReadOnlyState()
-		ringDesc.AddIngester(i.ID, i.Addr, i.Zone, i.getTokens(), i.GetState(), i.getRegisteredAt(), ro, rots)
+		ringDesc.AddIngesterWithWeight(i.ID, i.Addr, i.Zone, i.getTokens(), i.GetState(), i.getRegisteredAt(), ro, rots, i.getWeight())
 		return ringDesc, true, nil
 	})
 
@@ -949,7 +983,7 @@ func (i *Lifecycler) updateConsul(ctx context.Context) error {
 		}
 
 		ro, rots := i.GetReadOnlyState()
-		ringDesc.AddIngester(i.ID, i.Addr, i.Zone, tokens, i.GetState(), i.getRegisteredAt(), ro, rots)
+		ringDesc.AddIngesterWithWeight(i.ID, i.Addr, i.Zone, tokens, i.GetState(), i.getRegisteredAt(), ro, rots, i.getWeight())
 		return ringDesc, true, nil
 	})
 
@@ -79,6 +79,12 @@ func TestLifecyclerConfig_Validate(t *testing.T) {
 	cfg.RingTokenGenerator = spreadMinimizingTokenGenerator
 	err = cfg.Validate()
 	require.Error(t, err)
+
+	cfg = testLifecyclerConfig(ringConfig, "instance-1")
+	cfg.Weight = MaxInstanceWeight
+	require.NoError(t, cfg.Validate())
+	cfg.Weight = MaxInstanceWeight + 1
+	require.Error(t, cfg.Validate())
 }
 
 func TestLifecycler_TokenGenerator(t *testing.T) {
@@ -699,6 +705,41 @@ func TestLifecycler_IncreasingTokensLeavingInstanceInTheRing(t *testing.T) {
 	}), "tokens should be sorted")
 }
 
+func TestLifecycler_WeightedInstance(t *testing.T) {
+	ctx := context.Background()
+
+	ringStore, closer := consul.NewInMemoryClient(GetCodec(), log.NewNopLogger(), nil)
+	t.Cleanup(func() { assert.NoError(t, closer.Close()) })
+
+	var ringConfig Config
+	flagext.DefaultValues(&ringConfig)
+	ringConfig.KVStore.Mock = ringStore
+
+	lifecyclerConfig := testLifecyclerConfig(ringConfig, "ing1")
+	lifecyclerConfig.NumTokens = 16
+	lifecyclerConfig.Weight = 3
+
+	l, err := NewLifecycler(lifecyclerConfig, &noopFlushTransferer{}, "ingester", ringKey, true, log.NewNopLogger(), nil)
+	require.NoError(t, err)
+	require.NoError(t, services.StartAndAwaitRunning(ctx, l))
+	t.Cleanup(func() {
+		assert.NoError(t, services.StopAndAwaitTerminated(ctx, l))
+	})
+
+	// Verify the ingester joined with a number of tokens proportional to its weight.
+	test.Poll(t, time.Second, true, func() interface{} {
+		d, err := ringStore.Get(ctx, ringKey)
+		require.NoError(t, err)
+
+		desc, ok := d.(*Desc)
+		if !ok {
+			return false
+		}
+		ingDesc := desc.Ingesters["ing1"]
+		return ingDesc.State == ACTIVE && ingDesc.Weight == 3 && len(ingDesc.Tokens) == 48
+	})
+}
+
 func TestLifecycler_ChangeReadOnlyState(t *testing.T) {
 	ringStore, closer := consul.NewInMemoryClient(GetCodec(), log.NewNopLogger(), nil)
 	t.Cleanup(func() { assert.NoError(t, closer.Close()) })
@@ -493,3 +493,62 @@ func mergeLocalCAS(ring1, ring2 *Desc, nowUnixTime int64) (*Desc, *Desc) {
 	changeRing := change.(*Desc)
 	return ring1, changeRing
 }
+
+func TestMergeWeight(t *testing.T) {
+	now := time.Now().Unix()
+
+	firstRing := func() *Desc {
+		return &Desc{
+			Ingesters: map[string]InstanceDesc{
+				"Ing 1": {Addr: "addr1", Timestamp: now, State: ACTIVE, Tokens: []uint32{30, 40, 50}, Weight: 2},
+				"Ing 2": {Addr: "addr2", Timestamp: now, State: ACTIVE, Tokens: []uint32{5, 10, 20}, Weight: 1},
+			},
+		}
+	}
+
+	// The weight of "Ing 2" has been updated with a more recent heartbeat.
+	secondRing := func() *Desc {
+		return &Desc{
+			Ingesters: map[string]InstanceDesc{
+				"Ing 2": {Addr: "addr2", Timestamp: now + 5, State: ACTIVE, Tokens: []uint32{5, 10, 20}, Weight: 3},
+			},
+		}
+	}
+
+	// A member running an older version, unaware of weights, gossips back the same
+	// state without weights.
+	oldVersionRing := func() *Desc {
+		return &Desc{
+			Ingesters: map[string]InstanceDesc{
+				"Ing 1": {Addr: "addr1", Timestamp: now, State: ACTIVE, Tokens: []uint32{30, 40, 50}},
+				"Ing 2": {Addr: "addr2", Timestamp: now + 5, State: ACTIVE, Tokens: []uint32{5, 10, 20}},
+			},
+		}
+	}
+
+	expected := func() *Desc {
+		return &Desc{
+			Ingesters: map[string]InstanceDesc{
+				"Ing 1": {Addr: "addr1", Timestamp: now, State: ACTIVE, Tokens: []uint32{30, 40, 50}, Weight: 2},
+				"Ing 2": {Addr: "addr2", Timestamp: now + 5, State: ACTIVE, Tokens: []uint32{5, 10, 20}, Weight: 3},
+			},
+		}
+	}
+
+	{
+		our, ch := merge(firstRing(), secondRing())
+		assert.Equal(t, expected(), our)
+		assert.Equal(t, &Desc{
+			Ingesters: map[string]InstanceDesc{
+				"Ing 2": {Addr: "addr2", Timestamp: now + 5, State: ACTIVE, Tokens: []uint32{5, 10, 20}, Weight: 3},
+			},
+		}, ch)
+	}
+
+	{
+		// Entries with the same timestamp are not overridden, so weights are not lost.
+		our, ch := merge(expected(), oldVersionRing())
+		assert.Equal(t, expected(), our)
+		assert.Equal(t, (*Desc)(nil), ch)
+	}
+}
@@ -55,6 +55,12 @@ func timeToUnixSecons(t time.Time) int64 {
 // AddIngester adds the given ingester to the ring. Ingester will only use supplied tokens,
 // any other tokens are removed.
 func (d *Desc) AddIngester(id, addr, zone string, tokens []uint32, state InstanceState, registeredAt time.Time, readOnly bool, readOnlyUpdated time.Time) InstanceDesc {
+	return d.AddIngesterWithWeight(id, addr, zone, tokens, state, registeredAt, readOnly, readOnlyUpdated, 0)
+}
+
+// AddIngesterWithWeight is like AddIngester, but also sets the weight of the ingester.
+// A weight of 0 means that the ingester has the default weight.
+func (d *Desc) AddIngesterWithWeight(id, addr, zone string, tokens []uint32, state InstanceState, registeredAt time.Time, readOnly bool, readOnlyUpdated time.Time, weight uint32) InstanceDesc {
 	if d.Ingesters == nil {
 		d.Ingesters = map[string]InstanceDesc{}
 	}
@@ -69,6 +75,7 @@ func (d *Desc) AddIngester(id, addr, zone string, tokens []uint32, state Instanc
 		RegisteredTimestamp:      timeToUnixSecons(registeredAt),
 		ReadOnly:                 readOnly,
 		ReadOnlyUpdatedTimestamp: timeToUnixSecons(readOnlyUpdated),
+		Weight:                   weight,
 	}
 
 	d.Ingesters[id] = ingester
@@ -160,6 +167,16 @@ func (i *InstanceDesc) GetReadOnlyState() (bool, time.Time) {
 	return i.ReadOnly, ts
 }
 
+// GetEffectiveWeight returns the capacity weight of the instance. Instances whose weight
+// is unknown (e.g. registered by an older version) have the default weight of 1.
+func (i *InstanceDesc) GetEffectiveWeight() uint32 {
+	if i == nil || i.Weight == 0 {
+		return DefaultInstanceWeight
+	}
+
+	return i.Weight
+}
+
 func (i *InstanceDesc) IsHealthy(op Operation, heartbeatTimeout time.Duration, now time.Time) bool {
 	healthy := op.IsInstanceInStateHealthy(i.State)
 
@@ -404,6 +421,11 @@ func resolveConflicts(normalizedIngesters map[string]InstanceDesc) {
 
 				winnerKey := ingKey
 				switch {
+				// the token is being handed off, the receiving ingester wins (see TokenRebalancerDelegate)
+				case prevIng.OfferedTokens[token] == ingKey:
+					winnerKey = ingKey
+				case ing.OfferedTokens[token] == prevKey:
+					winnerKey = prevKey
 				case ing.State == LEAVING && prevIng.State != LEAVING:
 					winnerKey = prevKey
 				case prevIng.State == LEAVING && ing.State != LEAVING:
@@ -548,6 +570,19 @@ func (d *Desc) instancesWithTokensCount() int {
 	return count
 }
 
+// instancesWithTokensWeightPerZone returns the sum of the effective weights of the instances with tokens, per zone.
+func (d *Desc) instancesWithTokensWeightPerZone() map[string]uint64 {
+	weightPerZone := map[string]uint64{}
+	if d != nil {
+		for _, ingester := range d.Ingesters {
+			if len(ingester.Tokens) > 0 {
+				weightPerZone[ingester.Zone] += uint64(ingester.GetEffectiveWeight())
+			}
+		}
+	}
+	return weightPerZone
+}
+
 func (d *Desc) instancesCountPerZone() map[string]int {
 	instancesCountPerZone := map[string]int{}
 	if d != nil {
@@ -673,6 +708,10 @@ func (d *Desc) RingCompare(o *Desc) CompareResult {
 			return Different
 		}
 
+		if ing.GetEffectiveWeight() != oing.GetEffectiveWeight() {
+			return Different
+		}
+
 		if len(ing.Tokens) != len(oing.Tokens) {
 			return Different
 		}
@@ -698,6 +737,14 @@ func (d *Desc) RingCompare(o *Desc) CompareResult {
 	return EqualButStatesAndTimestamps
 }
 
+// MultiEqual implements kv.MultiComparable. The rings match unless RingCompare reports them as
+// Different, because the heartbeats are mirrored asynchronously to the secondary store, so the
+// states and timestamps of the instances may be behind.
+func (d *Desc) MultiEqual(secondary interface{}) bool {
+	o, ok := secondary.(*Desc)
+	return ok && d.RingCompare(o) != Different
+}
+
 // setInstanceIDs sets the ID of each InstanceDesc object managed by this Desc
 func (d *Desc) setInstanceIDs() {
 	for id, inst := range d.Ingesters {
@@ -111,6 +111,12 @@ func normalizedOutput() *Desc {
 	}
 }
 
+func TestInstanceDesc_GetEffectiveWeight(t *testing.T) {
+	assert.Equal(t, uint32(DefaultInstanceWeight), (*InstanceDesc)(nil).GetEffectiveWeight())
+	assert.Equal(t, uint32(DefaultInstanceWeight), (&InstanceDesc{}).GetEffectiveWeight())
+	assert.Equal(t, uint32(3), (&InstanceDesc{Weight: 3}).GetEffectiveWeight())
+}
+
 func TestClaimTokensFromNormalizedToNormalized(t *testing.T) {
 	r := normalizedSource()
 	result := r.ClaimTokens("first", "second")
@@ -300,6 +306,16 @@ func TestDesc_RingsCompare(t *testing.T) {
 			r2:       &Desc{Ingesters: map[string]InstanceDesc{"ing1": {Addr: "addr1", ReadOnlyUpdatedTimestamp: time.Now().Unix()}}},
 			expected: Different,
 		},
+		"same single instance, different weight": {
+			r1:       &Desc{Ingesters: map[string]InstanceDesc{"ing1": {Addr: "addr1", Weight: 1}}},
+			r2:       &Desc{Ingesters: map[string]InstanceDesc{"ing1": {Addr: "addr1", Weight: 2}}},
+			expected: Different,
+		},
+		"same single instance, unknown and default weight": {
+			r1:       &Desc{Ingesters: map[string]InstanceDesc{"ing1": {Addr: "addr1"}}},
+			r2:       &Desc{Ingesters: map[string]InstanceDesc{"ing1": {Addr: "addr1", Weight: DefaultInstanceWeight}}},
+			expected: Equal,
+		},
 		"instance in different zone": {
 			r1:       &Desc{Ingesters: map[string]InstanceDesc{"ing1": {Addr: "addr1", Zone: "one"}}},
 			r2:       &Desc{Ingesters: map[string]InstanceDesc{"ing1": {Addr: "addr1", Zone: "two"}}},
@@ -335,6 +351,23 @@ func TestDesc_RingsCompare(t *testing.T) {
 	}
 }
 
+func TestDesc_MultiEqual(t *testing.T) {
+	primary := &Desc{Ingesters: map[string]InstanceDesc{
+		"ing1": {Addr: "addr1", Timestamp: 100, State: ACTIVE, Tokens: []uint32{1, 2, 3}},
+	}}
+
+	// Heartbeats and state changes may not have been mirrored yet.
+	assert.True(t, primary.MultiEqual(&Desc{Ingesters: map[string]InstanceDesc{
+		"ing1": {Addr: "addr1", Timestamp: 50, State: JOINING, Tokens: []uint32{1, 2, 3}},
+	}}))
+
+	assert.False(t, primary.MultiEqual(&Desc{Ingesters: map[string]InstanceDesc{
+		"ing1": {Addr: "addr1", Timestamp: 100, State: ACTIVE, Tokens: []uint32{1, 2, 4}},
+	}}))
+	assert.False(t, primary.MultiEqual(&Desc{}))
+	assert.False(t, primary.MultiEqual("not a ring"))
+}
+
 func TestMergeTokens(t *testing.T) {
 	tests := map[string]struct {
 		input    [][]uint32
@@ -19,6 +19,7 @@ var (
 	ErrPartitionDoesNotExist          = errors.New("the partition does not exist")
 	ErrPartitionStateMismatch         = errors.New("the partition state does not match the expected one")
 	ErrPartitionStateChangeNotAllowed = errors.New("partition state change not allowed")
+	ErrOwnerAlreadyExists             = errors.New("the owner already exists and owns another partition")
 
 	allowedPartitionStateChanges = map[PartitionState][]PartitionState{
 		PartitionPending:  {PartitionActive, PartitionInactive},
@@ -34,6 +35,10 @@ type PartitionInstanceLifecyclerConfig struct {
 	// InstanceID is the ID of the instance managed by the lifecycler.
 	InstanceID string
 
+	// InstanceZone is the zone of the instance managed by the lifecycler. It's optional, and it's
+	// registered in the partitions ring as zone of the partition owner.
+	InstanceZone string
+
 	// WaitOwnersCountOnPending is the minimum number of owners to wait before switching a
 	// PENDING partition to ACTIVE.
 	WaitOwnersCountOnPending int
@@ -281,7 +286,7 @@ func (l *PartitionInstanceLifecycler) createPartitionAndRegisterOwner(ctx contex
 		}
 
 		// Ensure the instance is added as partition owner.
-		if ring.AddOrUpdateOwner(l.cfg.InstanceID, OwnerActive, l.cfg.PartitionID, now) {
+		if ring.AddOrUpdateOwnerWithZone(l.cfg.InstanceID, l.cfg.InstanceZone, OwnerActive, l.cfg.PartitionID, now) {
 			changed = true
 		}
 
@@ -329,7 +334,7 @@ func (l *PartitionInstanceLifecycler) waitPartitionAndRegisterOwner(ctx context.
 
 	// Ensure the instance is added as partition owner.
 	return l.updateRing(ctx, func(ring *PartitionRingDesc) (bool, error) {
-		return ring.AddOrUpdateOwner(l.cfg.InstanceID, OwnerActive, l.cfg.PartitionID, time.Now()), nil
+		return ring.AddOrUpdateOwnerWithZone(l.cfg.InstanceID, l.cfg.InstanceZone, OwnerActive, l.cfg.PartitionID, time.Now()), nil
 	})
 }
 
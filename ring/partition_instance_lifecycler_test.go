@@ -26,6 +26,8 @@ func TestPartitionInstanceLifecycler(t *testing.T) {
 
 		lifecycler1aConfig := createTestPartitionInstanceLifecyclerConfig(1, "instance-zone-a-1")
 		lifecycler1bConfig := createTestPartitionInstanceLifecyclerConfig(1, "instance-zone-b-1")
+		lifecycler1aConfig.InstanceZone = "zone-a"
+		lifecycler1bConfig.InstanceZone = "zone-b"
 		for _, cfg := range []*PartitionInstanceLifecyclerConfig{&lifecycler1aConfig, &lifecycler1bConfig} {
 			cfg.WaitOwnersCountOnPending = 2
 			cfg.WaitOwnersDurationOnPending = 0
@@ -59,6 +61,7 @@ func TestPartitionInstanceLifecycler(t *testing.T) {
 
 		actual = getPartitionRingFromStore(t, store, ringKey)
 		assert.ElementsMatch(t, []string{"instance-zone-a-1", "instance-zone-b-1"}, actual.ownersByPartition()[1])
+		assert.Equal(t, []string{"zone-a", "zone-b"}, actual.zonesByPartition()[1])
 
 		// We expect the partition to switch to active state.
 		assert.Eventually(t, func() bool {
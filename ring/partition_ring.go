@@ -15,6 +15,18 @@ import (
 
 var ErrNoActivePartitionFound = fmt.Errorf("no active partition found")
 
+// PartitionRingReplicationConfig holds the replication settings of a PartitionRing.
+type PartitionRingReplicationConfig struct {
+	// ReplicationFactor is the number of ACTIVE partitions each key is mapped to. Values lower than 1 are
+	// considered as 1.
+	ReplicationFactor int
+
+	// ZoneAwarenessEnabled controls whether a key should be mapped to partitions owned by different zones,
+	// and whether shuffle shards should be balanced across zones. The zones of a partition are the zones
+	// of its owners.
+	ZoneAwarenessEnabled bool
+}
+
 // PartitionRing holds an immutable view of the partitions ring.
 //
 // Design principles:
@@ -41,21 +53,81 @@ type PartitionRing struct {
 
 	// activePartitionsCount is a saved count of active partitions to avoid recomputing it.
 	activePartitionsCount int
+
+	// replication holds the replication settings of the ring.
+	replication PartitionRingReplicationConfig
+
+	// The following fields are only populated when zone-awareness is enabled.
+	//
+	// zonesByPartition is a map where the key is the partition ID and the value is the sorted list of its owners zones.
+	zonesByPartition map[int32][]string
+
+	// zones is the sorted list of all owners zones. It includes an empty zone if some partitions have
+	// no owners zones.
+	zones []string
+
+	// tokensByZone is a map where the key is a zone and the value is the sorted list of tokens registered
+	// by the partitions owned in that zone. Tokens of partitions without owners zones are in the empty zone.
+	tokensByZone map[string]Tokens
 }
 
+// NewPartitionRing returns a PartitionRing with replication factor 1.
 func NewPartitionRing(desc PartitionRingDesc) *PartitionRing {
-	return &PartitionRing{
+	return NewPartitionRingWithReplication(desc, PartitionRingReplicationConfig{ReplicationFactor: 1})
+}
+
+// NewPartitionRingWithReplication returns a PartitionRing with the given replication settings.
+func NewPartitionRingWithReplication(desc PartitionRingDesc, replication PartitionRingReplicationConfig) *PartitionRing {
+	if replication.ReplicationFactor < 1 {
+		replication.ReplicationFactor = 1
+	}
+
+	r := &PartitionRing{
 		desc:                  desc,
 		ringTokens:            desc.tokens(),
 		partitionByToken:      desc.partitionByToken(),
 		ownersByPartition:     desc.ownersByPartition(),
 		activePartitionsCount: desc.activePartitionsCount(),
 		shuffleShardCache:     newPartitionRingShuffleShardCache(),
+		replication:           replication,
 	}
+
+	if replication.ZoneAwarenessEnabled {
+		r.zonesByPartition = desc.zonesByPartition()
+		r.tokensByZone = map[string]Tokens{}
+
+		for partitionID, partition := range desc.Partitions {
+			zones := r.zonesByPartition[partitionID]
+
+			// Partitions without owners zones are grouped in a zone with an empty name.
+			if len(zones) == 0 {
+				zones = []string{""}
+			}
+
+			for _, zone := range zones {
+				r.tokensByZone[zone] = append(r.tokensByZone[zone], partition.Tokens...)
+			}
+		}
+
+		r.zones = make([]string, 0, len(r.tokensByZone))
+		for zone, tokens := range r.tokensByZone {
+			slices.Sort(tokens)
+			r.zones = append(r.zones, zone)
+		}
+		slices.Sort(r.zones)
+	}
+
+	return r
+}
+
+// ReplicationFactor returns the number of ACTIVE partitions each key is mapped to.
+func (r *PartitionRing) ReplicationFactor() int {
+	return r.replication.ReplicationFactor
 }
 
 // ActivePartitionForKey returns partition for the given key. Only active partitions are considered.
-// Only one partition is returned: in other terms, the replication factor is always 1.
+// Only one partition is returned, regardless of the ring replication factor: use ActivePartitionsForKey()
+// to get all the partitions the key is mapped to.
 func (r *PartitionRing) ActivePartitionForKey(key uint32) (int32, error) {
 	var (
 		start       = searchToken(r.ringTokens, key)
@@ -91,8 +163,76 @@ func (r *PartitionRing) ActivePartitionForKey(key uint32) (int32, error) {
 	return 0, ErrNoActivePartitionFound
 }
 
+// ActivePartitionsForKey returns the partitions the given key is mapped to: up to replication factor
+// ACTIVE partitions, found walking the ring clockwise starting from the key. When zone-awareness is
+// enabled, partitions sharing an owner zone with a partition already included are skipped, while
+// partitions without owners zones are never skipped because of zone-awareness.
+//
+// Less partitions than the replication factor are returned if there aren't enough ACTIVE partitions
+// (or zones) in the ring. The input buf, if any, is used to store the result in order to reduce
+// memory allocations.
+func (r *PartitionRing) ActivePartitionsForKey(key uint32, buf []int32) ([]int32, error) {
+	var (
+		start       = searchToken(r.ringTokens, key)
+		iterations  = 0
+		tokensCount = len(r.ringTokens)
+		result      = buf[:0]
+
+		// We use a slice instead of a map because it's faster to search within a
+		// slice than lookup a map for a very low number of items.
+		distinctZones []string
+	)
+
+	for i := start; iterations < tokensCount && len(result) < r.replication.ReplicationFactor; i++ {
+		iterations++
+
+		if i >= tokensCount {
+			i %= len(r.ringTokens)
+		}
+
+		token := r.ringTokens[i]
+
+		partitionID, ok := r.partitionByToken[Token(token)]
+		if !ok {
+			return nil, ErrInconsistentTokensInfo
+		}
+
+		partition, ok := r.desc.Partitions[partitionID]
+		if !ok {
+			return nil, ErrInconsistentTokensInfo
+		}
+
+		// If the partition is not active, or it has already been included, we'll keep walking the ring.
+		if !partition.IsActive() || slices.Contains(result, partitionID) {
+			continue
+		}
+
+		if r.replication.ZoneAwarenessEnabled {
+			partitionZones := r.zonesByPartition[partitionID]
+			if slices.ContainsFunc(partitionZones, func(zone string) bool { return slices.Contains(distinctZones, zone) }) {
+				continue
+			}
+
+			distinctZones = append(distinctZones, partitionZones...)
+		}
+
+		result = append(result, partitionID)
+	}
+
+	if len(result) == 0 {
+		return nil, ErrNoActivePartitionFound
+	}
+
+	return result, nil
+}
+
 // ShuffleShardSize returns number of partitions that would be in the result of ShuffleShard call with the same size.
+// The size is never lower than the replication factor and, when zone-awareness is enabled, it's rounded up to
+// a multiple of the number of zones. When zone-awareness is enabled and partitions are owned by multiple zones,
+// the returned value is an upper bound.
 func (r *PartitionRing) ShuffleShardSize(size int) int {
+	size = r.shuffleShardSizeWithReplication(size)
+
 	if size <= 0 || size > r.activePartitionsCount {
 		return r.activePartitionsCount
 	}
@@ -111,7 +251,9 @@ func (r *PartitionRing) ShuffleShardSize(size int) int {
 // predictable numbers. The random generator is initialised with a seed based on the
 // provided identifier.
 //
-// This function returns a subring containing ONLY ACTIVE partitions.
+// This function returns a subring containing ONLY ACTIVE partitions. The returned subring has the same
+// replication settings of this ring: the shard size is never lower than the replication factor and, when
+// zone-awareness is enabled, partitions are picked evenly from each zone (see ShuffleShardSize()).
 //
 // This function supports caching.
 //
@@ -162,7 +304,22 @@ func (r *PartitionRing) ShuffleShardWithLookback(identifier string, size int, lo
 	return subring, nil
 }
 
+// shuffleShardSizeWithReplication returns the input shard size adjusted to the ring replication settings.
+func (r *PartitionRing) shuffleShardSizeWithReplication(size int) int {
+	if size <= 0 {
+		return size
+	}
+
+	size = max(size, r.replication.ReplicationFactor)
+	if r.replication.ZoneAwarenessEnabled && len(r.zones) > 0 {
+		size = shardUtil.ShuffleShardExpectedInstances(size, len(r.zones))
+	}
+	return size
+}
+
 func (r *PartitionRing) shuffleShard(identifier string, size int, lookbackPeriod time.Duration, now time.Time) (*PartitionRing, error) {
+	size = r.shuffleShardSizeWithReplication(size)
+
 	// If the size is too small or too large, run with a size equal to the total number of partitions.
 	// We have to run the function anyway because the logic may filter out some INACTIVE partitions.
 	if size <= 0 || size >= len(r.desc.Partitions) {
@@ -174,20 +331,56 @@ func (r *PartitionRing) shuffleShard(identifier string, size int, lookbackPeriod
 		lookbackUntil = now.Add(-lookbackPeriod).Unix()
 	}
 
-	// Initialise the random generator used to select instances in the ring.
-	// There are no zones
-	random := rand.New(rand.NewSource(shardUtil.ShuffleShardSeed(identifier, "")))
+	var (
+		sizePerZone int
+		actualZones []string
+	)
 
-	// To select one more instance while guaranteeing the "consistency" property,
-	// we do pick a random value from the generator and resolve uniqueness collisions
-	// (if any) continuing walking the ring.
-	tokensCount := len(r.ringTokens)
+	if r.replication.ZoneAwarenessEnabled && len(r.zones) > 0 {
+		sizePerZone = shardUtil.ShuffleShardExpectedInstancesPerZone(size, len(r.zones))
+		actualZones = r.zones
+	} else {
+		sizePerZone = size
+		actualZones = nil
+	}
 
 	result := make(map[int32]struct{}, size)
 	exclude := map[int32]struct{}{}
 
-	for len(result) < size {
-		start := searchToken(r.ringTokens, random.Uint32())
+	if actualZones == nil {
+		// When zone-awareness is disabled, we just iterate over 1 single fake zone
+		// and use all tokens in the ring.
+		if err := r.shuffleShardZone(identifier, "", r.ringTokens, sizePerZone, lookbackPeriod, lookbackUntil, result, exclude); err != nil {
+			return nil, err
+		}
+	}
+
+	// We need to iterate zones always in the same order to guarantee stability.
+	for _, zone := range actualZones {
+		if err := r.shuffleShardZone(identifier, zone, r.tokensByZone[zone], sizePerZone, lookbackPeriod, lookbackUntil, result, exclude); err != nil {
+			return nil, err
+		}
+	}
+
+	return NewPartitionRingWithReplication(r.desc.WithPartitions(result), r.replication), nil
+}
+
+// shuffleShardZone adds to result up to size partitions registering the input tokens. Partitions found
+// already in result or exclude are skipped.
+func (r *PartitionRing) shuffleShardZone(identifier, zone string, tokens Tokens, size int, lookbackPeriod time.Duration, lookbackUntil int64, result, exclude map[int32]struct{}) error {
+	// Initialise the random generator used to select partitions in the ring.
+	// Since we consider each zone like an independent ring, we have to use dedicated
+	// pseudo-random generator for each zone, in order to guarantee the "consistency"
+	// property when the shard size changes or a new zone is added.
+	random := rand.New(rand.NewSource(shardUtil.ShuffleShardSeed(identifier, zone)))
+
+	// To select one more instance while guaranteeing the "consistency" property,
+	// we do pick a random value from the generator and resolve uniqueness collisions
+	// (if any) continuing walking the ring.
+	tokensCount := len(tokens)
+
+	for selected := 0; selected < size; {
+		start := searchToken(tokens, random.Uint32())
 		iterations := 0
 		found := false
 
@@ -199,9 +392,9 @@ func (r *PartitionRing) shuffleShard(identifier string, size int, lookbackPeriod
 				p %= tokensCount
 			}
 
-			pid, ok := r.partitionByToken[Token(r.ringTokens[p])]
+			pid, ok := r.partitionByToken[Token(tokens[p])]
 			if !ok {
-				return nil, ErrInconsistentTokensInfo
+				return ErrInconsistentTokensInfo
 			}
 
 			// Ensure the partition has not already been included or excluded.
@@ -214,7 +407,7 @@ func (r *PartitionRing) shuffleShard(identifier string, size int, lookbackPeriod
 
 			p, ok := r.desc.Partitions[pid]
 			if !ok {
-				return nil, ErrInconsistentTokensInfo
+				return ErrInconsistentTokensInfo
 			}
 
 			// PENDING partitions should be skipped because they're not ready for read or write yet,
@@ -233,6 +426,7 @@ func (r *PartitionRing) shuffleShard(identifier string, size int, lookbackPeriod
 			// Either include or exclude the found partition.
 			if shouldInclude {
 				result[pid] = struct{}{}
+				selected++
 			} else {
 				exclude[pid] = struct{}{}
 			}
@@ -255,7 +449,7 @@ func (r *PartitionRing) shuffleShard(identifier string, size int, lookbackPeriod
 		}
 	}
 
-	return NewPartitionRing(r.desc.WithPartitions(result)), nil
+	return nil
 }
 
 // PartitionsCount returns the number of partitions in the ring.
@@ -447,35 +641,54 @@ func (r *ActivePartitionBatchRing) InstancesCount() int {
 	return r.ring.ActivePartitionsCount()
 }
 
-// ReplicationFactor returns 1 as partitions replication factor: an entry (looked by key via Get())
-// is always stored in 1 and only 1 partition.
+// ReplicationFactor returns the partitions replication factor: an entry (looked by key via Get())
+// is stored in as many partitions as the replication factor of the wrapped PartitionRing.
 //
 // ReplicationFactor implements DoBatchRing.ReplicationFactor.
 func (r *ActivePartitionBatchRing) ReplicationFactor() int {
-	return 1
+	return r.ring.ReplicationFactor()
 }
 
-// Get implements DoBatchRing.Get.
+// Get implements DoBatchRing.Get. The returned ReplicationSet contains one instance for each
+// partition the key is mapped to, and the entry is expected to be successfully written to all of them.
 func (r *ActivePartitionBatchRing) Get(key uint32, _ Operation, bufInstances []InstanceDesc, _, _ []string) (ReplicationSet, error) {
-	partitionID, err := r.ring.ActivePartitionForKey(key)
-	if err != nil {
-		return ReplicationSet{}, err
+	// Stack buffer for the partition IDs, to not allocate for each key with the typical replication factors.
+	var bufPartitionIDs [8]int32
+	partitionIDs := bufPartitionIDs[:0]
+
+	if r.ring.ReplicationFactor() == 1 {
+		// Fast path when the replication factor is 1.
+		partitionID, err := r.ring.ActivePartitionForKey(key)
+		if err != nil {
+			return ReplicationSet{}, err
+		}
+
+		partitionIDs = append(partitionIDs, partitionID)
+	} else {
+		var err error
+
+		partitionIDs, err = r.ring.ActivePartitionsForKey(key, partitionIDs)
+		if err != nil {
+			return ReplicationSet{}, err
+		}
 	}
 
 	// Ensure we have enough capacity in bufInstances.
-	if cap(bufInstances) < 1 {
-		bufInstances = []InstanceDesc{{}}
+	if cap(bufInstances) < len(partitionIDs) {
+		bufInstances = make([]InstanceDesc, len(partitionIDs))
 	} else {
-		bufInstances = bufInstances[:1]
+		bufInstances = bufInstances[:len(partitionIDs)]
 	}
 
-	partitionIDString := strconv.Itoa(int(partitionID))
+	for i, partitionID := range partitionIDs {
+		partitionIDString := strconv.Itoa(int(partitionID))
 
-	bufInstances[0] = InstanceDesc{
-		Addr:      partitionIDString,
-		Timestamp: 0,
-		State:     ACTIVE,
-		Id:        partitionIDString,
+		bufInstances[i] = InstanceDesc{
+			Addr:      partitionIDString,
+			Timestamp: 0,
+			State:     ACTIVE,
+			Id:        partitionIDString,
+		}
 	}
 
 	return ReplicationSet{
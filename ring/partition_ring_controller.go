@@ -0,0 +1,319 @@
+package ring
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/grafana/dskit/services"
+)
+
+const (
+	// PartitionReassignmentPolicyInactive switches ownerless partitions to INACTIVE.
+	PartitionReassignmentPolicyInactive = "inactive"
+
+	// PartitionReassignmentPolicyStandby hands ownerless partitions to a healthy standby owner, if any,
+	// otherwise it switches them to INACTIVE.
+	PartitionReassignmentPolicyStandby = "standby"
+
+	// maxPartitionReassignmentDecisions is the number of most recent decisions kept by the controller.
+	maxPartitionReassignmentDecisions = 100
+)
+
+type PartitionRingControllerConfig struct {
+	// Policy is the policy applied to ownerless partitions. Supported values are
+	// PartitionReassignmentPolicyInactive (default) and PartitionReassignmentPolicyStandby.
+	Policy string
+
+	// OwnerlessGracePeriod is how long an ACTIVE partition should have no healthy owner
+	// before the policy is applied to it.
+	OwnerlessGracePeriod time.Duration
+
+	// HeartbeatTimeout is the heartbeat timeout after which an owner is considered unhealthy,
+	// based on its heartbeat in the instances ring. 0 means owners are healthy as long as
+	// they're registered in the instances ring.
+	HeartbeatTimeout time.Duration
+
+	// StandbyOwnerIDs is the list of IDs of the instances which can take over ownerless partitions,
+	// in order of preference, when the policy is PartitionReassignmentPolicyStandby. A standby owner
+	// must be healthy in the instances ring, and must not own any partition yet.
+	StandbyOwnerIDs []string
+
+	// PollingInterval is the internal polling interval. This setting is useful to let
+	// upstream projects to lower it in unit tests.
+	PollingInterval time.Duration
+}
+
+// Validate the PartitionRingControllerConfig.
+func (cfg *PartitionRingControllerConfig) Validate() error {
+	switch cfg.Policy {
+	case "", PartitionReassignmentPolicyInactive, PartitionReassignmentPolicyStandby:
+		return nil
+	default:
+		return fmt.Errorf("unsupported partition reassignment policy %q, supported values are: %v", cfg.Policy, []string{PartitionReassignmentPolicyInactive, PartitionReassignmentPolicyStandby})
+	}
+}
+
+// PartitionReassignmentDecision describes an action taken by the PartitionRingController on an ownerless partition.
+type PartitionReassignmentDecision struct {
+	Timestamp      time.Time `json:"timestamp"`
+	PartitionID    int32     `json:"partition_id"`
+	Action         string    `json:"action"`
+	PreviousOwners []string  `json:"previous_owners"`
+	NewOwner       string    `json:"new_owner,omitempty"`
+	Error          string    `json:"error,omitempty"`
+}
+
+// PartitionReassignmentDecisionsReader provides the most recent decisions taken on ownerless partitions.
+type PartitionReassignmentDecisionsReader interface {
+	// PartitionReassignmentDecisions returns the most recent decisions, sorted from the most recent one.
+	PartitionReassignmentDecisions() []PartitionReassignmentDecision
+}
+
+// PartitionRingController is a service which detects ACTIVE partitions whose owners are all unhealthy
+// (or missing) in the instances ring, and after a grace period applies the configured policy to them.
+// Multiple controllers can run for the same ring, because changes are applied via CAS and are idempotent.
+type PartitionRingController struct {
+	services.Service
+
+	cfg            PartitionRingControllerConfig
+	partitionsRing PartitionRingReader
+	instancesRing  InstanceRingReader
+	editor         *PartitionRingEditor
+	logger         log.Logger
+
+	// ownerlessSince tracks when the controller detected each partition without healthy owners.
+	// It's only accessed by the service goroutine.
+	ownerlessSince map[int32]time.Time
+
+	decisionsMx sync.Mutex
+	decisions   []PartitionReassignmentDecision
+
+	// Metrics.
+	ownerlessPartitions   prometheus.Gauge
+	reassignmentsTotal    *prometheus.CounterVec
+	reassignmentsFailures *prometheus.CounterVec
+}
+
+func NewPartitionRingController(cfg PartitionRingControllerConfig, ringName string, partitionsRing PartitionRingReader, instancesRing InstanceRingReader, editor *PartitionRingEditor, logger log.Logger, reg prometheus.Registerer) (*PartitionRingController, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if cfg.PollingInterval == 0 {
+		cfg.PollingInterval = 5 * time.Second
+	}
+	if cfg.Policy == "" {
+		cfg.Policy = PartitionReassignmentPolicyInactive
+	}
+
+	c := &PartitionRingController{
+		cfg:            cfg,
+		partitionsRing: partitionsRing,
+		instancesRing:  instancesRing,
+		editor:         editor,
+		logger:         log.With(logger, "ring", ringName),
+		ownerlessSince: map[int32]time.Time{},
+		ownerlessPartitions: promauto.With(reg).NewGauge(prometheus.GaugeOpts{
+			Name:        "partition_ring_controller_ownerless_partitions",
+			Help:        "Number of ACTIVE partitions with no healthy owner.",
+			ConstLabels: map[string]string{"name": ringName},
+		}),
+		reassignmentsTotal: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name:        "partition_ring_controller_reassignments_total",
+			Help:        "Total number of actions taken on ownerless partitions.",
+			ConstLabels: map[string]string{"name": ringName},
+		}, []string{"action"}),
+		reassignmentsFailures: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name:        "partition_ring_controller_reassignments_failed_total",
+			Help:        "Total number of actions on ownerless partitions which failed.",
+			ConstLabels: map[string]string{"name": ringName},
+		}, []string{"action"}),
+	}
+
+	c.Service = services.NewTimerService(cfg.PollingInterval, nil, c.iteration, nil).WithName("partition-ring-controller")
+	return c, nil
+}
+
+func (c *PartitionRingController) iteration(ctx context.Context) error {
+	c.reconcile(ctx, time.Now())
+
+	// Never return error, otherwise the service stops.
+	return nil
+}
+
+// reconcile detects ownerless partitions and applies the policy to the ones which have been ownerless
+// for longer than the grace period.
+func (c *PartitionRingController) reconcile(ctx context.Context, now time.Time) {
+	var (
+		ring      = c.partitionsRing.PartitionRing()
+		ownerless = 0
+		seen      = make(map[int32]struct{}, len(ring.desc.Partitions))
+
+		// The standby owners assigned in this pass, which are not in the ring snapshot yet.
+		assignedStandbys = map[string]struct{}{}
+	)
+
+	// Iterate partitions in a predictable order, so that standby owners are assigned predictably too.
+	partitionIDs := make([]int32, 0, len(ring.desc.Partitions))
+	for id := range ring.desc.Partitions {
+		partitionIDs = append(partitionIDs, id)
+	}
+	sort.Slice(partitionIDs, func(i, j int) bool { return partitionIDs[i] < partitionIDs[j] })
+
+	for _, partitionID := range partitionIDs {
+		seen[partitionID] = struct{}{}
+
+		partition := ring.desc.Partitions[partitionID]
+		if !partition.IsActive() {
+			delete(c.ownerlessSince, partitionID)
+			continue
+		}
+
+		ownerIDs := ring.PartitionOwnerIDsCopy(partitionID)
+		if c.hasHealthyInstance(ownerIDs, now) {
+			delete(c.ownerlessSince, partitionID)
+			continue
+		}
+
+		ownerless++
+
+		since, ok := c.ownerlessSince[partitionID]
+		if !ok {
+			level.Warn(c.logger).Log("msg", "detected ACTIVE partition without healthy owners", "partition", partitionID, "owners", fmt.Sprintf("%v", ownerIDs))
+			c.ownerlessSince[partitionID] = now
+			since = now
+		}
+
+		if now.Sub(since) < c.cfg.OwnerlessGracePeriod {
+			continue
+		}
+
+		// Keep tracking the partition if the policy couldn't be applied, so that it will be retried.
+		if c.applyPolicy(ctx, ring, partitionID, ownerIDs, assignedStandbys, now) {
+			delete(c.ownerlessSince, partitionID)
+		}
+	}
+
+	// Clean up partitions which don't exist anymore.
+	for partitionID := range c.ownerlessSince {
+		if _, ok := seen[partitionID]; !ok {
+			delete(c.ownerlessSince, partitionID)
+		}
+	}
+
+	c.ownerlessPartitions.Set(float64(ownerless))
+}
+
+// applyPolicy applies the configured policy to the ownerless partition, and returns whether it succeeded.
+// If the partition can't be reassigned to a standby owner, it falls back to switch the partition to INACTIVE.
+func (c *PartitionRingController) applyPolicy(ctx context.Context, ring *PartitionRing, partitionID int32, ownerIDs []string, assignedStandbys map[string]struct{}, now time.Time) bool {
+	if c.cfg.Policy == PartitionReassignmentPolicyStandby {
+		standbyID, standbyZone, ok := c.findStandbyOwner(ring, assignedStandbys, now)
+		if !ok {
+			level.Warn(c.logger).Log("msg", "no healthy standby owner available for ownerless partition, falling back to switch the partition to INACTIVE", "partition", partitionID)
+		} else {
+			// Never pick the same standby owner again in this pass, even if the reassignment failed.
+			assignedStandbys[standbyID] = struct{}{}
+
+			// The standby owner registers in the partitions ring with the zone it runs in.
+			err := c.editor.ReassignPartition(ctx, partitionID, ownerIDs, standbyID, standbyZone)
+			c.recordDecision(partitionID, PartitionReassignmentPolicyStandby, ownerIDs, standbyID, err, now)
+			if err == nil {
+				return true
+			}
+
+			level.Warn(c.logger).Log("msg", "failed to reassign ownerless partition to standby owner, falling back to switch the partition to INACTIVE", "partition", partitionID, "standby_owner", standbyID)
+		}
+	}
+
+	err := c.editor.ChangePartitionState(ctx, partitionID, PartitionInactive)
+	c.recordDecision(partitionID, PartitionReassignmentPolicyInactive, ownerIDs, "", err, now)
+	return err == nil
+}
+
+// recordDecision logs and tracks the outcome of an action taken on an ownerless partition.
+func (c *PartitionRingController) recordDecision(partitionID int32, action string, ownerIDs []string, newOwner string, err error, now time.Time) {
+	decision := PartitionReassignmentDecision{
+		Timestamp:      now,
+		PartitionID:    partitionID,
+		Action:         action,
+		PreviousOwners: ownerIDs,
+		NewOwner:       newOwner,
+	}
+
+	c.reassignmentsTotal.WithLabelValues(action).Inc()
+	if err != nil {
+		c.reassignmentsFailures.WithLabelValues(action).Inc()
+		decision.Error = err.Error()
+		level.Error(c.logger).Log("msg", "failed to reassign ownerless partition", "partition", partitionID, "action", action, "new_owner", newOwner, "err", err)
+	} else {
+		level.Info(c.logger).Log("msg", "reassigned ownerless partition", "partition", partitionID, "action", action, "new_owner", newOwner, "previous_owners", fmt.Sprintf("%v", ownerIDs))
+	}
+
+	c.addDecision(decision)
+}
+
+// findStandbyOwner returns the ID and zone of the first configured standby owner which is healthy, doesn't own
+// any partition and hasn't already been assigned in the current pass.
+func (c *PartitionRingController) findStandbyOwner(ring *PartitionRing, assignedStandbys map[string]struct{}, now time.Time) (string, string, bool) {
+	for _, id := range c.cfg.StandbyOwnerIDs {
+		if _, isOwner := ring.desc.Owners[id]; isOwner {
+			continue
+		}
+		if _, isAssigned := assignedStandbys[id]; isAssigned {
+			continue
+		}
+
+		instance, err := c.instancesRing.GetInstance(id)
+		if err != nil {
+			continue
+		}
+		if instance.IsHeartbeatHealthy(c.cfg.HeartbeatTimeout, now) {
+			return id, instance.Zone, true
+		}
+	}
+	return "", "", false
+}
+
+// hasHealthyInstance returns whether at least one of the input instances is registered and healthy in the instances ring.
+func (c *PartitionRingController) hasHealthyInstance(instanceIDs []string, now time.Time) bool {
+	for _, id := range instanceIDs {
+		instance, err := c.instancesRing.GetInstance(id)
+		if err != nil {
+			continue
+		}
+		if instance.IsHeartbeatHealthy(c.cfg.HeartbeatTimeout, now) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *PartitionRingController) addDecision(decision PartitionReassignmentDecision) {
+	c.decisionsMx.Lock()
+	defer c.decisionsMx.Unlock()
+
+	c.decisions = append(c.decisions, decision)
+	if len(c.decisions) > maxPartitionReassignmentDecisions {
+		c.decisions = c.decisions[len(c.decisions)-maxPartitionReassignmentDecisions:]
+	}
+}
+
+// PartitionReassignmentDecisions implements PartitionReassignmentDecisionsReader.
+func (c *PartitionRingController) PartitionReassignmentDecisions() []PartitionReassignmentDecision {
+	c.decisionsMx.Lock()
+	defer c.decisionsMx.Unlock()
+
+	out := make([]PartitionReassignmentDecision, 0, len(c.decisions))
+	for i := len(c.decisions) - 1; i >= 0; i-- {
+		out = append(out, c.decisions[i])
+	}
+	return out
+}
@@ -0,0 +1,373 @@
+package ring
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/dskit/kv"
+	"github.com/grafana/dskit/kv/consul"
+	"github.com/grafana/dskit/services"
+	"github.com/grafana/dskit/test"
+)
+
+func TestPartitionRingController_Reconcile(t *testing.T) {
+	const (
+		gracePeriod      = time.Minute
+		heartbeatTimeout = time.Minute
+	)
+
+	now := time.Now()
+	healthy := now.Add(time.Hour).Unix()
+	unhealthy := now.Add(-2 * heartbeatTimeout).Unix()
+
+	tests := map[string]struct {
+		policy          string
+		standbyOwnerIDs []string
+		instances       map[string]InstanceDesc
+		expectedOwners  map[int32][]string
+		expectedStates  map[int32]PartitionState
+		expectedAction  string
+		expectedOwner   string
+	}{
+		"should switch the ownerless partition to INACTIVE with the inactive policy": {
+			policy: PartitionReassignmentPolicyInactive,
+			instances: map[string]InstanceDesc{
+				"instance-2": {Id: "instance-2", Timestamp: healthy},
+			},
+			expectedOwners: map[int32][]string{1: {"instance-1"}, 2: {"instance-2"}},
+			expectedStates: map[int32]PartitionState{1: PartitionInactive, 2: PartitionActive},
+			expectedAction: PartitionReassignmentPolicyInactive,
+		},
+		"should consider owners with an expired heartbeat as unhealthy": {
+			policy: PartitionReassignmentPolicyInactive,
+			instances: map[string]InstanceDesc{
+				"instance-1": {Id: "instance-1", Timestamp: unhealthy},
+				"instance-2": {Id: "instance-2", Timestamp: healthy},
+			},
+			expectedOwners: map[int32][]string{1: {"instance-1"}, 2: {"instance-2"}},
+			expectedStates: map[int32]PartitionState{1: PartitionInactive, 2: PartitionActive},
+			expectedAction: PartitionReassignmentPolicyInactive,
+		},
+		"should reassign the ownerless partition to the first healthy standby owner with the standby policy": {
+			policy:          PartitionReassignmentPolicyStandby,
+			standbyOwnerIDs: []string{"standby-1", "standby-2"},
+			instances: map[string]InstanceDesc{
+				"instance-2": {Id: "instance-2", Timestamp: healthy},
+				"standby-1":  {Id: "standby-1", Timestamp: unhealthy},
+				"standby-2":  {Id: "standby-2", Timestamp: healthy},
+			},
+			expectedOwners: map[int32][]string{1: {"standby-2"}, 2: {"instance-2"}},
+			expectedStates: map[int32]PartitionState{1: PartitionActive, 2: PartitionActive},
+			expectedAction: PartitionReassignmentPolicyStandby,
+			expectedOwner:  "standby-2",
+		},
+		"should not reassign the ownerless partition to a standby owner already owning a partition": {
+			policy:          PartitionReassignmentPolicyStandby,
+			standbyOwnerIDs: []string{"instance-2"},
+			instances: map[string]InstanceDesc{
+				"instance-2": {Id: "instance-2", Timestamp: healthy},
+			},
+			expectedOwners: map[int32][]string{1: {"instance-1"}, 2: {"instance-2"}},
+			expectedStates: map[int32]PartitionState{1: PartitionInactive, 2: PartitionActive},
+			expectedAction: PartitionReassignmentPolicyInactive,
+		},
+		"should fall back to switch the ownerless partition to INACTIVE if there's no healthy standby owner": {
+			policy:          PartitionReassignmentPolicyStandby,
+			standbyOwnerIDs: []string{"standby-1"},
+			instances: map[string]InstanceDesc{
+				"instance-2": {Id: "instance-2", Timestamp: healthy},
+			},
+			expectedOwners: map[int32][]string{1: {"instance-1"}, 2: {"instance-2"}},
+			expectedStates: map[int32]PartitionState{1: PartitionInactive, 2: PartitionActive},
+			expectedAction: PartitionReassignmentPolicyInactive,
+		},
+	}
+
+	for testName, testData := range tests {
+		t.Run(testName, func(t *testing.T) {
+			ctx := context.Background()
+			store := initPartitionRingForControllerTest(t, map[string]int32{"instance-1": 1, "instance-2": 2})
+
+			reg := prometheus.NewPedanticRegistry()
+			c, err := NewPartitionRingController(PartitionRingControllerConfig{
+				Policy:               testData.policy,
+				OwnerlessGracePeriod: gracePeriod,
+				HeartbeatTimeout:     heartbeatTimeout,
+				StandbyOwnerIDs:      testData.standbyOwnerIDs,
+			}, testRingName, &partitionRingStoreReader{t: t, store: store}, &Ring{ringDesc: &Desc{Ingesters: testData.instances}}, NewPartitionRingEditor(ringKey, store), log.NewNopLogger(), reg)
+			require.NoError(t, err)
+
+			// The ownerless partition should be detected, but left untouched within the grace period.
+			c.reconcile(ctx, now)
+			c.reconcile(ctx, now.Add(gracePeriod/2))
+
+			assert.Equal(t, map[int32][]string{1: {"instance-1"}, 2: {"instance-2"}}, getPartitionOwnersFromStore(t, store))
+			assert.Equal(t, PartitionActive, getPartitionStateFromStore(t, store, ringKey, 1))
+			assert.Empty(t, c.PartitionReassignmentDecisions())
+			assert.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(`
+				# HELP partition_ring_controller_ownerless_partitions Number of ACTIVE partitions with no healthy owner.
+				# TYPE partition_ring_controller_ownerless_partitions gauge
+				partition_ring_controller_ownerless_partitions{name="test"} 1
+			`), "partition_ring_controller_ownerless_partitions"))
+
+			// The policy should be applied once the grace period has elapsed.
+			c.reconcile(ctx, now.Add(gracePeriod))
+
+			assert.Equal(t, testData.expectedOwners, getPartitionOwnersFromStore(t, store))
+			for partitionID, expectedState := range testData.expectedStates {
+				assert.Equal(t, expectedState, getPartitionStateFromStore(t, store, ringKey, partitionID))
+			}
+
+			decisions := c.PartitionReassignmentDecisions()
+			require.Len(t, decisions, 1)
+			assert.Equal(t, int32(1), decisions[0].PartitionID)
+			assert.Equal(t, testData.expectedAction, decisions[0].Action)
+			assert.Equal(t, testData.expectedOwner, decisions[0].NewOwner)
+			assert.Equal(t, []string{"instance-1"}, decisions[0].PreviousOwners)
+			assert.Empty(t, decisions[0].Error)
+			assert.Equal(t, float64(1), testutil.ToFloat64(c.reassignmentsTotal.WithLabelValues(testData.expectedAction)))
+			assert.Equal(t, float64(0), testutil.ToFloat64(c.reassignmentsFailures.WithLabelValues(testData.expectedAction)))
+
+			// Once the policy has been applied, no more actions should be taken.
+			c.reconcile(ctx, now.Add(2*gracePeriod))
+			assert.Len(t, c.PartitionReassignmentDecisions(), 1)
+		})
+	}
+}
+
+func TestPartitionRingController_ShouldResetGracePeriodWhenOwnerBecomesHealthyAgain(t *testing.T) {
+	const gracePeriod = time.Minute
+
+	ctx := context.Background()
+	now := time.Now()
+	store := initPartitionRingForControllerTest(t, map[string]int32{"instance-1": 1})
+
+	c, err := NewPartitionRingController(PartitionRingControllerConfig{
+		OwnerlessGracePeriod: gracePeriod,
+		HeartbeatTimeout:     time.Minute,
+	}, testRingName, &partitionRingStoreReader{t: t, store: store}, &Ring{ringDesc: &Desc{}}, NewPartitionRingEditor(ringKey, store), log.NewNopLogger(), nil)
+	require.NoError(t, err)
+
+	c.reconcile(ctx, now)
+	assert.Contains(t, c.ownerlessSince, int32(1))
+
+	// The owner joins the instances ring.
+	c.instancesRing = &Ring{ringDesc: &Desc{Ingesters: map[string]InstanceDesc{
+		"instance-1": {Id: "instance-1", Timestamp: now.Add(gracePeriod / 2).Unix()},
+	}}}
+	c.reconcile(ctx, now.Add(gracePeriod/2))
+	assert.NotContains(t, c.ownerlessSince, int32(1))
+
+	// The owner leaves the instances ring again: the grace period should start again.
+	c.instancesRing = &Ring{ringDesc: &Desc{}}
+	c.reconcile(ctx, now.Add(gracePeriod))
+	c.reconcile(ctx, now.Add(gracePeriod+gracePeriod/2))
+
+	assert.Equal(t, PartitionActive, getPartitionStateFromStore(t, store, ringKey, 1))
+	assert.Empty(t, c.PartitionReassignmentDecisions())
+
+	c.reconcile(ctx, now.Add(2*gracePeriod))
+	assert.Equal(t, PartitionInactive, getPartitionStateFromStore(t, store, ringKey, 1))
+	assert.Len(t, c.PartitionReassignmentDecisions(), 1)
+}
+
+func TestPartitionRingControllerConfig_Validate(t *testing.T) {
+	for _, policy := range []string{"", PartitionReassignmentPolicyInactive, PartitionReassignmentPolicyStandby} {
+		cfg := PartitionRingControllerConfig{Policy: policy}
+		assert.NoError(t, cfg.Validate(), "policy %q", policy)
+	}
+
+	cfg := PartitionRingControllerConfig{Policy: "unknown"}
+	assert.EqualError(t, cfg.Validate(), `unsupported partition reassignment policy "unknown", supported values are: [inactive standby]`)
+
+	_, err := NewPartitionRingController(cfg, testRingName, nil, nil, nil, log.NewNopLogger(), nil)
+	assert.Error(t, err)
+}
+
+func TestPartitionRingController_ShouldNotAssignTheSameStandbyOwnerToMultiplePartitions(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	store := initPartitionRingForControllerTest(t, map[string]int32{"instance-1": 1, "instance-2": 2, "instance-3": 3})
+
+	c, err := NewPartitionRingController(PartitionRingControllerConfig{
+		Policy:           PartitionReassignmentPolicyStandby,
+		HeartbeatTimeout: time.Minute,
+		StandbyOwnerIDs:  []string{"standby-1"},
+	}, testRingName, &partitionRingStoreReader{t: t, store: store}, &Ring{ringDesc: &Desc{Ingesters: map[string]InstanceDesc{
+		"instance-3": {Id: "instance-3", Timestamp: now.Add(time.Hour).Unix()},
+		"standby-1":  {Id: "standby-1", Zone: "zone-a", Timestamp: now.Add(time.Hour).Unix()},
+	}}}, NewPartitionRingEditor(ringKey, store), log.NewNopLogger(), nil)
+	require.NoError(t, err)
+
+	// Both partitions 1 and 2 are ownerless, but there's only one standby owner.
+	c.reconcile(ctx, now)
+
+	assert.Equal(t, map[int32][]string{1: {"standby-1"}, 2: {"instance-2"}, 3: {"instance-3"}}, getPartitionOwnersFromStore(t, store))
+	assert.Equal(t, PartitionActive, getPartitionStateFromStore(t, store, ringKey, 1))
+	assert.Equal(t, PartitionInactive, getPartitionStateFromStore(t, store, ringKey, 2))
+	assert.Equal(t, PartitionActive, getPartitionStateFromStore(t, store, ringKey, 3))
+	assert.Equal(t, "zone-a", getPartitionRingFromStore(t, store, ringKey).Owners["standby-1"].Zone)
+
+	decisions := c.PartitionReassignmentDecisions()
+	require.Len(t, decisions, 2)
+	assert.Equal(t, int32(2), decisions[0].PartitionID)
+	assert.Equal(t, PartitionReassignmentPolicyInactive, decisions[0].Action)
+	assert.Empty(t, decisions[0].Error)
+	assert.Equal(t, int32(1), decisions[1].PartitionID)
+	assert.Equal(t, PartitionReassignmentPolicyStandby, decisions[1].Action)
+	assert.Equal(t, "standby-1", decisions[1].NewOwner)
+	assert.Empty(t, decisions[1].Error)
+	assert.Empty(t, c.ownerlessSince)
+}
+
+func TestPartitionRingController_ShouldFallBackToInactiveWhenStandbyReassignmentFails(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	store := initPartitionRingForControllerTest(t, map[string]int32{"instance-1": 1, "instance-2": 2})
+
+	// The controller reads a stale partitions ring, where the standby owner doesn't own any partition yet.
+	staleRing := NewPartitionRing(*getPartitionRingFromStore(t, store, ringKey))
+	require.NoError(t, NewPartitionRingEditor(ringKey, store).ReassignPartition(ctx, 2, []string{"instance-2"}, "standby-1", ""))
+
+	c, err := NewPartitionRingController(PartitionRingControllerConfig{
+		Policy:           PartitionReassignmentPolicyStandby,
+		HeartbeatTimeout: time.Minute,
+		StandbyOwnerIDs:  []string{"standby-1"},
+	}, testRingName, newStaticPartitionRingReader(staleRing), &Ring{ringDesc: &Desc{Ingesters: map[string]InstanceDesc{
+		"instance-2": {Id: "instance-2", Timestamp: now.Add(time.Hour).Unix()},
+		"standby-1":  {Id: "standby-1", Timestamp: now.Add(time.Hour).Unix()},
+	}}}, NewPartitionRingEditor(ringKey, store), log.NewNopLogger(), nil)
+	require.NoError(t, err)
+
+	c.reconcile(ctx, now)
+
+	assert.Equal(t, map[int32][]string{1: {"instance-1"}, 2: {"standby-1"}}, getPartitionOwnersFromStore(t, store))
+	assert.Equal(t, PartitionInactive, getPartitionStateFromStore(t, store, ringKey, 1))
+
+	decisions := c.PartitionReassignmentDecisions()
+	require.Len(t, decisions, 2)
+	assert.Equal(t, PartitionReassignmentPolicyInactive, decisions[0].Action)
+	assert.Empty(t, decisions[0].Error)
+	assert.Equal(t, PartitionReassignmentPolicyStandby, decisions[1].Action)
+	assert.Equal(t, "standby-1", decisions[1].NewOwner)
+	assert.NotEmpty(t, decisions[1].Error)
+	assert.Equal(t, float64(1), testutil.ToFloat64(c.reassignmentsFailures.WithLabelValues(PartitionReassignmentPolicyStandby)))
+	assert.Equal(t, float64(0), testutil.ToFloat64(c.reassignmentsFailures.WithLabelValues(PartitionReassignmentPolicyInactive)))
+
+	// The policy has been successfully applied, so the partition should not be tracked anymore.
+	assert.Empty(t, c.ownerlessSince)
+}
+
+func TestPartitionRingController_ShouldKeepTrackingPartitionWhenPolicyFails(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	store := initPartitionRingForControllerTest(t, map[string]int32{"instance-1": 1})
+
+	// The controller reads a partitions ring with a partition which doesn't exist in the store,
+	// so any change to it fails.
+	staleDesc := getPartitionRingFromStore(t, store, ringKey)
+	staleDesc.AddPartition(2, PartitionActive, now)
+	staleDesc.AddOrUpdateOwner("instance-2", OwnerActive, 2, now)
+
+	c, err := NewPartitionRingController(PartitionRingControllerConfig{
+		HeartbeatTimeout: time.Minute,
+	}, testRingName, newStaticPartitionRingReader(NewPartitionRing(*staleDesc)), &Ring{ringDesc: &Desc{Ingesters: map[string]InstanceDesc{
+		"instance-1": {Id: "instance-1", Timestamp: now.Add(time.Hour).Unix()},
+	}}}, NewPartitionRingEditor(ringKey, store), log.NewNopLogger(), nil)
+	require.NoError(t, err)
+
+	c.reconcile(ctx, now)
+
+	decisions := c.PartitionReassignmentDecisions()
+	require.Len(t, decisions, 1)
+	assert.Equal(t, int32(2), decisions[0].PartitionID)
+	assert.NotEmpty(t, decisions[0].Error)
+
+	// The partition should still be tracked, keeping the time it was detected, so that the policy is retried.
+	assert.Equal(t, map[int32]time.Time{2: now}, c.ownerlessSince)
+
+	c.reconcile(ctx, now.Add(time.Second))
+	assert.Len(t, c.PartitionReassignmentDecisions(), 2)
+	assert.Equal(t, map[int32]time.Time{2: now}, c.ownerlessSince)
+}
+
+func TestPartitionRingController_Service(t *testing.T) {
+	ctx := context.Background()
+	logger := log.NewNopLogger()
+	store := initPartitionRingForControllerTest(t, map[string]int32{"instance-1": 1, "instance-2": 2})
+
+	watcher := NewPartitionRingWatcher(testRingName, ringKey, store, logger, nil)
+	require.NoError(t, services.StartAndAwaitRunning(ctx, watcher))
+	t.Cleanup(func() {
+		require.NoError(t, services.StopAndAwaitTerminated(ctx, watcher))
+	})
+
+	instancesRing := &Ring{ringDesc: &Desc{Ingesters: map[string]InstanceDesc{
+		"instance-2": {Id: "instance-2", Timestamp: time.Now().Add(time.Hour).Unix()},
+		"standby-1":  {Id: "standby-1", Timestamp: time.Now().Add(time.Hour).Unix()},
+	}}}
+
+	c, err := NewPartitionRingController(PartitionRingControllerConfig{
+		Policy:           PartitionReassignmentPolicyStandby,
+		HeartbeatTimeout: time.Minute,
+		StandbyOwnerIDs:  []string{"standby-1"},
+		PollingInterval:  10 * time.Millisecond,
+	}, testRingName, watcher, instancesRing, NewPartitionRingEditor(ringKey, store), logger, nil)
+	require.NoError(t, err)
+	require.NoError(t, services.StartAndAwaitRunning(ctx, c))
+	t.Cleanup(func() {
+		require.NoError(t, services.StopAndAwaitTerminated(ctx, c))
+	})
+
+	test.Poll(t, time.Second, map[int32][]string{1: {"standby-1"}, 2: {"instance-2"}}, func() interface{} {
+		return getPartitionOwnersFromStore(t, store)
+	})
+
+	// The standby owner is now in use, so it should never be reassigned to another partition.
+	time.Sleep(100 * time.Millisecond)
+	assert.Len(t, c.PartitionReassignmentDecisions(), 1)
+}
+
+func initPartitionRingForControllerTest(t *testing.T, owners map[string]int32) kv.Client {
+	store, closer := consul.NewInMemoryClient(GetPartitionRingCodec(), log.NewNopLogger(), nil)
+	t.Cleanup(func() { assert.NoError(t, closer.Close()) })
+
+	require.NoError(t, store.CAS(context.Background(), ringKey, func(in interface{}) (out interface{}, retry bool, err error) {
+		desc := GetOrCreatePartitionRingDesc(in)
+		for ownerID, partitionID := range owners {
+			desc.AddPartition(partitionID, PartitionActive, time.Now())
+			desc.AddOrUpdateOwner(ownerID, OwnerActive, partitionID, time.Now())
+		}
+		return desc, true, nil
+	}))
+
+	return store
+}
+
+func getPartitionOwnersFromStore(t *testing.T, store kv.Client) map[int32][]string {
+	ring := NewPartitionRing(*getPartitionRingFromStore(t, store, ringKey))
+
+	owners := map[int32][]string{}
+	for _, partitionID := range ring.PartitionIDs() {
+		owners[partitionID] = ring.PartitionOwnerIDsCopy(partitionID)
+	}
+	return owners
+}
+
+// partitionRingStoreReader is a PartitionRingReader reading the partitions ring from the store at every call.
+type partitionRingStoreReader struct {
+	t     *testing.T
+	store kv.Client
+}
+
+func (r *partitionRingStoreReader) PartitionRing() *PartitionRing {
+	return NewPartitionRing(*getPartitionRingFromStore(r.t, r.store, ringKey))
+}
@@ -232,6 +232,9 @@ type OwnerDesc struct {
 	// This timestamp is used to resolve conflicts when merging updates via memberlist (the most recent
 	// update wins).
 	UpdatedTimestamp int64 `protobuf:"varint,3,opt,name=updatedTimestamp,proto3" json:"updatedTimestamp,omitempty"`
+	// The zone of the owner. This field is optional, and it's used to map keys to partitions owned
+	// by different zones when the partitions ring replication factor is greater than 1.
+	Zone string `protobuf:"bytes,4,opt,name=zone,proto3" json:"zone,omitempty"`
 }
 
 func (m *OwnerDesc) Reset()      { *m = OwnerDesc{} }
@@ -287,6 +290,13 @@ func (m *OwnerDesc) GetUpdatedTimestamp() int64 {
 	return 0
 }
 
+func (m *OwnerDesc) GetZone() string {
+	if m != nil {
+		return m.Zone
+	}
+	return ""
+}
+
 func init() {
 	proto.RegisterEnum("ring.PartitionState", PartitionState_name, PartitionState_value)
 	proto.RegisterEnum("ring.OwnerState", OwnerState_name, OwnerState_value)
@@ -300,39 +310,39 @@ func init() {
 func init() { proto.RegisterFile("partition_ring_desc.proto", fileDescriptor_4df2762174d93dc4) }
 
 var fileDescriptor_4df2762174d93dc4 = []byte{
-	// 497 bytes of a gzipped FileDescriptorProto
+	// 504 bytes of a gzipped FileDescriptorProto
 	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x74, 0x93, 0x31, 0x6f, 0xd3, 0x40,
-	0x14, 0xc7, 0x7d, 0x76, 0x12, 0xa9, 0x2f, 0x34, 0x39, 0xae, 0x05, 0x99, 0x0c, 0x47, 0x14, 0x44,
-	0x09, 0x91, 0x48, 0xa5, 0xc0, 0x80, 0xd8, 0x52, 0x95, 0x01, 0x24, 0x44, 0x65, 0x60, 0xae, 0x9c,
-	0xf8, 0x30, 0xa7, 0x34, 0x77, 0x91, 0x7d, 0x6e, 0xd5, 0x05, 0xb1, 0x31, 0xb0, 0xf0, 0x31, 0xf8,
-	0x22, 0x48, 0x1d, 0x33, 0x76, 0x42, 0xc4, 0x59, 0x18, 0xfb, 0x11, 0x90, 0xcf, 0xae, 0x63, 0xbb,
-	0xea, 0x76, 0xef, 0x7f, 0xef, 0xfd, 0xfe, 0xff, 0x3b, 0x9f, 0xe1, 0xc1, 0xc2, 0x0d, 0x14, 0x57,
-	0x5c, 0x8a, 0xe3, 0x80, 0x0b, 0xff, 0xd8, 0x63, 0xe1, 0x74, 0xb8, 0x08, 0xa4, 0x92, 0xa4, 0x96,
-	0x08, 0x9d, 0x67, 0x3e, 0x57, 0x5f, 0xa2, 0xc9, 0x70, 0x2a, 0xe7, 0xfb, 0xbe, 0xf4, 0xe5, 0xbe,
-	0xde, 0x9c, 0x44, 0x9f, 0x75, 0xa5, 0x0b, 0xbd, 0x4a, 0x87, 0x7a, 0xbf, 0x4d, 0xb8, 0x7b, 0x74,
-	0x8d, 0x74, 0xb8, 0xf0, 0x0f, 0x59, 0x38, 0x25, 0xef, 0x00, 0x72, 0x9f, 0xd0, 0x46, 0x5d, 0xab,
-	0xdf, 0x1c, 0x3d, 0x19, 0x26, 0xfc, 0xe1, 0x8d, 0xe6, 0x8d, 0x12, 0xbe, 0x16, 0x2a, 0x38, 0x3f,
-	0xa8, 0x5d, 0xfc, 0x79, 0x68, 0x38, 0x05, 0x00, 0x19, 0x43, 0x43, 0x9e, 0x09, 0x16, 0x84, 0xb6,
-	0xa9, 0x51, 0x8f, 0x6e, 0x43, 0xbd, 0xd7, 0x5d, 0x45, 0x4c, 0x36, 0xd8, 0x71, 0xa0, 0x5d, 0xf1,
-	0x21, 0x18, 0xac, 0x19, 0x3b, 0xb7, 0x51, 0x17, 0xf5, 0xeb, 0x4e, 0xb2, 0x24, 0x4f, 0xa1, 0x7e,
-	0xea, 0x9e, 0x44, 0xcc, 0x36, 0xbb, 0xa8, 0xdf, 0x1c, 0xed, 0x54, 0x6c, 0x12, 0x0b, 0x27, 0xed,
-	0x78, 0x65, 0xbe, 0x44, 0x9d, 0xb7, 0xd0, 0x2c, 0x18, 0x16, 0x79, 0x5b, 0x29, 0xef, 0x71, 0x99,
-	0xd7, 0x4e, 0x79, 0x7a, 0xa6, 0xc2, 0xea, 0xfd, 0x40, 0xb0, 0x5d, 0x32, 0x22, 0x2d, 0x30, 0xb9,
-	0x67, 0xd7, 0x74, 0x3a, 0x93, 0x7b, 0xe4, 0x3e, 0x34, 0x94, 0x9c, 0xb1, 0xec, 0x3e, 0xb7, 0x9d,
-	0xac, 0x22, 0x03, 0xa8, 0x87, 0xca, 0x55, 0xa9, 0x49, 0x6b, 0xb4, 0x5b, 0x09, 0xfd, 0x21, 0xd9,
-	0x73, 0xd2, 0x16, 0xb2, 0x07, 0x2d, 0xbd, 0xf8, 0xc8, 0xe7, 0x2c, 0x54, 0xee, 0x7c, 0x61, 0x5b,
-	0x5d, 0xd4, 0xb7, 0x9c, 0x8a, 0xda, 0xfb, 0x8e, 0x60, 0x2b, 0x8f, 0x99, 0x4c, 0x25, 0xb7, 0xe8,
-	0xe5, 0xcc, 0xec, 0xce, 0x2a, 0x2a, 0xd9, 0x2b, 0x27, 0xc1, 0x85, 0xe3, 0x96, 0x52, 0x0c, 0x00,
-	0x47, 0x0b, 0xcf, 0x55, 0xcc, 0xab, 0xe6, 0xb8, 0xa1, 0x0f, 0xbe, 0x42, 0xab, 0x7c, 0x14, 0xb2,
-	0x0b, 0x38, 0x57, 0x3e, 0x89, 0x99, 0x90, 0x67, 0x02, 0x1b, 0x25, 0xf5, 0x88, 0x09, 0x8f, 0x0b,
-	0x1f, 0x23, 0xb2, 0x53, 0xf8, 0xea, 0xe3, 0xa9, 0xe2, 0xa7, 0x0c, 0x9b, 0xe4, 0x5e, 0xe1, 0xc5,
-	0xbe, 0x11, 0x6e, 0x2a, 0x5b, 0x25, 0xc2, 0x21, 0x3b, 0x61, 0x8a, 0x79, 0xb8, 0x36, 0x18, 0x03,
-	0x6c, 0x0e, 0x40, 0x30, 0xdc, 0xd1, 0xd5, 0xc6, 0xb7, 0x9d, 0xbd, 0x81, 0x8c, 0x8e, 0xf2, 0x96,
-	0x6b, 0x84, 0x79, 0xf0, 0x62, 0xb9, 0xa2, 0xc6, 0xe5, 0x8a, 0x1a, 0x57, 0x2b, 0x8a, 0xbe, 0xc5,
-	0x14, 0xfd, 0x8a, 0x29, 0xba, 0x88, 0x29, 0x5a, 0xc6, 0x14, 0xfd, 0x8d, 0x29, 0xfa, 0x17, 0x53,
-	0xe3, 0x2a, 0xa6, 0xe8, 0xe7, 0x9a, 0x1a, 0xcb, 0x35, 0x35, 0x2e, 0xd7, 0xd4, 0x98, 0x34, 0xf4,
-	0xff, 0xf5, 0xfc, 0x7f, 0x00, 0x00, 0x00, 0xff, 0xff, 0xc9, 0xd1, 0xa7, 0xbd, 0xb1, 0x03, 0x00,
-	0x00,
+	0x14, 0xc7, 0x7d, 0xb6, 0x1b, 0x29, 0x2f, 0x34, 0x31, 0xd7, 0x82, 0x4c, 0x86, 0x23, 0x0a, 0xa2,
+	0x84, 0x48, 0xa4, 0x52, 0x60, 0x40, 0x6c, 0xa9, 0xca, 0x00, 0x12, 0xa2, 0x3a, 0x60, 0xae, 0x9c,
+	0xf8, 0x30, 0xa7, 0x34, 0x77, 0x91, 0x7d, 0x69, 0x55, 0x06, 0xc4, 0xce, 0xc2, 0xce, 0x17, 0xe0,
+	0x8b, 0x20, 0x75, 0xcc, 0xd8, 0x09, 0x11, 0x67, 0x61, 0xec, 0x47, 0x40, 0x3e, 0xbb, 0x8e, 0xed,
+	0x8a, 0xed, 0xbd, 0xbf, 0xdf, 0xfb, 0xfd, 0xdf, 0x3d, 0x3d, 0xc3, 0xbd, 0xb9, 0x17, 0x2a, 0xae,
+	0xb8, 0x14, 0xc7, 0x21, 0x17, 0xc1, 0xb1, 0xcf, 0xa2, 0xc9, 0x60, 0x1e, 0x4a, 0x25, 0xb1, 0x9d,
+	0x08, 0xed, 0x27, 0x01, 0x57, 0x9f, 0x16, 0xe3, 0xc1, 0x44, 0xce, 0xf6, 0x03, 0x19, 0xc8, 0x7d,
+	0xfd, 0x71, 0xbc, 0xf8, 0xa8, 0x33, 0x9d, 0xe8, 0x28, 0x6d, 0xea, 0xfe, 0x32, 0xe1, 0xf6, 0xd1,
+	0x35, 0x92, 0x72, 0x11, 0x1c, 0xb2, 0x68, 0x82, 0xdf, 0x00, 0xe4, 0x3e, 0x91, 0x8b, 0x3a, 0x56,
+	0xaf, 0x31, 0x7c, 0x34, 0x48, 0xf8, 0x83, 0x1b, 0xc5, 0x1b, 0x25, 0x7a, 0x29, 0x54, 0x78, 0x7e,
+	0x60, 0x5f, 0xfc, 0xbe, 0x6f, 0xd0, 0x02, 0x00, 0x8f, 0xa0, 0x26, 0xcf, 0x04, 0x0b, 0x23, 0xd7,
+	0xd4, 0xa8, 0x07, 0xff, 0x43, 0xbd, 0xd5, 0x55, 0x45, 0x4c, 0xd6, 0xd8, 0xa6, 0xd0, 0xaa, 0xf8,
+	0x60, 0x07, 0xac, 0x29, 0x3b, 0x77, 0x51, 0x07, 0xf5, 0xb6, 0x68, 0x12, 0xe2, 0xc7, 0xb0, 0x75,
+	0xea, 0x9d, 0x2c, 0x98, 0x6b, 0x76, 0x50, 0xaf, 0x31, 0xdc, 0xa9, 0xd8, 0x24, 0x16, 0x34, 0xad,
+	0x78, 0x61, 0x3e, 0x47, 0xed, 0xd7, 0xd0, 0x28, 0x18, 0x16, 0x79, 0xf5, 0x94, 0xf7, 0xb0, 0xcc,
+	0x6b, 0xa5, 0x3c, 0xdd, 0x53, 0x61, 0x75, 0xbf, 0x21, 0xd8, 0x2e, 0x19, 0xe1, 0x26, 0x98, 0xdc,
+	0x77, 0x6d, 0x3d, 0x9d, 0xc9, 0x7d, 0x7c, 0x17, 0x6a, 0x4a, 0x4e, 0x59, 0xb6, 0xcf, 0x6d, 0x9a,
+	0x65, 0xb8, 0x0f, 0x5b, 0x91, 0xf2, 0x54, 0x6a, 0xd2, 0x1c, 0xee, 0x56, 0x86, 0x7e, 0x97, 0x7c,
+	0xa3, 0x69, 0x09, 0xde, 0x83, 0xa6, 0x0e, 0xde, 0xf3, 0x19, 0x8b, 0x94, 0x37, 0x9b, 0xbb, 0x56,
+	0x07, 0xf5, 0x2c, 0x5a, 0x51, 0xbb, 0x3f, 0x10, 0xd4, 0xf3, 0x31, 0x93, 0xae, 0x64, 0x8b, 0x7e,
+	0xce, 0xcc, 0x76, 0x56, 0x51, 0xf1, 0x5e, 0x79, 0x12, 0xa7, 0xf0, 0xdc, 0xd2, 0x14, 0x7d, 0x70,
+	0x16, 0x73, 0xdf, 0x53, 0xcc, 0xaf, 0xce, 0x71, 0x43, 0xc7, 0x18, 0xec, 0xcf, 0x52, 0x30, 0xbd,
+	0x87, 0x3a, 0xd5, 0x71, 0xff, 0x0b, 0x34, 0xcb, 0xcf, 0xc3, 0xbb, 0xe0, 0xe4, 0xca, 0x07, 0x31,
+	0x15, 0xf2, 0x4c, 0x38, 0x46, 0x49, 0x3d, 0x62, 0xc2, 0xe7, 0x22, 0x70, 0x10, 0xde, 0x29, 0x5c,
+	0xc2, 0x68, 0xa2, 0xf8, 0x29, 0x73, 0x4c, 0x7c, 0xa7, 0x70, 0xc5, 0xaf, 0x84, 0x97, 0xca, 0x56,
+	0x89, 0x70, 0xc8, 0x4e, 0x98, 0x62, 0xbe, 0x63, 0xf7, 0x47, 0x00, 0x9b, 0x47, 0x61, 0x07, 0x6e,
+	0xe9, 0x6c, 0xe3, 0xdb, 0xca, 0xee, 0x22, 0xa3, 0xa3, 0xbc, 0xe4, 0x1a, 0x61, 0x1e, 0x3c, 0x5b,
+	0xae, 0x88, 0x71, 0xb9, 0x22, 0xc6, 0xd5, 0x8a, 0xa0, 0xaf, 0x31, 0x41, 0x3f, 0x63, 0x82, 0x2e,
+	0x62, 0x82, 0x96, 0x31, 0x41, 0x7f, 0x62, 0x82, 0xfe, 0xc6, 0xc4, 0xb8, 0x8a, 0x09, 0xfa, 0xbe,
+	0x26, 0xc6, 0x72, 0x4d, 0x8c, 0xcb, 0x35, 0x31, 0xc6, 0x35, 0xfd, 0xcf, 0x3d, 0xfd, 0x37, 0x00,
+	0x32, 0xbc, 0xf2, 0x7b, 0xc5, 0x03, 0x00, 0x00,
 }
 
 func (x PartitionState) String() string {
@@ -456,6 +466,9 @@ func (this *OwnerDesc) Equal(that interface{}) bool {
 	if this.UpdatedTimestamp != that1.UpdatedTimestamp {
 		return false
 	}
+	if this.Zone != that1.Zone {
+		return false
+	}
 	return true
 }
 func (this *PartitionRingDesc) GoString() string {
@@ -510,11 +523,12 @@ func (this *OwnerDesc) GoString() string {
 	if this == nil {
 		return "nil"
 	}
-	s := make([]string, 0, 7)
+	s := make([]string, 0, 8)
 	s = append(s, "&ring.OwnerDesc{")
 	s = append(s, "OwnedPartition: "+fmt.Sprintf("%#v", this.OwnedPartition)+",\n")
 	s = append(s, "State: "+fmt.Sprintf("%#v", this.State)+",\n")
 	s = append(s, "UpdatedTimestamp: "+fmt.Sprintf("%#v", this.UpdatedTimestamp)+",\n")
+	s = append(s, "Zone: "+fmt.Sprintf("%#v", this.Zone)+",\n")
 	s = append(s, "}")
 	return strings.Join(s, "")
 }
@@ -671,6 +685,13 @@ func (m *OwnerDesc) MarshalToSizedBuffer(dAtA []byte) (int, error) {
 	_ = i
 	var l int
 	_ = l
+	if len(m.Zone) > 0 {
+		i -= len(m.Zone)
+		copy(dAtA[i:], m.Zone)
+		i = encodeVarintPartitionRingDesc(dAtA, i, uint64(len(m.Zone)))
+		i--
+		dAtA[i] = 0x22
+	}
 	if m.UpdatedTimestamp != 0 {
 		i = encodeVarintPartitionRingDesc(dAtA, i, uint64(m.UpdatedTimestamp))
 		i--
@@ -767,6 +788,10 @@ func (m *OwnerDesc) Size() (n int) {
 	if m.UpdatedTimestamp != 0 {
 		n += 1 + sovPartitionRingDesc(uint64(m.UpdatedTimestamp))
 	}
+	l = len(m.Zone)
+	if l > 0 {
+		n += 1 + l + sovPartitionRingDesc(uint64(l))
+	}
 	return n
 }
 
@@ -828,6 +853,7 @@ func (this *OwnerDesc) String() string {
 		`OwnedPartition:` + fmt.Sprintf("%v", this.OwnedPartition) + `,`,
 		`State:` + fmt.Sprintf("%v", this.State) + `,`,
 		`UpdatedTimestamp:` + fmt.Sprintf("%v", this.UpdatedTimestamp) + `,`,
+		`Zone:` + fmt.Sprintf("%v", this.Zone) + `,`,
 		`}`,
 	}, "")
 	return s
@@ -1409,6 +1435,38 @@ func (m *OwnerDesc) Unmarshal(dAtA []byte) error {
 					break
 				}
 			}
+		case 4:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Zone", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowPartitionRingDesc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthPartitionRingDesc
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthPartitionRingDesc
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Zone = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
 		default:
 			iNdEx = preIndex
 			skippy, err := skipPartitionRingDesc(dAtA[iNdEx:])
@@ -62,3 +62,32 @@ func changePartitionState(ring *PartitionRingDesc, partitionID int32, toState Pa
 
 	return ring.UpdatePartitionState(partitionID, toState, time.Now()), nil
 }
+
+// ReassignPartition removes fromOwnerIDs from the owners of the partition, and registers toOwnerID, running in the
+// toOwnerZone zone, as new owner of it. This function returns ErrPartitionDoesNotExist if the partition doesn't exist, and ErrOwnerAlreadyExists if
+// toOwnerID is already the owner of another partition.
+func (l *PartitionRingEditor) ReassignPartition(ctx context.Context, partitionID int32, fromOwnerIDs []string, toOwnerID, toOwnerZone string) error {
+	return l.updateRing(ctx, func(ring *PartitionRingDesc) (bool, error) {
+		return reassignPartition(ring, partitionID, fromOwnerIDs, toOwnerID, toOwnerZone, time.Now())
+	})
+}
+
+func reassignPartition(ring *PartitionRingDesc, partitionID int32, fromOwnerIDs []string, toOwnerID, toOwnerZone string, now time.Time) (changed bool, _ error) {
+	if !ring.HasPartition(partitionID) {
+		return false, ErrPartitionDoesNotExist
+	}
+
+	if owner, exists := ring.Owners[toOwnerID]; exists && owner.OwnedPartition != partitionID {
+		return false, errors.Wrapf(ErrOwnerAlreadyExists, "owner %s owns partition %d", toOwnerID, owner.OwnedPartition)
+	}
+
+	for _, ownerID := range fromOwnerIDs {
+		// Only remove owners which still own the partition.
+		if owner, exists := ring.Owners[ownerID]; exists && owner.OwnedPartition == partitionID && ownerID != toOwnerID {
+			changed = ring.RemoveOwner(ownerID) || changed
+		}
+	}
+
+	changed = ring.AddOrUpdateOwnerWithZone(toOwnerID, toOwnerZone, OwnerActive, partitionID, now) || changed
+	return changed, nil
+}
@@ -48,3 +48,43 @@ func TestPartitionRingEditor_ChangePartitionState(t *testing.T) {
 	require.Equal(t, PartitionInactive, getPartitionStateFromStore(t, store, ringKey, 1))
 	require.Equal(t, PartitionActive, getPartitionStateFromStore(t, store, ringKey, 2))
 }
+
+func TestPartitionRingEditor_ReassignPartition(t *testing.T) {
+	ctx := context.Background()
+
+	store, closer := consul.NewInMemoryClient(GetPartitionRingCodec(), log.NewNopLogger(), nil)
+	t.Cleanup(func() { assert.NoError(t, closer.Close()) })
+
+	// Init the ring.
+	require.NoError(t, store.CAS(ctx, ringKey, func(in interface{}) (out interface{}, retry bool, err error) {
+		desc := GetOrCreatePartitionRingDesc(in)
+		desc.AddPartition(1, PartitionActive, time.Now())
+		desc.AddPartition(2, PartitionActive, time.Now())
+		desc.AddOrUpdateOwnerWithZone("instance-zone-a-1", "zone-a", OwnerActive, 1, time.Now())
+		desc.AddOrUpdateOwnerWithZone("instance-zone-b-1", "zone-b", OwnerActive, 1, time.Now())
+		desc.AddOrUpdateOwnerWithZone("instance-zone-a-2", "zone-a", OwnerActive, 2, time.Now())
+		return desc, true, nil
+	}))
+
+	editor := NewPartitionRingEditor(ringKey, store)
+
+	// A request to reassign a non-existing partition should return error.
+	require.ErrorIs(t, editor.ReassignPartition(ctx, 3, nil, "instance-zone-a-3", "zone-a"), ErrPartitionDoesNotExist)
+
+	// A request to reassign a partition to an owner of another partition should return error.
+	require.ErrorIs(t, editor.ReassignPartition(ctx, 1, []string{"instance-zone-a-1"}, "instance-zone-a-2", "zone-a"), ErrOwnerAlreadyExists)
+	assert.Equal(t, map[int32][]string{1: {"instance-zone-a-1", "instance-zone-b-1"}, 2: {"instance-zone-a-2"}}, getPartitionOwnersFromStore(t, store))
+
+	// Reassign the partition. Owners of other partitions should not be removed.
+	require.NoError(t, editor.ReassignPartition(ctx, 1, []string{"instance-zone-a-1", "instance-zone-a-2"}, "instance-zone-a-3", "zone-a"))
+	assert.Equal(t, map[int32][]string{1: {"instance-zone-a-3", "instance-zone-b-1"}, 2: {"instance-zone-a-2"}}, getPartitionOwnersFromStore(t, store))
+
+	// The new owner should be registered with its zone, so that zone-aware lookups keep working.
+	desc := getPartitionRingFromStore(t, store, ringKey)
+	assert.Equal(t, "zone-a", desc.Owners["instance-zone-a-3"].Zone)
+	assert.Equal(t, "zone-b", desc.Owners["instance-zone-b-1"].Zone)
+
+	// A request to reassign the partition to the same owner should be a no-op.
+	require.NoError(t, editor.ReassignPartition(ctx, 1, []string{"instance-zone-a-1"}, "instance-zone-a-3", "zone-a"))
+	assert.Equal(t, map[int32][]string{1: {"instance-zone-a-3", "instance-zone-b-1"}, 2: {"instance-zone-a-2"}}, getPartitionOwnersFromStore(t, store))
+}
@@ -29,8 +29,9 @@ type PartitionRingUpdater interface {
 }
 
 type PartitionRingPageHandler struct {
-	reader  PartitionRingReader
-	updater PartitionRingUpdater
+	reader    PartitionRingReader
+	updater   PartitionRingUpdater
+	decisions PartitionReassignmentDecisionsReader
 }
 
 func NewPartitionRingPageHandler(reader PartitionRingReader, updater PartitionRingUpdater) *PartitionRingPageHandler {
@@ -40,6 +41,13 @@ func NewPartitionRingPageHandler(reader PartitionRingReader, updater PartitionRi
 	}
 }
 
+// WithReassignmentDecisions configures the handler to show the most recent decisions taken
+// on ownerless partitions, typically by a PartitionRingController.
+func (h *PartitionRingPageHandler) WithReassignmentDecisions(decisions PartitionReassignmentDecisionsReader) *PartitionRingPageHandler {
+	h.decisions = decisions
+	return h
+}
+
 func (h *PartitionRingPageHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	switch req.Method {
 	case http.MethodGet:
@@ -106,8 +114,14 @@ func (h *PartitionRingPageHandler) handleGetRequest(w http.ResponseWriter, req *
 		return partitions[i].ID < partitions[j].ID
 	})
 
+	var decisions []PartitionReassignmentDecision
+	if h.decisions != nil {
+		decisions = h.decisions.PartitionReassignmentDecisions()
+	}
+
 	renderHTTPResponse(w, partitionRingPageData{
-		Partitions: partitions,
+		Partitions:            partitions,
+		ReassignmentDecisions: decisions,
 		PartitionStateChanges: map[PartitionState]PartitionState{
 			PartitionPending:  PartitionActive,
 			PartitionActive:   PartitionInactive,
@@ -145,6 +159,9 @@ func (h *PartitionRingPageHandler) handlePostRequest(w http.ResponseWriter, req
 type partitionRingPageData struct {
 	Partitions []partitionPageData `json:"partitions"`
 
+	// ReassignmentDecisions are the most recent decisions taken on ownerless partitions.
+	ReassignmentDecisions []PartitionReassignmentDecision `json:"reassignment_decisions,omitempty"`
+
 	// PartitionStateChanges maps the allowed state changes through the UI.
 	PartitionStateChanges map[PartitionState]PartitionState `json:"-"`
 }
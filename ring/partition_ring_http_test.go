@@ -198,3 +198,39 @@ func TestPartitionRingPageHandler_ChangePartitionState(t *testing.T) {
 		require.Equal(t, PartitionActive, getPartitionStateFromStore(t, store, ringKey, 2))
 	})
 }
+
+func TestPartitionRingPageHandler_ViewPageWithReassignmentDecisions(t *testing.T) {
+	handler := NewPartitionRingPageHandler(
+		newStaticPartitionRingReader(
+			NewPartitionRing(PartitionRingDesc{
+				Partitions: map[int32]PartitionDesc{
+					1: {State: PartitionActive, StateTimestamp: time.Now().Unix()},
+				},
+				Owners: map[string]OwnerDesc{
+					"ingester-zone-a-3": {OwnedPartition: 1},
+				},
+			}),
+		),
+		nil,
+	).WithReassignmentDecisions(staticPartitionReassignmentDecisionsReader{
+		{Timestamp: time.Now(), PartitionID: 1, Action: PartitionReassignmentPolicyStandby, PreviousOwners: []string{"ingester-zone-a-0"}, NewOwner: "ingester-zone-a-3"},
+	})
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/partition-ring", nil))
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Regexp(t, regexp.MustCompile(fmt.Sprintf("(?m)%s", strings.Join([]string{
+		"<td>", "1", "</td>",
+		"<td>", "standby", "</td>",
+		"<td>", "ingester-zone-a-0", "<br />", "</td>",
+		"<td>", "ingester-zone-a-3", "</td>",
+		"<td>", "</td>",
+	}, `\s*`))), recorder.Body.String())
+}
+
+type staticPartitionReassignmentDecisionsReader []PartitionReassignmentDecision
+
+func (r staticPartitionReassignmentDecisionsReader) PartitionReassignmentDecisions() []PartitionReassignmentDecision {
+	return r
+}
@@ -109,6 +109,24 @@ func (m *PartitionRingDesc) ownersByPartition() map[int32][]string {
 	return out
 }
 
+// zonesByPartition returns a map where the key is the partition ID and the value is the sorted list of
+// the distinct zones of its owners. Owners without a zone are ignored.
+func (m *PartitionRingDesc) zonesByPartition() map[int32][]string {
+	out := make(map[int32][]string, len(m.Partitions))
+	for _, o := range m.Owners {
+		if o.Zone == "" || slices.Contains(out[o.OwnedPartition], o.Zone) {
+			continue
+		}
+		out[o.OwnedPartition] = append(out[o.OwnedPartition], o.Zone)
+	}
+
+	for id := range out {
+		slices.Sort(out[id])
+	}
+
+	return out
+}
+
 // countPartitionsByState returns a map containing the number of partitions by state.
 func (m *PartitionRingDesc) countPartitionsByState() map[PartitionState]int {
 	// Init the map to have to zero values for all states.
@@ -206,12 +224,19 @@ func (m *PartitionRingDesc) HasPartition(id int32) bool {
 }
 
 // AddOrUpdateOwner adds or updates a partition owner in the ring. Returns true, if the
-// owner was added or updated, false if it was left unchanged.
+// owner was added or updated, false if it was left unchanged. The zone of an existing
+// owner is preserved.
 func (m *PartitionRingDesc) AddOrUpdateOwner(id string, state OwnerState, ownedPartition int32, now time.Time) bool {
+	return m.AddOrUpdateOwnerWithZone(id, m.Owners[id].Zone, state, ownedPartition, now)
+}
+
+// AddOrUpdateOwnerWithZone is like AddOrUpdateOwner, but also sets the zone of the owner.
+func (m *PartitionRingDesc) AddOrUpdateOwnerWithZone(id, zone string, state OwnerState, ownedPartition int32, now time.Time) bool {
 	prev, ok := m.Owners[id]
 	updated := OwnerDesc{
 		State:          state,
 		OwnedPartition: ownedPartition,
+		Zone:           zone,
 
 		// Preserve the previous timestamp so that we'll NOT compare it.
 		// Then, if we detect that the OwnerDesc should be updated, we'll
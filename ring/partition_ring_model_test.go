@@ -137,6 +137,44 @@ func TestPartitionRingDesc_AddOrUpdateOwner(t *testing.T) {
 	})
 }
 
+func TestPartitionRingDesc_AddOrUpdateOwnerWithZone(t *testing.T) {
+	now := time.Now()
+
+	desc := NewPartitionRingDesc()
+	require.True(t, desc.AddOrUpdateOwnerWithZone("instance-1", "zone-a", OwnerActive, 1, now))
+	require.False(t, desc.AddOrUpdateOwnerWithZone("instance-1", "zone-a", OwnerActive, 1, now.Add(time.Second)))
+
+	// Updating the owner without a zone should preserve the zone.
+	require.False(t, desc.AddOrUpdateOwner("instance-1", OwnerActive, 1, now.Add(time.Second)))
+	assert.Equal(t, "zone-a", desc.Owners["instance-1"].Zone)
+
+	// Update the zone.
+	require.True(t, desc.AddOrUpdateOwnerWithZone("instance-1", "zone-b", OwnerActive, 1, now.Add(2*time.Second)))
+
+	assert.Equal(t, map[string]OwnerDesc{
+		"instance-1": {
+			UpdatedTimestamp: now.Add(2 * time.Second).Unix(),
+			State:            OwnerActive,
+			OwnedPartition:   1,
+			Zone:             "zone-b",
+		},
+	}, desc.Owners)
+}
+
+func TestPartitionRingDesc_zonesByPartition(t *testing.T) {
+	desc := NewPartitionRingDesc()
+	desc.AddOrUpdateOwnerWithZone("instance-zone-b-1", "zone-b", OwnerActive, 1, time.Now())
+	desc.AddOrUpdateOwnerWithZone("instance-zone-a-1", "zone-a", OwnerActive, 1, time.Now())
+	desc.AddOrUpdateOwnerWithZone("instance-zone-a-1-bis", "zone-a", OwnerActive, 1, time.Now())
+	desc.AddOrUpdateOwnerWithZone("instance-zone-a-2", "zone-a", OwnerActive, 2, time.Now())
+	desc.AddOrUpdateOwner("instance-3", OwnerActive, 3, time.Now())
+
+	assert.Equal(t, map[int32][]string{
+		1: {"zone-a", "zone-b"},
+		2: {"zone-a"},
+	}, desc.zonesByPartition())
+}
+
 func TestPartitionRingDesc_Merge_AddPartition(t *testing.T) {
 	tests := map[string]struct {
 		local                *PartitionRingDesc
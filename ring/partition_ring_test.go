@@ -131,6 +131,131 @@ func TestPartitionRing_ActivePartitionForKey(t *testing.T) {
 	}
 }
 
+func TestPartitionRing_ActivePartitionsForKey(t *testing.T) {
+	type addPartition struct {
+		state       PartitionState
+		ownersZones []string
+	}
+
+	tests := map[string]struct {
+		partitions           []addPartition
+		replicationFactor    int
+		zoneAwarenessEnabled bool
+		key                  uint32
+
+		expectedPartitionIDs []int32
+		expectedErr          error
+	}{
+		"no partitions": {
+			replicationFactor: 2,
+			key:               1,
+			expectedErr:       ErrNoActivePartitionFound,
+		},
+		"replication factor 1": {
+			partitions:           []addPartition{{state: PartitionActive}, {state: PartitionActive}, {state: PartitionActive}},
+			replicationFactor:    1,
+			key:                  150,
+			expectedPartitionIDs: []int32{1},
+		},
+		"replication factor 2": {
+			partitions:           []addPartition{{state: PartitionActive}, {state: PartitionActive}, {state: PartitionActive}},
+			replicationFactor:    2,
+			key:                  150,
+			expectedPartitionIDs: []int32{1, 2},
+		},
+		"replication factor 2, wrapping around the ring": {
+			partitions:           []addPartition{{state: PartitionActive}, {state: PartitionActive}, {state: PartitionActive}},
+			replicationFactor:    2,
+			key:                  250,
+			expectedPartitionIDs: []int32{2, 0},
+		},
+		"replication factor 2, INACTIVE and PENDING partitions are skipped": {
+			partitions:           []addPartition{{state: PartitionActive}, {state: PartitionInactive}, {state: PartitionPending}, {state: PartitionActive}},
+			replicationFactor:    2,
+			key:                  50,
+			expectedPartitionIDs: []int32{0, 3},
+		},
+		"replication factor greater than the number of ACTIVE partitions": {
+			partitions:           []addPartition{{state: PartitionActive}, {state: PartitionInactive}, {state: PartitionActive}},
+			replicationFactor:    3,
+			key:                  150,
+			expectedPartitionIDs: []int32{2, 0},
+		},
+		"replication factor 2, zone-awareness enabled": {
+			partitions: []addPartition{
+				{state: PartitionActive, ownersZones: []string{"zone-a"}},
+				{state: PartitionActive, ownersZones: []string{"zone-a"}},
+				{state: PartitionActive, ownersZones: []string{"zone-b"}},
+			},
+			replicationFactor:    2,
+			zoneAwarenessEnabled: true,
+			key:                  50,
+			expectedPartitionIDs: []int32{0, 2}, // partition 1 is skipped, because it's in the same zone of partition 0
+		},
+		"replication factor 2, zone-awareness enabled, partitions owned by multiple zones": {
+			partitions: []addPartition{
+				{state: PartitionActive, ownersZones: []string{"zone-a", "zone-b"}},
+				{state: PartitionActive, ownersZones: []string{"zone-b", "zone-c"}},
+				{state: PartitionActive, ownersZones: []string{"zone-c", "zone-d"}},
+			},
+			replicationFactor:    2,
+			zoneAwarenessEnabled: true,
+			key:                  50,
+			expectedPartitionIDs: []int32{0, 2}, // partition 1 is skipped, because it shares zone-b with partition 0
+		},
+		"replication factor 2, zone-awareness enabled, partitions without owners zones are never skipped": {
+			partitions: []addPartition{
+				{state: PartitionActive, ownersZones: []string{"zone-a"}},
+				{state: PartitionActive},
+				{state: PartitionActive, ownersZones: []string{"zone-b"}},
+			},
+			replicationFactor:    2,
+			zoneAwarenessEnabled: true,
+			key:                  50,
+			expectedPartitionIDs: []int32{0, 1},
+		},
+		"replication factor greater than the number of zones, zone-awareness enabled": {
+			partitions: []addPartition{
+				{state: PartitionActive, ownersZones: []string{"zone-a"}},
+				{state: PartitionActive, ownersZones: []string{"zone-b"}},
+				{state: PartitionActive, ownersZones: []string{"zone-a"}},
+				{state: PartitionActive, ownersZones: []string{"zone-b"}},
+			},
+			replicationFactor:    3,
+			zoneAwarenessEnabled: true,
+			key:                  50,
+			expectedPartitionIDs: []int32{0, 1},
+		},
+	}
+
+	for testName, testCase := range tests {
+		t.Run(testName, func(t *testing.T) {
+			// Each partition has 1 token, so that the order of partitions in the ring is predictable:
+			// partition 0 has token 100, partition 1 has token 200, and so on.
+			desc := NewPartitionRingDesc()
+			for pIdx, p := range testCase.partitions {
+				desc.Partitions[int32(pIdx)] = PartitionDesc{Id: int32(pIdx), Tokens: []uint32{uint32(pIdx+1) * 100}, State: p.state}
+
+				for _, zone := range p.ownersZones {
+					desc.AddOrUpdateOwnerWithZone(fmt.Sprintf("instance-%s-%d", zone, pIdx), zone, OwnerActive, int32(pIdx), time.Now())
+				}
+			}
+
+			pr := NewPartitionRingWithReplication(*desc, PartitionRingReplicationConfig{
+				ReplicationFactor:    testCase.replicationFactor,
+				ZoneAwarenessEnabled: testCase.zoneAwarenessEnabled,
+			})
+
+			partitionIDs, err := pr.ActivePartitionsForKey(testCase.key, nil)
+
+			assert.ErrorIs(t, err, testCase.expectedErr)
+			if testCase.expectedErr == nil {
+				assert.Equal(t, testCase.expectedPartitionIDs, partitionIDs)
+			}
+		})
+	}
+}
+
 func TestPartitionRing_ActivePartitionForKey_NoMemoryAllocations(t *testing.T) {
 	const (
 		numActivePartitions   = 100
@@ -212,6 +337,76 @@ func TestPartitionRing_ShuffleShard(t *testing.T) {
 	})
 }
 
+func TestPartitionRing_ShuffleShard_WithReplication(t *testing.T) {
+	t.Run("should never return less partitions than the replication factor", func(t *testing.T) {
+		desc := createPartitionRingWithPartitions(10, 0, 0).desc
+		ring := NewPartitionRingWithReplication(desc, PartitionRingReplicationConfig{ReplicationFactor: 3})
+
+		for shardSize := 1; shardSize <= 3; shardSize++ {
+			subring, err := ring.ShuffleShard("tenant-id", shardSize)
+			require.NoError(t, err)
+			assert.Equal(t, 3, subring.PartitionsCount())
+			assert.Equal(t, 3, ring.ShuffleShardSize(shardSize))
+			assert.Equal(t, 3, subring.ReplicationFactor())
+		}
+
+		// A shard size greater than the replication factor should be honored.
+		subring, err := ring.ShuffleShard("tenant-id", 5)
+		require.NoError(t, err)
+		assert.Equal(t, 5, subring.PartitionsCount())
+		assert.Equal(t, 5, ring.ShuffleShardSize(5))
+	})
+
+	t.Run("should pick partitions evenly from each zone when zone-awareness is enabled", func(t *testing.T) {
+		const numPartitionsPerZone = 10
+
+		desc := NewPartitionRingDesc()
+		for i := 0; i < 3*numPartitionsPerZone; i++ {
+			zone := fmt.Sprintf("zone-%c", 'a'+i%3)
+			desc.AddPartition(int32(i), PartitionActive, time.Now())
+			desc.AddOrUpdateOwnerWithZone(fmt.Sprintf("instance-%d", i), zone, OwnerActive, int32(i), time.Now())
+		}
+
+		ring := NewPartitionRingWithReplication(*desc, PartitionRingReplicationConfig{ReplicationFactor: 3, ZoneAwarenessEnabled: true})
+
+		for _, testCase := range []struct{ shardSize, expectedSize int }{{1, 3}, {3, 3}, {4, 6}, {6, 6}, {100, 30}} {
+			for _, tenantID := range []string{"tenant-1", "tenant-2", "tenant-3"} {
+				subring, err := ring.ShuffleShard(tenantID, testCase.shardSize)
+				require.NoError(t, err)
+				require.Equal(t, testCase.expectedSize, subring.PartitionsCount())
+				assert.Equal(t, testCase.expectedSize, ring.ShuffleShardSize(testCase.shardSize))
+
+				partitionsByZone := map[string]int{}
+				for _, partitionID := range subring.PartitionIDs() {
+					partitionsByZone[subring.zonesByPartition[partitionID][0]]++
+				}
+				assert.Equal(t, map[string]int{"zone-a": testCase.expectedSize / 3, "zone-b": testCase.expectedSize / 3, "zone-c": testCase.expectedSize / 3}, partitionsByZone)
+
+				// Each key should be mapped to 1 partition per zone within the shard.
+				partitionIDs, err := subring.ActivePartitionsForKey(12345, nil)
+				require.NoError(t, err)
+				assert.Len(t, partitionIDs, 3)
+			}
+		}
+	})
+
+	t.Run("should return the same shard of a ring without replication when the replication factor is 1 and zone-awareness is disabled", func(t *testing.T) {
+		desc := createPartitionRingWithPartitions(20, 5, 5).desc
+		ring := NewPartitionRing(desc)
+		ringWithReplication := NewPartitionRingWithReplication(desc, PartitionRingReplicationConfig{ReplicationFactor: 1})
+
+		for shardSize := 0; shardSize <= 20; shardSize++ {
+			expected, err := ring.ShuffleShard("tenant-id", shardSize)
+			require.NoError(t, err)
+
+			actual, err := ringWithReplication.ShuffleShard("tenant-id", shardSize)
+			require.NoError(t, err)
+
+			assert.Equal(t, expected.PartitionIDs(), actual.PartitionIDs())
+		}
+	})
+}
+
 // This test asserts on shard stability across multiple invocations and given the same input ring.
 func TestPartitionRing_ShuffleShard_Stability(t *testing.T) {
 	var (
@@ -1226,6 +1421,47 @@ func TestActivePartitionBatchRing_Get(t *testing.T) {
 	})
 }
 
+func TestActivePartitionBatchRing_GetWithReplicationFactor(t *testing.T) {
+	const numRuns = 1000
+
+	ring := NewPartitionRingWithReplication(createPartitionRingWithPartitions(10, 5, 5).desc, PartitionRingReplicationConfig{ReplicationFactor: 3})
+	activeRing := NewActivePartitionBatchRing(ring)
+	buf := [GetBufferSize]InstanceDesc{}
+
+	assert.Equal(t, 3, activeRing.ReplicationFactor())
+
+	// Randomise the seed but log it in case we need to reproduce the test on failure.
+	seed := time.Now().UnixNano()
+	rnd := rand.New(rand.NewSource(seed))
+	t.Log("random generator seed:", seed)
+
+	for i := 0; i < numRuns; i++ {
+		key := uint32(rnd.Intn(math.MaxUint32))
+		expected, err := ring.ActivePartitionsForKey(key, nil)
+		require.NoError(t, err)
+		require.Len(t, expected, 3)
+
+		actual, err := activeRing.Get(key, WriteNoExtend, buf[:0], nil, nil)
+		require.NoError(t, err)
+
+		require.Len(t, actual.Instances, 3)
+		for i, partitionID := range expected {
+			assert.Equal(t, strconv.Itoa(int(partitionID)), actual.Instances[i].Id)
+			assert.Equal(t, strconv.Itoa(int(partitionID)), actual.Instances[i].Addr)
+		}
+		assert.Equal(t, 0, actual.MaxErrors)
+	}
+
+	// The partition IDs should be looked up without allocating memory.
+	allocs := testing.AllocsPerRun(numRuns, func() {
+		_, err := activeRing.Get(12345, WriteNoExtend, buf[:0], nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+	require.Equal(t, 0.0, allocs)
+}
+
 func BenchmarkActivePartitionBatchRing_Get(b *testing.B) {
 	benchCases := map[string]struct {
 		ring *ActivePartitionBatchRing
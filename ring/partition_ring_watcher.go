@@ -22,6 +22,9 @@ type PartitionRingWatcher struct {
 	kv     kv.Client
 	logger log.Logger
 
+	// replication holds the replication settings of the PartitionRing built by the watcher.
+	replication PartitionRingReplicationConfig
+
 	ringMx sync.Mutex
 	ring   *PartitionRing
 
@@ -46,6 +49,14 @@ func NewPartitionRingWatcher(name, key string, kv kv.Client, logger log.Logger,
 	return r
 }
 
+// WithReplication configures the replication settings of the PartitionRing returned by the watcher.
+// This function must be called before the watcher is started.
+func (w *PartitionRingWatcher) WithReplication(cfg PartitionRingReplicationConfig) *PartitionRingWatcher {
+	w.replication = cfg
+	w.ring = NewPartitionRingWithReplication(*NewPartitionRingDesc(), cfg)
+	return w
+}
+
 func (w *PartitionRingWatcher) starting(ctx context.Context) error {
 	// Get the initial ring state so that, as soon as the service will be running, the in-memory
 	// ring would be already populated and there's no race condition between when the service is
@@ -78,7 +89,7 @@ func (w *PartitionRingWatcher) loop(ctx context.Context) error {
 }
 
 func (w *PartitionRingWatcher) updatePartitionRing(desc *PartitionRingDesc) {
-	newRing := NewPartitionRing(*desc)
+	newRing := NewPartitionRingWithReplication(*desc, w.replication)
 
 	w.ringMx.Lock()
 	w.ring = newRing
@@ -103,3 +103,37 @@ func TestPartitionRingWatcher_ShouldWatchUpdates(t *testing.T) {
 		partition_ring_partitions{name="test",state="Inactive"} 1
 	`)))
 }
+
+func TestPartitionRingWatcher_WithReplication(t *testing.T) {
+	const ringKey = "ring"
+
+	ctx := context.Background()
+	logger := log.NewNopLogger()
+
+	store, closer := consul.NewInMemoryClient(GetPartitionRingCodec(), logger, nil)
+	t.Cleanup(func() { assert.NoError(t, closer.Close()) })
+
+	watcher := NewPartitionRingWatcher("test", ringKey, store, logger, nil).WithReplication(PartitionRingReplicationConfig{ReplicationFactor: 2})
+	assert.Equal(t, 2, watcher.PartitionRing().ReplicationFactor())
+
+	require.NoError(t, services.StartAndAwaitRunning(ctx, watcher))
+	t.Cleanup(func() {
+		require.NoError(t, services.StopAndAwaitTerminated(ctx, watcher))
+	})
+
+	require.NoError(t, store.CAS(ctx, ringKey, func(in interface{}) (out interface{}, retry bool, err error) {
+		desc := GetOrCreatePartitionRingDesc(in)
+		desc.AddPartition(1, PartitionActive, time.Now())
+		desc.AddPartition(2, PartitionActive, time.Now())
+		return desc, true, nil
+	}))
+
+	require.Eventually(t, func() bool {
+		return watcher.PartitionRing().PartitionsCount() == 2
+	}, time.Second, 10*time.Millisecond)
+
+	// The updated ring should preserve the replication settings.
+	partitionIDs, err := watcher.PartitionRing().ActivePartitionsForKey(12345, nil)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []int32{1, 2}, partitionIDs)
+}
@@ -0,0 +1,186 @@
+package ring
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+
+	"github.com/cespare/xxhash/v2"
+
+	"github.com/grafana/dskit/internal/slices"
+)
+
+const (
+	// TokensLookupStrategy looks up the instances owning a key walking the ring tokens
+	// clockwise, starting from the key (consistent hashing).
+	TokensLookupStrategy = "tokens"
+
+	// RendezvousLookupStrategy looks up the instances owning a key by ranking all the instances
+	// in the ring by a score computed from the key and the instance ID, weighted by the instance
+	// weight (highest random weight hashing). The number of tokens of each instance doesn't
+	// affect the keys distribution, but each instance needs at least one token to be part of the ring.
+	// Since keys are not distributed by tokens, the token ranges of an instance (see GetTokenRangesForInstance)
+	// don't reflect the keys it owns.
+	RendezvousLookupStrategy = "rendezvous"
+)
+
+var lookupStrategies = []string{TokensLookupStrategy, RendezvousLookupStrategy}
+
+func validateLookupStrategy(strategy string) error {
+	// An empty strategy defaults to the tokens one, to keep backward compatibility
+	// with configs not registering flags.
+	if strategy != "" && !slices.Contains(lookupStrategies, strategy) {
+		return fmt.Errorf("unsupported ring lookup strategy %q, supported values are: %v", strategy, lookupStrategies)
+	}
+	return nil
+}
+
+// rendezvousInstance holds the information of an instance required to compute its rendezvous score.
+type rendezvousInstance struct {
+	instanceInfo
+
+	idHash uint64
+	weight float64
+}
+
+// rendezvousCandidate is an instance ranked by its rendezvous score for a given key.
+type rendezvousCandidate struct {
+	instance *rendezvousInstance
+	score    float64
+}
+
+// rendezvousCandidatesPool holds the candidates slices used by findInstancesForKeyRendezvous, to not
+// allocate a slice as big as the ring for each lookup.
+var rendezvousCandidatesPool = sync.Pool{
+	New: func() interface{} {
+		return &[]rendezvousCandidate{}
+	},
+}
+
+// getRendezvousInstances returns the instances with tokens, sorted by ID.
+func (d *Desc) getRendezvousInstances() []rendezvousInstance {
+	instances := make([]rendezvousInstance, 0, len(d.Ingesters))
+	for id, instance := range d.Ingesters {
+		if len(instance.Tokens) == 0 {
+			continue
+		}
+
+		instances = append(instances, rendezvousInstance{
+			instanceInfo: instanceInfo{InstanceID: id, Zone: instance.Zone},
+			idHash:       xxhash.Sum64String(id),
+			weight:       float64(instance.GetEffectiveWeight()),
+		})
+	}
+
+	sort.Slice(instances, func(i, j int) bool {
+		return instances[i].InstanceID < instances[j].InstanceID
+	})
+	return instances
+}
+
+// rendezvousScore returns the weighted score of the instance for the given key. The higher the score,
+// the higher the priority of the instance for the key. The score is computed as -weight / ln(u), where
+// u is a value uniformly distributed in (0, 1) computed from the key and the instance: this guarantees
+// each instance is ranked first for a number of keys proportional to its weight.
+func rendezvousScore(key uint32, instance *rendezvousInstance) float64 {
+	// Mix the key with the instance ID hash, using the splitmix64 finalizer.
+	h := instance.idHash ^ (uint64(key) * 0x9e3779b97f4a7c15)
+	h ^= h >> 30
+	h *= 0xbf58476d1ce4e5b9
+	h ^= h >> 27
+	h *= 0x94d049bb133111eb
+	h ^= h >> 31
+
+	// Take the 53 most significant bits, which fit the float64 mantissa.
+	u := (float64(h>>11) + 0.5) / (1 << 53)
+	return -instance.weight / math.Log(u)
+}
+
+// nextRendezvousCandidate returns the index of the candidate with the highest score. Candidates are sorted
+// by instance ID, so the first one wins on equal scores to guarantee a deterministic result.
+func nextRendezvousCandidate(candidates []rendezvousCandidate) int {
+	next := 0
+	for i := 1; i < len(candidates); i++ {
+		if candidates[i].score > candidates[next].score {
+			next = i
+		}
+	}
+	return next
+}
+
+// findInstancesForKeyRendezvous is the equivalent of findInstancesForKey for the rendezvous lookup strategy.
+// Instances are considered in the order of their score for the key, instead of the order of their tokens
+// in the ring. This function needs to be called with read lock on the ring.
+func (r *Ring) findInstancesForKeyRendezvous(key uint32, op Operation, bufDescs []InstanceDesc, bufHosts []string, bufZones []string, instanceFilter func(instanceID string) (include, keepGoing bool)) ([]InstanceDesc, error) {
+	bufCandidates := rendezvousCandidatesPool.Get().(*[]rendezvousCandidate)
+	defer rendezvousCandidatesPool.Put(bufCandidates)
+
+	candidates := *bufCandidates
+	if cap(candidates) < len(r.rendezvousInstances) {
+		candidates = make([]rendezvousCandidate, len(r.rendezvousInstances))
+		*bufCandidates = candidates
+	}
+	candidates = candidates[:len(r.rendezvousInstances)]
+	for i := range r.rendezvousInstances {
+		candidates[i] = rendezvousCandidate{
+			instance: &r.rendezvousInstances[i],
+			score:    rendezvousScore(key, &r.rendezvousInstances[i]),
+		}
+	}
+
+	var (
+		n            = r.cfg.ReplicationFactor
+		instances    = bufDescs[:0]
+		maxZones     = len(r.ringTokensByZone)
+		maxInstances = len(r.ringDesc.Ingesters)
+
+		// We use a slice instead of a map because it's faster to search within a
+		// slice than lookup a map for a very low number of items.
+		distinctHosts = bufHosts[:0]
+		distinctZones = bufZones[:0]
+	)
+	for i := 0; len(distinctHosts) < min(maxInstances, n) && len(distinctZones) < maxZones && i < len(candidates); i++ {
+		// We typically need only a few instances, so instead of sorting all the candidates
+		// we do select the one with the highest score among the remaining ones.
+		next := nextRendezvousCandidate(candidates)
+		info := candidates[next].instance.instanceInfo
+		candidates[next].score = -1 // Scores are always positive, so the candidate will not be selected again.
+
+		// Ignore if the instances don't have a zone set.
+		if r.cfg.ZoneAwarenessEnabled && info.Zone != "" {
+			if slices.Contains(distinctZones, info.Zone) {
+				continue
+			}
+		}
+
+		distinctHosts = append(distinctHosts, info.InstanceID)
+		instance, ok := r.ringDesc.Ingesters[info.InstanceID]
+		if !ok {
+			// This should never happen unless a bug in the ring code.
+			return nil, ErrInconsistentTokensInfo
+		}
+
+		// Check whether the replica set should be extended given we're including
+		// this instance.
+		if op.ShouldExtendReplicaSetOnState(instance.State) {
+			n++
+		} else if r.cfg.ZoneAwarenessEnabled && info.Zone != "" {
+			// We should only add the zone if we are not going to extend,
+			// as we want to extend the instance in the same AZ.
+			distinctZones = append(distinctZones, info.Zone)
+		}
+
+		include, keepGoing := true, true
+		if instanceFilter != nil {
+			include, keepGoing = instanceFilter(info.InstanceID)
+		}
+		if include {
+			instances = append(instances, instance)
+		}
+		if !keepGoing {
+			break
+		}
+	}
+	return instances, nil
+}
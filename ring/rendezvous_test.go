@@ -0,0 +1,217 @@
+package ring
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/dskit/kv/consul"
+)
+
+func TestValidateLookupStrategy(t *testing.T) {
+	require.NoError(t, validateLookupStrategy(""))
+	require.NoError(t, validateLookupStrategy(TokensLookupStrategy))
+	require.NoError(t, validateLookupStrategy(RendezvousLookupStrategy))
+	require.EqualError(t, validateLookupStrategy("unknown"), `unsupported ring lookup strategy "unknown", supported values are: [tokens rendezvous]`)
+
+	store, closer := consul.NewInMemoryClient(GetCodec(), log.NewNopLogger(), nil)
+	t.Cleanup(func() { assert.NoError(t, closer.Close()) })
+
+	_, err := NewWithStoreClientAndStrategy(Config{ReplicationFactor: 1, LookupStrategy: "unknown"}, testRingName, testRingKey, store, NewDefaultReplicationStrategy(), nil, log.NewNopLogger())
+	require.Error(t, err)
+}
+
+func TestRing_Get_Rendezvous_ShouldDistributeKeysProportionallyToWeight(t *testing.T) {
+	const numKeys = 100000
+
+	tests := map[string]struct {
+		weights map[string]uint32
+	}{
+		"instances with the same weight": {
+			weights: map[string]uint32{"instance-1": 0, "instance-2": 0, "instance-3": 0, "instance-4": 0, "instance-5": 0},
+		},
+		"instances with different weights": {
+			weights: map[string]uint32{"instance-1": 1, "instance-2": 2, "instance-3": 3, "instance-4": 1},
+		},
+	}
+
+	for testName, testData := range tests {
+		t.Run(testName, func(t *testing.T) {
+			ringDesc := NewDesc()
+			totalWeight := 0.0
+			for id, weight := range testData.weights {
+				// A single token per instance is enough, because tokens are not used to distribute keys.
+				ringDesc.AddIngesterWithWeight(id, id, "", []uint32{rand.Uint32()}, ACTIVE, time.Now(), false, time.Time{}, weight)
+				totalWeight += float64(max(weight, 1))
+			}
+
+			ring := newRingForTesting(Config{HeartbeatTimeout: time.Hour, ReplicationFactor: 1, LookupStrategy: RendezvousLookupStrategy}, false)
+			ring.setRingStateFromDesc(ringDesc, false, false, false)
+
+			bufDescs, bufHosts, bufZones := MakeBuffersForGet()
+			owned := map[string]int{}
+			for i := 0; i < numKeys; i++ {
+				set, err := ring.Get(rand.Uint32(), Write, bufDescs, bufHosts, bufZones)
+				require.NoError(t, err)
+				require.Len(t, set.Instances, 1)
+				owned[set.Instances[0].Id]++
+			}
+
+			for id, weight := range testData.weights {
+				expected := float64(max(weight, 1)) / totalWeight
+				actual := float64(owned[id]) / numKeys
+				assert.InDelta(t, expected, actual, 0.01, "instance %s", id)
+			}
+		})
+	}
+}
+
+func TestRing_Get_Rendezvous_ShouldOnlyMoveKeysOfChangedInstances(t *testing.T) {
+	const (
+		numKeys      = 10000
+		numInstances = 10
+	)
+
+	ringDesc := NewDesc()
+	for i := 0; i < numInstances; i++ {
+		id := fmt.Sprintf("instance-%d", i)
+		ringDesc.AddIngester(id, id, "", []uint32{uint32(i)}, ACTIVE, time.Now(), false, time.Time{})
+	}
+
+	cfg := Config{HeartbeatTimeout: time.Hour, ReplicationFactor: 2, LookupStrategy: RendezvousLookupStrategy}
+	before := newRingForTesting(cfg, false)
+	before.setRingStateFromDesc(ringDesc, false, false, false)
+
+	// Remove an instance from the ring.
+	afterDesc := ringDesc.Clone().(*Desc)
+	afterDesc.RemoveIngester("instance-3")
+	after := newRingForTesting(cfg, false)
+	after.setRingStateFromDesc(afterDesc, false, false, false)
+
+	keys := make([]uint32, numKeys)
+	for i := range keys {
+		keys[i] = rand.Uint32()
+	}
+
+	moved := 0
+	for _, key := range keys {
+		bufDescs, bufHosts, bufZones := MakeBuffersForGet()
+		beforeSet, err := before.Get(key, Write, bufDescs, bufHosts, bufZones)
+		require.NoError(t, err)
+
+		bufDescs, bufHosts, bufZones = MakeBuffersForGet()
+		afterSet, err := after.Get(key, Write, bufDescs, bufHosts, bufZones)
+		require.NoError(t, err)
+
+		if !beforeSet.Includes("instance-3") {
+			// Keys not owned by the removed instance should not move.
+			assert.Equal(t, beforeSet.GetAddresses(), afterSet.GetAddresses())
+			continue
+		}
+
+		moved++
+		assert.False(t, afterSet.Includes("instance-3"))
+		assert.Len(t, afterSet.Instances, 2)
+	}
+
+	// Each key has 2 replicas, so the removed instance should have owned about 2/numInstances of the keys.
+	assert.InDelta(t, 2.0/numInstances, float64(moved)/numKeys, 0.02)
+}
+
+func TestRing_Get_Rendezvous_ZoneAwareness(t *testing.T) {
+	const numKeys = 10000
+
+	ringDesc := NewDesc()
+	for i := 0; i < 9; i++ {
+		id := fmt.Sprintf("instance-%d", i)
+		ringDesc.AddIngester(id, id, fmt.Sprintf("zone-%d", i%3), []uint32{uint32(i)}, ACTIVE, time.Now(), false, time.Time{})
+	}
+
+	ring := newRingForTesting(Config{HeartbeatTimeout: time.Hour, ReplicationFactor: 3, ZoneAwarenessEnabled: true, LookupStrategy: RendezvousLookupStrategy}, false)
+	ring.setRingStateFromDesc(ringDesc, false, false, false)
+
+	bufDescs, bufHosts, bufZones := MakeBuffersForGet()
+	for i := 0; i < numKeys; i++ {
+		set, err := ring.Get(rand.Uint32(), Write, bufDescs, bufHosts, bufZones)
+		require.NoError(t, err)
+		require.Len(t, set.Instances, 3)
+
+		distinctZones := map[string]struct{}{}
+		for _, instance := range set.Instances {
+			distinctZones[instance.Zone] = struct{}{}
+		}
+		assert.Len(t, distinctZones, 3)
+	}
+}
+
+func TestRing_Get_Rendezvous_ShouldExtendReplicaSetOnLeavingInstances(t *testing.T) {
+	ringDesc := NewDesc()
+	ringDesc.AddIngester("instance-1", "instance-1", "", []uint32{1}, LEAVING, time.Now(), false, time.Time{})
+	ringDesc.AddIngester("instance-2", "instance-2", "", []uint32{2}, ACTIVE, time.Now(), false, time.Time{})
+	ringDesc.AddIngester("instance-3", "instance-3", "", []uint32{3}, ACTIVE, time.Now(), false, time.Time{})
+
+	ring := newRingForTesting(Config{HeartbeatTimeout: time.Hour, ReplicationFactor: 2, LookupStrategy: RendezvousLookupStrategy}, false)
+	ring.setRingStateFromDesc(ringDesc, false, false, false)
+
+	bufDescs, bufHosts, bufZones := MakeBuffersForGet()
+	for i := 0; i < 1000; i++ {
+		key := rand.Uint32()
+
+		// The Write operation extends the replica set on LEAVING instances, so the LEAVING
+		// instance is excluded and replaced.
+		set, err := ring.Get(key, Write, bufDescs, bufHosts, bufZones)
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []string{"instance-2", "instance-3"}, set.GetAddresses())
+
+		// The Read operation doesn't extend the replica set, so the LEAVING instance is included
+		// in the replica set when it has one of the 2 highest scores.
+		set, err = ring.Get(key, Read, bufDescs, bufHosts, bufZones)
+		require.NoError(t, err)
+		assert.Len(t, set.Instances, 2)
+	}
+}
+
+func TestRendezvousScore(t *testing.T) {
+	instance := &rendezvousInstance{idHash: 12345, weight: 1}
+	weighted := &rendezvousInstance{idHash: 12345, weight: 2}
+
+	for i := 0; i < 1000; i++ {
+		key := rand.Uint32()
+		score := rendezvousScore(key, instance)
+
+		// The score should be deterministic, positive and finite.
+		require.Equal(t, score, rendezvousScore(key, instance))
+		require.Greater(t, score, 0.0)
+		require.False(t, math.IsInf(score, 0))
+
+		// The score should scale with the weight.
+		require.InDelta(t, 2*score, rendezvousScore(key, weighted), 1e-9*score)
+	}
+}
+
+func BenchmarkRing_Get_Rendezvous(b *testing.B) {
+	ringDesc := NewDesc()
+	for i := 0; i < 30; i++ {
+		id := fmt.Sprintf("instance-%d", i)
+		ringDesc.AddIngester(id, id, fmt.Sprintf("zone-%d", i%3), []uint32{uint32(i)}, ACTIVE, time.Now(), false, time.Time{})
+	}
+
+	ring := newRingForTesting(Config{HeartbeatTimeout: time.Hour, ReplicationFactor: 3, ZoneAwarenessEnabled: true, LookupStrategy: RendezvousLookupStrategy}, false)
+	ring.setRingStateFromDesc(ringDesc, false, false, false)
+
+	bufDescs, bufHosts, bufZones := MakeBuffersForGet()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		_, err := ring.Get(uint32(n), Write, bufDescs, bufHosts, bufZones)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"math"
 	"math/rand"
 	"net/http"
+	"strings"
 	"sync"
 	"time"
 
@@ -146,6 +147,7 @@ type Config struct {
 	ReplicationFactor    int                    `yaml:"replication_factor"`
 	ZoneAwarenessEnabled bool                   `yaml:"zone_awareness_enabled"`
 	ExcludedZones        flagext.StringSliceCSV `yaml:"excluded_zones" category:"advanced"`
+	LookupStrategy       string                 `yaml:"lookup_strategy" category:"experimental"`
 
 	// Whether the shuffle-sharding subring cache is disabled. This option is set
 	// internally and never exposed to the user.
@@ -165,6 +167,7 @@ func (cfg *Config) RegisterFlagsWithPrefix(prefix string, f *flag.FlagSet) {
 	f.IntVar(&cfg.ReplicationFactor, prefix+"distributor.replication-factor", 3, "The number of ingesters to write to and read from.")
 	f.BoolVar(&cfg.ZoneAwarenessEnabled, prefix+"distributor.zone-awareness-enabled", false, "True to enable the zone-awareness and replicate ingested samples across different availability zones.")
 	f.Var(&cfg.ExcludedZones, prefix+"distributor.excluded-zones", "Comma-separated list of zones to exclude from the ring. Instances in excluded zones will be filtered out from the ring.")
+	f.StringVar(&cfg.LookupStrategy, prefix+"ring.lookup-strategy", TokensLookupStrategy, fmt.Sprintf("The strategy used to look up the instances owning a key. Supported values are: %s.", strings.Join(lookupStrategies, ", ")))
 }
 
 type instanceInfo struct {
@@ -209,6 +212,10 @@ type Ring struct {
 	// to be sorted alphabetically.
 	ringZones []string
 
+	// Instances with tokens, used by the rendezvous lookup strategy. This list is guaranteed
+	// to be sorted by instance ID, and it's only populated when the rendezvous lookup strategy is enabled.
+	rendezvousInstances []rendezvousInstance
+
 	// Number of registered instances with tokens.
 	instancesWithTokensCount int
 
@@ -269,6 +276,9 @@ func NewWithStoreClientAndStrategy(cfg Config, name, key string, store kv.Client
 	if cfg.ReplicationFactor <= 0 {
 		return nil, fmt.Errorf("ReplicationFactor must be greater than zero: %d", cfg.ReplicationFactor)
 	}
+	if err := validateLookupStrategy(cfg.LookupStrategy); err != nil {
+		return nil, err
+	}
 
 	r := &Ring{
 		key:                              key,
@@ -386,6 +396,11 @@ func (r *Ring) setRingStateFromDesc(ringDesc *Desc, updateMetrics, updateRegiste
 	writableInstancesWithTokensCountPerZone := ringDesc.writableInstancesWithTokensCountPerZone()
 	readOnlyInstances, oldestReadOnlyUpdatedTimestamp := ringDesc.readOnlyInstancesAndOldestReadOnlyUpdatedTimestamp()
 
+	var rendezvousInstances []rendezvousInstance
+	if r.cfg.LookupStrategy == RendezvousLookupStrategy {
+		rendezvousInstances = ringDesc.getRendezvousInstances()
+	}
+
 	r.mtx.Lock()
 	defer r.mtx.Unlock()
 	r.ringDesc = ringDesc
@@ -393,6 +408,7 @@ func (r *Ring) setRingStateFromDesc(ringDesc *Desc, updateMetrics, updateRegiste
 	r.ringTokensByZone = ringTokensByZone
 	r.ringInstanceByToken = ringInstanceByToken
 	r.ringZones = ringZones
+	r.rendezvousInstances = rendezvousInstances
 	r.instancesWithTokensCount = instancesWithTokensCount
 	r.instancesCountPerZone = instancesCountPerZone
 	r.instancesWithTokensCountPerZone = instancesWithTokensCountPerZone
@@ -448,6 +464,10 @@ func (r *Ring) Get(key uint32, op Operation, bufDescs []InstanceDesc, bufHosts,
 // InstanceFilter can ignore uninteresting instances that would otherwise be part of the output, and can also stop search early.
 // This function needs to be called with read lock on the ring.
 func (r *Ring) findInstancesForKey(key uint32, op Operation, bufDescs []InstanceDesc, bufHosts []string, bufZones []string, instanceFilter func(instanceID string) (include, keepGoing bool)) ([]InstanceDesc, error) {
+	if r.cfg.LookupStrategy == RendezvousLookupStrategy {
+		return r.findInstancesForKeyRendezvous(key, op, bufDescs, bufHosts, bufZones, instanceFilter)
+	}
+
 	var (
 		n            = r.cfg.ReplicationFactor
 		instances    = bufDescs[:0]
@@ -937,6 +957,11 @@ func (r *Ring) buildRingForTheShard(shard map[string]InstanceDesc) *Ring {
 	shardTokensByZone := shardDesc.getTokensByZone()
 	shardTokens := mergeTokenGroups(shardTokensByZone)
 
+	var shardRendezvousInstances []rendezvousInstance
+	if r.cfg.LookupStrategy == RendezvousLookupStrategy {
+		shardRendezvousInstances = shardDesc.getRendezvousInstances()
+	}
+
 	return &Ring{
 		cfg:                                     r.cfg,
 		strategy:                                r.strategy,
@@ -944,6 +969,7 @@ func (r *Ring) buildRingForTheShard(shard map[string]InstanceDesc) *Ring {
 		ringTokens:                              shardTokens,
 		ringTokensByZone:                        shardTokensByZone,
 		ringZones:                               getZones(shardTokensByZone),
+		rendezvousInstances:                     shardRendezvousInstances,
 		instancesWithTokensCount:                shardDesc.instancesWithTokensCount(),
 		instancesCountPerZone:                   shardDesc.instancesCountPerZone(),
 		instancesWithTokensCountPerZone:         shardDesc.instancesWithTokensCountPerZone(),
@@ -137,6 +137,15 @@ type InstanceDesc struct {
 	// Read-only instances go through standard state changes, and special handling is applied to them
 	// during shuffle shards.
 	ReadOnly bool `protobuf:"varint,11,opt,name=read_only,json=readOnly,proto3" json:"read_only,omitempty"`
+	// Relative capacity of this instance, compared to the other instances in the ring.
+	// An instance with weight 2 is expected to own twice the token space of an instance
+	// with weight 1. A value of 0 means the weight is unknown (e.g. the instance has been
+	// registered by an older version), and it's treated as 1.
+	Weight uint32 `protobuf:"varint,12,opt,name=weight,proto3" json:"weight,omitempty"`
+	// Tokens this instance is handing off to other instances, with the ID of the receiving
+	// instance as value. An offered token is still owned by this instance until the receiving
+	// instance adds it to its own tokens. Used by the TokenRebalancerDelegate.
+	OfferedTokens map[uint32]string `protobuf:"bytes,13,rep,name=offered_tokens,json=offeredTokens,proto3" json:"offered_tokens,omitempty" protobuf_key:"varint,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
 }
 
 func (m *InstanceDesc) Reset()      { *m = InstanceDesc{} }
@@ -234,47 +243,66 @@ func (m *InstanceDesc) GetReadOnly() bool {
 	return false
 }
 
+func (m *InstanceDesc) GetWeight() uint32 {
+	if m != nil {
+		return m.Weight
+	}
+	return 0
+}
+
+func (m *InstanceDesc) GetOfferedTokens() map[uint32]string {
+	if m != nil {
+		return m.OfferedTokens
+	}
+	return nil
+}
+
 func init() {
 	proto.RegisterEnum("ring.InstanceState", InstanceState_name, InstanceState_value)
 	proto.RegisterType((*Desc)(nil), "ring.Desc")
 	proto.RegisterMapType((map[string]InstanceDesc)(nil), "ring.Desc.IngestersEntry")
 	proto.RegisterType((*InstanceDesc)(nil), "ring.InstanceDesc")
+	proto.RegisterMapType((map[uint32]string)(nil), "ring.InstanceDesc.OfferedTokensEntry")
 }
 
 func init() { proto.RegisterFile("ring.proto", fileDescriptor_26381ed67e202a6e) }
 
 var fileDescriptor_26381ed67e202a6e = []byte{
-	// 478 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x54, 0x92, 0x31, 0x6f, 0xd3, 0x40,
-	0x1c, 0xc5, 0x7d, 0xf6, 0xc5, 0xb5, 0xff, 0xa1, 0x91, 0x75, 0x45, 0xc8, 0xb4, 0xe8, 0xb0, 0x3a,
-	0x19, 0x24, 0x52, 0x11, 0x18, 0x10, 0x52, 0x87, 0x96, 0x1a, 0xe4, 0x28, 0x4a, 0x2b, 0x13, 0xba,
-	0x46, 0x4e, 0x7c, 0x18, 0xab, 0x89, 0x1d, 0xd9, 0x17, 0xa4, 0x30, 0xf1, 0x11, 0xf8, 0x02, 0xec,
-	0x7c, 0x0e, 0xa6, 0x8e, 0x19, 0x3b, 0x21, 0xe2, 0x2c, 0x8c, 0xfd, 0x08, 0xe8, 0xce, 0x6d, 0xdd,
-	0x6c, 0xef, 0xe5, 0xbd, 0xff, 0xef, 0xe5, 0x24, 0x03, 0xe4, 0x49, 0x1a, 0xb7, 0x67, 0x79, 0xc6,
-	0x33, 0x82, 0x85, 0xde, 0x7d, 0x11, 0x27, 0xfc, 0xcb, 0x7c, 0xd4, 0x1e, 0x67, 0xd3, 0x83, 0x38,
-	0x8b, 0xb3, 0x03, 0x19, 0x8e, 0xe6, 0x9f, 0xa5, 0x93, 0x46, 0xaa, 0xea, 0x68, 0xff, 0x27, 0x02,
-	0x7c, 0xc2, 0x8a, 0x31, 0x39, 0x04, 0x33, 0x49, 0x63, 0x56, 0x70, 0x96, 0x17, 0x36, 0x72, 0x34,
-	0xb7, 0xd9, 0x79, 0xdc, 0x96, 0x74, 0x11, 0xb7, 0xfd, 0xdb, 0xcc, 0x4b, 0x79, 0xbe, 0x38, 0xc6,
-	0x97, 0x7f, 0x9e, 0x2a, 0x41, 0x7d, 0xb1, 0x7b, 0x06, 0xad, 0xcd, 0x0a, 0xb1, 0x40, 0xbb, 0x60,
-	0x0b, 0x1b, 0x39, 0xc8, 0x35, 0x03, 0x21, 0x89, 0x0b, 0x8d, 0xaf, 0xe1, 0x64, 0xce, 0x6c, 0xd5,
-	0x41, 0x6e, 0xb3, 0x43, 0x2a, 0xbc, 0x9f, 0x16, 0x3c, 0x4c, 0xc7, 0x4c, 0xcc, 0x04, 0x55, 0xe1,
-	0xad, 0xfa, 0x06, 0x75, 0xb1, 0xa1, 0x5a, 0xda, 0xfe, 0x6f, 0x15, 0x1e, 0xdc, 0x6f, 0x10, 0x02,
-	0x38, 0x8c, 0xa2, 0xfc, 0x86, 0x2b, 0x35, 0x79, 0x02, 0x26, 0x4f, 0xa6, 0xac, 0xe0, 0xe1, 0x74,
-	0x26, 0xe1, 0x5a, 0x50, 0xff, 0x40, 0x9e, 0x41, 0xa3, 0xe0, 0x21, 0x67, 0xb6, 0xe6, 0x20, 0xb7,
-	0xd5, 0xd9, 0xd9, 0x9c, 0xfd, 0x28, 0xa2, 0xa0, 0x6a, 0x90, 0x47, 0xa0, 0xf3, 0xec, 0x82, 0xa5,
-	0x85, 0xad, 0x3b, 0x9a, 0xbb, 0x1d, 0xdc, 0x38, 0x31, 0xfa, 0x2d, 0x4b, 0x99, 0xbd, 0x55, 0x8d,
-	0x0a, 0x4d, 0x5e, 0xc2, 0xc3, 0x9c, 0xc5, 0x89, 0x78, 0x31, 0x8b, 0x86, 0xf5, 0xbe, 0x21, 0xf7,
-	0x77, 0xea, 0x6c, 0x70, 0xf7, 0x4f, 0x5a, 0xa0, 0x26, 0x91, 0x6d, 0x4a, 0x88, 0x9a, 0x44, 0xe4,
-	0x10, 0xf6, 0x72, 0x16, 0x46, 0xc3, 0x2c, 0x9d, 0x2c, 0x86, 0xf3, 0x59, 0x14, 0xf2, 0x0d, 0x12,
-	0x48, 0x92, 0x2d, 0x2a, 0xa7, 0xe9, 0x64, 0xf1, 0xa9, 0x2a, 0xd4, 0xb8, 0x3d, 0x30, 0xef, 0xce,
-	0xed, 0xa6, 0x83, 0x5c, 0x23, 0x30, 0x6e, 0xcb, 0x5d, 0x6c, 0x60, 0xab, 0xd1, 0xc5, 0x46, 0xc3,
-	0xd2, 0x9f, 0xf7, 0x60, 0x7b, 0xe3, 0xb9, 0x04, 0x40, 0x3f, 0x7a, 0x37, 0xf0, 0xcf, 0x3d, 0x4b,
-	0x21, 0x4d, 0xd8, 0xea, 0x79, 0x47, 0xe7, 0x7e, 0xff, 0x83, 0x85, 0x84, 0x39, 0xf3, 0xfa, 0x27,
-	0xc2, 0xa8, 0xc2, 0x74, 0x4f, 0xfd, 0xbe, 0x30, 0x1a, 0x31, 0x00, 0xf7, 0xbc, 0xf7, 0x03, 0x0b,
-	0x1f, 0xbf, 0x5e, 0xae, 0xa8, 0x72, 0xb5, 0xa2, 0xca, 0xf5, 0x8a, 0xa2, 0xef, 0x25, 0x45, 0xbf,
-	0x4a, 0x8a, 0x2e, 0x4b, 0x8a, 0x96, 0x25, 0x45, 0x7f, 0x4b, 0x8a, 0xfe, 0x95, 0x54, 0xb9, 0x2e,
-	0x29, 0xfa, 0xb1, 0xa6, 0xca, 0x72, 0x4d, 0x95, 0xab, 0x35, 0x55, 0x46, 0xba, 0xfc, 0xde, 0x5e,
-	0xfd, 0x0f, 0x00, 0x00, 0xff, 0xff, 0x6a, 0x5b, 0x75, 0x81, 0xb2, 0x02, 0x00, 0x00,
+	// 535 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x64, 0x53, 0xc1, 0x6a, 0xdb, 0x4c,
+	0x18, 0xd4, 0x4a, 0xb2, 0x23, 0x7d, 0x8e, 0x8d, 0xd8, 0x84, 0x1f, 0xfd, 0x49, 0xd9, 0x8a, 0x40,
+	0x41, 0x2d, 0xd4, 0xa1, 0x6e, 0x0f, 0xa5, 0x10, 0x68, 0xd2, 0xb8, 0xc5, 0xc6, 0xd8, 0x41, 0x75,
+	0x73, 0x35, 0xb2, 0xb5, 0x56, 0x44, 0x6c, 0xc9, 0x48, 0xeb, 0x16, 0xf7, 0xd4, 0x47, 0xe8, 0x0b,
+	0xf4, 0xde, 0x47, 0xc9, 0xd1, 0xc7, 0x9c, 0x4a, 0x2d, 0x5f, 0x4a, 0x4f, 0x79, 0x84, 0xb2, 0xbb,
+	0x49, 0x6c, 0x93, 0xdb, 0x37, 0x9a, 0xf9, 0x66, 0xf6, 0x63, 0x10, 0x40, 0x1a, 0xc5, 0x61, 0x75,
+	0x92, 0x26, 0x2c, 0xc1, 0x3a, 0x9f, 0xf7, 0x9e, 0x87, 0x11, 0xbb, 0x98, 0xf6, 0xab, 0x83, 0x64,
+	0x7c, 0x18, 0x26, 0x61, 0x72, 0x28, 0xc8, 0xfe, 0x74, 0x28, 0x90, 0x00, 0x62, 0x92, 0x4b, 0x07,
+	0x3f, 0x10, 0xe8, 0xa7, 0x34, 0x1b, 0xe0, 0x23, 0x30, 0xa3, 0x38, 0xa4, 0x19, 0xa3, 0x69, 0x66,
+	0x23, 0x47, 0x73, 0x4b, 0xb5, 0xff, 0xab, 0xc2, 0x9d, 0xd3, 0xd5, 0xc6, 0x1d, 0x57, 0x8f, 0x59,
+	0x3a, 0x3b, 0xd1, 0xaf, 0x7e, 0x3d, 0x56, 0xbc, 0xd5, 0xc6, 0xde, 0x19, 0x54, 0x36, 0x25, 0xd8,
+	0x02, 0xed, 0x92, 0xce, 0x6c, 0xe4, 0x20, 0xd7, 0xf4, 0xf8, 0x88, 0x5d, 0x28, 0x7c, 0xf6, 0x47,
+	0x53, 0x6a, 0xab, 0x0e, 0x72, 0x4b, 0x35, 0x2c, 0xed, 0x1b, 0x71, 0xc6, 0xfc, 0x78, 0x40, 0x79,
+	0x8c, 0x27, 0x05, 0x6f, 0xd4, 0xd7, 0xa8, 0xa9, 0x1b, 0xaa, 0xa5, 0x1d, 0xfc, 0xd5, 0x60, 0x7b,
+	0x5d, 0x81, 0x31, 0xe8, 0x7e, 0x10, 0xa4, 0xb7, 0xbe, 0x62, 0xc6, 0x8f, 0xc0, 0x64, 0xd1, 0x98,
+	0x66, 0xcc, 0x1f, 0x4f, 0x84, 0xb9, 0xe6, 0xad, 0x3e, 0xe0, 0xa7, 0x50, 0xc8, 0x98, 0xcf, 0xa8,
+	0xad, 0x39, 0xc8, 0xad, 0xd4, 0x76, 0x36, 0x63, 0x3f, 0x72, 0xca, 0x93, 0x0a, 0xfc, 0x1f, 0x14,
+	0x59, 0x72, 0x49, 0xe3, 0xcc, 0x2e, 0x3a, 0x9a, 0x5b, 0xf6, 0x6e, 0x11, 0x0f, 0xfd, 0x9a, 0xc4,
+	0xd4, 0xde, 0x92, 0xa1, 0x7c, 0xc6, 0x2f, 0x60, 0x37, 0xa5, 0x61, 0xc4, 0x2f, 0xa6, 0x41, 0x6f,
+	0x95, 0x6f, 0x88, 0xfc, 0x9d, 0x15, 0xd7, 0xbd, 0x7f, 0x49, 0x05, 0xd4, 0x28, 0xb0, 0x4d, 0x61,
+	0xa2, 0x46, 0x01, 0x3e, 0x82, 0xfd, 0x94, 0xfa, 0x41, 0x2f, 0x89, 0x47, 0xb3, 0xde, 0x74, 0x12,
+	0xf8, 0x6c, 0xc3, 0x09, 0x84, 0x93, 0xcd, 0x25, 0x9d, 0x78, 0x34, 0xfb, 0x24, 0x05, 0x2b, 0xbb,
+	0x7d, 0x30, 0xef, 0xd7, 0xed, 0x92, 0x83, 0x5c, 0xc3, 0x33, 0xee, 0xc4, 0xfc, 0x94, 0x2f, 0x34,
+	0x0a, 0x2f, 0x98, 0xbd, 0xed, 0x20, 0x7e, 0x8a, 0x44, 0xb8, 0x05, 0x95, 0x64, 0x38, 0x94, 0x6f,
+	0x96, 0xa7, 0x96, 0x45, 0xd9, 0x4f, 0x1e, 0xb6, 0x51, 0xed, 0x48, 0x61, 0x57, 0xe8, 0x44, 0xab,
+	0x5e, 0x39, 0x59, 0xff, 0xb6, 0xf7, 0x16, 0xf0, 0x43, 0xd1, 0x7a, 0xf5, 0x65, 0x59, 0xfd, 0xee,
+	0x7a, 0xf5, 0xe6, 0x66, 0xcd, 0xba, 0x55, 0x68, 0xea, 0x46, 0xc1, 0x2a, 0x3e, 0x6b, 0x41, 0x79,
+	0xa3, 0x16, 0x0c, 0x50, 0x3c, 0x7e, 0xd7, 0x6d, 0x9c, 0xd7, 0x2d, 0x05, 0x97, 0x60, 0xab, 0x55,
+	0x3f, 0x3e, 0x6f, 0xb4, 0x3f, 0x58, 0x88, 0x83, 0xb3, 0x7a, 0xfb, 0x94, 0x03, 0x95, 0x83, 0x66,
+	0xa7, 0xd1, 0xe6, 0x40, 0xc3, 0x06, 0xe8, 0xad, 0xfa, 0xfb, 0xae, 0xa5, 0x9f, 0xbc, 0x9a, 0x2f,
+	0x88, 0x72, 0xbd, 0x20, 0xca, 0xcd, 0x82, 0xa0, 0x6f, 0x39, 0x41, 0x3f, 0x73, 0x82, 0xae, 0x72,
+	0x82, 0xe6, 0x39, 0x41, 0xbf, 0x73, 0x82, 0xfe, 0xe4, 0x44, 0xb9, 0xc9, 0x09, 0xfa, 0xbe, 0x24,
+	0xca, 0x7c, 0x49, 0x94, 0xeb, 0x25, 0x51, 0xfa, 0x45, 0xf1, 0x5f, 0xbc, 0xfc, 0x37, 0x00, 0x6d,
+	0x38, 0x61, 0xed, 0x5a, 0x03, 0x00, 0x00,
 }
 
 func (x InstanceState) String() string {
@@ -366,6 +394,17 @@ func (this *InstanceDesc) Equal(that interface{}) bool {
 	if this.ReadOnly != that1.ReadOnly {
 		return false
 	}
+	if this.Weight != that1.Weight {
+		return false
+	}
+	if len(this.OfferedTokens) != len(that1.OfferedTokens) {
+		return false
+	}
+	for i := range this.OfferedTokens {
+		if this.OfferedTokens[i] != that1.OfferedTokens[i] {
+			return false
+		}
+	}
 	return true
 }
 func (this *Desc) GoString() string {
@@ -394,7 +433,7 @@ func (this *InstanceDesc) GoString() string {
 	if this == nil {
 		return "nil"
 	}
-	s := make([]string, 0, 13)
+	s := make([]string, 0, 15)
 	s = append(s, "&ring.InstanceDesc{")
 	s = append(s, "Addr: "+fmt.Sprintf("%#v", this.Addr)+",\n")
 	s = append(s, "Timestamp: "+fmt.Sprintf("%#v", this.Timestamp)+",\n")
@@ -405,6 +444,20 @@ func (this *InstanceDesc) GoString() string {
 	s = append(s, "Id: "+fmt.Sprintf("%#v", this.Id)+",\n")
 	s = append(s, "ReadOnlyUpdatedTimestamp: "+fmt.Sprintf("%#v", this.ReadOnlyUpdatedTimestamp)+",\n")
 	s = append(s, "ReadOnly: "+fmt.Sprintf("%#v", this.ReadOnly)+",\n")
+	s = append(s, "Weight: "+fmt.Sprintf("%#v", this.Weight)+",\n")
+	keysForOfferedTokens := make([]uint32, 0, len(this.OfferedTokens))
+	for k, _ := range this.OfferedTokens {
+		keysForOfferedTokens = append(keysForOfferedTokens, k)
+	}
+	github_com_gogo_protobuf_sortkeys.Uint32s(keysForOfferedTokens)
+	mapStringForOfferedTokens := "map[uint32]string{"
+	for _, k := range keysForOfferedTokens {
+		mapStringForOfferedTokens += fmt.Sprintf("%#v: %#v,", k, this.OfferedTokens[k])
+	}
+	mapStringForOfferedTokens += "}"
+	if this.OfferedTokens != nil {
+		s = append(s, "OfferedTokens: "+mapStringForOfferedTokens+",\n")
+	}
 	s = append(s, "}")
 	return strings.Join(s, "")
 }
@@ -483,6 +536,28 @@ func (m *InstanceDesc) MarshalToSizedBuffer(dAtA []byte) (int, error) {
 	_ = i
 	var l int
 	_ = l
+	if len(m.OfferedTokens) > 0 {
+		for k := range m.OfferedTokens {
+			v := m.OfferedTokens[k]
+			baseI := i
+			i -= len(v)
+			copy(dAtA[i:], v)
+			i = encodeVarintRing(dAtA, i, uint64(len(v)))
+			i--
+			dAtA[i] = 0x12
+			i = encodeVarintRing(dAtA, i, uint64(k))
+			i--
+			dAtA[i] = 0x8
+			i = encodeVarintRing(dAtA, i, uint64(baseI-i))
+			i--
+			dAtA[i] = 0x6a
+		}
+	}
+	if m.Weight != 0 {
+		i = encodeVarintRing(dAtA, i, uint64(m.Weight))
+		i--
+		dAtA[i] = 0x60
+	}
 	if m.ReadOnly {
 		i--
 		if m.ReadOnly {
@@ -624,6 +699,17 @@ func (m *InstanceDesc) Size() (n int) {
 	if m.ReadOnly {
 		n += 2
 	}
+	if m.Weight != 0 {
+		n += 1 + sovRing(uint64(m.Weight))
+	}
+	if len(m.OfferedTokens) > 0 {
+		for k, v := range m.OfferedTokens {
+			_ = k
+			_ = v
+			mapEntrySize := 1 + sovRing(uint64(k)) + 1 + len(v) + sovRing(uint64(len(v)))
+			n += mapEntrySize + 1 + sovRing(uint64(mapEntrySize))
+		}
+	}
 	return n
 }
 
@@ -657,6 +743,16 @@ func (this *InstanceDesc) String() string {
 	if this == nil {
 		return "nil"
 	}
+	keysForOfferedTokens := make([]uint32, 0, len(this.OfferedTokens))
+	for k, _ := range this.OfferedTokens {
+		keysForOfferedTokens = append(keysForOfferedTokens, k)
+	}
+	github_com_gogo_protobuf_sortkeys.Uint32s(keysForOfferedTokens)
+	mapStringForOfferedTokens := "map[uint32]string{"
+	for _, k := range keysForOfferedTokens {
+		mapStringForOfferedTokens += fmt.Sprintf("%v: %v,", k, this.OfferedTokens[k])
+	}
+	mapStringForOfferedTokens += "}"
 	s := strings.Join([]string{`&InstanceDesc{`,
 		`Addr:` + fmt.Sprintf("%v", this.Addr) + `,`,
 		`Timestamp:` + fmt.Sprintf("%v", this.Timestamp) + `,`,
@@ -667,6 +763,8 @@ func (this *InstanceDesc) String() string {
 		`Id:` + fmt.Sprintf("%v", this.Id) + `,`,
 		`ReadOnlyUpdatedTimestamp:` + fmt.Sprintf("%v", this.ReadOnlyUpdatedTimestamp) + `,`,
 		`ReadOnly:` + fmt.Sprintf("%v", this.ReadOnly) + `,`,
+		`Weight:` + fmt.Sprintf("%v", this.Weight) + `,`,
+		`OfferedTokens:` + mapStringForOfferedTokens + `,`,
 		`}`,
 	}, "")
 	return s
@@ -1158,6 +1256,138 @@ func (m *InstanceDesc) Unmarshal(dAtA []byte) error {
 				}
 			}
 			m.ReadOnly = bool(v != 0)
+		case 12:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Weight", wireType)
+			}
+			m.Weight = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRing
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.Weight |= uint32(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 13:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field OfferedTokens", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRing
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthRing
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthRing
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			if m.OfferedTokens == nil {
+				m.OfferedTokens = make(map[uint32]string)
+			}
+			var mapkey uint32
+			var mapvalue string
+			for iNdEx < postIndex {
+				entryPreIndex := iNdEx
+				var wire uint64
+				for shift := uint(0); ; shift += 7 {
+					if shift >= 64 {
+						return ErrIntOverflowRing
+					}
+					if iNdEx >= l {
+						return io.ErrUnexpectedEOF
+					}
+					b := dAtA[iNdEx]
+					iNdEx++
+					wire |= uint64(b&0x7F) << shift
+					if b < 0x80 {
+						break
+					}
+				}
+				fieldNum := int32(wire >> 3)
+				if fieldNum == 1 {
+					for shift := uint(0); ; shift += 7 {
+						if shift >= 64 {
+							return ErrIntOverflowRing
+						}
+						if iNdEx >= l {
+							return io.ErrUnexpectedEOF
+						}
+						b := dAtA[iNdEx]
+						iNdEx++
+						mapkey |= uint32(b&0x7F) << shift
+						if b < 0x80 {
+							break
+						}
+					}
+				} else if fieldNum == 2 {
+					var stringLenmapvalue uint64
+					for shift := uint(0); ; shift += 7 {
+						if shift >= 64 {
+							return ErrIntOverflowRing
+						}
+						if iNdEx >= l {
+							return io.ErrUnexpectedEOF
+						}
+						b := dAtA[iNdEx]
+						iNdEx++
+						stringLenmapvalue |= uint64(b&0x7F) << shift
+						if b < 0x80 {
+							break
+						}
+					}
+					intStringLenmapvalue := int(stringLenmapvalue)
+					if intStringLenmapvalue < 0 {
+						return ErrInvalidLengthRing
+					}
+					postStringIndexmapvalue := iNdEx + intStringLenmapvalue
+					if postStringIndexmapvalue < 0 {
+						return ErrInvalidLengthRing
+					}
+					if postStringIndexmapvalue > l {
+						return io.ErrUnexpectedEOF
+					}
+					mapvalue = string(dAtA[iNdEx:postStringIndexmapvalue])
+					iNdEx = postStringIndexmapvalue
+				} else {
+					iNdEx = entryPreIndex
+					skippy, err := skipRing(dAtA[iNdEx:])
+					if err != nil {
+						return err
+					}
+					if (skippy < 0) || (iNdEx+skippy) < 0 {
+						return ErrInvalidLengthRing
+					}
+					if (iNdEx + skippy) > postIndex {
+						return io.ErrUnexpectedEOF
+					}
+					iNdEx += skippy
+				}
+			}
+			m.OfferedTokens[mapkey] = mapvalue
+			iNdEx = postIndex
 		default:
 			iNdEx = preIndex
 			skippy, err := skipRing(dAtA[iNdEx:])
@@ -0,0 +1,437 @@
+package ring
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/grafana/dskit/internal/slices"
+)
+
+const (
+	// RandomTokenGeneratorName is the name of the RandomTokenGenerator in a RingChange.
+	RandomTokenGeneratorName = "random"
+
+	// SpreadMinimizingTokenGeneratorName is the name of the SpreadMinimizingTokenGenerator in a RingChange.
+	SpreadMinimizingTokenGeneratorName = "spread-minimizing"
+
+	// rendezvousSimulationKeys is the number of keys, evenly distributed in the token space,
+	// used to simulate the ownership of a ring with the rendezvous lookup strategy.
+	rendezvousSimulationKeys = 1 << 16
+
+	// maxSimulatedAddedInstances and maxSimulatedAddedTokens bound the instances added by a RingChange,
+	// and the tokens generated for them, since simulations are run by the process serving the ring page.
+	maxSimulatedAddedInstances = 10000
+	maxSimulatedAddedTokens    = 1 << 20
+)
+
+var (
+	errEmptySimulatedRing = errors.New("the ring would be empty after the change")
+	errTokensPerInstance  = errors.New("the number of tokens per added instance can't be inferred from the ring and must be set")
+)
+
+// RingChange describes an hypothetical change of the ring, whose effects can be simulated
+// via SimulateRingChange without changing the actual ring.
+type RingChange struct {
+	// AddInstances is the list of instances to add to the ring, grouped by zone.
+	AddInstances []AddInstancesChange `json:"add_instances,omitempty"`
+
+	// RemoveInstances is the list of IDs of the instances to remove from the ring.
+	RemoveInstances []string `json:"remove_instances,omitempty"`
+
+	// ReplicationFactor is the replication factor after the change. If 0, the replication factor doesn't change.
+	ReplicationFactor int `json:"replication_factor,omitempty"`
+
+	// TokensPerInstance is the number of tokens of each added instance, before scaling it by the instance weight.
+	// If 0, the highest number of tokens per unit of weight among the instances in the ring is used.
+	TokensPerInstance int `json:"tokens_per_instance,omitempty"`
+
+	// TokenGenerator is the name of the token generator used to generate the tokens of the added instances.
+	// Supported values are RandomTokenGeneratorName (default) and SpreadMinimizingTokenGeneratorName.
+	TokenGenerator string `json:"token_generator,omitempty"`
+
+	// Seed of the random token generator. If 0, the simulation is not deterministic.
+	Seed int64 `json:"seed,omitempty"`
+}
+
+// AddInstancesChange describes the addition of Count instances with the given Weight to a zone.
+// The IDs of the added instances are generated following the naming of the instances already in
+// the zone, if they're named like "<prefix>-<id>".
+type AddInstancesChange struct {
+	Zone   string `json:"zone"`
+	Count  int    `json:"count"`
+	Weight uint32 `json:"weight,omitempty"`
+}
+
+// InstanceOwnershipChange is the ownership of an instance before and after a RingChange, expressed
+// as the percentage of the token space for which the instance is one of the replicas.
+type InstanceOwnershipChange struct {
+	ID              string  `json:"id"`
+	Zone            string  `json:"zone"`
+	Added           bool    `json:"added,omitempty"`
+	Removed         bool    `json:"removed,omitempty"`
+	OwnershipBefore float64 `json:"ownership_before"`
+	OwnershipAfter  float64 `json:"ownership_after"`
+}
+
+// RingChangeSimulation is the result of the simulation of a RingChange.
+type RingChangeSimulation struct {
+	Change                  RingChange                `json:"change"`
+	ReplicationFactorBefore int                       `json:"replication_factor_before"`
+	ReplicationFactorAfter  int                       `json:"replication_factor_after"`
+	Instances               []InstanceOwnershipChange `json:"instances"`
+
+	// MovedTokenSpace is the percentage of the token space whose replica set changes,
+	// i.e. the share of keys for which at least one replica would move to a different instance.
+	MovedTokenSpace float64 `json:"moved_token_space"`
+}
+
+// SimulateChange simulates the given change on a snapshot of the ring. See SimulateRingChange.
+func (r *Ring) SimulateChange(change RingChange) (*RingChangeSimulation, error) {
+	r.mtx.RLock()
+	ringDesc := r.ringDesc.Clone().(*Desc)
+	r.mtx.RUnlock()
+
+	return SimulateRingChange(r.cfg, ringDesc, change)
+}
+
+// SimulateRingChange computes the ownership of each instance of the ring described by cfg and ringDesc,
+// before and after applying the given change, and the share of the token space whose replica set changes.
+// Replica sets are computed regardless of the instances state and health. The given ringDesc is not modified.
+// An error is returned if the instances to add, or the tokens to generate for them, exceed the simulation limits.
+func SimulateRingChange(cfg Config, ringDesc *Desc, change RingChange) (*RingChangeSimulation, error) {
+	if change.ReplicationFactor < 0 {
+		return nil, fmt.Errorf("invalid replication factor %d", change.ReplicationFactor)
+	}
+	if err := validateLookupStrategy(cfg.LookupStrategy); err != nil {
+		return nil, err
+	}
+
+	beforeDesc := ringDesc.Clone().(*Desc)
+	beforeDesc.setInstanceIDs()
+	if len(beforeDesc.GetTokens()) == 0 {
+		return nil, ErrEmptyRing
+	}
+
+	afterDesc, err := applyRingChange(beforeDesc, change)
+	if err != nil {
+		return nil, err
+	}
+	if len(afterDesc.GetTokens()) == 0 {
+		return nil, errEmptySimulatedRing
+	}
+
+	beforeCfg, afterCfg := cfg, cfg
+	if change.ReplicationFactor > 0 {
+		afterCfg.ReplicationFactor = change.ReplicationFactor
+	}
+
+	before := newRingForSimulation(beforeCfg, beforeDesc)
+	after := newRingForSimulation(afterCfg, afterDesc)
+
+	ownershipBefore, ownershipAfter, moved, err := simulateOwnership(before, after)
+	if err != nil {
+		return nil, err
+	}
+
+	simulation := &RingChangeSimulation{
+		Change:                  change,
+		ReplicationFactorBefore: beforeCfg.ReplicationFactor,
+		ReplicationFactorAfter:  afterCfg.ReplicationFactor,
+		MovedTokenSpace:         toOwnershipPercentage(moved),
+	}
+
+	ids := make([]string, 0, len(beforeDesc.Ingesters)+len(afterDesc.Ingesters))
+	for id := range beforeDesc.Ingesters {
+		ids = append(ids, id)
+	}
+	for id := range afterDesc.Ingesters {
+		if _, ok := beforeDesc.Ingesters[id]; !ok {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		instanceBefore, inBefore := beforeDesc.Ingesters[id]
+		instanceAfter, inAfter := afterDesc.Ingesters[id]
+
+		instanceChange := InstanceOwnershipChange{
+			ID:              id,
+			Zone:            instanceBefore.Zone,
+			Added:           !inBefore,
+			Removed:         !inAfter,
+			OwnershipBefore: toOwnershipPercentage(ownershipBefore[id]),
+			OwnershipAfter:  toOwnershipPercentage(ownershipAfter[id]),
+		}
+		if !inBefore {
+			instanceChange.Zone = instanceAfter.Zone
+		}
+
+		// The token ranges are the most accurate measure of the ownership, but they can be
+		// computed only for some ring configurations.
+		if inBefore {
+			if owned, ok := tokenRangesOwnership(before, id); ok {
+				instanceChange.OwnershipBefore = toOwnershipPercentage(owned)
+			}
+		}
+		if inAfter {
+			if owned, ok := tokenRangesOwnership(after, id); ok {
+				instanceChange.OwnershipAfter = toOwnershipPercentage(owned)
+			}
+		}
+
+		simulation.Instances = append(simulation.Instances, instanceChange)
+	}
+
+	return simulation, nil
+}
+
+// validateAddedInstances checks that the instances to add, and the tokens generated for them, are within the
+// simulation limits.
+func validateAddedInstances(addInstances []AddInstancesChange, tokensPerInstance int) error {
+	if tokensPerInstance > maxSimulatedAddedTokens {
+		return fmt.Errorf("invalid number of tokens per instance %d: it must be lower than or equal to %d", tokensPerInstance, maxSimulatedAddedTokens)
+	}
+
+	instances, tokens := 0, 0
+	for _, add := range addInstances {
+		if add.Count <= 0 {
+			return fmt.Errorf("invalid number of instances %d to add to zone %q", add.Count, add.Zone)
+		}
+		if add.Weight > MaxInstanceWeight {
+			return fmt.Errorf("invalid weight %d of the instances to add to zone %q: it must be lower than or equal to %d", add.Weight, add.Zone, MaxInstanceWeight)
+		}
+
+		instances += add.Count
+		if instances > maxSimulatedAddedInstances {
+			return fmt.Errorf("too many instances to add: the limit is %d", maxSimulatedAddedInstances)
+		}
+
+		tokens += add.Count * WeightedTokensCount(tokensPerInstance, add.Weight)
+		if tokens > maxSimulatedAddedTokens {
+			return fmt.Errorf("too many tokens to generate for the instances to add: the limit is %d", maxSimulatedAddedTokens)
+		}
+	}
+	return nil
+}
+
+// applyRingChange returns a copy of ringDesc with the given change applied.
+func applyRingChange(ringDesc *Desc, change RingChange) (*Desc, error) {
+	out := ringDesc.Clone().(*Desc)
+
+	for _, id := range change.RemoveInstances {
+		if _, ok := out.Ingesters[id]; !ok {
+			return nil, errors.Wrapf(ErrInstanceNotFound, "can't remove instance %s", id)
+		}
+		out.RemoveIngester(id)
+	}
+
+	if len(change.AddInstances) == 0 {
+		return out, nil
+	}
+
+	tokensPerInstance := change.TokensPerInstance
+	if tokensPerInstance <= 0 {
+		tokensPerInstance = tokensPerUnitOfWeight(ringDesc)
+	}
+	if tokensPerInstance <= 0 {
+		return nil, errTokensPerInstance
+	}
+	if err := validateAddedInstances(change.AddInstances, tokensPerInstance); err != nil {
+		return nil, err
+	}
+
+	// The zones are required by the spread minimizing token generator, and they include the zones
+	// of the instances being added.
+	zones := getZones(ringDesc.getTokensByZone())
+	for _, add := range change.AddInstances {
+		if !slices.Contains(zones, add.Zone) {
+			zones = append(zones, add.Zone)
+		}
+	}
+	sort.Strings(zones)
+
+	seed := change.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	randomTokenGenerator := NewRandomTokenGeneratorWithSeed(seed)
+
+	now := time.Now()
+	for _, add := range change.AddInstances {
+		for i := 0; i < add.Count; i++ {
+			id := nextInstanceIDInZone(out, add.Zone)
+
+			var tokenGenerator TokenGenerator
+			switch change.TokenGenerator {
+			case "", RandomTokenGeneratorName:
+				tokenGenerator = randomTokenGenerator
+			case SpreadMinimizingTokenGeneratorName:
+				var err error
+				tokenGenerator, err = NewSpreadMinimizingTokenGenerator(id, add.Zone, zones, false)
+				if err != nil {
+					return nil, err
+				}
+			default:
+				return nil, fmt.Errorf("unsupported token generator %q", change.TokenGenerator)
+			}
+
+			tokens := generateTokens(tokenGenerator, WeightedTokensCount(tokensPerInstance, add.Weight), add.Weight, out)
+			out.AddIngesterWithWeight(id, "", add.Zone, tokens, ACTIVE, now, false, time.Time{}, add.Weight)
+		}
+	}
+
+	return out, nil
+}
+
+// tokensPerUnitOfWeight returns the highest number of tokens per unit of weight among the instances of the ring.
+func tokensPerUnitOfWeight(ringDesc *Desc) int {
+	result := 0
+	for _, instance := range ringDesc.Ingesters {
+		result = max(result, len(instance.Tokens)/int(instance.GetEffectiveWeight()))
+	}
+	return result
+}
+
+// nextInstanceIDInZone returns the ID of a new instance of the given zone. If the instances of the zone
+// are named like "<prefix>-<id>", the returned ID continues the sequence, otherwise it's "<zone>-<id>".
+func nextInstanceIDInZone(ringDesc *Desc, zone string) string {
+	prefix, nextID := "", 0
+	for id, instance := range ringDesc.Ingesters {
+		if instance.Zone != zone {
+			continue
+		}
+		instancePrefix, instanceID, err := parseInstanceID(id)
+		if err != nil {
+			continue
+		}
+		if prefix == "" || instanceID >= nextID {
+			prefix, nextID = instancePrefix, instanceID+1
+		}
+	}
+
+	if prefix == "" {
+		prefix = "simulated-"
+		if zone != "" {
+			prefix = zone + "-"
+		}
+	}
+
+	// Guarantee the ID is unique, even if the zone has instances with mixed naming.
+	for {
+		id := fmt.Sprintf("%s%d", prefix, nextID)
+		if _, ok := ringDesc.Ingesters[id]; !ok {
+			return id
+		}
+		nextID++
+	}
+}
+
+// newRingForSimulation returns a read-only Ring for the given ringDesc, not backed by any KV store.
+func newRingForSimulation(cfg Config, ringDesc *Desc) *Ring {
+	r := &Ring{
+		cfg:      cfg,
+		strategy: NewDefaultReplicationStrategy(),
+	}
+	r.setRingStateFromDesc(ringDesc, false, false, false)
+	return r
+}
+
+// simulateOwnership returns the number of tokens owned by each instance in the rings before and after
+// the change, and the number of tokens whose replica set is different in the two rings. The token space
+// is split into segments, whose keys all have the same replica set both before and after the change.
+func simulateOwnership(before, after *Ring) (ownershipBefore, ownershipAfter map[string]int64, moved int64, _ error) {
+	segments := simulationSegments(before, after)
+	ownershipBefore = map[string]int64{}
+	ownershipAfter = map[string]int64{}
+
+	bufDescs, bufHosts, bufZones := MakeBuffersForGet()
+	var beforeIDs []string
+
+	for i, start := range segments {
+		size := tokenDistance(start, segments[(i+1)%len(segments)])
+		if len(segments) == 1 {
+			size = math.MaxUint32 + 1
+		}
+
+		instances, err := before.findInstancesForKey(start, allStatesRingOperation, bufDescs, bufHosts, bufZones, nil)
+		if err != nil {
+			return nil, nil, 0, err
+		}
+		beforeIDs = beforeIDs[:0]
+		for _, instance := range instances {
+			beforeIDs = append(beforeIDs, instance.Id)
+			ownershipBefore[instance.Id] += size
+		}
+
+		instances, err = after.findInstancesForKey(start, allStatesRingOperation, bufDescs, bufHosts, bufZones, nil)
+		if err != nil {
+			return nil, nil, 0, err
+		}
+		changed := len(instances) != len(beforeIDs)
+		for _, instance := range instances {
+			ownershipAfter[instance.Id] += size
+			if !slices.Contains(beforeIDs, instance.Id) {
+				changed = true
+			}
+		}
+
+		if changed {
+			moved += size
+		}
+	}
+
+	return ownershipBefore, ownershipAfter, moved, nil
+}
+
+// simulationSegments returns the sorted start keys of the segments of the token space whose keys all
+// have the same replica set, both in the before and after rings. With the tokens lookup strategy the
+// segments are bounded by the tokens of both rings, otherwise the token space is split evenly.
+func simulationSegments(before, after *Ring) []uint32 {
+	if before.cfg.LookupStrategy == RendezvousLookupStrategy || after.cfg.LookupStrategy == RendezvousLookupStrategy {
+		segments := make([]uint32, rendezvousSimulationKeys)
+		step := uint32((math.MaxUint32 + 1) / rendezvousSimulationKeys)
+		for i := range segments {
+			segments[i] = uint32(i) * step
+		}
+		return segments
+	}
+
+	segments := mergeTokenGroups(map[string][]uint32{"before": before.ringTokens, "after": after.ringTokens})
+
+	// Remove duplicates, because both rings can have the same tokens.
+	out := segments[:0]
+	for i, token := range segments {
+		if i == 0 || token != segments[i-1] {
+			out = append(out, token)
+		}
+	}
+	return out
+}
+
+// tokenRangesOwnership returns the number of tokens owned by the instance, computed from its token ranges.
+// It returns false if the token ranges can't be computed with the ring configuration.
+func tokenRangesOwnership(r *Ring, instanceID string) (int64, bool) {
+	if r.cfg.LookupStrategy == RendezvousLookupStrategy {
+		return 0, false
+	}
+
+	ranges, err := r.GetTokenRangesForInstance(instanceID)
+	if err != nil {
+		return 0, false
+	}
+
+	owned := int64(0)
+	for i := 0; i+1 < len(ranges); i += 2 {
+		owned += int64(ranges[i+1]) - int64(ranges[i]) + 1
+	}
+	return owned, true
+}
+
+func toOwnershipPercentage(tokens int64) float64 {
+	return (float64(tokens) / (math.MaxUint32 + 1)) * 100
+}
@@ -0,0 +1,104 @@
+package ring
+
+import (
+	_ "embed"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+//go:embed ring_change_simulator.gohtml
+var ringChangeSimulatorPageContent string
+var ringChangeSimulatorPageTemplate = template.Must(template.New("webpage").Funcs(template.FuncMap{
+	"mod": func(i, j int) bool { return i%j == 0 },
+	"humanFloat": func(f float64) string {
+		return fmt.Sprintf("%.3g", f)
+	},
+}).Parse(ringChangeSimulatorPageContent))
+
+type ringChangeSimulatorResponse struct {
+	Simulation *RingChangeSimulation `json:"simulation,omitempty"`
+}
+
+// ServeSimulateChangeHTTP simulates the change of the ring described by the request query parameters, and
+// responds with the ownership of each instance before and after the change. The supported parameters are:
+//
+//   - add: instances to add, as "<zone>:<count>" or "<zone>:<count>:<weight>" (can be repeated)
+//   - remove: ID of an instance to remove (can be repeated)
+//   - replication_factor: the replication factor after the change
+//   - tokens_per_instance: the number of tokens of each added instance
+//   - token_generator: the token generator of the added instances, "random" or "spread-minimizing"
+//   - seed: the seed of the random token generator
+//
+// If no change is requested, only the form to simulate a change is rendered. The number of added instances, their
+// weight and the number of tokens generated for them are limited: requests exceeding a limit fail with 400.
+func (r *Ring) ServeSimulateChangeHTTP(w http.ResponseWriter, req *http.Request) {
+	change, err := parseRingChange(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	resp := ringChangeSimulatorResponse{}
+	if len(change.AddInstances) > 0 || len(change.RemoveInstances) > 0 || change.ReplicationFactor > 0 {
+		resp.Simulation, err = r.SimulateChange(change)
+		if err != nil {
+			http.Error(w, fmt.Errorf("error simulating the ring change: %w", err).Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	renderHTTPResponse(w, resp, ringChangeSimulatorPageTemplate, req)
+}
+
+func parseRingChange(req *http.Request) (RingChange, error) {
+	var (
+		query  = req.URL.Query()
+		change = RingChange{
+			RemoveInstances: query["remove"],
+			TokenGenerator:  query.Get("token_generator"),
+		}
+		err error
+	)
+
+	for _, add := range query["add"] {
+		parts := strings.Split(add, ":")
+		if len(parts) < 2 || len(parts) > 3 {
+			return RingChange{}, fmt.Errorf("invalid add '%s': it must be in the format <zone>:<count>[:<weight>]", add)
+		}
+
+		addInstances := AddInstancesChange{Zone: parts[0]}
+		if addInstances.Count, err = strconv.Atoi(parts[1]); err != nil {
+			return RingChange{}, fmt.Errorf("invalid number of instances in add '%s': %w", add, err)
+		}
+		if len(parts) == 3 {
+			weight, err := strconv.ParseUint(parts[2], 10, 32)
+			if err != nil {
+				return RingChange{}, fmt.Errorf("invalid weight in add '%s': %w", add, err)
+			}
+			addInstances.Weight = uint32(weight)
+		}
+
+		change.AddInstances = append(change.AddInstances, addInstances)
+	}
+
+	if param := query.Get("replication_factor"); param != "" {
+		if change.ReplicationFactor, err = strconv.Atoi(param); err != nil {
+			return RingChange{}, fmt.Errorf("invalid replication_factor '%s': %w", param, err)
+		}
+	}
+	if param := query.Get("tokens_per_instance"); param != "" {
+		if change.TokensPerInstance, err = strconv.Atoi(param); err != nil {
+			return RingChange{}, fmt.Errorf("invalid tokens_per_instance '%s': %w", param, err)
+		}
+	}
+	if param := query.Get("seed"); param != "" {
+		if change.Seed, err = strconv.ParseInt(param, 10, 64); err != nil {
+			return RingChange{}, fmt.Errorf("invalid seed '%s': %w", param, err)
+		}
+	}
+
+	return change, nil
+}
@@ -0,0 +1,264 @@
+package ring
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func prepareRingDescForSimulation(t *testing.T, zones []string, instancesPerZone, tokensPerInstance int) *Desc {
+	t.Helper()
+
+	ringDesc := NewDesc()
+	gen := NewRandomTokenGeneratorWithSeed(1)
+	for _, zone := range zones {
+		for i := 0; i < instancesPerZone; i++ {
+			id := fmt.Sprintf("instance-%s-%d", zone, i)
+			ringDesc.AddIngester(id, id, zone, gen.GenerateTokens(tokensPerInstance, ringDesc.GetTokens()), ACTIVE, time.Now(), false, time.Time{})
+		}
+	}
+	return ringDesc
+}
+
+func getInstanceOwnershipChange(t *testing.T, simulation *RingChangeSimulation, id string) InstanceOwnershipChange {
+	t.Helper()
+
+	for _, instance := range simulation.Instances {
+		if instance.ID == id {
+			return instance
+		}
+	}
+	require.Failf(t, "instance not found in the simulation", "instance: %s", id)
+	return InstanceOwnershipChange{}
+}
+
+func TestSimulateRingChange(t *testing.T) {
+	zones := []string{"zone-a", "zone-b", "zone-c"}
+	zoneAwareCfg := Config{ReplicationFactor: 3, ZoneAwarenessEnabled: true}
+
+	t.Run("no change", func(t *testing.T) {
+		ringDesc := prepareRingDescForSimulation(t, zones, 3, 32)
+
+		simulation, err := SimulateRingChange(zoneAwareCfg, ringDesc, RingChange{})
+		require.NoError(t, err)
+		assert.Equal(t, 0.0, simulation.MovedTokenSpace)
+		require.Len(t, simulation.Instances, 9)
+		for _, instance := range simulation.Instances {
+			assert.Equal(t, instance.OwnershipBefore, instance.OwnershipAfter)
+		}
+	})
+
+	t.Run("remove an instance", func(t *testing.T) {
+		ringDesc := prepareRingDescForSimulation(t, zones, 3, 32)
+
+		simulation, err := SimulateRingChange(zoneAwareCfg, ringDesc, RingChange{RemoveInstances: []string{"instance-zone-a-1"}})
+		require.NoError(t, err)
+
+		// Only the keys replicated to the removed instance should move.
+		removed := getInstanceOwnershipChange(t, simulation, "instance-zone-a-1")
+		assert.True(t, removed.Removed)
+		assert.Equal(t, 0.0, removed.OwnershipAfter)
+		assert.InDelta(t, removed.OwnershipBefore, simulation.MovedTokenSpace, 1e-9)
+
+		// The ownership of the other zones should not change, while the ownership
+		// of each zone should be the whole token space.
+		ownershipByZone := map[string]float64{}
+		for _, instance := range simulation.Instances {
+			ownershipByZone[instance.Zone] += instance.OwnershipAfter
+			if instance.Zone != "zone-a" {
+				assert.Equal(t, instance.OwnershipBefore, instance.OwnershipAfter)
+			}
+		}
+		for _, zone := range zones {
+			assert.InDelta(t, 100, ownershipByZone[zone], 1e-9)
+		}
+
+		// The input ring should not be modified.
+		assert.Contains(t, ringDesc.Ingesters, "instance-zone-a-1")
+	})
+
+	t.Run("add instances", func(t *testing.T) {
+		ringDesc := prepareRingDescForSimulation(t, zones, 3, 32)
+
+		simulation, err := SimulateRingChange(zoneAwareCfg, ringDesc, RingChange{
+			AddInstances: []AddInstancesChange{{Zone: "zone-b", Count: 2}, {Zone: "zone-c", Count: 1, Weight: 2}},
+			Seed:         1,
+		})
+		require.NoError(t, err)
+		require.Len(t, simulation.Instances, 12)
+
+		movedOwnership := 0.0
+		for _, id := range []string{"instance-zone-b-3", "instance-zone-b-4", "instance-zone-c-3"} {
+			added := getInstanceOwnershipChange(t, simulation, id)
+			assert.True(t, added.Added)
+			assert.Equal(t, 0.0, added.OwnershipBefore)
+			assert.Greater(t, added.OwnershipAfter, 0.0)
+			movedOwnership += added.OwnershipAfter
+		}
+
+		// Keys only move to the added instances, so the moved token space can't exceed their ownership.
+		assert.LessOrEqual(t, simulation.MovedTokenSpace, movedOwnership+1e-9)
+		assert.Greater(t, simulation.MovedTokenSpace, 0.0)
+	})
+
+	t.Run("add instances with the spread minimizing token generator", func(t *testing.T) {
+		ringDesc := NewDesc()
+		for _, zone := range zones {
+			for i := 0; i < 3; i++ {
+				id := fmt.Sprintf("instance-%s-%d", zone, i)
+				gen, err := NewSpreadMinimizingTokenGenerator(id, zone, zones, false)
+				require.NoError(t, err)
+				ringDesc.AddIngester(id, id, zone, gen.GenerateTokens(optimalTokensPerInstance, nil), ACTIVE, time.Now(), false, time.Time{})
+			}
+		}
+
+		simulation, err := SimulateRingChange(zoneAwareCfg, ringDesc, RingChange{
+			AddInstances:   []AddInstancesChange{{Zone: "zone-a", Count: 1}},
+			TokenGenerator: SpreadMinimizingTokenGeneratorName,
+		})
+		require.NoError(t, err)
+
+		// The spread minimizing token generator guarantees the ownership of each instance of the zone is balanced.
+		for _, instance := range simulation.Instances {
+			if instance.Zone == "zone-a" {
+				assert.InDelta(t, 25, instance.OwnershipAfter, 0.1, instance.ID)
+			}
+		}
+		assert.InDelta(t, 25, simulation.MovedTokenSpace, 0.1)
+	})
+
+	t.Run("change the replication factor", func(t *testing.T) {
+		ringDesc := prepareRingDescForSimulation(t, []string{""}, 4, 32)
+
+		simulation, err := SimulateRingChange(Config{ReplicationFactor: 1}, ringDesc, RingChange{ReplicationFactor: 2})
+		require.NoError(t, err)
+		assert.Equal(t, 1, simulation.ReplicationFactorBefore)
+		assert.Equal(t, 2, simulation.ReplicationFactorAfter)
+
+		// Each key gets an additional replica.
+		assert.InDelta(t, 100, simulation.MovedTokenSpace, 1e-9)
+
+		totalBefore, totalAfter := 0.0, 0.0
+		for _, instance := range simulation.Instances {
+			totalBefore += instance.OwnershipBefore
+			totalAfter += instance.OwnershipAfter
+		}
+		assert.InDelta(t, 100, totalBefore, 1e-9)
+		assert.InDelta(t, 200, totalAfter, 1e-9)
+	})
+
+	t.Run("rendezvous lookup strategy", func(t *testing.T) {
+		ringDesc := prepareRingDescForSimulation(t, []string{""}, 5, 1)
+		cfg := Config{ReplicationFactor: 1, LookupStrategy: RendezvousLookupStrategy}
+
+		simulation, err := SimulateRingChange(cfg, ringDesc, RingChange{RemoveInstances: []string{"instance--2"}})
+		require.NoError(t, err)
+
+		// The rendezvous hashing moves only the keys of the removed instance, and distributes keys evenly.
+		removed := getInstanceOwnershipChange(t, simulation, "instance--2")
+		assert.InDelta(t, removed.OwnershipBefore, simulation.MovedTokenSpace, 1e-9)
+		for _, instance := range simulation.Instances {
+			assert.InDelta(t, 20, instance.OwnershipBefore, 1, instance.ID)
+			if !instance.Removed {
+				assert.InDelta(t, 25, instance.OwnershipAfter, 1, instance.ID)
+			}
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		ringDesc := prepareRingDescForSimulation(t, zones, 1, 4)
+
+		_, err := SimulateRingChange(zoneAwareCfg, ringDesc, RingChange{RemoveInstances: []string{"unknown"}})
+		require.ErrorIs(t, err, ErrInstanceNotFound)
+
+		_, err = SimulateRingChange(zoneAwareCfg, ringDesc, RingChange{AddInstances: []AddInstancesChange{{Zone: "zone-a"}}})
+		require.Error(t, err)
+
+		_, err = SimulateRingChange(zoneAwareCfg, ringDesc, RingChange{AddInstances: []AddInstancesChange{{Zone: "zone-a", Count: 1}}, TokenGenerator: "unknown"})
+		require.Error(t, err)
+
+		_, err = SimulateRingChange(zoneAwareCfg, ringDesc, RingChange{ReplicationFactor: -1})
+		require.Error(t, err)
+
+		_, err = SimulateRingChange(zoneAwareCfg, NewDesc(), RingChange{})
+		require.ErrorIs(t, err, ErrEmptyRing)
+
+		_, err = SimulateRingChange(zoneAwareCfg, ringDesc, RingChange{RemoveInstances: []string{"instance-zone-a-0", "instance-zone-b-0", "instance-zone-c-0"}})
+		require.ErrorIs(t, err, errEmptySimulatedRing)
+	})
+}
+
+func TestSimulateOwnership_ShouldMatchTokenRanges(t *testing.T) {
+	ringDesc := prepareRingDescForSimulation(t, []string{"zone-a", "zone-b", "zone-c"}, 4, 64)
+	r := newRingForSimulation(Config{ReplicationFactor: 3, ZoneAwarenessEnabled: true}, ringDesc)
+
+	ownership, _, _, err := simulateOwnership(r, r)
+	require.NoError(t, err)
+
+	for id := range ringDesc.Ingesters {
+		expected, ok := tokenRangesOwnership(r, id)
+		require.True(t, ok)
+		assert.Equal(t, expected, ownership[id], id)
+	}
+}
+
+func TestNextInstanceIDInZone(t *testing.T) {
+	ringDesc := NewDesc()
+	ringDesc.AddIngester("ingester-zone-a-0", "", "zone-a", nil, ACTIVE, time.Now(), false, time.Time{})
+	ringDesc.AddIngester("ingester-zone-a-5", "", "zone-a", nil, ACTIVE, time.Now(), false, time.Time{})
+	ringDesc.AddIngester("ingester-zone-b", "", "zone-b", nil, ACTIVE, time.Now(), false, time.Time{})
+
+	assert.Equal(t, "ingester-zone-a-6", nextInstanceIDInZone(ringDesc, "zone-a"))
+	assert.Equal(t, "zone-b-0", nextInstanceIDInZone(ringDesc, "zone-b"))
+	assert.Equal(t, "zone-c-0", nextInstanceIDInZone(ringDesc, "zone-c"))
+	assert.Equal(t, "simulated-0", nextInstanceIDInZone(ringDesc, ""))
+}
+
+func TestRing_ServeSimulateChangeHTTP(t *testing.T) {
+	ring := newRingForTesting(Config{ReplicationFactor: 3, ZoneAwarenessEnabled: true}, false)
+	ring.setRingStateFromDesc(prepareRingDescForSimulation(t, []string{"zone-a", "zone-b", "zone-c"}, 2, 16), false, false, false)
+
+	t.Run("should simulate the requested change", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/ring/simulate?add=zone-a:2&remove=instance-zone-b-0&seed=1", nil)
+		req.Header.Set("Accept", "application/json")
+		rec := httptest.NewRecorder()
+		ring.ServeSimulateChangeHTTP(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		resp := ringChangeSimulatorResponse{}
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+		require.NotNil(t, resp.Simulation)
+		assert.Equal(t, []AddInstancesChange{{Zone: "zone-a", Count: 2}}, resp.Simulation.Change.AddInstances)
+		assert.Equal(t, []string{"instance-zone-b-0"}, resp.Simulation.Change.RemoveInstances)
+		assert.Len(t, resp.Simulation.Instances, 8)
+		assert.Greater(t, resp.Simulation.MovedTokenSpace, 0.0)
+	})
+
+	t.Run("should render the page", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/ring/simulate?add=zone-a:1:2", nil)
+		rec := httptest.NewRecorder()
+		ring.ServeSimulateChangeHTTP(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), "instance-zone-a-2")
+	})
+
+	t.Run("should fail on invalid parameters", func(t *testing.T) {
+		for _, query := range []string{
+			"add=zone-a", "add=zone-a:x", "replication_factor=x", "remove=unknown",
+			// Changes exceeding the simulation limits.
+			"add=zone-a:1000000:4294967295", "add=zone-a:1:101", "add=zone-a:10001", "add=zone-a:2&tokens_per_instance=2000000",
+			"add=zone-a:5000&add=zone-b:5000&tokens_per_instance=512",
+		} {
+			req := httptest.NewRequest(http.MethodGet, "/ring/simulate?"+query, nil)
+			rec := httptest.NewRecorder()
+			ring.ServeSimulateChangeHTTP(rec, req)
+			assert.Equal(t, http.StatusBadRequest, rec.Code, query)
+		}
+	})
+}
@@ -9,6 +9,7 @@ import (
 	"math"
 	"net/http"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -29,10 +30,17 @@ var defaultPageTemplate = template.Must(template.New("webpage").Funcs(template.F
 	"durationSince": func(t time.Time) string { return time.Since(t).Truncate(time.Second).String() },
 }).Parse(defaultPageContent))
 
+// defaultRebalanceTargetSpread is the target spread of the token rebalancing plan shown
+// in the ring page, unless a different one is requested.
+const defaultRebalanceTargetSpread = 0.05
+
 type httpResponse struct {
 	Ingesters  []ingesterDesc `json:"shards"`
 	Now        time.Time      `json:"now"`
 	ShowTokens bool           `json:"-"`
+
+	// Rebalance is the dry-run token rebalancing plan, computed only when requested.
+	Rebalance *TokenRebalancePlan `json:"rebalance,omitempty"`
 }
 
 type ingesterDesc struct {
@@ -45,8 +53,10 @@ type ingesterDesc struct {
 	ReadOnlyUpdatedTimestamp time.Time `json:"read_only_updated_timestamp"`
 	Zone                     string    `json:"zone"`
 	Tokens                   []uint32  `json:"tokens"`
+	Weight                   uint32    `json:"weight"`
 	NumTokens                int       `json:"-"`
 	Ownership                float64   `json:"-"`
+	ExpectedOwnership        float64   `json:"-"`
 }
 
 type ringAccess interface {
@@ -96,6 +106,7 @@ func (h *ringPageHandler) handle(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 	ownedTokens := ringDesc.CountTokens()
+	weightPerZone := ringDesc.instancesWithTokensWeightPerZone()
 
 	var ingesterIDs []string
 	for id := range ringDesc.Ingesters {
@@ -114,6 +125,13 @@ func (h *ringPageHandler) handle(w http.ResponseWriter, req *http.Request) {
 
 		ro, rots := ing.GetReadOnlyState()
 
+		// The expected ownership is the share of the token space of the instance's zone
+		// proportional to its weight, assuming the tokens are perfectly balanced.
+		expectedOwnership := 0.0
+		if len(ing.Tokens) > 0 && weightPerZone[ing.Zone] > 0 {
+			expectedOwnership = (float64(ing.GetEffectiveWeight()) / float64(weightPerZone[ing.Zone])) * 100
+		}
+
 		ingesters = append(ingesters, ingesterDesc{
 			ID:                       id,
 			State:                    state,
@@ -124,17 +142,36 @@ func (h *ringPageHandler) handle(w http.ResponseWriter, req *http.Request) {
 			ReadOnlyUpdatedTimestamp: rots.UTC(),
 			Tokens:                   ing.Tokens,
 			Zone:                     ing.Zone,
+			Weight:                   ing.GetEffectiveWeight(),
 			NumTokens:                len(ing.Tokens),
 			Ownership:                (float64(ownedTokens[id]) / float64(math.MaxUint32)) * 100,
+			ExpectedOwnership:        expectedOwnership,
 		})
 	}
 
 	tokensParam := req.URL.Query().Get("tokens")
 
+	// The token rebalancing plan is only shown (never applied) by this page.
+	var rebalancePlan *TokenRebalancePlan
+	if req.URL.Query().Get("rebalance") == "true" {
+		targetSpread := defaultRebalanceTargetSpread
+		if param := req.URL.Query().Get("target_spread"); param != "" {
+			targetSpread, err = strconv.ParseFloat(param, 64)
+			if err != nil || targetSpread < 0 || targetSpread >= 1 {
+				http.Error(w, fmt.Sprintf("invalid target_spread '%s': it must be a number greater than or equal to 0 and lower than 1", param), http.StatusBadRequest)
+				return
+			}
+		}
+
+		plan := PlanTokenRebalance(ringDesc, targetSpread, 0)
+		rebalancePlan = &plan
+	}
+
 	renderHTTPResponse(w, httpResponse{
 		Ingesters:  ingesters,
 		Now:        now,
 		ShowTokens: tokensParam == "true",
+		Rebalance:  rebalancePlan,
 	}, defaultPageTemplate, req)
 }
 
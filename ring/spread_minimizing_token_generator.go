@@ -103,12 +103,18 @@ func findZoneID(zone string, sortedZones []string) (int, error) {
 // generateFirstInstanceTokens calculates a set of tokens that should be assigned to the first instance (with id 0)
 // of the zone of the underlying instance.
 func (t *SpreadMinimizingTokenGenerator) generateFirstInstanceTokens() Tokens {
+	return t.generateFirstInstanceWeightedTokens(optimalTokensPerInstance)
+}
+
+// generateFirstInstanceWeightedTokens is like generateFirstInstanceTokens, but it calculates the given
+// tokensCount tokens, so that the number of tokens of the first instance can be proportional to its weight.
+func (t *SpreadMinimizingTokenGenerator) generateFirstInstanceWeightedTokens(tokensCount int) Tokens {
 	// In this approach all the tokens from the same zone are equal to each other modulo maxZonesCount.
 	// Therefore, tokenDistance is calculated as a multiple of maxZonesCount, so that we ensure that
 	// the following for loop calculates the actual tokens following the approach's requirement.
-	tokenDistance := (totalTokensCount / optimalTokensPerInstance / maxZonesCount) * maxZonesCount
-	tokens := make(Tokens, 0, optimalTokensPerInstance)
-	for i := 0; i < optimalTokensPerInstance; i++ {
+	tokenDistance := (totalTokensCount / tokensCount / maxZonesCount) * maxZonesCount
+	tokens := make(Tokens, 0, tokensCount)
+	for i := 0; i < tokensCount; i++ {
 		token := uint32(i*tokenDistance) + uint32(t.zoneID)
 		tokens = append(tokens, token)
 	}
@@ -164,12 +170,38 @@ func (t *SpreadMinimizingTokenGenerator) optimalTokenOwnership(optimalInstanceOw
 //   - if among the 512 (optimalTokenPerInstance) reserved tokens there is less than tokenCount
 //     tokens not already present in takenTokens.
 func (t *SpreadMinimizingTokenGenerator) GenerateTokens(requestedTokensCount int, allTakenTokens []uint32) Tokens {
+	return t.generateTokens(requestedTokensCount, allTakenTokens, nil)
+}
+
+// GenerateWeightedTokens is like GenerateTokens, but both the number of reserved tokens and the registered
+// ownership of each instance are proportional to its weight, i.e., an instance with weight 2 reserves 1024
+// tokens, and owns twice the token space of an instance with weight 1. The weights of the instances
+// whose id is lower than the id of the underlying instance are taken from the given instances, while the
+// weight of the underlying instance is the given weight. Instances with an unknown weight have the default
+// weight. Since the tokens of an instance depend on the weights of all the instances with lower ids, the
+// weights should not change once the instances have joined the ring.
+func (t *SpreadMinimizingTokenGenerator) GenerateWeightedTokens(requestedTokensCount int, weight uint32, instances map[string]InstanceDesc) Tokens {
+	weights := make(map[int]uint32, t.instanceID+1)
+	for id, instance := range instances {
+		prefix, instanceID, err := parseInstanceID(id)
+		if err != nil || prefix != t.instancePrefix || instanceID >= t.instanceID {
+			continue
+		}
+		weights[instanceID] = instance.GetEffectiveWeight()
+	}
+	weights[t.instanceID] = weight
+
+	ringDesc := &Desc{Ingesters: instances}
+	return t.generateTokens(requestedTokensCount, ringDesc.GetTokens(), weights)
+}
+
+func (t *SpreadMinimizingTokenGenerator) generateTokens(requestedTokensCount int, allTakenTokens []uint32, weights map[int]uint32) Tokens {
 	used := make(map[uint32]bool, len(allTakenTokens))
 	for _, v := range allTakenTokens {
 		used[v] = true
 	}
 
-	allTokens, err := t.generateAllTokens()
+	allTokens, err := t.generateAllWeightedTokens(weights)
 	if err != nil {
 		// we were unable to generate required tokens, so we panic.
 		panic(err)
@@ -195,7 +227,14 @@ func (t *SpreadMinimizingTokenGenerator) GenerateTokens(requestedTokensCount int
 // is optimal.
 // Calls to this method will always return the same set of tokens.
 func (t *SpreadMinimizingTokenGenerator) generateAllTokens() (Tokens, error) {
-	tokensByInstanceID, err := t.generateTokensByInstanceID()
+	return t.generateAllWeightedTokens(nil)
+}
+
+// generateAllWeightedTokens is like generateAllTokens, but the registered ownership of the instances
+// is proportional to the given weights, indexed by instance id. Instances missing from weights, or
+// with a 0 weight, have the default weight.
+func (t *SpreadMinimizingTokenGenerator) generateAllWeightedTokens(weights map[int]uint32) (Tokens, error) {
+	tokensByInstanceID, err := t.generateWeightedTokensByInstanceID(weights)
 	if err != nil {
 		return nil, err
 	}
@@ -210,7 +249,25 @@ func (t *SpreadMinimizingTokenGenerator) generateAllTokens() (Tokens, error) {
 // way that registered ownership of all the instances is optimal.
 // Calls to this method will always return the same set of tokens.
 func (t *SpreadMinimizingTokenGenerator) generateTokensByInstanceID() (map[int]Tokens, error) {
-	firstInstanceTokens := t.generateFirstInstanceTokens()
+	return t.generateWeightedTokensByInstanceID(nil)
+}
+
+// generateWeightedTokensByInstanceID is like generateTokensByInstanceID, but the registered ownership
+// of the instances is proportional to the given weights, indexed by instance id. Instances missing
+// from weights, or with a 0 weight, have the default weight.
+func (t *SpreadMinimizingTokenGenerator) generateWeightedTokensByInstanceID(weights map[int]uint32) (map[int]Tokens, error) {
+	instanceWeight := func(instanceID int) float64 {
+		if weight := weights[instanceID]; weight > 0 {
+			return float64(weight)
+		}
+		return DefaultInstanceWeight
+	}
+	// In order to preserve the ownership of each token, the number of tokens of each instance is proportional to its weight.
+	instanceTokensCount := func(instanceID int) int {
+		return optimalTokensPerInstance * int(instanceWeight(instanceID))
+	}
+
+	firstInstanceTokens := t.generateFirstInstanceWeightedTokens(instanceTokensCount(0))
 	tokensByInstanceID := make(map[int]Tokens, t.instanceID+1)
 	tokensByInstanceID[0] = firstInstanceTokens
 
@@ -224,7 +281,7 @@ func (t *SpreadMinimizingTokenGenerator) generateTokensByInstanceID() (map[int]T
 	tokensQueues := make([]ownershipPriorityQueue[ringToken], t.instanceID)
 
 	// Create and initialize priority queue of tokens for the first instance
-	tokensQueue := newPriorityQueue[ringToken](optimalTokensPerInstance)
+	tokensQueue := newPriorityQueue[ringToken](len(firstInstanceTokens))
 	prev := len(firstInstanceTokens) - 1
 	firstInstanceOwnership := 0.0
 	for tk, token := range firstInstanceTokens {
@@ -235,26 +292,32 @@ func (t *SpreadMinimizingTokenGenerator) generateTokensByInstanceID() (map[int]T
 	}
 	tokensQueues[0] = tokensQueue
 
-	// instanceQueue is a priority queue of instances such that instances with higher ownership have a higher priority
+	// instanceQueue is a priority queue of instances such that instances with higher ownership have a higher priority.
+	// The ownership of each instance is divided by its weight, so that the instances owning most token space
+	// compared to their capacity have a higher priority.
 	instanceQueue := newPriorityQueue[ringInstance](t.instanceID)
-	heap.Push(&instanceQueue, newRingInstanceOwnershipInfo(0, firstInstanceOwnership))
+	heap.Push(&instanceQueue, newRingInstanceOwnershipInfo(0, firstInstanceOwnership/instanceWeight(0)))
+	totalWeight := instanceWeight(0)
 
 	// ignoredInstances is a slice of the current instances whose tokens
 	// don't have enough space to accommodate new tokens.
 	ignoredInstances := make([]ownershipInfo[ringInstance], 0, t.instanceID)
 
 	for i := 1; i <= t.instanceID; i++ {
-		optimalInstanceOwnership := float64(totalTokensCount) / float64(i+1)
+		weight := instanceWeight(i)
+		totalWeight += weight
+		optimalInstanceOwnership := float64(totalTokensCount) * weight / totalWeight
 		currInstanceOwnership := 0.0
 		addedTokens := 0
 		ignoredInstances = ignoredInstances[:0]
-		tokens := make(Tokens, 0, optimalTokensPerInstance)
+		tokensCount := instanceTokensCount(i)
+		tokens := make(Tokens, 0, tokensCount)
 		// currInstanceTokenQueue is the priority queue of tokens of newInstance
-		currInstanceTokenQueue := newPriorityQueue[ringToken](optimalTokensPerInstance)
-		for addedTokens < optimalTokensPerInstance {
-			optimalTokenOwnership := t.optimalTokenOwnership(optimalInstanceOwnership, currInstanceOwnership, uint32(optimalTokensPerInstance-addedTokens))
+		currInstanceTokenQueue := newPriorityQueue[ringToken](tokensCount)
+		for addedTokens < tokensCount {
+			optimalTokenOwnership := t.optimalTokenOwnership(optimalInstanceOwnership, currInstanceOwnership, uint32(tokensCount-addedTokens))
 			highestOwnershipInstance := instanceQueue.Peek()
-			if highestOwnershipInstance == nil || highestOwnershipInstance.ownership <= float64(optimalTokenOwnership) {
+			if highestOwnershipInstance == nil || highestOwnershipInstance.ownership*instanceWeight(highestOwnershipInstance.item.instanceID) <= float64(optimalTokenOwnership) {
 				// if this happens, it means that we cannot accommodate other tokens
 				return nil, fmt.Errorf("it was impossible to add %dth token for instance with id %d in zone id %d because the instance with the highest ownership cannot satisfy the requested ownership %d", addedTokens+1, i, t.zoneID, optimalTokenOwnership)
 			}
@@ -288,7 +351,8 @@ func (t *SpreadMinimizingTokenGenerator) generateTokensByInstanceID() (map[int]T
 
 			// The ownership of the instance with the highest ownership has changed,
 			// so we propagate these changes in the instances queue.
-			highestOwnershipInstance.ownership = highestOwnershipInstance.ownership - oldTokenOwnership + newTokenOwnership
+			highestOwnershipInstanceWeight := instanceWeight(highestOwnershipInstance.item.instanceID)
+			highestOwnershipInstance.ownership = (highestOwnershipInstance.ownership*highestOwnershipInstanceWeight - oldTokenOwnership + newTokenOwnership) / highestOwnershipInstanceWeight
 			heap.Fix(&instanceQueue, 0)
 
 			addedTokens++
@@ -307,7 +371,7 @@ func (t *SpreadMinimizingTokenGenerator) generateTokensByInstanceID() (map[int]T
 		tokensQueues[i] = currInstanceTokenQueue
 
 		// add the current instance with the calculated ownership currInstanceOwnership to instanceQueue
-		heap.Push(&instanceQueue, newRingInstanceOwnershipInfo(i, currInstanceOwnership))
+		heap.Push(&instanceQueue, newRingInstanceOwnershipInfo(i, currInstanceOwnership/weight))
 	}
 
 	return tokensByInstanceID, nil
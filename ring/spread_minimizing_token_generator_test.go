@@ -593,3 +593,49 @@ func (t *spreadMinimizingTokenGeneratorWithDelay) CanJoin(instances map[string]I
 	time.Sleep(t.canJoinDelay)
 	return t.SpreadMinimizingTokenGenerator.CanJoin(instances)
 }
+
+func TestSpreadMinimizingTokenGenerator_GenerateWeightedTokens(t *testing.T) {
+	const instancesCount = 8
+	zone := zones[0]
+	weights := map[int]uint32{0: 1, 1: 2, 2: 1, 3: 4, 4: 1, 5: 2, 6: 1, 7: 3}
+
+	// Register all the instances one by one, as it happens when they join the ring.
+	ringDesc := NewDesc()
+	for i := 0; i < instancesCount; i++ {
+		instance := fmt.Sprintf("instance-%s-%d", zone, i)
+		tokenGenerator := createSpreadMinimizingTokenGenerator(t, instance, zone, zones)
+		tokensCount := WeightedTokensCount(optimalTokensPerInstance, weights[i])
+		tokens := tokenGenerator.GenerateWeightedTokens(tokensCount, weights[i], ringDesc.Ingesters)
+		require.Len(t, tokens, tokensCount)
+		ringDesc.AddIngesterWithWeight(instance, instance, zone, tokens, ACTIVE, time.Now(), false, time.Time{}, weights[i])
+	}
+
+	totalWeight := uint32(0)
+	for _, weight := range weights {
+		totalWeight += weight
+	}
+
+	// Ensure the ownership of each instance is proportional to its weight.
+	ownership := ringDesc.CountTokens()
+	for i := 0; i < instancesCount; i++ {
+		instance := fmt.Sprintf("instance-%s-%d", zone, i)
+		expected := float64(totalTokensCount) * float64(weights[i]) / float64(totalWeight)
+		require.InDelta(t, expected, float64(ownership[instance]), expected*0.01, "instance %s", instance)
+	}
+}
+
+func TestSpreadMinimizingTokenGenerator_GenerateWeightedTokensWithDefaultWeights(t *testing.T) {
+	instanceID := 100
+	zone := zones[1]
+	instance := fmt.Sprintf("instance-%s-%d", zone, instanceID)
+	tokenGenerator := createSpreadMinimizingTokenGenerator(t, instance, zone, zones)
+
+	// When all instances have the default weight, weighted tokens must match the unweighted ones,
+	// so that instances can be restarted with weights support without changing their tokens.
+	instances := map[string]InstanceDesc{}
+	for i := 0; i < instanceID; i++ {
+		instances[fmt.Sprintf("instance-%s-%d", zone, i)] = InstanceDesc{Zone: zone}
+	}
+	require.Equal(t, tokenGenerator.GenerateTokens(optimalTokensPerInstance, nil), tokenGenerator.GenerateWeightedTokens(optimalTokensPerInstance, 0, instances))
+	require.Equal(t, tokenGenerator.GenerateTokens(optimalTokensPerInstance, nil), tokenGenerator.GenerateWeightedTokens(optimalTokensPerInstance, DefaultInstanceWeight, nil))
+}
@@ -7,6 +7,13 @@ import (
 	"time"
 )
 
+// DefaultInstanceWeight is the capacity weight of instances which have no weight configured.
+const DefaultInstanceWeight = 1
+
+// MaxInstanceWeight is the maximum capacity weight of an instance. It bounds the number of tokens
+// registered by an instance to num tokens multiplied by this value.
+const MaxInstanceWeight = 100
+
 type TokenGenerator interface {
 	// GenerateTokens generates at most requestedTokensCount unique tokens, none of which clashes with
 	// the given allTakenTokens, representing the set of all tokens currently present in the ring.
@@ -22,6 +29,39 @@ type TokenGenerator interface {
 	CanJoinEnabled() bool
 }
 
+// WeightedTokenGenerator is an optional interface which can be implemented by a TokenGenerator
+// whose generated tokens depend on the capacity weights of all the instances in the ring, and not
+// only on the number of requested tokens (see WeightedTokensCount).
+type WeightedTokenGenerator interface {
+	TokenGenerator
+
+	// GenerateWeightedTokens is like GenerateTokens, but it generates tokens in such a way that the
+	// ownership of the instance owning this WeightedTokenGenerator is proportional to the given weight,
+	// compared to the weights of the given instances, representing all instances currently present
+	// in the ring.
+	GenerateWeightedTokens(requestedTokensCount int, weight uint32, instances map[string]InstanceDesc) Tokens
+}
+
+// WeightedTokensCount returns the number of tokens that an instance with the given weight should own,
+// given the number of tokens owned by an instance with the default weight.
+func WeightedTokensCount(tokensCount int, weight uint32) int {
+	if weight == 0 {
+		weight = DefaultInstanceWeight
+	}
+	return tokensCount * int(weight)
+}
+
+// generateTokens generates at most requestedTokensCount tokens for an instance with the given weight
+// using the given TokenGenerator. If the latter is a WeightedTokenGenerator, the weights of the
+// instances registered in ringDesc are taken into account, otherwise it's the caller's responsibility
+// to request a number of tokens proportional to the weight.
+func generateTokens(tokenGenerator TokenGenerator, requestedTokensCount int, weight uint32, ringDesc *Desc) Tokens {
+	if weightedTokenGenerator, ok := tokenGenerator.(WeightedTokenGenerator); ok {
+		return weightedTokenGenerator.GenerateWeightedTokens(requestedTokensCount, weight, ringDesc.GetIngesters())
+	}
+	return tokenGenerator.GenerateTokens(requestedTokensCount, ringDesc.GetTokens())
+}
+
 type RandomTokenGenerator struct {
 	m sync.Mutex
 	r *rand.Rand
@@ -34,3 +34,25 @@ func TestRandomTokenGenerator_IgnoresOldTokens(t *testing.T) {
 		}
 	}
 }
+
+func TestWeightedTokensCount(t *testing.T) {
+	tests := map[string]struct {
+		tokensCount int
+		weight      uint32
+		expected    int
+	}{
+		"unknown weight":  {tokensCount: 128, weight: 0, expected: 128},
+		"default weight":  {tokensCount: 128, weight: DefaultInstanceWeight, expected: 128},
+		"weight 3":        {tokensCount: 128, weight: 3, expected: 384},
+		"no tokens":       {tokensCount: 0, weight: 3, expected: 0},
+		"weight 2, token": {tokensCount: 1, weight: 2, expected: 2},
+	}
+
+	for testName, testData := range tests {
+		t.Run(testName, func(t *testing.T) {
+			if actual := WeightedTokensCount(testData.tokensCount, testData.weight); actual != testData.expected {
+				t.Errorf("expected %d tokens, got %d", testData.expected, actual)
+			}
+		})
+	}
+}
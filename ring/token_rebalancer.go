@@ -0,0 +1,305 @@
+package ring
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// TokenRebalancerConfig is the config of the TokenRebalancerDelegate.
+type TokenRebalancerConfig struct {
+	Enabled              bool    `yaml:"enabled" category:"experimental"`
+	TargetSpread         float64 `yaml:"target_spread" category:"experimental"`
+	MaxMovesPerHeartbeat int     `yaml:"max_moves_per_heartbeat" category:"experimental"`
+	DryRun               bool    `yaml:"dry_run" category:"experimental"`
+}
+
+// RegisterFlagsWithPrefix registers the flags of TokenRebalancerConfig with the given prefix.
+func (cfg *TokenRebalancerConfig) RegisterFlagsWithPrefix(prefix string, f *flag.FlagSet) {
+	f.BoolVar(&cfg.Enabled, prefix+"token-rebalancer.enabled", false, "Enable the online rebalancing of tokens among the instances of the ring, moving tokens from the instances with the highest ownership to the ones with the lowest.")
+	f.Float64Var(&cfg.TargetSpread, prefix+"token-rebalancer.target-spread", 0.05, "The target ownership spread within each zone, computed as 1 - (lowest ownership / highest ownership) after normalizing the ownership of each instance by its weight. Tokens are moved only while the spread is higher than this value.")
+	f.IntVar(&cfg.MaxMovesPerHeartbeat, prefix+"token-rebalancer.max-moves-per-heartbeat", 1, "Maximum number of tokens each instance gives away at every heartbeat.")
+	f.BoolVar(&cfg.DryRun, prefix+"token-rebalancer.dry-run", false, "If enabled, the rebalancing plan is computed and exported as metrics, but tokens are not moved.")
+}
+
+// Validate the TokenRebalancerConfig.
+func (cfg *TokenRebalancerConfig) Validate() error {
+	if cfg.TargetSpread < 0 || cfg.TargetSpread >= 1 {
+		return fmt.Errorf("the token rebalancer target spread %v is not valid: it must be greater than or equal to 0 and lower than 1", cfg.TargetSpread)
+	}
+	if cfg.MaxMovesPerHeartbeat <= 0 {
+		return fmt.Errorf("the token rebalancer max moves per heartbeat %d is not valid: it must be greater than 0", cfg.MaxMovesPerHeartbeat)
+	}
+	return nil
+}
+
+// TokenMove describes the transfer of the ownership of a token from an instance to another one
+// in the same zone. The token itself doesn't change its position in the ring.
+type TokenMove struct {
+	Zone  string `json:"zone"`
+	Token uint32 `json:"token"`
+	From  string `json:"from"`
+	To    string `json:"to"`
+}
+
+// TokenRebalancePlan is the list of token moves that should be applied to a ring in order
+// to bring the ownership spread of each zone within a target spread.
+type TokenRebalancePlan struct {
+	Moves []TokenMove `json:"moves"`
+
+	// SpreadBefore and SpreadAfter are the highest ownership spread among all zones,
+	// respectively before and after applying the planned moves.
+	SpreadBefore float64 `json:"spread_before"`
+	SpreadAfter  float64 `json:"spread_after"`
+}
+
+// rebalancingInstance holds the state of an instance while planning a rebalance.
+type rebalancingInstance struct {
+	id        string
+	weight    float64
+	ownership int64
+	tokens    map[uint32]int64
+}
+
+func (i *rebalancingInstance) normalizedOwnership() float64 {
+	return float64(i.ownership) / i.weight
+}
+
+// PlanTokenRebalance computes the moves of tokens between the ACTIVE instances of each zone of the
+// ring, required to bring the spread of the registered ownership, normalized by instance weight,
+// within targetSpread. Moves are greedily chosen so that each of them gives the token of the
+// instance with the highest ownership whose range best balances it with the instance with the
+// lowest ownership, which keeps the number of moves low. At most maxMoves moves are planned,
+// unless maxMoves is 0. The given ring is not modified.
+//
+// Instances in any state other than ACTIVE, or without tokens, are excluded from the plan,
+// as well as their tokens. Moving tokens changes the ownership of data, so the planned moves
+// should be applied gradually.
+func PlanTokenRebalance(ringDesc *Desc, targetSpread float64, maxMoves int) TokenRebalancePlan {
+	plan := TokenRebalancePlan{}
+	if ringDesc == nil {
+		return plan
+	}
+
+	tokensByZone := ringDesc.getTokensByZone()
+	tokensInfo := ringDesc.getTokensInfo()
+
+	zones := make([]string, 0, len(tokensByZone))
+	for zone := range tokensByZone {
+		zones = append(zones, zone)
+	}
+	sort.Strings(zones)
+
+	for _, zone := range zones {
+		instances := rebalancingInstancesForZone(ringDesc, tokensByZone[zone], tokensInfo)
+		plan.SpreadBefore = math.Max(plan.SpreadBefore, ownershipSpread(instances))
+
+		for maxMoves == 0 || len(plan.Moves) < maxMoves {
+			move, ok := nextTokenMove(instances, targetSpread)
+			if !ok {
+				break
+			}
+			move.Zone = zone
+			plan.Moves = append(plan.Moves, move)
+		}
+
+		plan.SpreadAfter = math.Max(plan.SpreadAfter, ownershipSpread(instances))
+	}
+
+	return plan
+}
+
+// rebalancingInstancesForZone returns the ACTIVE instances owning the given zoneTokens, sorted by ID.
+func rebalancingInstancesForZone(ringDesc *Desc, zoneTokens []uint32, tokensInfo map[uint32]instanceInfo) []*rebalancingInstance {
+	byID := map[string]*rebalancingInstance{}
+	for i, token := range zoneTokens {
+		var prevToken uint32
+		if i == 0 {
+			prevToken = zoneTokens[len(zoneTokens)-1]
+		} else {
+			prevToken = zoneTokens[i-1]
+		}
+
+		instanceID := tokensInfo[token].InstanceID
+		desc := ringDesc.Ingesters[instanceID]
+		if desc.State != ACTIVE {
+			continue
+		}
+
+		instance, ok := byID[instanceID]
+		if !ok {
+			instance = &rebalancingInstance{
+				id:     instanceID,
+				weight: float64(desc.GetEffectiveWeight()),
+				tokens: make(map[uint32]int64, len(desc.Tokens)),
+			}
+			byID[instanceID] = instance
+		}
+
+		ownership := tokenDistance(prevToken, token)
+		instance.tokens[token] = ownership
+		instance.ownership += ownership
+	}
+
+	instances := make([]*rebalancingInstance, 0, len(byID))
+	for _, instance := range byID {
+		instances = append(instances, instance)
+	}
+	sort.Slice(instances, func(i, j int) bool {
+		return instances[i].id < instances[j].id
+	})
+	return instances
+}
+
+// ownershipSpread returns 1 - (lowest normalized ownership / highest normalized ownership) of the given instances.
+func ownershipSpread(instances []*rebalancingInstance) float64 {
+	lowest, highest := lowestAndHighestOwnership(instances)
+	if lowest == nil || highest == nil || highest.normalizedOwnership() == 0 {
+		return 0
+	}
+	return 1 - lowest.normalizedOwnership()/highest.normalizedOwnership()
+}
+
+// lowestAndHighestOwnership returns the instances with the lowest and the highest normalized ownership.
+// Ties are broken by instance ID, so that the result is deterministic.
+func lowestAndHighestOwnership(instances []*rebalancingInstance) (lowest, highest *rebalancingInstance) {
+	for _, instance := range instances {
+		if lowest == nil || instance.normalizedOwnership() < lowest.normalizedOwnership() {
+			lowest = instance
+		}
+		if highest == nil || instance.normalizedOwnership() > highest.normalizedOwnership() {
+			highest = instance
+		}
+	}
+	return lowest, highest
+}
+
+// nextTokenMove finds the token of the instance with the highest ownership that, moved to the instance
+// with the lowest ownership, minimizes the highest ownership among the two. The move is applied to the
+// given instances and returned, unless the spread is already within targetSpread, or there is no move
+// improving it.
+func nextTokenMove(instances []*rebalancingInstance, targetSpread float64) (TokenMove, bool) {
+	if ownershipSpread(instances) <= targetSpread {
+		return TokenMove{}, false
+	}
+
+	lowest, highest := lowestAndHighestOwnership(instances)
+	if lowest == highest || len(highest.tokens) <= 1 {
+		return TokenMove{}, false
+	}
+
+	var (
+		bestToken      uint32
+		bestOwnership  int64
+		bestResult     = highest.normalizedOwnership()
+		bestTokenFound = false
+	)
+
+	for token, ownership := range highest.tokens {
+		result := math.Max(
+			float64(highest.ownership-ownership)/highest.weight,
+			float64(lowest.ownership+ownership)/lowest.weight,
+		)
+
+		// Ties are broken by token, so that the plan is deterministic.
+		if result < bestResult || (bestTokenFound && result == bestResult && token < bestToken) {
+			bestToken, bestOwnership, bestResult, bestTokenFound = token, ownership, result, true
+		}
+	}
+
+	if !bestTokenFound {
+		return TokenMove{}, false
+	}
+
+	delete(highest.tokens, bestToken)
+	highest.ownership -= bestOwnership
+	lowest.tokens[bestToken] = bestOwnership
+	lowest.ownership += bestOwnership
+
+	return TokenMove{Token: bestToken, From: highest.id, To: lowest.id}, true
+}
+
+// ApplyTokenMove transfers the token of the given move between the two instances. The timestamp of both
+// instances is set to now, so that the change is propagated by gossiping too. Since it updates the entry of
+// another instance, it must not be used on a ring whose instances are running: the TokenRebalancerDelegate
+// hands off tokens via OfferedTokens instead.
+func (d *Desc) ApplyTokenMove(move TokenMove, now time.Time) error {
+	from, ok := d.Ingesters[move.From]
+	if !ok {
+		return errors.Errorf("instance %s not found in the ring", move.From)
+	}
+	to, ok := d.Ingesters[move.To]
+	if !ok {
+		return errors.Errorf("instance %s not found in the ring", move.To)
+	}
+
+	index := sort.Search(len(from.Tokens), func(i int) bool { return from.Tokens[i] >= move.Token })
+	if index == len(from.Tokens) || from.Tokens[index] != move.Token {
+		return errors.Errorf("token %d is not owned by instance %s", move.Token, move.From)
+	}
+
+	from.Tokens = append(from.Tokens[:index:index], from.Tokens[index+1:]...)
+	from.Timestamp = now.Unix()
+
+	to.Tokens = append(append([]uint32(nil), to.Tokens...), move.Token)
+	sort.Sort(Tokens(to.Tokens))
+	to.Timestamp = now.Unix()
+
+	d.Ingesters[move.From] = from
+	d.Ingesters[move.To] = to
+	return nil
+}
+
+// hasOfferedTokens returns whether any instance of the ring is handing off tokens.
+func (d *Desc) hasOfferedTokens() bool {
+	for _, ing := range d.Ingesters {
+		if len(ing.OfferedTokens) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// tokensContain returns whether the sorted tokens contain the given token.
+func tokensContain(tokens []uint32, token uint32) bool {
+	index := sort.Search(len(tokens), func(i int) bool { return tokens[i] >= token })
+	return index < len(tokens) && tokens[index] == token
+}
+
+type tokenRebalancerMetrics struct {
+	plannedMoves    prometheus.Gauge
+	executedMoves   prometheus.Counter
+	failedMoves     prometheus.Counter
+	ownershipSpread prometheus.Gauge
+}
+
+func newTokenRebalancerMetrics(ringName string, reg prometheus.Registerer) *tokenRebalancerMetrics {
+	return &tokenRebalancerMetrics{
+		plannedMoves: promauto.With(reg).NewGauge(prometheus.GaugeOpts{
+			Name:        "ring_token_rebalancer_planned_moves",
+			Help:        "The number of token moves required to bring the ring ownership spread within the target, as of the last plan.",
+			ConstLabels: prometheus.Labels{"name": ringName},
+		}),
+		executedMoves: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name:        "ring_token_rebalancer_executed_moves_total",
+			Help:        "The total number of tokens handed off by this instance to other instances.",
+			ConstLabels: prometheus.Labels{"name": ringName},
+		}),
+		failedMoves: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name:        "ring_token_rebalancer_failed_moves_total",
+			Help:        "The total number of tokens offered by this instance which have not been claimed by the receiving instance.",
+			ConstLabels: prometheus.Labels{"name": ringName},
+		}),
+		ownershipSpread: promauto.With(reg).NewGauge(prometheus.GaugeOpts{
+			Name:        "ring_token_rebalancer_ownership_spread",
+			Help:        "The highest ownership spread among the ring zones, as of the last plan.",
+			ConstLabels: prometheus.Labels{"name": ringName},
+		}),
+	}
+}
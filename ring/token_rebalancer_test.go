@@ -0,0 +1,366 @@
+package ring
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/dskit/kv/consul"
+	"github.com/grafana/dskit/services"
+	"github.com/grafana/dskit/test"
+)
+
+func TestTokenRebalancerConfig_Validate(t *testing.T) {
+	tests := map[string]struct {
+		cfg         TokenRebalancerConfig
+		expectedErr bool
+	}{
+		"valid config": {
+			cfg: TokenRebalancerConfig{TargetSpread: 0.05, MaxMovesPerHeartbeat: 1},
+		},
+		"negative target spread": {
+			cfg:         TokenRebalancerConfig{TargetSpread: -0.1, MaxMovesPerHeartbeat: 1},
+			expectedErr: true,
+		},
+		"target spread equal to 1": {
+			cfg:         TokenRebalancerConfig{TargetSpread: 1, MaxMovesPerHeartbeat: 1},
+			expectedErr: true,
+		},
+		"no moves per heartbeat": {
+			cfg:         TokenRebalancerConfig{TargetSpread: 0.05, MaxMovesPerHeartbeat: 0},
+			expectedErr: true,
+		},
+	}
+
+	for testName, testData := range tests {
+		t.Run(testName, func(t *testing.T) {
+			err := testData.cfg.Validate()
+			if testData.expectedErr {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestPlanTokenRebalance(t *testing.T) {
+	const step = math.MaxUint32 / 8
+
+	tests := map[string]struct {
+		setup         func(desc *Desc)
+		targetSpread  float64
+		maxMoves      int
+		expectedMoves []TokenMove
+	}{
+		"empty ring": {
+			setup:        func(*Desc) {},
+			targetSpread: 0.05,
+		},
+		"already balanced ring": {
+			setup: func(desc *Desc) {
+				desc.AddIngester("instance-1", "", "", []uint32{2 * step, 6 * step}, ACTIVE, time.Now(), false, time.Time{})
+				desc.AddIngester("instance-2", "", "", []uint32{4 * step, 8 * step}, ACTIVE, time.Now(), false, time.Time{})
+			},
+			targetSpread: 0.05,
+		},
+		"unbalanced ring": {
+			setup: func(desc *Desc) {
+				desc.AddIngester("instance-1", "", "", []uint32{step, 2 * step, 3 * step, 4 * step, 5 * step, 6 * step}, ACTIVE, time.Now(), false, time.Time{})
+				desc.AddIngester("instance-2", "", "", []uint32{7 * step, 8 * step}, ACTIVE, time.Now(), false, time.Time{})
+			},
+			targetSpread: 0.05,
+			expectedMoves: []TokenMove{
+				{Token: step, From: "instance-1", To: "instance-2"},
+				{Token: 2 * step, From: "instance-1", To: "instance-2"},
+			},
+		},
+		"unbalanced ring with max moves": {
+			setup: func(desc *Desc) {
+				desc.AddIngester("instance-1", "", "", []uint32{step, 2 * step, 3 * step, 4 * step, 5 * step, 6 * step}, ACTIVE, time.Now(), false, time.Time{})
+				desc.AddIngester("instance-2", "", "", []uint32{7 * step, 8 * step}, ACTIVE, time.Now(), false, time.Time{})
+			},
+			targetSpread: 0.05,
+			maxMoves:     1,
+			expectedMoves: []TokenMove{
+				{Token: step, From: "instance-1", To: "instance-2"},
+			},
+		},
+		"unbalanced ring within the target spread": {
+			setup: func(desc *Desc) {
+				desc.AddIngester("instance-1", "", "", []uint32{step, 2 * step, 3 * step, 4 * step, 5 * step, 6 * step}, ACTIVE, time.Now(), false, time.Time{})
+				desc.AddIngester("instance-2", "", "", []uint32{7 * step, 8 * step}, ACTIVE, time.Now(), false, time.Time{})
+			},
+			targetSpread: 0.7,
+		},
+		"weighted instances with ownership proportional to weight": {
+			setup: func(desc *Desc) {
+				desc.AddIngesterWithWeight("instance-1", "", "", []uint32{step, 2 * step, 3 * step, 4 * step, 5 * step, 6 * step}, ACTIVE, time.Now(), false, time.Time{}, 3)
+				desc.AddIngesterWithWeight("instance-2", "", "", []uint32{7 * step, 8 * step}, ACTIVE, time.Now(), false, time.Time{}, 1)
+			},
+			targetSpread: 0.05,
+		},
+		"instances not ACTIVE are excluded": {
+			setup: func(desc *Desc) {
+				desc.AddIngester("instance-1", "", "", []uint32{step, 2 * step, 3 * step, 4 * step, 5 * step, 6 * step}, ACTIVE, time.Now(), false, time.Time{})
+				desc.AddIngester("instance-2", "", "", []uint32{7 * step, 8 * step}, LEAVING, time.Now(), false, time.Time{})
+			},
+			targetSpread: 0.05,
+		},
+		"zones are rebalanced independently": {
+			setup: func(desc *Desc) {
+				desc.AddIngester("instance-a-1", "", "zone-a", []uint32{step, 2 * step, 3 * step, 4 * step, 5 * step, 6 * step}, ACTIVE, time.Now(), false, time.Time{})
+				desc.AddIngester("instance-a-2", "", "zone-a", []uint32{7 * step, 8 * step}, ACTIVE, time.Now(), false, time.Time{})
+				desc.AddIngester("instance-b-1", "", "zone-b", []uint32{step + 1, 5*step + 1}, ACTIVE, time.Now(), false, time.Time{})
+				desc.AddIngester("instance-b-2", "", "zone-b", []uint32{3*step + 1, 7*step + 1}, ACTIVE, time.Now(), false, time.Time{})
+			},
+			targetSpread: 0.05,
+			expectedMoves: []TokenMove{
+				{Zone: "zone-a", Token: step, From: "instance-a-1", To: "instance-a-2"},
+				{Zone: "zone-a", Token: 2 * step, From: "instance-a-1", To: "instance-a-2"},
+			},
+		},
+	}
+
+	for testName, testData := range tests {
+		t.Run(testName, func(t *testing.T) {
+			desc := NewDesc()
+			testData.setup(desc)
+			orig := desc.Clone()
+
+			plan := PlanTokenRebalance(desc, testData.targetSpread, testData.maxMoves)
+			assert.Equal(t, testData.expectedMoves, plan.Moves)
+			assert.LessOrEqual(t, plan.SpreadAfter, plan.SpreadBefore)
+			if len(plan.Moves) == 0 {
+				assert.Equal(t, plan.SpreadBefore, plan.SpreadAfter)
+			}
+
+			// The input ring should not be modified.
+			assert.True(t, desc.Equal(orig))
+		})
+	}
+}
+
+func TestPlanTokenRebalance_ShouldReachTheTargetSpread(t *testing.T) {
+	const (
+		numInstances = 10
+		targetSpread = 0.02
+	)
+
+	desc := NewDesc()
+	gen := NewRandomTokenGenerator()
+	for i := 0; i < numInstances; i++ {
+		id := fmt.Sprintf("instance-%d", i)
+		desc.AddIngesterWithWeight(id, "", "", gen.GenerateTokens(64, desc.GetTokens()), ACTIVE, time.Now(), false, time.Time{}, uint32(1+i%2))
+	}
+
+	plan := PlanTokenRebalance(desc, targetSpread, 0)
+	require.NotEmpty(t, plan.Moves)
+	require.Greater(t, plan.SpreadBefore, targetSpread)
+	require.LessOrEqual(t, plan.SpreadAfter, targetSpread)
+
+	// Applying the plan, the ownership normalized by weight should be within the target spread.
+	for _, move := range plan.Moves {
+		require.NoError(t, desc.ApplyTokenMove(move, time.Now()))
+	}
+
+	minOwnership, maxOwnership := math.MaxFloat64, 0.0
+	for id, ownership := range desc.CountTokens() {
+		instance := desc.Ingesters[id]
+		normalized := float64(ownership) / float64(instance.GetEffectiveWeight())
+		minOwnership = math.Min(minOwnership, normalized)
+		maxOwnership = math.Max(maxOwnership, normalized)
+	}
+	assert.LessOrEqual(t, 1-minOwnership/maxOwnership, targetSpread)
+
+	// Once applied, no further move should be planned.
+	assert.Empty(t, PlanTokenRebalance(desc, targetSpread, 0).Moves)
+}
+
+func TestDesc_ApplyTokenMove(t *testing.T) {
+	now := time.Now()
+
+	tests := map[string]struct {
+		move              TokenMove
+		expectedErr       string
+		expectedInstance1 []uint32
+		expectedInstance2 []uint32
+	}{
+		"should move the token": {
+			move:              TokenMove{Token: 3, From: "instance-1", To: "instance-2"},
+			expectedInstance1: []uint32{1, 5},
+			expectedInstance2: []uint32{2, 3, 4},
+		},
+		"should fail if the token is not owned by the source instance": {
+			move:        TokenMove{Token: 2, From: "instance-1", To: "instance-2"},
+			expectedErr: "token 2 is not owned by instance instance-1",
+		},
+		"should fail if the source instance does not exist": {
+			move:        TokenMove{Token: 3, From: "instance-3", To: "instance-2"},
+			expectedErr: "instance instance-3 not found in the ring",
+		},
+		"should fail if the destination instance does not exist": {
+			move:        TokenMove{Token: 3, From: "instance-1", To: "instance-3"},
+			expectedErr: "instance instance-3 not found in the ring",
+		},
+	}
+
+	for testName, testData := range tests {
+		t.Run(testName, func(t *testing.T) {
+			desc := NewDesc()
+			desc.AddIngester("instance-1", "", "", []uint32{1, 3, 5}, ACTIVE, time.Now(), false, time.Time{})
+			desc.AddIngester("instance-2", "", "", []uint32{2, 4}, ACTIVE, time.Now(), false, time.Time{})
+			orig := desc.Clone().(*Desc)
+
+			err := desc.ApplyTokenMove(testData.move, now.Add(time.Minute))
+			if testData.expectedErr != "" {
+				require.EqualError(t, err, testData.expectedErr)
+				assert.Equal(t, orig, desc)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, testData.expectedInstance1, desc.Ingesters["instance-1"].Tokens)
+			assert.Equal(t, testData.expectedInstance2, desc.Ingesters["instance-2"].Tokens)
+			assert.Equal(t, now.Add(time.Minute).Unix(), desc.Ingesters["instance-1"].Timestamp)
+			assert.Equal(t, now.Add(time.Minute).Unix(), desc.Ingesters["instance-2"].Timestamp)
+
+			// The tokens of the original ring should not be modified.
+			assert.Equal(t, []uint32{1, 3, 5}, orig.Ingesters["instance-1"].Tokens)
+		})
+	}
+}
+
+func TestTokenRebalancerDelegate(t *testing.T) {
+	const step = math.MaxUint32 / 8
+	allTokens := []uint32{step, 2 * step, 3 * step, 4 * step, 5 * step, 6 * step, 7 * step, 8 * step}
+
+	tests := map[string]struct {
+		dryRun                bool
+		otherInstanceState    InstanceState
+		expectedPlannedMoves  float64
+		expectedExecutedMoves float64
+	}{
+		"should hand off tokens to other instances until the ring is balanced": {
+			otherInstanceState:    ACTIVE,
+			expectedPlannedMoves:  0,
+			expectedExecutedMoves: 2,
+		},
+		"should not move tokens in dry-run mode": {
+			dryRun:                true,
+			otherInstanceState:    ACTIVE,
+			expectedPlannedMoves:  2,
+			expectedExecutedMoves: 0,
+		},
+		"should not move tokens while the ring is not ready": {
+			otherInstanceState:    JOINING,
+			expectedPlannedMoves:  0,
+			expectedExecutedMoves: 0,
+		},
+	}
+
+	for testName, testData := range tests {
+		t.Run(testName, func(t *testing.T) {
+			ctx := context.Background()
+			store, closer := consul.NewInMemoryClient(GetCodec(), log.NewNopLogger(), nil)
+			t.Cleanup(func() { assert.NoError(t, closer.Close()) })
+
+			rebalancerCfg := TokenRebalancerConfig{TargetSpread: 0.05, MaxMovesPerHeartbeat: 1, DryRun: testData.dryRun}
+
+			// Starts an instance running the rebalancer, and returns it along with the tokens notified
+			// to the delegate chain (e.g. to be persisted).
+			startInstance := func(id string, state InstanceState, tokens Tokens) (*BasicLifecycler, *TokenRebalancerDelegate, *atomic.Value) {
+				cfg := prepareBasicLifecyclerConfig()
+				cfg.ID = id
+				cfg.Zone = ""
+				cfg.HeartbeatPeriod = 100 * time.Millisecond
+
+				notified := &atomic.Value{}
+				testDelegate := &mockDelegate{
+					onRegister: func(*BasicLifecycler, Desc, bool, string, InstanceDesc) (InstanceState, Tokens) {
+						return state, tokens
+					},
+					onTokensChanged: func(_ *BasicLifecycler, tokens Tokens) {
+						notified.Store(append(Tokens(nil), tokens...))
+					},
+				}
+
+				delegate := NewTokenRebalancerDelegate(rebalancerCfg, testDelegate, testRingName, log.NewNopLogger(), prometheus.NewPedanticRegistry())
+				lifecycler, err := NewBasicLifecycler(cfg, testRingName, testRingKey, store, delegate, log.NewNopLogger(), nil)
+				require.NoError(t, err)
+				require.NoError(t, services.StartAndAwaitRunning(ctx, lifecycler))
+				t.Cleanup(func() {
+					require.NoError(t, services.StopAndAwaitTerminated(ctx, lifecycler))
+				})
+				return lifecycler, delegate, notified
+			}
+
+			other, _, otherNotified := startInstance("instance-1", testData.otherInstanceState, Tokens{7 * step, 8 * step})
+			lifecycler, rebalancerDelegate, notified := startInstance(testInstanceID, ACTIVE, Tokens{step, 2 * step, 3 * step, 4 * step, 5 * step, 6 * step})
+
+			// Tokens must always be owned by an instance while they're handed off.
+			deadline := time.Now().Add(2 * time.Second)
+			for time.Now().Before(deadline) && testutil.ToFloat64(lifecycler.metrics.heartbeats) <= 10 {
+				inst, _ := getInstanceFromStore(t, store, testInstanceID)
+				otherInst, _ := getInstanceFromStore(t, store, "instance-1")
+				owned := map[uint32]bool{}
+				for _, token := range append(append([]uint32(nil), inst.Tokens...), otherInst.Tokens...) {
+					owned[token] = true
+				}
+				require.Len(t, owned, len(allTokens))
+				time.Sleep(10 * time.Millisecond)
+			}
+
+			test.Poll(t, 2*time.Second, testData.expectedExecutedMoves, func() interface{} {
+				return testutil.ToFloat64(rebalancerDelegate.metrics.executedMoves)
+			})
+			assert.Equal(t, testData.expectedPlannedMoves, testutil.ToFloat64(rebalancerDelegate.metrics.plannedMoves))
+			assert.Equal(t, 0.0, testutil.ToFloat64(rebalancerDelegate.metrics.failedMoves))
+
+			inst, ok := getInstanceFromStore(t, store, testInstanceID)
+			require.True(t, ok)
+			assert.Len(t, inst.Tokens, 6-int(testData.expectedExecutedMoves))
+			assert.Empty(t, inst.OfferedTokens)
+			assert.Equal(t, Tokens(inst.Tokens), lifecycler.GetTokens())
+
+			otherInst, ok := getInstanceFromStore(t, store, "instance-1")
+			require.True(t, ok)
+			assert.Len(t, otherInst.Tokens, 2+int(testData.expectedExecutedMoves))
+			assert.Equal(t, Tokens(otherInst.Tokens), other.GetTokens())
+
+			// The tokens changed by the handoff are notified to the delegates of both instances.
+			if testData.expectedExecutedMoves > 0 {
+				assert.Equal(t, Tokens(inst.Tokens), notified.Load())
+				assert.Equal(t, Tokens(otherInst.Tokens), otherNotified.Load())
+			}
+		})
+	}
+}
+
+func TestResolveConflicts_ShouldPreferReceiverOfOfferedToken(t *testing.T) {
+	ingesters := map[string]InstanceDesc{
+		"instance-1": {State: ACTIVE, Tokens: []uint32{1, 2}},
+		"instance-2": {State: ACTIVE, Tokens: []uint32{2, 3}, OfferedTokens: map[uint32]string{2: "instance-1"}},
+	}
+	resolveConflicts(ingesters)
+	assert.Equal(t, []uint32{1, 2}, ingesters["instance-1"].Tokens)
+	assert.Equal(t, []uint32{3}, ingesters["instance-2"].Tokens)
+
+	// The receiver wins even if it has not the lowest ID.
+	ingesters = map[string]InstanceDesc{
+		"instance-1": {State: ACTIVE, Tokens: []uint32{1, 2}, OfferedTokens: map[uint32]string{2: "instance-2"}},
+		"instance-2": {State: ACTIVE, Tokens: []uint32{2, 3}},
+	}
+	resolveConflicts(ingesters)
+	assert.Equal(t, []uint32{1}, ingesters["instance-1"].Tokens)
+	assert.Equal(t, []uint32{2, 3}, ingesters["instance-2"].Tokens)
+}